@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="NodeName",type=string,JSONPath=`.spec.nodeName`,description="Name of the Node which this AzDriverNode object represents."
+// +kubebuilder:printcolumn:name="ReadyForVolumeAllocation",type=boolean,JSONPath=`.status.readyForVolumeAllocation`,description="Indicates if the azure persistent volume driver is ready for new pods which use azure persistent volumes."
+// +kubebuilder:printcolumn:name="LastHeartbeatTime",type=date,JSONPath=`.status.lastHeartbeatTime`,description="Represents the time stamp at which azure persistent volume driver sent a heatbeat."
+// +kubebuilder:printcolumn:name="StatusMessage",type=string,JSONPath=`.status.statusMessage`,description="A brief node status message."
+
+// AzDriverNode is a representation of a node, where azure CSI driver node plug-in runs.
+type AzDriverNode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// spec defines the desired state of a AzDriverNode.
+	// Required.
+	Spec AzDriverNodeSpec `json:"spec"`
+
+	// status represents the current state of AzDriverNode.
+	// If this is nil or empty, clients should prefer other nodes
+	// for persistent volume allocations or pod places for pods which use azure persistent volumes.
+	// +optional
+	Status *AzDriverNodeStatus `json:"status,omitempty"`
+}
+
+// AzDriverNodeSpec is the spec for a AzDriverNode resource.
+type AzDriverNodeSpec struct {
+	// Name of the node which this AzDriverNode represents.
+	// Required.
+	NodeName string `json:"nodeName"`
+}
+
+// AzDriverNodeStatus is the status for a AzDriverNode resource.
+type AzDriverNodeStatus struct {
+	// LastHeartbeatTime represents the timestamp when a heatbeat was sent by driver node plugin.
+	// A recent timestamp means that node-plugin is responsive and is communicating to API server.
+	// Clients should not solely reply on LastHeartbeatTime to ascertain node plugin's health state.
+	// +optional
+	LastHeartbeatTime *metav1.Time `json:"lastHeartbeatTime,omitempty"`
+
+	// ReadyForVolumeAllocation tells client whether the node plug-in is ready for volume allocation.
+	// If status is not present or ReadyForVolumeAllocation, then clients should prefer
+	// other nodes in the clusters for azure persistent volumes\pod placements for pods with azure disks.
+	// +optional
+	ReadyForVolumeAllocation *bool `json:"readyForVolumeAllocation,omitempty"`
+
+	// StatusMessage is a brief status message regarding nodes health
+	// This field should not be used for any decision making in code
+	// It is for display/debug purpose only
+	// For code logic dependency, use Conditions filed
+	// +optional
+	StatusMessage *string `json:"statusMessage,omitempty"`
+
+	// LUNsInUse lists the LUNs of the data disks currently visible to the node plug-in.
+	// +optional
+	LUNsInUse []int32 `json:"lunsInUse,omitempty"`
+
+	// Conditions contains an array of generic AzDriver related health conditions
+	// These conditions can be used programmatically to take decisions
+	// +optional
+	Conditions []AzDriverCondition `json:"conditions,omitempty"`
+}
+
+// AzDriverConditionType defines the condition of Azure node.
+type AzDriverConditionType string
+
+const (
+	// AzDriverConditionDeviceDiscovery reports whether the node plug-in is able to discover attached data disks.
+	AzDriverConditionDeviceDiscovery AzDriverConditionType = "DeviceDiscovery"
+)
+
+// AzDriverCondition defines condition for the AzDriver
+type AzDriverCondition struct {
+	// Type of node condition.
+	Type AzDriverConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status metav1.ConditionStatus `json:"status"`
+	// Last time we got an update on a given condition.
+	// +optional
+	LastHeartbeatTime *metav1.Time `json:"lastHeartbeatTime,omitempty"`
+	// Last time the condition transit from one status to another.
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+	// (brief) reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Human readable message indicating details about last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AzDriverNodeList is a list of AzDriverNode resources
+type AzDriverNodeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []AzDriverNode `json:"items"`
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:printcolumn:name="NodeName",type=string,JSONPath=`.spec.nodeName`,description="Name of the Node which this AzVolumeAttachment object is attached to",priority=10
+// +kubebuilder:printcolumn:name="VolumeName",type=string,JSONPath=`.spec.volumeName`,description="Name of the volume which this AzVolumeAttachment object references",priority=10
+// +kubebuilder:printcolumn:name="RequestedRole",type=string,JSONPath=`.spec.role`,description="Indicates if the volume attachment should be primary attachment or not"
+// +kubebuilder:printcolumn:name="Role",type=string,JSONPath=`.status.detail.role`,description="Indicates if the volume attachment is primary attachment or not"
+// +kubebuilder:printcolumn:name="PreviousRole",type=string,JSONPath=`.status.detail.previous_role`,description="Describes the previous volume attachment role",priority=10
+// +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`,description="Indicates the state of the volume attachment"
+
+// AzVolumeAttachment is a specification for a AzVolumeAttachment resource
+type AzVolumeAttachment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// spec defines the desired state of a AzVolumeAttachment.
+	// Required.
+	Spec AzVolumeAttachmentSpec `json:"spec"`
+
+	// status represents the current state of AzVolumeAttachment.
+	// includes error, state, and attachment status
+	// +optional
+	Status AzVolumeAttachmentStatus `json:"status,omitempty"`
+}
+
+// AzVolumeAttachmentSpec is the spec for a AzVolumeAttachment resource
+type AzVolumeAttachmentSpec struct {
+	VolumeName    string            `json:"volumeName"`
+	VolumeID      string            `json:"volume_id"`
+	NodeName      string            `json:"nodeName"`
+	VolumeContext map[string]string `json:"volume_context"`
+	// Role indicates if the volume attachment is replica attachment or not
+	RequestedRole Role `json:"role"`
+}
+
+// Role indicates whether a volume attachment is the primary attachment used by a workload or a failover replica.
+type Role string
+
+const (
+	// PrimaryRole is the role of the attachment to the node where the volume is published.
+	PrimaryRole Role = "Primary"
+	// ReplicaRole is the role of an attachment to a backup node kept for faster failover.
+	ReplicaRole Role = "Replica"
+)
+
+// AzVolumeAttachmentAttachmentState indicates the progress of an attach or detach operation.
+type AzVolumeAttachmentAttachmentState string
+
+const (
+	// AttachmentPending indicates that the attachment has not been processed yet.
+	AttachmentPending AzVolumeAttachmentAttachmentState = "Pending"
+	// Attaching indicates that the disk is being attached to the node.
+	Attaching AzVolumeAttachmentAttachmentState = "Attaching"
+	// Attached indicates that the disk is attached to the node.
+	Attached AzVolumeAttachmentAttachmentState = "Attached"
+	// AttachmentFailed indicates that the last attach operation failed.
+	AttachmentFailed AzVolumeAttachmentAttachmentState = "AttachmentFailed"
+	// Detaching indicates that the disk is being detached from the node.
+	Detaching AzVolumeAttachmentAttachmentState = "Detaching"
+	// DetachmentFailed indicates that the last detach operation failed.
+	DetachmentFailed AzVolumeAttachmentAttachmentState = "DetachmentFailed"
+)
+
+// AzVolumeAttachmentStatus is the status for a AzVolumeAttachment resource
+type AzVolumeAttachmentStatus struct {
+	// Status summarizes the current attachment state of the volume attachment
+	// Nil Status indicates that the volume has not yet been attached to the node
+	// +optional
+	Detail *AzVolumeAttachmentStatusDetail `json:"detail,omitempty"`
+	// State shows the current attachment state (whether operations are in progress or not)
+	// +optional
+	State AzVolumeAttachmentAttachmentState `json:"state,omitempty"`
+	// Error occurred during attach/detach of volume
+	// +optional
+	Error *AzError `json:"error,omitempty"`
+	// Annotations contains additional resource information to guide driver actions
+	// +optional
+	Annotations map[string]string `json:"annotation,omitempty"`
+}
+
+// AzVolumeAttachmentStatusDetail is the status of the attachment between specified node and volume.
+type AzVolumeAttachmentStatusDetail struct {
+	// The current attachment role.
+	Role Role `json:"role"`
+	// The previous attachment role.
+	// +optional
+	PreviousRole Role `json:"previous_role,omitempty"`
+	// +optional
+	PublishContext map[string]string `json:"publish_context,omitempty"`
+}
+
+// AzError is the status of an operation that failed on an Azure Disk CSI custom resource
+type AzError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AzVolumeAttachmentList is a list of AzVolumeAttachment resources
+type AzVolumeAttachmentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []AzVolumeAttachment `json:"items"`
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// OutcomeSuccess is recorded when the mutating call returned without error
+	OutcomeSuccess = "success"
+	// OutcomeFailure is recorded when the mutating call returned an error
+	OutcomeFailure = "failure"
+
+	// RedactedValue replaces the value of any sensitive parameter
+	RedactedValue = "<redacted>"
+
+	correlationRequestIDHeader = "x-ms-correlation-request-id"
+	clientRequestIDHeader      = "x-ms-client-request-id"
+)
+
+// sensitiveKeyFragments lists lower-case fragments of parameter keys whose values must never be written to the audit log
+var sensitiveKeyFragments = []string{"secret", "password", "token", "credential", "key", "sas"}
+
+// Record is a single audit log entry describing one mutating Azure call.
+type Record struct {
+	Time          time.Time         `json:"time"`
+	Operation     string            `json:"operation"`
+	ResourceID    string            `json:"resourceID,omitempty"`
+	Node          string            `json:"node,omitempty"`
+	CSIMethod     string            `json:"csiMethod,omitempty"`
+	RequestName   string            `json:"requestName,omitempty"`
+	PVName        string            `json:"pvName,omitempty"`
+	PVCName       string            `json:"pvcName,omitempty"`
+	PVCNamespace  string            `json:"pvcNamespace,omitempty"`
+	Parameters    map[string]string `json:"parameters,omitempty"`
+	CorrelationID string            `json:"correlationID,omitempty"`
+	LatencyMs     int64             `json:"latencyMs"`
+	Outcome       string            `json:"outcome"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// RequestInfo carries the identity of the CSI request that triggered a mutating call.
+type RequestInfo struct {
+	CSIMethod    string
+	RequestName  string
+	PVName       string
+	PVCName      string
+	PVCNamespace string
+	Parameters   map[string]string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of ctx carrying the CSI request information used to populate audit records.
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the CSI request information stored in ctx, if any.
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	if ctx == nil {
+		return RequestInfo{}, false
+	}
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// RedactParameters returns a copy of parameters with the values of sensitive keys replaced by RedactedValue
+func RedactParameters(parameters map[string]string) map[string]string {
+	if len(parameters) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(parameters))
+	for k, v := range parameters {
+		if isSensitiveKey(k) {
+			redacted[k] = RedactedValue
+		} else {
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+func isSensitiveKey(key string) bool {
+	lowerKey := strings.ToLower(key)
+	// csi.storage.k8s.io/*-secret-name and -namespace only reference a secret, they do not hold one
+	if strings.HasPrefix(lowerKey, "csi.storage.k8s.io/") {
+		return false
+	}
+	for _, fragment := range sensitiveKeyFragments {
+		if strings.Contains(lowerKey, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// Event tracks a single mutating call until it is observed.
+// A nil *Event is valid and records nothing, so callers need not check whether auditing is enabled.
+type Event struct {
+	sink     Sink
+	record   Record
+	start    time.Time
+	response *http.Response
+}
+
+// NewEvent starts an audit event for operation on resourceID. It returns nil when sink is nil.
+func NewEvent(ctx context.Context, sink Sink, operation, resourceID string) *Event {
+	if sink == nil {
+		return nil
+	}
+	e := &Event{
+		sink:  sink,
+		start: time.Now(),
+		record: Record{
+			Operation:  operation,
+			ResourceID: resourceID,
+		},
+	}
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		e.record.CSIMethod = info.CSIMethod
+		e.record.RequestName = info.RequestName
+		e.record.PVName = info.PVName
+		e.record.PVCName = info.PVCName
+		e.record.PVCNamespace = info.PVCNamespace
+		e.record.Parameters = RedactParameters(info.Parameters)
+	}
+	return e
+}
+
+// WithNode sets the node the operation targets
+func (e *Event) WithNode(node string) *Event {
+	if e != nil {
+		e.record.Node = node
+	}
+	return e
+}
+
+// WithResourceID sets the resource ID when it is only known after the call completes
+func (e *Event) WithResourceID(resourceID string) *Event {
+	if e != nil && resourceID != "" {
+		e.record.ResourceID = resourceID
+	}
+	return e
+}
+
+// Context returns a copy of ctx that captures the ARM response of the call it is passed to,
+// so that the correlation ID can be recorded.
+func (e *Event) Context(ctx context.Context) context.Context {
+	if e == nil {
+		return ctx
+	}
+	return policy.WithCaptureResponse(ctx, &e.response)
+}
+
+// Observe completes the event with the result of the call and writes it to the sink.
+func (e *Event) Observe(err error) {
+	if e == nil {
+		return
+	}
+	e.record.Time = e.start.UTC()
+	e.record.LatencyMs = time.Since(e.start).Milliseconds()
+	e.record.Outcome = OutcomeSuccess
+	if err != nil {
+		e.record.Outcome = OutcomeFailure
+		e.record.Error = err.Error()
+	}
+	e.record.CorrelationID = correlationID(e.response, err)
+	if werr := e.sink.Write(&e.record); werr != nil {
+		klog.Errorf("failed to write audit record for %s(%s): %v", e.record.Operation, e.record.ResourceID, werr)
+	}
+}
+
+// correlationID extracts the ARM correlation ID from the captured response or from the response embedded in err
+func correlationID(resp *http.Response, err error) string {
+	var respErr *azcore.ResponseError
+	if resp == nil && errors.As(err, &respErr) {
+		resp = respErr.RawResponse
+	}
+	if resp == nil {
+		return ""
+	}
+	if id := resp.Header.Get(correlationRequestIDHeader); id != "" {
+		return id
+	}
+	if resp.Request != nil {
+		return resp.Request.Header.Get(clientRequestIDHeader)
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSink struct {
+	records []Record
+	err     error
+}
+
+func (s *fakeSink) Write(record *Record) error {
+	s.records = append(s.records, *record)
+	return s.err
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func TestRedactParameters(t *testing.T) {
+	tests := []struct {
+		desc       string
+		parameters map[string]string
+		expected   map[string]string
+	}{
+		{
+			desc:       "nil parameters",
+			parameters: nil,
+			expected:   nil,
+		},
+		{
+			desc: "sensitive values are redacted",
+			parameters: map[string]string{
+				"skuName":              "Premium_LRS",
+				"diskEncryptionSetID":  "des",
+				"clientSecret":         "secret-value",
+				"sasToken":             "sas-value",
+				"Password":             "password-value",
+				"storageAccountKey":    "key-value",
+				"azureCredentialsFile": "/etc/kubernetes/azure.json",
+			},
+			expected: map[string]string{
+				"skuName":              "Premium_LRS",
+				"diskEncryptionSetID":  "des",
+				"clientSecret":         RedactedValue,
+				"sasToken":             RedactedValue,
+				"Password":             RedactedValue,
+				"storageAccountKey":    RedactedValue,
+				"azureCredentialsFile": RedactedValue,
+			},
+		},
+		{
+			desc: "secret references are kept",
+			parameters: map[string]string{
+				"csi.storage.k8s.io/provisioner-secret-name":      "azure-secret",
+				"csi.storage.k8s.io/provisioner-secret-namespace": "default",
+			},
+			expected: map[string]string{
+				"csi.storage.k8s.io/provisioner-secret-name":      "azure-secret",
+				"csi.storage.k8s.io/provisioner-secret-namespace": "default",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		result := RedactParameters(test.parameters)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestNilEvent(t *testing.T) {
+	ctx := context.Background()
+	e := NewEvent(ctx, nil, "CreateManagedDisk", "diskURI")
+	assert.Nil(t, e)
+	assert.Equal(t, ctx, e.WithNode("node").WithResourceID("id").Context(ctx))
+	e.Observe(errors.New("failed"))
+}
+
+func TestEventObserve(t *testing.T) {
+	sink := &fakeSink{}
+	ctx := WithRequestInfo(context.Background(), RequestInfo{
+		CSIMethod:    "CreateVolume",
+		RequestName:  "pvc-123",
+		PVName:       "pv",
+		PVCName:      "pvc",
+		PVCNamespace: "default",
+		Parameters:   map[string]string{"skuName": "Premium_LRS", "clientSecret": "value"},
+	})
+
+	e := NewEvent(ctx, sink, "AttachDisk", "diskURI").WithNode("node")
+	e.Observe(nil)
+
+	respErr := &azcore.ResponseError{
+		StatusCode: http.StatusConflict,
+		RawResponse: &http.Response{
+			StatusCode: http.StatusConflict,
+			Header:     http.Header{"X-Ms-Correlation-Request-Id": []string{"correlation-id"}},
+		},
+	}
+	e = NewEvent(ctx, sink, "DetachDisk", "diskURI")
+	e.Observe(respErr)
+
+	// errors from the sink are logged and do not panic
+	sink.err = errors.New("write failed")
+	NewEvent(context.Background(), sink, "DeleteManagedDisk", "diskURI").Observe(nil)
+
+	assert.Len(t, sink.records, 3)
+	assert.Equal(t, "AttachDisk", sink.records[0].Operation)
+	assert.Equal(t, "node", sink.records[0].Node)
+	assert.Equal(t, "CreateVolume", sink.records[0].CSIMethod)
+	assert.Equal(t, "pvc-123", sink.records[0].RequestName)
+	assert.Equal(t, "pv", sink.records[0].PVName)
+	assert.Equal(t, "pvc", sink.records[0].PVCName)
+	assert.Equal(t, "default", sink.records[0].PVCNamespace)
+	assert.Equal(t, map[string]string{"skuName": "Premium_LRS", "clientSecret": RedactedValue}, sink.records[0].Parameters)
+	assert.Equal(t, OutcomeSuccess, sink.records[0].Outcome)
+	assert.Empty(t, sink.records[0].Error)
+	assert.False(t, sink.records[0].Time.IsZero())
+
+	assert.Equal(t, OutcomeFailure, sink.records[1].Outcome)
+	assert.Equal(t, "correlation-id", sink.records[1].CorrelationID)
+	assert.NotEmpty(t, sink.records[1].Error)
+
+	assert.Empty(t, sink.records[2].CSIMethod)
+}
+
+func TestCorrelationID(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPut, "https://management.azure.com", nil)
+	req.Header.Set(clientRequestIDHeader, "client-id")
+
+	tests := []struct {
+		desc     string
+		resp     *http.Response
+		err      error
+		expected string
+	}{
+		{
+			desc:     "no response",
+			expected: "",
+		},
+		{
+			desc:     "correlation id header",
+			resp:     &http.Response{Header: http.Header{"X-Ms-Correlation-Request-Id": []string{"id"}}},
+			expected: "id",
+		},
+		{
+			desc:     "fall back to client request id",
+			resp:     &http.Response{Header: http.Header{}, Request: req},
+			expected: "client-id",
+		},
+		{
+			desc:     "non ARM error",
+			err:      errors.New("failed"),
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		result := correlationID(test.resp, test.err)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
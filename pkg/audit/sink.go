@@ -0,0 +1,182 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// StdoutPath selects the stdout sink instead of a file
+	StdoutPath = "stdout"
+
+	megabyte = 1024 * 1024
+)
+
+// Sink is an append-only destination for audit records
+type Sink interface {
+	Write(record *Record) error
+	Close() error
+}
+
+// NewSink creates the sink selected by path. An empty path disables auditing and returns a nil Sink,
+// "stdout" writes to the standard output and any other value is treated as a file path that is
+// rotated once it grows beyond maxSizeMB, keeping at most maxBackups rotated files.
+func NewSink(path string, maxSizeMB, maxBackups int) (Sink, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case StdoutPath:
+		return NewWriterSink(os.Stdout), nil
+	default:
+		return NewRotatingFileSink(path, int64(maxSizeMB)*megabyte, maxBackups)
+	}
+}
+
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink writing one JSON document per line to w
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Write(record *Record) error {
+	line, err := marshalLine(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(line)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+type rotatingFileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFileSink returns a sink appending JSON lines to path.
+// When maxBytes is greater than zero the file is rotated to path.1, path.2, ... before it would exceed maxBytes.
+func NewRotatingFileSink(path string, maxBytes int64, maxBackups int) (Sink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory for %s: %w", path, err)
+	}
+	s := &rotatingFileSink{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *rotatingFileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", s.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit log %s: %w", s.path, err)
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *rotatingFileSink) Write(record *Record) error {
+	line, err := marshalLine(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("audit log %s is closed", s.path)
+	}
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1 and reopens an empty path
+func (s *rotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log %s: %w", s.path, err)
+	}
+	s.file = nil
+	if s.maxBackups > 0 {
+		for i := s.maxBackups - 1; i > 0; i-- {
+			src := fmt.Sprintf("%s.%d", s.path, i)
+			if _, err := os.Stat(src); err == nil {
+				if err := os.Rename(src, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil {
+					return fmt.Errorf("failed to rotate audit log %s: %w", src, err)
+				}
+			}
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit log %s: %w", s.path, err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to truncate audit log %s: %w", s.path, err)
+	}
+	return s.open()
+}
+
+func (s *rotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func marshalLine(record *Record) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	return append(line, '\n'), nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSink(t *testing.T) {
+	sink, err := NewSink("", 100, 5)
+	assert.NoError(t, err)
+	assert.Nil(t, sink)
+
+	sink, err = NewSink(StdoutPath, 100, 5)
+	assert.NoError(t, err)
+	assert.NotNil(t, sink)
+
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	sink, err = NewSink(path, 100, 5)
+	require.NoError(t, err)
+	defer sink.Close()
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	assert.NoError(t, sink.Write(&Record{Operation: "CreateManagedDisk", Outcome: OutcomeSuccess}))
+	assert.NoError(t, sink.Write(&Record{Operation: "DeleteManagedDisk", Outcome: OutcomeFailure}))
+	assert.NoError(t, sink.Close())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var record Record
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, "DeleteManagedDisk", record.Operation)
+	assert.Equal(t, OutcomeFailure, record.Outcome)
+}
+
+func TestRotatingFileSink(t *testing.T) {
+	line, err := marshalLine(&Record{Operation: "AttachDisk"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc            string
+		maxBackups      int
+		writes          int
+		expectedFiles   []string
+		unexpectedFiles []string
+	}{
+		{
+			desc:            "keep backups",
+			maxBackups:      2,
+			writes:          4,
+			expectedFiles:   []string{"audit.log", "audit.log.1", "audit.log.2"},
+			unexpectedFiles: []string{"audit.log.3"},
+		},
+		{
+			desc:            "no backups",
+			maxBackups:      0,
+			writes:          3,
+			expectedFiles:   []string{"audit.log"},
+			unexpectedFiles: []string{"audit.log.1"},
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "audit.log")
+		// every file holds exactly one record
+		sink, err := NewRotatingFileSink(path, int64(len(line)), test.maxBackups)
+		require.NoError(t, err, test.desc)
+		for i := 0; i < test.writes; i++ {
+			assert.NoError(t, sink.Write(&Record{Operation: "AttachDisk"}), test.desc)
+		}
+		for _, f := range test.expectedFiles {
+			content, err := os.ReadFile(filepath.Join(dir, f))
+			assert.NoError(t, err, test.desc)
+			assert.Equal(t, string(line), string(content), test.desc)
+		}
+		for _, f := range test.unexpectedFiles {
+			_, err := os.Stat(filepath.Join(dir, f))
+			assert.True(t, os.IsNotExist(err), test.desc)
+		}
+		assert.NoError(t, sink.Close(), test.desc)
+		assert.Error(t, sink.Write(&Record{}), test.desc)
+		assert.NoError(t, sink.Close(), test.desc)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+func init() {
+	klog.InitFlags(nil)
+}
+
+var (
+	kubeconfig            = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Required only when running out of cluster.")
+	port                  = flag.String("port", "8889", "port on which the scheduler extender serves the filter and prioritize verbs")
+	metricsAddress        = flag.String("metrics-address", "", "export the metrics")
+	driverName            = flag.String("drivername", consts.DefaultDriverName, "name of the Azure Disk CSI driver")
+	driverObjectNamespace = flag.String("driver-object-namespace", consts.DefaultAzureDiskCrdNamespace, "namespace of the AzDriverNode and AzVolumeAttachment objects")
+	heartbeatTimeout      = flag.Duration("heartbeat-timeout", 2*time.Minute, "age after which the AzDriverNode heartbeat of a node is considered stale")
+)
+
+func main() {
+	flag.Parse()
+
+	kubeClient, err := azureutils.GetKubeClient(*kubeconfig)
+	if err != nil {
+		klog.Fatalf("failed to create kubernetes clientset: %v", err)
+	}
+	azDiskClient, err := azureutils.GetAzDiskClient(*kubeconfig)
+	if err != nil {
+		klog.Fatalf("failed to create azdisk clientset: %v", err)
+	}
+
+	ext := newExtender(informers.NewSharedInformerFactory(kubeClient, 0), azDiskClient, *driverName, *driverObjectNamespace, *heartbeatTimeout)
+
+	exportMetrics()
+	go func() {
+		if err := trapClosedConnErr(http.ListenAndServe(":"+*port, ext.newServeMux())); err != nil {
+			klog.Fatalf("failed to serve scheduler extender on port %s: %v", *port, err)
+		}
+	}()
+
+	// the extender has no leader election, the kube-scheduler of each replica elects a leader and calls the extender
+	// of its own pod. Every replica syncs its caches so that it can answer once its kube-scheduler leads, requests are
+	// passed through unchanged until the caches are synced.
+	if err := ext.run(context.Background()); err != nil {
+		klog.Fatalf("failed to run scheduler extender: %v", err)
+	}
+	select {}
+}
+
+func exportMetrics() {
+	if *metricsAddress == "" {
+		return
+	}
+	l, err := net.Listen("tcp", *metricsAddress)
+	if err != nil {
+		klog.Warningf("failed to get listener for metrics endpoint: %v", err)
+		return
+	}
+	klog.V(2).Infof("set up prometheus server on %v", l.Addr().String())
+	go func() {
+		defer l.Close()
+		m := http.NewServeMux()
+		m.Handle("/metrics", legacyregistry.Handler()) //nolint, because azure cloud provider uses legacyregistry currently
+		if err := trapClosedConnErr(http.Serve(l, m)); err != nil {
+			klog.Fatalf("serve failure(%v), address(%v)", err, *metricsAddress)
+		}
+	}()
+}
+
+func trapClosedConnErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(err.Error(), "use of closed network connection") {
+		return nil
+	}
+	return err
+}
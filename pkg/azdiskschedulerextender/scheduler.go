@@ -0,0 +1,423 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	azdiskv1beta2 "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/azuredisk/v1beta2"
+	azdiskclientset "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/client/clientset/versioned"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+const (
+	apiPrefix      = "/azdiskschedulerextender"
+	filterPath     = apiPrefix + "/filter"
+	prioritizePath = apiPrefix + "/prioritize"
+	pingPath       = "/ping"
+
+	// azVolumeAttachmentVolumeIndex indexes AzVolumeAttachment objects by the name of their volume
+	azVolumeAttachmentVolumeIndex = "volumeName"
+)
+
+var requestLatency = metrics.NewHistogramVec(
+	&metrics.HistogramOpts{
+		Subsystem:      "azdiskschedulerextender",
+		Name:           "request_duration_seconds",
+		Help:           "Latency of scheduler extender requests by verb.",
+		Buckets:        metrics.ExponentialBuckets(0.001, 2, 12),
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"verb"},
+)
+
+func init() {
+	legacyregistry.MustRegister(requestLatency)
+}
+
+// extender scores nodes for pods using Azure disks. Nodes where the disks of a pod are already attached, as
+// attachment replicas or as leftovers of a previous attachment, are preferred, and unhealthy nodes are filtered out.
+type extender struct {
+	driverName       string
+	namespace        string
+	heartbeatTimeout time.Duration
+	now              func() time.Time
+
+	pvcLister                  corelisters.PersistentVolumeClaimLister
+	pvLister                   corelisters.PersistentVolumeLister
+	nodeLister                 corelisters.NodeLister
+	volumeAttachmentLister     storagelisters.VolumeAttachmentLister
+	azDriverNodeInformer       cache.SharedIndexInformer
+	azVolumeAttachmentInformer cache.SharedIndexInformer
+	informerFactory            informers.SharedInformerFactory
+	informersSynced            []cache.InformerSynced
+
+	// synced is set once the caches are populated; until then the extender does not influence scheduling
+	synced atomic.Bool
+}
+
+func newListWatch(list func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error), watchFunc func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return list(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return watchFunc(context.Background(), options)
+		},
+	}
+}
+
+func newExtender(informerFactory informers.SharedInformerFactory, azDiskClient azdiskclientset.Interface, driverName, namespace string, heartbeatTimeout time.Duration) *extender {
+	azDriverNodes := azDiskClient.DiskV1beta2().AzDriverNodes(namespace)
+	azDriverNodeInformer := cache.NewSharedIndexInformer(
+		newListWatch(
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return azDriverNodes.List(ctx, opts)
+			},
+			azDriverNodes.Watch,
+		),
+		&azdiskv1beta2.AzDriverNode{},
+		0,
+		cache.Indexers{},
+	)
+	azVolumeAttachments := azDiskClient.DiskV1beta2().AzVolumeAttachments(namespace)
+	azVolumeAttachmentInformer := cache.NewSharedIndexInformer(
+		newListWatch(
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return azVolumeAttachments.List(ctx, opts)
+			},
+			azVolumeAttachments.Watch,
+		),
+		&azdiskv1beta2.AzVolumeAttachment{},
+		0,
+		cache.Indexers{azVolumeAttachmentVolumeIndex: func(obj interface{}) ([]string, error) {
+			attachment, ok := obj.(*azdiskv1beta2.AzVolumeAttachment)
+			if !ok {
+				return nil, fmt.Errorf("object is not an AzVolumeAttachment: %#v", obj)
+			}
+			return []string{attachment.Spec.VolumeName}, nil
+		}},
+	)
+
+	pvcInformer := informerFactory.Core().V1().PersistentVolumeClaims()
+	pvInformer := informerFactory.Core().V1().PersistentVolumes()
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	volumeAttachmentInformer := informerFactory.Storage().V1().VolumeAttachments()
+
+	return &extender{
+		driverName:                 driverName,
+		namespace:                  namespace,
+		heartbeatTimeout:           heartbeatTimeout,
+		now:                        time.Now,
+		pvcLister:                  pvcInformer.Lister(),
+		pvLister:                   pvInformer.Lister(),
+		nodeLister:                 nodeInformer.Lister(),
+		volumeAttachmentLister:     volumeAttachmentInformer.Lister(),
+		azDriverNodeInformer:       azDriverNodeInformer,
+		azVolumeAttachmentInformer: azVolumeAttachmentInformer,
+		informerFactory:            informerFactory,
+		informersSynced: []cache.InformerSynced{
+			pvcInformer.Informer().HasSynced,
+			pvInformer.Informer().HasSynced,
+			nodeInformer.Informer().HasSynced,
+			volumeAttachmentInformer.Informer().HasSynced,
+			azDriverNodeInformer.HasSynced,
+			azVolumeAttachmentInformer.HasSynced,
+		},
+	}
+}
+
+// run starts the informers and waits for their caches to sync
+func (e *extender) run(ctx context.Context) error {
+	e.informerFactory.Start(ctx.Done())
+	go e.azDriverNodeInformer.Run(ctx.Done())
+	go e.azVolumeAttachmentInformer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), e.informersSynced...) {
+		return fmt.Errorf("failed to sync caches")
+	}
+	e.synced.Store(true)
+	klog.V(2).Infof("scheduler extender caches synced")
+	return nil
+}
+
+// getPodDiskNames returns the names of the Azure disks used by the bound persistent volumes of a pod
+func (e *extender) getPodDiskNames(pod *v1.Pod) []string {
+	diskNames := []string{}
+	for _, volume := range pod.Spec.Volumes {
+		var claimName string
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			claimName = volume.PersistentVolumeClaim.ClaimName
+		case volume.Ephemeral != nil:
+			claimName = pod.Name + "-" + volume.Name
+		default:
+			continue
+		}
+
+		pvc, err := e.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(claimName)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				klog.Warningf("failed to get PVC(%s/%s): %v", pod.Namespace, claimName, err)
+			}
+			continue
+		}
+		if pvc.Spec.VolumeName == "" {
+			// the volume will be provisioned after the pod is scheduled
+			continue
+		}
+		pv, err := e.pvLister.Get(pvc.Spec.VolumeName)
+		if err != nil {
+			klog.Warningf("failed to get PV(%s): %v", pvc.Spec.VolumeName, err)
+			continue
+		}
+
+		switch {
+		case pv.Spec.CSI != nil && pv.Spec.CSI.Driver == e.driverName:
+			diskName, err := azureutils.GetDiskName(pv.Spec.CSI.VolumeHandle)
+			if err != nil {
+				klog.Warningf("failed to get disk name of PV(%s): %v", pv.Name, err)
+				continue
+			}
+			diskNames = append(diskNames, diskName)
+		case pv.Spec.AzureDisk != nil:
+			diskNames = append(diskNames, pv.Spec.AzureDisk.DiskName)
+		}
+	}
+	return diskNames
+}
+
+// isNodeHealthy reports whether a node can accept pods with Azure disks. The AzDriverNode heartbeat is used
+// if the node plug-in registered one, and the Ready condition of the node otherwise.
+func (e *extender) isNodeHealthy(nodeName string) (bool, string) {
+	obj, exists, err := e.azDriverNodeInformer.GetIndexer().GetByKey(e.namespace + "/" + nodeName)
+	if err == nil && exists {
+		azDriverNode, ok := obj.(*azdiskv1beta2.AzDriverNode)
+		if ok {
+			status := azDriverNode.Status
+			switch {
+			case status == nil || status.LastHeartbeatTime == nil:
+				return false, "node plug-in has not reported its status"
+			case e.now().Sub(status.LastHeartbeatTime.Time) > e.heartbeatTimeout:
+				return false, fmt.Sprintf("node plug-in heartbeat is older than %v", e.heartbeatTimeout)
+			case status.ReadyForVolumeAllocation == nil || !*status.ReadyForVolumeAllocation:
+				message := "node plug-in is not ready for volume allocation"
+				if status.StatusMessage != nil {
+					message = fmt.Sprintf("%s: %s", message, *status.StatusMessage)
+				}
+				return false, message
+			}
+			return true, ""
+		}
+	}
+
+	node, err := e.nodeLister.Get(nodeName)
+	if err != nil {
+		// the node is unknown to the cache, leave the decision to the scheduler
+		return true, ""
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			if condition.Status != v1.ConditionTrue {
+				return false, "node is not ready"
+			}
+			return true, ""
+		}
+	}
+	return false, "node has not reported its readiness"
+}
+
+// getAttachedNodes returns the nodes a disk is attached to, as tracked by AzVolumeAttachment objects, and the nodes
+// with a VolumeAttachment of persistent volumes bound to the disk
+func (e *extender) getAttachedNodes(diskName string, volumeAttachments []*storagev1.VolumeAttachment) sets.Set[string] {
+	nodes := sets.New[string]()
+	objs, err := e.azVolumeAttachmentInformer.GetIndexer().ByIndex(azVolumeAttachmentVolumeIndex, diskName)
+	if err != nil {
+		klog.Warningf("failed to get AzVolumeAttachments of volume %s: %v", diskName, err)
+	}
+	for _, obj := range objs {
+		if attachment, ok := obj.(*azdiskv1beta2.AzVolumeAttachment); ok && attachment.Status.State == azdiskv1beta2.Attached {
+			nodes.Insert(attachment.Spec.NodeName)
+		}
+	}
+
+	for _, volumeAttachment := range volumeAttachments {
+		if volumeAttachment.Spec.Attacher != e.driverName || !volumeAttachment.Status.Attached || volumeAttachment.Spec.Source.PersistentVolumeName == nil {
+			continue
+		}
+		pv, err := e.pvLister.Get(*volumeAttachment.Spec.Source.PersistentVolumeName)
+		if err != nil || pv.Spec.CSI == nil {
+			continue
+		}
+		if name, err := azureutils.GetDiskName(pv.Spec.CSI.VolumeHandle); err == nil && name == diskName {
+			nodes.Insert(volumeAttachment.Spec.NodeName)
+		}
+	}
+	return nodes
+}
+
+func getNodeNames(args *ExtenderArgs) []string {
+	if args.NodeNames != nil {
+		return *args.NodeNames
+	}
+	names := []string{}
+	if args.Nodes != nil {
+		for _, node := range args.Nodes.Items {
+			names = append(names, node.Name)
+		}
+	}
+	return names
+}
+
+// filter removes unhealthy nodes for pods using Azure disks
+func (e *extender) filter(args *ExtenderArgs) *ExtenderFilterResult {
+	result := &ExtenderFilterResult{
+		Nodes:       args.Nodes,
+		NodeNames:   args.NodeNames,
+		FailedNodes: FailedNodesMap{},
+	}
+	if !e.synced.Load() || args.Pod == nil || len(e.getPodDiskNames(args.Pod)) == 0 {
+		return result
+	}
+
+	if args.NodeNames != nil {
+		nodeNames := []string{}
+		for _, nodeName := range *args.NodeNames {
+			if healthy, reason := e.isNodeHealthy(nodeName); healthy {
+				nodeNames = append(nodeNames, nodeName)
+			} else {
+				result.FailedNodes[nodeName] = reason
+			}
+		}
+		result.NodeNames = &nodeNames
+	} else if args.Nodes != nil {
+		nodes := &v1.NodeList{}
+		for _, node := range args.Nodes.Items {
+			if healthy, reason := e.isNodeHealthy(node.Name); healthy {
+				nodes.Items = append(nodes.Items, node)
+			} else {
+				result.FailedNodes[node.Name] = reason
+			}
+		}
+		result.Nodes = nodes
+	}
+	klog.V(5).Infof("filter pod(%s/%s): failed nodes %v", args.Pod.Namespace, args.Pod.Name, result.FailedNodes)
+	return result
+}
+
+// prioritize scores healthy nodes by the fraction of the disks of the pod already attached to them
+func (e *extender) prioritize(args *ExtenderArgs) HostPriorityList {
+	nodeNames := getNodeNames(args)
+	priorities := make(HostPriorityList, 0, len(nodeNames))
+	var diskNames []string
+	if e.synced.Load() && args.Pod != nil {
+		diskNames = e.getPodDiskNames(args.Pod)
+	}
+	if len(diskNames) == 0 {
+		for _, nodeName := range nodeNames {
+			priorities = append(priorities, HostPriority{Host: nodeName})
+		}
+		return priorities
+	}
+
+	volumeAttachments, err := e.volumeAttachmentLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("failed to list VolumeAttachments: %v", err)
+	}
+	attachedCount := map[string]int64{}
+	for _, diskName := range diskNames {
+		for nodeName := range e.getAttachedNodes(diskName, volumeAttachments) {
+			attachedCount[nodeName]++
+		}
+	}
+
+	for _, nodeName := range nodeNames {
+		score := int64(0)
+		if healthy, _ := e.isNodeHealthy(nodeName); healthy {
+			score = attachedCount[nodeName] * MaxExtenderPriority / int64(len(diskNames))
+		}
+		priorities = append(priorities, HostPriority{Host: nodeName, Score: score})
+	}
+	klog.V(5).Infof("prioritize pod(%s/%s): %v", args.Pod.Namespace, args.Pod.Name, priorities)
+	return priorities
+}
+
+func (e *extender) handleFilter(w http.ResponseWriter, r *http.Request) {
+	defer observeLatency("filter", time.Now())
+	var args ExtenderArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		writeResponse(w, http.StatusBadRequest, &ExtenderFilterResult{Error: err.Error()})
+		return
+	}
+	writeResponse(w, http.StatusOK, e.filter(&args))
+}
+
+func (e *extender) handlePrioritize(w http.ResponseWriter, r *http.Request) {
+	defer observeLatency("prioritize", time.Now())
+	var args ExtenderArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		writeResponse(w, http.StatusBadRequest, HostPriorityList{})
+		return
+	}
+	writeResponse(w, http.StatusOK, e.prioritize(&args))
+}
+
+func handlePing(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("pong"))
+}
+
+// newServeMux returns the handler serving the extender verbs and the health probe
+func (e *extender) newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(filterPath, e.handleFilter)
+	mux.HandleFunc(prioritizePath, e.handlePrioritize)
+	mux.HandleFunc(pingPath, handlePing)
+	return mux
+}
+
+func observeLatency(verb string, start time.Time) {
+	requestLatency.WithLabelValues(verb).Observe(time.Since(start).Seconds())
+}
+
+func writeResponse(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		klog.Errorf("failed to encode response: %v", err)
+	}
+}
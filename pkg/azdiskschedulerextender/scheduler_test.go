@@ -0,0 +1,322 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+
+	azdiskv1beta2 "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/azuredisk/v1beta2"
+	azdiskfake "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/client/clientset/versioned/fake"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const testNamespace = "default"
+
+var testNow = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func newNode(name string, ready v1.ConditionStatus) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}},
+		},
+	}
+}
+
+func newAzDriverNode(name string, heartbeat time.Time, ready bool) *azdiskv1beta2.AzDriverNode {
+	return &azdiskv1beta2.AzDriverNode{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: consts.DefaultAzureDiskCrdNamespace},
+		Spec:       azdiskv1beta2.AzDriverNodeSpec{NodeName: name},
+		Status: &azdiskv1beta2.AzDriverNodeStatus{
+			LastHeartbeatTime:        &metav1.Time{Time: heartbeat},
+			ReadyForVolumeAllocation: pointer.Bool(ready),
+		},
+	}
+}
+
+func newAzVolumeAttachment(diskName, nodeName string, role azdiskv1beta2.Role) *azdiskv1beta2.AzVolumeAttachment {
+	return &azdiskv1beta2.AzVolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: diskName + "-" + nodeName + "-attachment", Namespace: consts.DefaultAzureDiskCrdNamespace},
+		Spec: azdiskv1beta2.AzVolumeAttachmentSpec{
+			VolumeName:    diskName,
+			VolumeID:      diskURI(diskName),
+			NodeName:      nodeName,
+			RequestedRole: role,
+		},
+		Status: azdiskv1beta2.AzVolumeAttachmentStatus{State: azdiskv1beta2.Attached},
+	}
+}
+
+func diskURI(diskName string) string {
+	return "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/" + diskName
+}
+
+func newBoundClaim(claimName, diskName string) (*v1.PersistentVolumeClaim, *v1.PersistentVolume) {
+	pvName := "pv-" + diskName
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: claimName, Namespace: testNamespace},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: pvName},
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: consts.DefaultDriverName, VolumeHandle: diskURI(diskName)},
+			},
+		},
+	}
+	return pvc, pv
+}
+
+func newPod(claimNames ...string) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: testNamespace}}
+	for _, claimName := range claimNames {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+			Name:         claimName,
+			VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+		})
+	}
+	return pod
+}
+
+func newTestExtender(t *testing.T, kubeObjects []runtime.Object, azDiskObjects []runtime.Object) *extender {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	kubeClient := fake.NewSimpleClientset(kubeObjects...)
+	azDiskClient := azdiskfake.NewSimpleClientset(azDiskObjects...)
+	ext := newExtender(informers.NewSharedInformerFactory(kubeClient, 0), azDiskClient, consts.DefaultDriverName, consts.DefaultAzureDiskCrdNamespace, time.Minute)
+	ext.now = func() time.Time { return testNow }
+	require.NoError(t, ext.run(ctx))
+	return ext
+}
+
+func newTestObjects() ([]runtime.Object, []runtime.Object) {
+	pvc1, pv1 := newBoundClaim("claim1", "disk1")
+	pvc2, pv2 := newBoundClaim("claim2", "disk2")
+	pvc3 := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "unbound", Namespace: testNamespace}}
+	volumeAttachment := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "va-disk2-node3"},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: consts.DefaultDriverName,
+			NodeName: "node3",
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: pointer.String(pv2.Name)},
+		},
+		Status: storagev1.VolumeAttachmentStatus{Attached: true},
+	}
+	kubeObjects := []runtime.Object{
+		pvc1, pv1, pvc2, pv2, pvc3, volumeAttachment,
+		newNode("node1", v1.ConditionTrue),
+		newNode("node2", v1.ConditionTrue),
+		newNode("node3", v1.ConditionTrue),
+		newNode("node4", v1.ConditionFalse),
+		newNode("node5", v1.ConditionTrue),
+	}
+
+	// node1 holds the primary attachment of disk1 and node2 a replica of both disks; node3 is only known through
+	// the VolumeAttachment of disk2 and has no node plug-in registration, so its Node condition decides its health
+	azDiskObjects := []runtime.Object{
+		newAzDriverNode("node1", testNow.Add(-10*time.Second), true),
+		newAzDriverNode("node2", testNow.Add(-10*time.Second), true),
+		newAzDriverNode("node5", testNow.Add(-10*time.Minute), true),
+		newAzVolumeAttachment("disk1", "node1", azdiskv1beta2.PrimaryRole),
+		newAzVolumeAttachment("disk1", "node2", azdiskv1beta2.ReplicaRole),
+		newAzVolumeAttachment("disk2", "node2", azdiskv1beta2.ReplicaRole),
+	}
+	return kubeObjects, azDiskObjects
+}
+
+func TestGetPodDiskNames(t *testing.T) {
+	kubeObjects, azDiskObjects := newTestObjects()
+	ext := newTestExtender(t, kubeObjects, azDiskObjects)
+
+	tests := []struct {
+		desc     string
+		pod      *v1.Pod
+		expected []string
+	}{
+		{
+			desc:     "pod without volumes",
+			pod:      newPod(),
+			expected: []string{},
+		},
+		{
+			desc:     "bound claims",
+			pod:      newPod("claim1", "claim2"),
+			expected: []string{"disk1", "disk2"},
+		},
+		{
+			desc:     "unbound and missing claims are skipped",
+			pod:      newPod("unbound", "missing", "claim2"),
+			expected: []string{"disk2"},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, ext.getPodDiskNames(test.pod), test.desc)
+	}
+}
+
+func TestIsNodeHealthy(t *testing.T) {
+	kubeObjects, azDiskObjects := newTestObjects()
+	azDiskObjects = append(azDiskObjects, newAzDriverNode("node6", testNow, false))
+	ext := newTestExtender(t, kubeObjects, azDiskObjects)
+
+	tests := []struct {
+		nodeName string
+		expected bool
+	}{
+		{nodeName: "node1", expected: true},
+		{nodeName: "node3", expected: true},
+		{nodeName: "node4", expected: false},
+		{nodeName: "node5", expected: false},
+		{nodeName: "node6", expected: false},
+		{nodeName: "unknown", expected: true},
+	}
+	for _, test := range tests {
+		healthy, reason := ext.isNodeHealthy(test.nodeName)
+		assert.Equal(t, test.expected, healthy, test.nodeName)
+		assert.Equal(t, test.expected, reason == "", test.nodeName)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	kubeObjects, azDiskObjects := newTestObjects()
+	ext := newTestExtender(t, kubeObjects, azDiskObjects)
+	nodeNames := []string{"node1", "node2", "node3", "node4", "node5"}
+
+	result := ext.filter(&ExtenderArgs{Pod: newPod("claim1"), NodeNames: &nodeNames})
+	require.NotNil(t, result.NodeNames)
+	assert.Equal(t, []string{"node1", "node2", "node3"}, *result.NodeNames)
+	assert.Len(t, result.FailedNodes, 2)
+	assert.Contains(t, result.FailedNodes, "node4")
+	assert.Contains(t, result.FailedNodes, "node5")
+
+	nodes := &v1.NodeList{Items: []v1.Node{*newNode("node1", v1.ConditionTrue), *newNode("node4", v1.ConditionFalse)}}
+	result = ext.filter(&ExtenderArgs{Pod: newPod("claim1"), Nodes: nodes})
+	require.NotNil(t, result.Nodes)
+	require.Len(t, result.Nodes.Items, 1)
+	assert.Equal(t, "node1", result.Nodes.Items[0].Name)
+	assert.Contains(t, result.FailedNodes, "node4")
+
+	// pods without Azure disks are not filtered
+	result = ext.filter(&ExtenderArgs{Pod: newPod(), NodeNames: &nodeNames})
+	assert.Equal(t, nodeNames, *result.NodeNames)
+	assert.Empty(t, result.FailedNodes)
+}
+
+func TestPrioritize(t *testing.T) {
+	kubeObjects, azDiskObjects := newTestObjects()
+	ext := newTestExtender(t, kubeObjects, azDiskObjects)
+	nodeNames := []string{"node1", "node2", "node3", "node4", "node5"}
+
+	tests := []struct {
+		desc     string
+		pod      *v1.Pod
+		expected HostPriorityList
+	}{
+		{
+			desc: "single disk",
+			pod:  newPod("claim1"),
+			expected: HostPriorityList{
+				{Host: "node1", Score: MaxExtenderPriority},
+				{Host: "node2", Score: MaxExtenderPriority},
+				{Host: "node3"},
+				{Host: "node4"},
+				{Host: "node5"},
+			},
+		},
+		{
+			desc: "multiple disks",
+			pod:  newPod("claim1", "claim2"),
+			expected: HostPriorityList{
+				{Host: "node1", Score: MaxExtenderPriority / 2},
+				{Host: "node2", Score: MaxExtenderPriority},
+				{Host: "node3", Score: MaxExtenderPriority / 2},
+				{Host: "node4"},
+				{Host: "node5"},
+			},
+		},
+		{
+			desc: "no disks",
+			pod:  newPod(),
+			expected: HostPriorityList{
+				{Host: "node1"},
+				{Host: "node2"},
+				{Host: "node3"},
+				{Host: "node4"},
+				{Host: "node5"},
+			},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, ext.prioritize(&ExtenderArgs{Pod: test.pod, NodeNames: &nodeNames}), test.desc)
+	}
+}
+
+func TestServeMux(t *testing.T) {
+	kubeObjects, azDiskObjects := newTestObjects()
+	ext := newTestExtender(t, kubeObjects, azDiskObjects)
+	server := httptest.NewServer(ext.newServeMux())
+	defer server.Close()
+
+	nodeNames := []string{"node1", "node4"}
+	body, err := json.Marshal(&ExtenderArgs{Pod: newPod("claim1"), NodeNames: &nodeNames})
+	require.NoError(t, err)
+
+	resp, err := http.Post(server.URL+filterPath, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var filterResult ExtenderFilterResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&filterResult))
+	assert.Equal(t, []string{"node1"}, *filterResult.NodeNames)
+
+	resp, err = http.Post(server.URL+prioritizePath, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var priorities HostPriorityList
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&priorities))
+	assert.Equal(t, HostPriorityList{{Host: "node1", Score: MaxExtenderPriority}, {Host: "node4"}}, priorities)
+
+	resp, err = http.Post(server.URL+filterPath, "application/json", bytes.NewReader([]byte("{")))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + pingPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -23,7 +23,9 @@ import (
 const (
 	AzureDiskCSIDriverName            = "azuredisk_csi_driver"
 	CachingModeField                  = "cachingmode"
+	ContentSourceTypeField            = "contentsourcetype"
 	DefaultAzureCredentialFileEnv     = "AZURE_CREDENTIAL_FILE"
+	DefaultAzureDiskCrdNamespace      = "azure-disk-csi"
 	DefaultCredFilePathLinux          = "/etc/kubernetes/azure.json"
 	DefaultCredFilePathWindows        = "C:\\k\\azure.json"
 	DefaultDriverName                 = "disk.csi.azure.com"
@@ -33,15 +35,29 @@ const (
 	DiskIOPSReadWriteField            = "diskiopsreadwrite"
 	DiskMBPSReadWriteField            = "diskmbpsreadwrite"
 	DiskNameField                     = "diskname"
+	DiskSizeGBField                   = "disksizegb"
+	DiskUniqueIDField                 = "diskuniqueid"
 	EnableBurstingField               = "enablebursting"
 	ErrDiskNotFound                   = "not found"
 	FsTypeField                       = "fstype"
+	FormatOptionsField                = "formatoptions"
+	FsckPolicyField                   = "fsckpolicy"
+	FsckPolicyCheck                   = "check"
+	FsckPolicyNone                    = "none"
+	FsckPolicyRepair                  = "repair"
 	IncrementalField                  = "incremental"
 	KindField                         = "kind"
 	LocationField                     = "location"
+	LocalCacheField                   = "localcache"
+	LocalCacheDMCache                 = "dm-cache"
+	LocalCacheModeField               = "localcachemode"
+	LocalCacheModeWritethrough        = "writethrough"
+	LocalCacheNone                    = "none"
+	LocalCacheSizeField               = "localcachesize"
 	LogicalSectorSizeField            = "logicalsectorsize"
 	LUN                               = "LUN"
 	MaxSharesField                    = "maxshares"
+	MaxMountReplicaCountField         = "maxmountreplicacount"
 	MinimumDiskSizeGiB                = 1
 	NetworkAccessPolicyField          = "networkaccesspolicy"
 	PublicNetworkAccessField          = "publicnetworkaccess"
@@ -70,7 +86,12 @@ const (
 	SourceVolume                      = "volume"
 	StandardSsdAccountPrefix          = "standardssd"
 	StorageAccountTypeField           = "storageaccounttype"
+	StripeCountField                  = "stripecount"
+	MaxStripeCount                    = 8
+	StripedVolumeIDSeparator          = ","
 	TagsField                         = "tags"
+	TrimScheduleField                 = "trimschedule"
+	TrimScheduleNone                  = "none"
 	GetDiskThrottlingKey              = "getdiskthrottlingKey"
 	CheckDiskLunThrottlingKey         = "checkdisklunthrottlingKey"
 	TrueValue                         = "true"
@@ -101,6 +122,15 @@ const (
 	AgentNotReadyNodeTaintKeySuffix = "/agent-not-ready"
 	// define tag value delimiter and default is comma
 	TagValueDelimiterField = "tagValueDelimiter"
+
+	// keys of the provisioner and snapshotter secrets that scope controller operations to other credentials,
+	// the cloud config key holds a complete cloud config, the other keys override the cloud config of the driver
+	SecretCloudConfigField     = "cloud-config"
+	SecretTenantIDField        = "tenantId"
+	SecretSubscriptionIDField  = "subscriptionId"
+	SecretResourceGroupField   = "resourceGroup"
+	SecretAADClientIDField     = "aadClientId"
+	SecretAADClientSecretField = "aadClientSecret"
 )
 
 var (
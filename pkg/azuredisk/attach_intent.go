@@ -0,0 +1,547 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/retry"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	"sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	attachIntentOperation = "attach"
+	detachIntentOperation = "detach"
+
+	attachIntentDriverLabel = "disk.csi.azure.com/attach-intents"
+	attachIntentNodeKey     = "node"
+	attachIntentIntentsKey  = "intents"
+
+	// every controller process renews an owner lease while it runs, the intents of a process are only reconciled
+	// once its owner lease expired, or once they are older than attachIntentGracePeriod if the process has no owner
+	// lease, e.g. a process of a previous version of the driver
+	attachIntentOwnerLeaseDuration = 40 * time.Second
+	attachIntentOwnerRenewInterval = 10 * time.Second
+	attachIntentGracePeriod        = 10 * time.Minute
+	attachIntentReconcileInterval  = time.Minute
+	attachIntentLeaseRetryPeriod   = 2 * time.Second
+)
+
+// attachIntent is an attach or detach of a disk that was requested on a VM and has not completed yet
+type attachIntent struct {
+	Operation string           `json:"operation"`
+	DiskURI   string           `json:"diskURI"`
+	DiskName  string           `json:"diskName"`
+	Lun       int32            `json:"lun,omitempty"`
+	Owner     string           `json:"owner"`
+	Created   metav1.MicroTime `json:"created"`
+}
+
+// attachIntentStore persists the attach and detach intents of each node in a ConfigMap before the VM update
+// is issued, so that a batch interrupted by a controller restart is completed or rolled back on startup
+type attachIntentStore struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	driverName string
+	// owner identifies the process of the controller recording intents, it is the holder of its owner lease and its
+	// identity in the intents lease
+	owner string
+}
+
+func newAttachIntentStore(kubeClient kubernetes.Interface, namespace, driverName, owner string) *attachIntentStore {
+	return &attachIntentStore{
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		driverName: driverName,
+		owner:      owner,
+	}
+}
+
+// ownerLeaseName returns the name of the owner lease of the process owner
+func (s *attachIntentStore) ownerLeaseName(owner string) string {
+	hash := sha256.Sum256([]byte(owner))
+	return fmt.Sprintf("%s-intents-owner-%x", strings.ReplaceAll(s.driverName, ".", "-"), hash[:8])
+}
+
+// renewOwnerLease creates or renews the owner lease of this process
+func (s *attachIntentStore) renewOwnerLease(ctx context.Context) error {
+	name := s.ownerLeaseName(s.owner)
+	now := metav1.NowMicro()
+	lease, err := s.kubeClient.CoordinationV1().Leases(s.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: s.namespace,
+				Labels:    map[string]string{attachIntentDriverLabel: s.driverName},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.owner,
+				LeaseDurationSeconds: pointer.Int32(int32(attachIntentOwnerLeaseDuration / time.Second)),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = s.kubeClient.CoordinationV1().Leases(s.namespace).Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.RenewTime = &now
+	_, err = s.kubeClient.CoordinationV1().Leases(s.namespace).Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// runOwnerLease renews the owner lease of this process until ctx is done. The lease is left to expire, the intents
+// of this process are reconciled once it expired.
+func (s *attachIntentStore) runOwnerLease(ctx context.Context) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.renewOwnerLease(ctx); err != nil {
+			klog.Warningf("failed to renew attach intents owner lease %s/%s: %v", s.namespace, s.ownerLeaseName(s.owner), err)
+		}
+	}, attachIntentOwnerRenewInterval)
+}
+
+// listOwnerLeases returns the owner leases of the controller processes by owner
+func (s *attachIntentStore) listOwnerLeases(ctx context.Context) (map[string]*coordinationv1.Lease, error) {
+	leases, err := s.kubeClient.CoordinationV1().Leases(s.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", attachIntentDriverLabel, s.driverName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ownerLeases := make(map[string]*coordinationv1.Lease, len(leases.Items))
+	for i := range leases.Items {
+		if holder := leases.Items[i].Spec.HolderIdentity; holder != nil && *holder != "" {
+			ownerLeases[*holder] = &leases.Items[i]
+		}
+	}
+	return ownerLeases, nil
+}
+
+// isOwnerLeaseExpired returns whether the owner lease was not renewed within its duration
+func isOwnerLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// deleteExpiredOwnerLeases deletes the expired owner leases of the processes without intents left
+func (s *attachIntentStore) deleteExpiredOwnerLeases(ctx context.Context, ownerLeases map[string]*coordinationv1.Lease, nodeIntents map[string][]attachIntent) {
+	owners := map[string]bool{}
+	for _, intents := range nodeIntents {
+		for _, intent := range intents {
+			owners[intent.Owner] = true
+		}
+	}
+	now := time.Now()
+	for owner, lease := range ownerLeases {
+		if owner == s.owner || owners[owner] || !isOwnerLeaseExpired(lease, now) {
+			continue
+		}
+		err := s.kubeClient.CoordinationV1().Leases(s.namespace).Delete(ctx, lease.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+		})
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			klog.Warningf("failed to delete expired attach intents owner lease %s/%s: %v", s.namespace, lease.Name, err)
+		}
+	}
+}
+
+// configMapName returns the name of the ConfigMap holding the intents of node
+func (s *attachIntentStore) configMapName(node string) string {
+	return fmt.Sprintf("%s-intents-%s", strings.ReplaceAll(s.driverName, ".", "-"), strings.ToLower(node))
+}
+
+// record adds intents to the intents of node
+func (s *attachIntentStore) record(ctx context.Context, node string, intents []attachIntent) error {
+	if s == nil || len(intents) == 0 {
+		return nil
+	}
+	now := metav1.NowMicro()
+	for i := range intents {
+		intents[i].Owner = s.owner
+		intents[i].Created = now
+	}
+	return s.update(ctx, node, func(current []attachIntent) []attachIntent {
+		for _, intent := range intents {
+			current = append(removeAttachIntents(current, intent.DiskURI), intent)
+		}
+		return current
+	})
+}
+
+// complete removes the intents of node for diskURIs, the ConfigMap is deleted once node has no intents left
+func (s *attachIntentStore) complete(ctx context.Context, node string, diskURIs ...string) error {
+	if s == nil || len(diskURIs) == 0 {
+		return nil
+	}
+	return s.update(ctx, node, func(current []attachIntent) []attachIntent {
+		return removeAttachIntents(current, diskURIs...)
+	})
+}
+
+func (s *attachIntentStore) update(ctx context.Context, node string, mutate func([]attachIntent) []attachIntent) error {
+	name := s.configMapName(node)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			intents := mutate(nil)
+			if len(intents) == 0 {
+				return nil
+			}
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: s.namespace,
+					Labels:    map[string]string{attachIntentDriverLabel: s.driverName},
+				},
+				Data: map[string]string{attachIntentNodeKey: node},
+			}
+			if err := setAttachIntents(cm, intents); err != nil {
+				return err
+			}
+			_, err = s.kubeClient.CoreV1().ConfigMaps(s.namespace).Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(v1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		current, err := getAttachIntents(cm)
+		if err != nil {
+			return err
+		}
+		intents := mutate(current)
+		if len(intents) == 0 {
+			err = s.kubeClient.CoreV1().ConfigMaps(s.namespace).Delete(ctx, name, metav1.DeleteOptions{
+				Preconditions: &metav1.Preconditions{ResourceVersion: &cm.ResourceVersion},
+			})
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if err := setAttachIntents(cm, intents); err != nil {
+			return err
+		}
+		_, err = s.kubeClient.CoreV1().ConfigMaps(s.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// list returns the intents of every node <node, intents>
+func (s *attachIntentStore) list(ctx context.Context) (map[string][]attachIntent, error) {
+	cms, err := s.kubeClient.CoreV1().ConfigMaps(s.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", attachIntentDriverLabel, s.driverName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	nodeIntents := make(map[string][]attachIntent, len(cms.Items))
+	for i := range cms.Items {
+		intents, err := getAttachIntents(&cms.Items[i])
+		if err != nil {
+			klog.Warningf("skip invalid attach intents in ConfigMap %s/%s: %v", s.namespace, cms.Items[i].Name, err)
+			continue
+		}
+		if node := cms.Items[i].Data[attachIntentNodeKey]; node != "" && len(intents) > 0 {
+			nodeIntents[node] = intents
+		}
+	}
+	return nodeIntents, nil
+}
+
+// isStale returns whether intent is no longer in flight on any controller process: the owner lease of the process
+// that recorded it expired, or it is older than attachIntentGracePeriod if the process has no owner lease
+func (s *attachIntentStore) isStale(intent attachIntent, ownerLeases map[string]*coordinationv1.Lease, now time.Time) bool {
+	if intent.Owner == s.owner {
+		return false
+	}
+	if lease, ok := ownerLeases[intent.Owner]; ok {
+		return isOwnerLeaseExpired(lease, now)
+	}
+	return now.Sub(intent.Created.Time) > attachIntentGracePeriod
+}
+
+func getAttachIntents(cm *v1.ConfigMap) ([]attachIntent, error) {
+	var intents []attachIntent
+	if data := cm.Data[attachIntentIntentsKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &intents); err != nil {
+			return nil, err
+		}
+	}
+	return intents, nil
+}
+
+func setAttachIntents(cm *v1.ConfigMap, intents []attachIntent) error {
+	data, err := json.Marshal(intents)
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[attachIntentIntentsKey] = string(data)
+	return nil
+}
+
+func removeAttachIntents(intents []attachIntent, diskURIs ...string) []attachIntent {
+	result := intents[:0:0]
+	for _, intent := range intents {
+		removed := false
+		for _, diskURI := range diskURIs {
+			if strings.EqualFold(intent.DiskURI, diskURI) {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			result = append(result, intent)
+		}
+	}
+	return result
+}
+
+func diskMapKeys[V any](diskMap map[string]V) []string {
+	diskURIs := make([]string, 0, len(diskMap))
+	for diskURI := range diskMap {
+		diskURIs = append(diskURIs, diskURI)
+	}
+	return diskURIs
+}
+
+// recordAttachIntents persists the attach intents of a batch before the VM update is issued. The attach goes on if
+// the intents cannot be persisted, it is then only reconciled if the controller restarts after it.
+func (c *controllerCommon) recordAttachIntents(ctx context.Context, node string, diskMap map[string]*provider.AttachDiskOptions) {
+	if c.intentStore == nil {
+		return
+	}
+	intents := make([]attachIntent, 0, len(diskMap))
+	for diskURI, options := range diskMap {
+		intents = append(intents, attachIntent{Operation: attachIntentOperation, DiskURI: diskURI, DiskName: options.DiskName, Lun: options.Lun})
+	}
+	if err := c.intentStore.record(ctx, node, intents); err != nil {
+		klog.Warningf("failed to record attach intents of disks(%v) on node(%s): %v", diskMapKeys(diskMap), node, err)
+	}
+}
+
+// recordDetachIntents persists the detach intents of a batch before the VM update is issued, the detach goes on if
+// the intents cannot be persisted
+func (c *controllerCommon) recordDetachIntents(ctx context.Context, node string, diskMap map[string]string) {
+	if c.intentStore == nil {
+		return
+	}
+	intents := make([]attachIntent, 0, len(diskMap))
+	for diskURI, diskName := range diskMap {
+		intents = append(intents, attachIntent{Operation: detachIntentOperation, DiskURI: diskURI, DiskName: diskName})
+	}
+	if err := c.intentStore.record(ctx, node, intents); err != nil {
+		klog.Warningf("failed to record detach intents of disks(%v) on node(%s): %v", diskMapKeys(diskMap), node, err)
+	}
+}
+
+// completeIntents removes the intents of a batch once the VM update returned, whatever its result, since the
+// state of the VM is known to the caller again. Intents left behind are reconciled by reconcileAttachIntents.
+func (c *controllerCommon) completeIntents(ctx context.Context, node string, diskURIs []string) {
+	if c.intentStore == nil {
+		return
+	}
+	if err := c.intentStore.complete(context.WithoutCancel(ctx), node, diskURIs...); err != nil {
+		klog.Warningf("failed to complete intents of disks(%v) on node(%s): %v", diskURIs, node, err)
+	}
+}
+
+// reconcileAttachIntents completes or rolls back the stale intents of every node against its data disks.
+// An attach intent is completed if the disk is on the VM, recovering the VM if its update failed and detaching
+// the disk again if the recovery fails too. A detach intent is completed by detaching the disk if it is still on the VM.
+func (c *controllerCommon) reconcileAttachIntents(ctx context.Context) error {
+	if c.intentStore == nil {
+		return nil
+	}
+	// the owner leases are listed before the intents, so that the intents of a process that started in between are
+	// not reconciled before its first renewal
+	ownerLeases, err := c.intentStore.listOwnerLeases(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list attach intents owner leases: %w", err)
+	}
+	nodeIntents, err := c.intentStore.list(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list attach intents: %w", err)
+	}
+	defer c.intentStore.deleteExpiredOwnerLeases(ctx, ownerLeases, nodeIntents)
+
+	now := time.Now()
+	var errs []error
+	for node, intents := range nodeIntents {
+		var stale []attachIntent
+		for _, intent := range intents {
+			if c.intentStore.isStale(intent, ownerLeases, now) {
+				stale = append(stale, intent)
+			}
+		}
+		if len(stale) == 0 {
+			continue
+		}
+		if err := c.reconcileNodeIntents(ctx, node, stale); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *controllerCommon) reconcileNodeIntents(ctx context.Context, node string, intents []attachIntent) error {
+	nodeName := types.NodeName(node)
+	c.lockMap.LockEntry(node)
+	defer c.lockMap.UnlockEntry(node)
+
+	diskURIs := make([]string, 0, len(intents))
+	for _, intent := range intents {
+		diskURIs = append(diskURIs, intent.DiskURI)
+	}
+	vmset, err := c.getCloud().GetNodeVMSet(nodeName, azcache.CacheReadTypeForceRefresh)
+	if err != nil {
+		return err
+	}
+	dataDisks, vmState, err := vmset.GetDataDisks(nodeName, azcache.CacheReadTypeForceRefresh)
+	if err != nil {
+		if errors.Is(err, cloudprovider.InstanceNotFound) {
+			klog.Warningf("node(%s) not found, drop attach intents of disks(%v)", node, diskURIs)
+			return c.intentStore.complete(ctx, node, diskURIs...)
+		}
+		return fmt.Errorf("failed to get data disks of node(%s): %w", node, err)
+	}
+	attached := map[string]bool{}
+	for _, disk := range dataDisks {
+		if disk != nil && disk.ManagedDisk != nil && disk.ManagedDisk.ID != nil {
+			attached[strings.ToLower(*disk.ManagedDisk.ID)] = true
+		}
+	}
+
+	failed := map[string]string{}
+	detach := map[string]string{}
+	for _, intent := range intents {
+		onVM := attached[strings.ToLower(intent.DiskURI)]
+		switch {
+		case intent.Operation == attachIntentOperation && onVM:
+			if vmState != nil && strings.ToLower(*vmState) == "failed" {
+				failed[intent.DiskURI] = intent.DiskName
+			} else {
+				klog.V(2).Infof("attach intent of disk(%s) on node(%s) is completed", intent.DiskURI, node)
+			}
+		case intent.Operation == attachIntentOperation:
+			klog.V(2).Infof("attach intent of disk(%s) on node(%s) is rolled back, disk is not on the VM", intent.DiskURI, node)
+		case intent.Operation == detachIntentOperation && onVM:
+			detach[intent.DiskURI] = intent.DiskName
+		default:
+			klog.V(2).Infof("detach intent of disk(%s) on node(%s) is completed", intent.DiskURI, node)
+		}
+	}
+
+	defer func() {
+		_ = vmset.DeleteCacheForNode(node)
+	}()
+	if len(failed) > 0 {
+		klog.V(2).Infof("node(%s) is in failed state with pending attach of disks(%v), update VM to complete the attach", node, failed)
+		if err := vmset.UpdateVM(ctx, nodeName); err != nil {
+			klog.Warningf("failed to update node(%s), roll back attach of disks(%v): %v", node, failed, err)
+			for diskURI, diskName := range failed {
+				detach[diskURI] = diskName
+			}
+		}
+	}
+	if len(detach) > 0 {
+		klog.V(2).Infof("detach disks(%v) from node(%s) to complete pending intents", detach, node)
+		if err := vmset.DetachDisk(ctx, nodeName, detach, false); err != nil {
+			return fmt.Errorf("failed to detach disks(%v) from node(%s): %w", detach, node, err)
+		}
+	}
+	return c.intentStore.complete(ctx, node, diskURIs...)
+}
+
+// runAttachIntentReconciler renews the owner lease of this controller process and reconciles the stale attach
+// intents while this process holds the intents lease, starting right after the lease is acquired. The intents lease
+// only elects the process reconciling intents, every process attaches and detaches disks and owns its intents.
+func (d *DriverCore) runAttachIntentReconciler(ctx context.Context) {
+	store := d.attachIntentStore
+	go store.runOwnerLease(ctx)
+	lockName := strings.ReplaceAll(d.Name, ".", "-") + "-attach-intents"
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		store.namespace,
+		lockName,
+		d.kubeClient.CoreV1(),
+		d.kubeClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: store.owner},
+	)
+	if err != nil {
+		klog.Errorf("failed to create attach intents lock: %v", err)
+		return
+	}
+	// campaign for the lease again after losing it until ctx is done
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaderElectionLeaseDuration,
+			RenewDeadline:   leaderElectionRenewDeadline,
+			RetryPeriod:     attachIntentLeaseRetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            lockName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.V(2).Infof("acquired lease %s/%s, reconciling attach intents", store.namespace, lockName)
+					wait.UntilWithContext(ctx, func(ctx context.Context) {
+						diskController := d.getDiskController()
+						if diskController == nil {
+							return
+						}
+						if err := diskController.reconcileAttachIntents(ctx); err != nil {
+							klog.Errorf("failed to reconcile attach intents: %v", err)
+						}
+					}, attachIntentReconcileInterval)
+				},
+				OnStoppedLeading: func() {
+					klog.V(2).Infof("lost lease %s/%s", store.namespace, lockName)
+				},
+			},
+		})
+	}, attachIntentLeaseRetryPeriod)
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/utils/pointer"
+
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/vmclient/mockvmclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+func TestAttachIntentStore(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset()
+	store := newAttachIntentStore(kubeClient, "kube-system", "disk.csi.azure.com", "controller-0")
+
+	require.NoError(t, store.record(ctx, "VM1", []attachIntent{
+		{Operation: attachIntentOperation, DiskURI: "disk-a", DiskName: "a", Lun: 1},
+		{Operation: attachIntentOperation, DiskURI: "disk-b", DiskName: "b", Lun: 2},
+	}))
+	require.NoError(t, store.record(ctx, "VM1", []attachIntent{{Operation: detachIntentOperation, DiskURI: "disk-a", DiskName: "a"}}))
+
+	cm, err := kubeClient.CoreV1().ConfigMaps("kube-system").Get(ctx, "disk-csi-azure-com-intents-vm1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "disk.csi.azure.com", cm.Labels[attachIntentDriverLabel])
+
+	nodeIntents, err := store.list(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodeIntents["VM1"], 2)
+	assert.Equal(t, "disk-b", nodeIntents["VM1"][0].DiskURI)
+	assert.Equal(t, int32(2), nodeIntents["VM1"][0].Lun)
+	assert.Equal(t, detachIntentOperation, nodeIntents["VM1"][1].Operation, "a later intent replaces the intent of the same disk")
+	assert.Equal(t, "controller-0", nodeIntents["VM1"][1].Owner)
+
+	require.NoError(t, store.complete(ctx, "VM1", "DISK-A"))
+	nodeIntents, err = store.list(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodeIntents["VM1"], 1)
+
+	require.NoError(t, store.complete(ctx, "VM1", "disk-b"))
+	_, err = kubeClient.CoreV1().ConfigMaps("kube-system").Get(ctx, "disk-csi-azure-com-intents-vm1", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "ConfigMap must be deleted once the node has no intents")
+	require.NoError(t, store.complete(ctx, "VM1", "disk-b"))
+
+	var nilStore *attachIntentStore
+	assert.NoError(t, nilStore.record(ctx, "vm1", []attachIntent{{DiskURI: "disk-a"}}))
+	assert.NoError(t, nilStore.complete(ctx, "vm1", "disk-a"))
+}
+
+// newOwnerLease returns the owner lease of owner last renewed at renewTime
+func newOwnerLease(store *attachIntentStore, owner string, renewTime time.Time) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      store.ownerLeaseName(owner),
+			Namespace: store.namespace,
+			Labels:    map[string]string{attachIntentDriverLabel: store.driverName},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       pointer.String(owner),
+			LeaseDurationSeconds: pointer.Int32(int32(attachIntentOwnerLeaseDuration / time.Second)),
+			RenewTime:            &metav1.MicroTime{Time: renewTime},
+		},
+	}
+}
+
+func TestAttachIntentIsStale(t *testing.T) {
+	store := newAttachIntentStore(fake.NewSimpleClientset(), "kube-system", "disk.csi.azure.com", "controller-0")
+	now := time.Now()
+	recent := metav1.NewMicroTime(now)
+	old := metav1.NewMicroTime(now.Add(-2 * attachIntentGracePeriod))
+	ownerLeases := map[string]*coordinationv1.Lease{
+		"controller-live":    newOwnerLease(store, "controller-live", now),
+		"controller-expired": newOwnerLease(store, "controller-expired", now.Add(-2*attachIntentOwnerLeaseDuration)),
+	}
+
+	assert.False(t, store.isStale(attachIntent{Owner: "controller-0", Created: old}, ownerLeases, now), "intents of this process are in flight")
+	assert.False(t, store.isStale(attachIntent{Owner: "controller-live", Created: old}, ownerLeases, now), "intents of a live process are in flight")
+	assert.True(t, store.isStale(attachIntent{Owner: "controller-expired", Created: recent}, ownerLeases, now), "intents of a process with an expired owner lease are stale")
+	assert.False(t, store.isStale(attachIntent{Owner: "controller-legacy", Created: recent}, ownerLeases, now))
+	assert.True(t, store.isStale(attachIntent{Owner: "controller-legacy", Created: old}, ownerLeases, now), "intents of a process without owner lease are stale after the grace period")
+}
+
+func TestAttachIntentOwnerLease(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := fake.NewSimpleClientset()
+	store := newAttachIntentStore(kubeClient, "kube-system", "disk.csi.azure.com", "controller-0")
+
+	require.NoError(t, store.renewOwnerLease(ctx))
+	lease, err := kubeClient.CoordinationV1().Leases("kube-system").Get(ctx, store.ownerLeaseName("controller-0"), metav1.GetOptions{})
+	require.NoError(t, err)
+	firstRenewTime := lease.Spec.RenewTime.Time
+	require.NoError(t, store.renewOwnerLease(ctx))
+	lease, err = kubeClient.CoordinationV1().Leases("kube-system").Get(ctx, store.ownerLeaseName("controller-0"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, lease.Spec.RenewTime.Time.Before(firstRenewTime))
+	assert.False(t, isOwnerLeaseExpired(lease, time.Now()))
+
+	expired := time.Now().Add(-2 * attachIntentOwnerLeaseDuration)
+	for _, owner := range []string{"controller-expired", "controller-expired-with-intents"} {
+		_, err := kubeClient.CoordinationV1().Leases("kube-system").Create(ctx, newOwnerLease(store, owner, expired), metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	ownerLeases, err := store.listOwnerLeases(ctx)
+	require.NoError(t, err)
+	assert.Len(t, ownerLeases, 3)
+
+	store.deleteExpiredOwnerLeases(ctx, ownerLeases, map[string][]attachIntent{"vm1": {{Owner: "controller-expired-with-intents"}}})
+	ownerLeases, err = store.listOwnerLeases(ctx)
+	require.NoError(t, err)
+	assert.Len(t, ownerLeases, 2, "only the expired owner lease of a process without intents is deleted")
+	assert.NotContains(t, ownerLeases, "controller-expired")
+}
+
+func TestReconcileAttachIntents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	testCloud := provider.GetTestCloud(ctrl)
+	diskURI := func(name string) string {
+		return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/%s", testCloud.SubscriptionID, testCloud.ResourceGroup, name)
+	}
+	vm := compute.VirtualMachine{
+		Name:     pointer.String("vm1"),
+		ID:       pointer.String("/subscriptions/subscription/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"),
+		Location: &testCloud.Location,
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			ProvisioningState: pointer.String("Succeeded"),
+			StorageProfile: &compute.StorageProfile{
+				DataDisks: &[]compute.DataDisk{
+					{Lun: pointer.Int32(0), Name: pointer.String("attached"), ManagedDisk: &compute.ManagedDiskParameters{ID: pointer.String(diskURI("attached"))}},
+					{Lun: pointer.Int32(1), Name: pointer.String("detaching"), ManagedDisk: &compute.ManagedDiskParameters{ID: pointer.String(diskURI("detaching"))}},
+				},
+			},
+		},
+	}
+	mockVMsClient := testCloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, "vm1", gomock.Any()).Return(vm, nil).AnyTimes()
+	mockVMsClient.EXPECT().Update(gomock.Any(), testCloud.ResourceGroup, "vm1", gomock.Any(), "detach_disk").Return(nil, nil).Times(1)
+
+	kubeClient := fake.NewSimpleClientset()
+	store := newAttachIntentStore(kubeClient, "kube-system", "disk.csi.azure.com", "controller-0")
+	common := &controllerCommon{
+		cloud:       testCloud,
+		lockMap:     newLockMap(),
+		intentStore: store,
+	}
+
+	previousRun := &attachIntentStore{kubeClient: kubeClient, namespace: "kube-system", driverName: "disk.csi.azure.com", owner: "controller-prev"}
+	require.NoError(t, previousRun.record(ctx, "vm1", []attachIntent{
+		{Operation: attachIntentOperation, DiskURI: diskURI("attached"), DiskName: "attached"},
+		{Operation: attachIntentOperation, DiskURI: diskURI("not-attached"), DiskName: "not-attached"},
+		{Operation: detachIntentOperation, DiskURI: diskURI("detaching"), DiskName: "detaching"},
+		{Operation: detachIntentOperation, DiskURI: diskURI("detached"), DiskName: "detached"},
+	}))
+	// the process of the previous run stopped renewing its owner lease while another process is live
+	_, err := kubeClient.CoordinationV1().Leases("kube-system").Create(ctx, newOwnerLease(store, "controller-prev", time.Now().Add(-2*attachIntentOwnerLeaseDuration)), metav1.CreateOptions{})
+	require.NoError(t, err)
+	inFlight := &attachIntentStore{kubeClient: kubeClient, namespace: "kube-system", driverName: "disk.csi.azure.com", owner: "controller-1"}
+	require.NoError(t, inFlight.renewOwnerLease(ctx))
+	require.NoError(t, inFlight.record(ctx, "vm1", []attachIntent{{Operation: attachIntentOperation, DiskURI: diskURI("in-flight"), DiskName: "in-flight"}}))
+
+	require.NoError(t, common.reconcileAttachIntents(ctx))
+
+	nodeIntents, err := store.list(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodeIntents["vm1"], 1, "only the intent in flight on a live process is kept")
+	assert.Equal(t, diskURI("in-flight"), nodeIntents["vm1"][0].DiskURI)
+
+	// the expired owner lease of the previous run is deleted on the next pass since it has no intents left
+	require.NoError(t, common.reconcileAttachIntents(ctx))
+	ownerLeases, err := store.listOwnerLeases(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, ownerLeases, "controller-prev")
+	assert.Contains(t, ownerLeases, "controller-1")
+}
+
+func TestAttachDiskRecordsIntents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	testCloud := provider.GetTestCloud(ctrl)
+	kubeClient := fake.NewSimpleClientset()
+	common := &controllerCommon{
+		cloud:               testCloud,
+		lockMap:             newLockMap(),
+		DisableDiskLunCheck: true,
+		intentStore:         newAttachIntentStore(kubeClient, "kube-system", "disk.csi.azure.com", "controller-0"),
+	}
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/disk-name", testCloud.SubscriptionID, testCloud.ResourceGroup)
+	expectedVMs := setTestVirtualMachines(testCloud, map[string]string{"vm1": "PowerState/Running"}, false)
+	mockVMsClient := testCloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, "vm1", gomock.Any()).Return(expectedVMs[0], nil).AnyTimes()
+	mockVMsClient.EXPECT().UpdateAsync(gomock.Any(), testCloud.ResourceGroup, "vm1", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, resourceGroup, nodeName string, parameters compute.VirtualMachineUpdate, source string) (*azure.Future, *retry.Error) {
+			nodeIntents, err := common.intentStore.list(ctx)
+			assert.NoError(t, err)
+			if assert.Len(t, nodeIntents["vm1"], 1, "intent must be persisted before the VM update") {
+				assert.Equal(t, attachIntentOperation, nodeIntents["vm1"][0].Operation)
+			}
+			return fakeUpdateAsync(200)(ctx, resourceGroup, nodeName, parameters, source)
+		}).Times(1)
+	mockVMsClient.EXPECT().WaitForUpdateResult(gomock.Any(), gomock.Any(), testCloud.ResourceGroup, gomock.Any()).Return(nil, nil).MaxTimes(1)
+
+	_, err := common.AttachDisk(ctx, "disk-name", diskURI, "vm1", armcompute.CachingTypesReadOnly, nil, nil)
+	require.NoError(t, err)
+
+	nodeIntents, err := common.intentStore.list(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, nodeIntents, "intents must be completed once the VM update returned")
+}
+
+func TestAttachDiskIntentRecordFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	testCloud := provider.GetTestCloud(ctrl)
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("create configmap failed")
+	})
+	common := &controllerCommon{
+		cloud:               testCloud,
+		lockMap:             newLockMap(),
+		DisableDiskLunCheck: true,
+		intentStore:         newAttachIntentStore(kubeClient, "kube-system", "disk.csi.azure.com", "controller-0"),
+	}
+	diskURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/disks/disk-name", testCloud.SubscriptionID, testCloud.ResourceGroup)
+	expectedVMs := setTestVirtualMachines(testCloud, map[string]string{"vm1": "PowerState/Running"}, false)
+	mockVMsClient := testCloud.VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), testCloud.ResourceGroup, "vm1", gomock.Any()).Return(expectedVMs[0], nil).AnyTimes()
+	mockVMsClient.EXPECT().UpdateAsync(gomock.Any(), testCloud.ResourceGroup, "vm1", gomock.Any(), gomock.Any()).
+		DoAndReturn(fakeUpdateAsync(200)).Times(1)
+	mockVMsClient.EXPECT().WaitForUpdateResult(gomock.Any(), gomock.Any(), testCloud.ResourceGroup, gomock.Any()).Return(nil, nil).MaxTimes(1)
+
+	_, err := common.AttachDisk(ctx, "disk-name", diskURI, "vm1", armcompute.CachingTypesReadOnly, nil, nil)
+	assert.NoError(t, err, "a failure to record the intents must not fail the attach")
+}
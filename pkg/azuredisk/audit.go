@@ -0,0 +1,42 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/audit"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// withAuditRequestInfo attaches the identity of a CSI request to ctx so that every mutating Azure call
+// made on its behalf is recorded with the CSI method, request name and PV/PVC identity.
+// PV/PVC identity is taken from the parameters injected by the external-provisioner with --extra-create-metadata.
+// ctx is returned unchanged when auditing is disabled.
+func (d *DriverCore) withAuditRequestInfo(ctx context.Context, csiMethod, requestName string, parameters map[string]string) context.Context {
+	if d.auditSink == nil {
+		return ctx
+	}
+	return audit.WithRequestInfo(ctx, audit.RequestInfo{
+		CSIMethod:    csiMethod,
+		RequestName:  requestName,
+		PVName:       parameters[consts.PvNameKey],
+		PVCName:      parameters[consts.PvcNameKey],
+		PVCNamespace: parameters[consts.PvcNamespaceKey],
+		Parameters:   parameters,
+	})
+}
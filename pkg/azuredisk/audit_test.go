@@ -0,0 +1,59 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/audit"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestWithAuditRequestInfo(t *testing.T) {
+	parameters := map[string]string{
+		consts.PvNameKey:       "pv",
+		consts.PvcNameKey:      "pvc",
+		consts.PvcNamespaceKey: "default",
+		"skuName":              "Premium_LRS",
+	}
+	d := &DriverCore{}
+	ctx := d.withAuditRequestInfo(context.Background(), "CreateVolume", "pvc-123", parameters)
+	_, ok := audit.RequestInfoFromContext(ctx)
+	assert.False(t, ok)
+
+	d.auditSink = audit.NewWriterSink(io.Discard)
+	ctx = d.withAuditRequestInfo(context.Background(), "CreateVolume", "pvc-123", parameters)
+	info, ok := audit.RequestInfoFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, audit.RequestInfo{
+		CSIMethod:    "CreateVolume",
+		RequestName:  "pvc-123",
+		PVName:       "pv",
+		PVCName:      "pvc",
+		PVCNamespace: "default",
+		Parameters:   parameters,
+	}, info)
+
+	ctx = d.withAuditRequestInfo(context.Background(), "DeleteVolume", "diskURI", nil)
+	info, ok = audit.RequestInfoFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, audit.RequestInfo{CSIMethod: "DeleteVolume", RequestName: "diskURI"}, info)
+}
@@ -0,0 +1,162 @@
+//go:build azurediskv2
+// +build azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	azdiskclientset "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/client/clientset/versioned"
+)
+
+// azDriverNodeGCPeriod is the interval at which all AzDriverNode objects are checked against the nodes in the cluster,
+// covering nodes deleted while the controller plug-in was not running
+const azDriverNodeGCPeriod = 5 * time.Minute
+
+// azDriverNodeGarbageCollector deletes AzDriverNode objects whose node no longer exists in the cluster
+type azDriverNodeGarbageCollector struct {
+	azDiskClient azdiskclientset.Interface
+	namespace    string
+	nodeLister   corelisters.NodeLister
+	nodesSynced  cache.InformerSynced
+	queue        workqueue.RateLimitingInterface
+	resyncPeriod time.Duration
+}
+
+func newAzDriverNodeGarbageCollector(azDiskClient azdiskclientset.Interface, namespace string, nodeInformer coreinformers.NodeInformer, resyncPeriod time.Duration) *azDriverNodeGarbageCollector {
+	gc := &azDriverNodeGarbageCollector{
+		azDiskClient: azDiskClient,
+		namespace:    namespace,
+		nodeLister:   nodeInformer.Lister(),
+		nodesSynced:  nodeInformer.Informer().HasSynced,
+		queue:        workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: "azdrivernode"}),
+		resyncPeriod: resyncPeriod,
+	}
+	_, _ = nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: gc.onNodeDelete,
+	})
+	return gc
+}
+
+func (gc *azDriverNodeGarbageCollector) onNodeDelete(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			klog.Errorf("couldn't get object from tombstone %#v", obj)
+			return
+		}
+		if node, ok = tombstone.Obj.(*v1.Node); !ok {
+			klog.Errorf("tombstone contained object that is not a Node %#v", obj)
+			return
+		}
+	}
+	// AzDriverNode objects are named after their node
+	gc.queue.Add(node.Name)
+}
+
+// Run waits for the node cache to sync and deletes orphaned AzDriverNode objects until ctx is done
+func (gc *azDriverNodeGarbageCollector) Run(ctx context.Context) {
+	defer gc.queue.ShutDown()
+
+	klog.V(2).Infof("starting AzDriverNode garbage collector")
+	if !cache.WaitForCacheSync(ctx.Done(), gc.nodesSynced) {
+		klog.Errorf("failed to sync node cache for AzDriverNode garbage collector")
+		return
+	}
+
+	go wait.UntilWithContext(ctx, gc.enqueueOrphans, gc.resyncPeriod)
+	go wait.UntilWithContext(ctx, gc.runWorker, time.Second)
+
+	<-ctx.Done()
+	klog.V(2).Infof("stopping AzDriverNode garbage collector")
+}
+
+// enqueueOrphans enqueues every AzDriverNode whose node cannot be found
+func (gc *azDriverNodeGarbageCollector) enqueueOrphans(ctx context.Context) {
+	azDriverNodes, err := gc.azDiskClient.DiskV1beta2().AzDriverNodes(gc.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("failed to list AzDriverNodes in namespace(%s): %v", gc.namespace, err)
+		return
+	}
+	for _, azDriverNode := range azDriverNodes.Items {
+		if _, err := gc.nodeLister.Get(azDriverNode.Spec.NodeName); apierrors.IsNotFound(err) {
+			gc.queue.Add(azDriverNode.Name)
+		}
+	}
+}
+
+func (gc *azDriverNodeGarbageCollector) runWorker(ctx context.Context) {
+	for gc.processNextItem(ctx) {
+	}
+}
+
+func (gc *azDriverNodeGarbageCollector) processNextItem(ctx context.Context) bool {
+	key, quit := gc.queue.Get()
+	if quit {
+		return false
+	}
+	defer gc.queue.Done(key)
+
+	if err := gc.reconcile(ctx, key.(string)); err != nil {
+		klog.Errorf("failed to garbage collect AzDriverNode(%s): %v", key, err)
+		gc.queue.AddRateLimited(key)
+		return true
+	}
+	gc.queue.Forget(key)
+	return true
+}
+
+// reconcile deletes the AzDriverNode named name if its node no longer exists
+func (gc *azDriverNodeGarbageCollector) reconcile(ctx context.Context, name string) error {
+	azDriverNode, err := gc.azDiskClient.DiskV1beta2().AzDriverNodes(gc.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = gc.nodeLister.Get(azDriverNode.Spec.NodeName)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get node(%s): %v", azDriverNode.Spec.NodeName, err)
+	}
+
+	klog.V(2).Infof("deleting AzDriverNode(%s) since node(%s) no longer exists", name, azDriverNode.Spec.NodeName)
+	err = gc.azDiskClient.DiskV1beta2().AzDriverNodes(gc.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+//go:build azurediskv2
+// +build azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	azdiskv1beta2 "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/azuredisk/v1beta2"
+)
+
+const (
+	deviceDiscoverySucceededReason = "DeviceDiscoverySucceeded"
+	deviceDiscoveryFailedReason    = "DeviceDiscoveryFailed"
+)
+
+// registerAzDriverNode creates the AzDriverNode representing this node if it does not exist yet
+func (d *DriverV2) registerAzDriverNode(ctx context.Context) (*azdiskv1beta2.AzDriverNode, error) {
+	azDriverNodes := d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace)
+	azDriverNode, err := azDriverNodes.Get(ctx, d.NodeID, metav1.GetOptions{})
+	if err == nil {
+		return azDriverNode, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get AzDriverNode(%s): %v", d.NodeID, err)
+	}
+
+	klog.V(2).Infof("creating AzDriverNode(%s) in namespace(%s)", d.NodeID, d.objectNamespace)
+	azDriverNode, err = azDriverNodes.Create(ctx, &azdiskv1beta2.AzDriverNode{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      d.NodeID,
+			Namespace: d.objectNamespace,
+		},
+		Spec: azdiskv1beta2.AzDriverNodeSpec{
+			NodeName: d.NodeID,
+		},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return azDriverNodes.Get(ctx, d.NodeID, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AzDriverNode(%s): %v", d.NodeID, err)
+	}
+	return azDriverNode, nil
+}
+
+// getAzDriverNodeStatus returns the current health of the node plug-in.
+// The node is ready for volume allocation only if attached data disks can be discovered.
+func (d *DriverV2) getAzDriverNodeStatus(previous *azdiskv1beta2.AzDriverNodeStatus) *azdiskv1beta2.AzDriverNodeStatus {
+	now := metav1.Now()
+	condition := azdiskv1beta2.AzDriverCondition{
+		Type:               azdiskv1beta2.AzDriverConditionDeviceDiscovery,
+		Status:             metav1.ConditionTrue,
+		LastHeartbeatTime:  &now,
+		LastTransitionTime: &now,
+		Reason:             deviceDiscoverySucceededReason,
+	}
+
+	luns, err := listLUNsInUse(d.ioHandler)
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = deviceDiscoveryFailedReason
+		condition.Message = err.Error()
+	}
+
+	if previous != nil {
+		for _, c := range previous.Conditions {
+			if c.Type == condition.Type && c.Status == condition.Status && c.LastTransitionTime != nil {
+				condition.LastTransitionTime = c.LastTransitionTime
+			}
+		}
+	}
+
+	ready := condition.Status == metav1.ConditionTrue
+	message := "Driver node healthy."
+	if !ready {
+		message = fmt.Sprintf("Device discovery failed: %s", condition.Message)
+	}
+
+	return &azdiskv1beta2.AzDriverNodeStatus{
+		LastHeartbeatTime:        &now,
+		ReadyForVolumeAllocation: &ready,
+		StatusMessage:            &message,
+		LUNsInUse:                luns,
+		Conditions:               []azdiskv1beta2.AzDriverCondition{condition},
+	}
+}
+
+// updateAzDriverNodeHeartbeat refreshes the status of the AzDriverNode, recreating it if it was removed
+func (d *DriverV2) updateAzDriverNodeHeartbeat(ctx context.Context) error {
+	azDriverNode, err := d.registerAzDriverNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	updated := azDriverNode.DeepCopy()
+	updated.Status = d.getAzDriverNodeStatus(azDriverNode.Status)
+	if _, err = d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace).UpdateStatus(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update status of AzDriverNode(%s): %v", d.NodeID, err)
+	}
+	klog.V(6).Infof("updated heartbeat of AzDriverNode(%s), ready: %v, LUNs in use: %v", d.NodeID, *updated.Status.ReadyForVolumeAllocation, updated.Status.LUNsInUse)
+	return nil
+}
+
+// runAzDriverNodeHeartbeat periodically updates the heartbeat of the AzDriverNode until ctx is done
+func (d *DriverV2) runAzDriverNodeHeartbeat(ctx context.Context) {
+	klog.V(2).Infof("starting AzDriverNode(%s) heartbeat every %v", d.NodeID, d.heartbeatFrequency)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := d.updateAzDriverNodeHeartbeat(ctx); err != nil {
+			klog.Errorf("AzDriverNode heartbeat failed: %v", err)
+		}
+	}, d.heartbeatFrequency)
+}
@@ -0,0 +1,149 @@
+//go:build azurediskv2
+// +build azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	azdiskv1beta2 "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/azuredisk/v1beta2"
+	azdiskfake "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/client/clientset/versioned/fake"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+type failingIOHandler struct{}
+
+func (failingIOHandler) ReadDir(dirname string) ([]os.DirEntry, error) {
+	return nil, fmt.Errorf("read %s failed", dirname)
+}
+
+func (failingIOHandler) WriteFile(_ string, _ []byte, _ os.FileMode) error {
+	return fmt.Errorf("write failed")
+}
+
+func (failingIOHandler) Readlink(name string) (string, error) {
+	return "", fmt.Errorf("readlink %s failed", name)
+}
+
+func (failingIOHandler) ReadFile(filename string) ([]byte, error) {
+	return nil, fmt.Errorf("read %s failed", filename)
+}
+
+func TestUpdateAzDriverNodeHeartbeat(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV2(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, d.updateAzDriverNodeHeartbeat(ctx))
+	azDriverNode, err := d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace).Get(ctx, d.NodeID, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, d.NodeID, azDriverNode.Spec.NodeName)
+	require.NotNil(t, azDriverNode.Status)
+	assert.True(t, *azDriverNode.Status.ReadyForVolumeAllocation)
+	assert.Equal(t, []int32{0, 1}, azDriverNode.Status.LUNsInUse)
+	require.Len(t, azDriverNode.Status.Conditions, 1)
+	condition := azDriverNode.Status.Conditions[0]
+	assert.Equal(t, azdiskv1beta2.AzDriverConditionDeviceDiscovery, condition.Type)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+
+	// the transition time is kept while the condition does not change
+	transitionTime := metav1.NewTime(time.Now().Add(-time.Hour))
+	azDriverNode.Status.Conditions[0].LastTransitionTime = &transitionTime
+	_, err = d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace).UpdateStatus(ctx, azDriverNode, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, d.updateAzDriverNodeHeartbeat(ctx))
+	azDriverNode, err = d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace).Get(ctx, d.NodeID, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, transitionTime.Equal(azDriverNode.Status.Conditions[0].LastTransitionTime))
+
+	// the node is no longer ready once device discovery fails
+	d.ioHandler = failingIOHandler{}
+	require.NoError(t, d.updateAzDriverNodeHeartbeat(ctx))
+	azDriverNode, err = d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace).Get(ctx, d.NodeID, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.False(t, *azDriverNode.Status.ReadyForVolumeAllocation)
+	assert.Empty(t, azDriverNode.Status.LUNsInUse)
+	assert.Equal(t, metav1.ConditionFalse, azDriverNode.Status.Conditions[0].Status)
+	assert.Equal(t, deviceDiscoveryFailedReason, azDriverNode.Status.Conditions[0].Reason)
+	assert.False(t, transitionTime.Equal(azDriverNode.Status.Conditions[0].LastTransitionTime))
+
+	// the AzDriverNode is recreated if it was deleted
+	require.NoError(t, d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace).Delete(ctx, d.NodeID, metav1.DeleteOptions{}))
+	require.NoError(t, d.updateAzDriverNodeHeartbeat(ctx))
+	_, err = d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace).Get(ctx, d.NodeID, metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestAzDriverNodeGarbageCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newAzDriverNode := func(name string) *azdiskv1beta2.AzDriverNode {
+		return &azdiskv1beta2.AzDriverNode{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: consts.DefaultAzureDiskCrdNamespace},
+			Spec:       azdiskv1beta2.AzDriverNodeSpec{NodeName: name},
+		}
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	kubeClient := fake.NewSimpleClientset(node)
+	azDiskClient := azdiskfake.NewSimpleClientset(newAzDriverNode("node1"), newAzDriverNode("node2"))
+
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	gc := newAzDriverNodeGarbageCollector(azDiskClient, consts.DefaultAzureDiskCrdNamespace, informerFactory.Core().V1().Nodes(), azDriverNodeGCPeriod)
+	informerFactory.Start(ctx.Done())
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), gc.nodesSynced))
+
+	gc.enqueueOrphans(ctx)
+	assert.Equal(t, 1, gc.queue.Len())
+	assert.True(t, gc.processNextItem(ctx))
+
+	azDriverNodes := azDiskClient.DiskV1beta2().AzDriverNodes(consts.DefaultAzureDiskCrdNamespace)
+	_, err := azDriverNodes.Get(ctx, "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	_, err = azDriverNodes.Get(ctx, "node2", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// deleting a node enqueues its AzDriverNode
+	require.NoError(t, kubeClient.CoreV1().Nodes().Delete(ctx, "node1", metav1.DeleteOptions{}))
+	require.Eventually(t, func() bool {
+		return gc.queue.Len() == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.True(t, gc.processNextItem(ctx))
+	_, err = azDriverNodes.Get(ctx, "node1", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// reconciling an unknown AzDriverNode is a no-op
+	assert.NoError(t, gc.reconcile(ctx, "unknown"))
+}
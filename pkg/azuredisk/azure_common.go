@@ -17,7 +17,10 @@ limitations under the License.
 package azuredisk
 
 import (
+	"path"
 	"strings"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 )
 
 func strFirstLetterToUpper(str string) string {
@@ -26,3 +29,32 @@ func strFirstLetterToUpper(str string) string {
 	}
 	return strings.ToUpper(string(str[0])) + str[1:]
 }
+
+// getPartitionPath returns the path of partition of the disk at source. The partitions of the udev links of the data
+// disks, e.g. /dev/disk/azure/scsi1/lun0, are linked with a -part<N> suffix. The partitions of the disk devices are
+// named by the kernel with a <N> suffix, e.g. /dev/sdc1, or a p<N> suffix if the disk name ends with a digit, e.g.
+// /dev/nvme0n2p1.
+func getPartitionPath(source, partition string) string {
+	if path.Dir(source) != "/dev" {
+		return source + "-part" + partition
+	}
+	if last := source[len(source)-1]; last >= '0' && last <= '9' {
+		return source + "p" + partition
+	}
+	return source + partition
+}
+
+// getFormatOptions returns the mkfs arguments of the formatOptions in the volume context. The logical sector size is
+// taken from the publish context, which reflects the attached disk, and falls back to the volume context.
+func getFormatOptions(fstype string, volumeContext, publishContext map[string]string) ([]string, error) {
+	logicalSectorSize, err := azureutils.GetLogicalSectorSize(publishContext)
+	if err != nil {
+		return nil, err
+	}
+	if logicalSectorSize == 0 {
+		if logicalSectorSize, err = azureutils.GetLogicalSectorSize(volumeContext); err != nil {
+			return nil, err
+		}
+	}
+	return azureutils.ParseFormatOptions(fstype, azureutils.GetFormatOptions(volumeContext), logicalSectorSize)
+}
@@ -27,6 +27,7 @@ import (
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
@@ -38,7 +39,99 @@ const sysClassBlockPath = "/sys/class/block/"
 func scsiHostRescan(io azureutils.IOHandler, m *mount.SafeFormatAndMount) {
 }
 
-func formatAndMount(source, target, fstype string, options []string, m *mount.SafeFormatAndMount) error {
+func checkFilesystem(source, fstype string, repair bool, m *mount.SafeFormatAndMount) (string, error) {
+	return "", fmt.Errorf("checkFilesystem not implemented")
+}
+
+func trimFilesystem(target string, m *mount.SafeFormatAndMount) (int64, error) {
+	return 0, fmt.Errorf("trimFilesystem not implemented")
+}
+
+func assembleStripedVolume(vgName string, devices []string, m *mount.SafeFormatAndMount) (string, error) {
+	return "", fmt.Errorf("assembleStripedVolume not implemented")
+}
+
+func growStripedVolume(vgName string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("growStripedVolume not implemented")
+}
+
+func deactivateStripedVolume(vgName string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("deactivateStripedVolume not implemented")
+}
+
+func setupLocalCache(cacheDevice, name, origin string, sizeBytes int64, m *mount.SafeFormatAndMount) (string, error) {
+	return "", fmt.Errorf("setupLocalCache not implemented")
+}
+
+func teardownLocalCache(name string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("teardownLocalCache not implemented")
+}
+
+func resizeLocalCache(name string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("resizeLocalCache not implemented")
+}
+
+func getLocalCacheStats(name string, m *mount.SafeFormatAndMount) (localCacheStats, error) {
+	return localCacheStats{}, fmt.Errorf("getLocalCacheStats not implemented")
+}
+
+func getFilesystemUUID(source string, m *mount.SafeFormatAndMount) (string, string, error) {
+	return "", "", fmt.Errorf("getFilesystemUUID not implemented")
+}
+
+func listMountedFilesystemUUIDs(m *mount.SafeFormatAndMount) (sets.Set[string], error) {
+	return nil, fmt.Errorf("listMountedFilesystemUUIDs not implemented")
+}
+
+func regenerateFilesystemUUID(source, fstype string, repair bool, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("regenerateFilesystemUUID not implemented")
+}
+
+func setVolumeMountGroup(path string, gid int) error {
+	return fmt.Errorf("setVolumeMountGroup not implemented")
+}
+
+func listBlockDevices(io azureutils.IOHandler) ([]string, error) {
+	return nil, fmt.Errorf("listBlockDevices not implemented")
+}
+
+func getDataDiskLUN(io azureutils.IOHandler, devName string) (int, error) {
+	return 0, fmt.Errorf("getDataDiskLUN not implemented")
+}
+
+func getDataDiskPath(io azureutils.IOHandler, lun int, devName string) string {
+	return ""
+}
+
+func getBlockDeviceSizeBytes(io azureutils.IOHandler, devName string) (int64, error) {
+	return 0, fmt.Errorf("getBlockDeviceSizeBytes not implemented")
+}
+
+func getDataDiskIdentifiers(io azureutils.IOHandler, devName string) ([]string, error) {
+	return nil, fmt.Errorf("getDataDiskIdentifiers not implemented")
+}
+
+func rescanDataDiskLUN(io azureutils.IOHandler, lun int) error {
+	return fmt.Errorf("rescanDataDiskLUN not implemented")
+}
+
+func watchBlockDeviceEvents(ctx context.Context, onEvent func(map[string]string)) error {
+	return fmt.Errorf("watchBlockDeviceEvents not implemented")
+}
+
+func listDataDiskSCSIDevices(io azureutils.IOHandler, devicePath string) []string {
+	return nil
+}
+
+func getSCSIDeviceIdentity(io azureutils.IOHandler, devName string) (string, error) {
+	return "", fmt.Errorf("getSCSIDeviceIdentity not implemented")
+}
+
+func deleteSCSIDevice(io azureutils.IOHandler, devName, identity string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("deleteSCSIDevice not implemented")
+}
+
+func formatAndMount(source, target, fstype string, options, formatOptions []string, m *mount.SafeFormatAndMount) error {
 	return nil
 }
 
@@ -46,6 +139,10 @@ func findDiskByLun(lun int, io azureutils.IOHandler, m *mount.SafeFormatAndMount
 	return "", fmt.Errorf("findDiskByLun not implemented")
 }
 
+func listLUNsInUse(io azureutils.IOHandler) ([]int32, error) {
+	return nil, fmt.Errorf("listLUNsInUse not implemented")
+}
+
 func preparePublishPath(path string, m *mount.SafeFormatAndMount) error {
 	return nil
 }
@@ -92,6 +189,10 @@ func needResizeVolume(devicePath, volumePath string, m *mount.SafeFormatAndMount
 	return false, nil
 }
 
+func growPartition(io azureutils.IOHandler, devicePath string, m *mount.SafeFormatAndMount) error {
+	return nil
+}
+
 // rescanVolume rescan device for detecting device size expansion
 // devicePath e.g. `/dev/sdc`
 func rescanVolume(io azureutils.IOHandler, devicePath string) error {
@@ -24,19 +24,42 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume"
 	mount "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 )
 
-const sysClassBlockPath = "/sys/class/block/"
+const (
+	sysClassBlockPath = "/sys/class/block/"
+	sysClassNVMePath  = "/sys/class/nvme/"
+	// azureNVMeDiskModel is the model of the NVMe controller exposing the OS and data disks on VM sizes with the NVMe
+	// disk controller, the local disks are exposed by other controllers
+	azureNVMeDiskModel = "MSFT NVMe Accelerator"
+	// nvmeDataDiskNamespaceOffset is the namespace id of the data disk at LUN 0, the OS disk is namespace 1
+	nvmeDataDiskNamespaceOffset = 2
+	// azureNVMeDataDiskLinkPath holds the links of the NVMe data disks by LUN created by the Azure udev rules, e.g.
+	// 0 -> ../../../../nvme0n2, their partitions are linked as 0-part1
+	azureNVMeDataDiskLinkPath = "/dev/disk/azure/data/by-lun/"
+)
+
+// nvmeNamespaceRE matches NVMe namespaces and their partitions, e.g. nvme0n2 or nvme0n2p1
+var nvmeNamespaceRE = regexp.MustCompile(`^(nvme\d+)n\d+(p\d+)?$`)
+
+var fstrimOutputRE = regexp.MustCompile(`\((\d+) bytes\) trimmed`)
 
 // exclude those used by azure as resource and OS root in /dev/disk/azure, /dev/disk/azure/scsi0
 // "/dev/disk/azure/scsi0" dir is populated in Standard_DC4s/DC2s on Ubuntu 18.04
@@ -81,6 +104,7 @@ func getDiskLinkByDevName(io azureutils.IOHandler, devLinkPath, devName string)
 	return "", fmt.Errorf("read %s error: %v", devLinkPath, err)
 }
 
+// scsiHostRescan rescans the SCSI hosts and the Azure NVMe controllers to discover the data disks attached to the node
 func scsiHostRescan(io azureutils.IOHandler, _ *mount.SafeFormatAndMount) {
 	scsiPath := "/sys/class/scsi_host/"
 	if dirs, err := io.ReadDir(scsiPath); err == nil {
@@ -94,15 +118,820 @@ func scsiHostRescan(io azureutils.IOHandler, _ *mount.SafeFormatAndMount) {
 	} else {
 		klog.Warningf("failed to read %s, err %v", scsiPath, err)
 	}
+	for _, controller := range listAzureNVMeControllers(io) {
+		if err := rescanNVMeController(io, controller); err != nil {
+			klog.Warningf("failed to rescan nvme controller %s: %v", controller, err)
+		}
+	}
 }
 
 func findDiskByLun(lun int, io azureutils.IOHandler, _ *mount.SafeFormatAndMount) (string, error) {
 	azureDisks := listAzureDiskPath(io)
-	return findDiskByLunWithConstraint(lun, io, azureDisks)
+	diskPath, err := findDiskByLunWithConstraint(lun, io, azureDisks)
+	if err != nil || diskPath != "" {
+		return diskPath, err
+	}
+	// data disks are NVMe namespaces on VM sizes with the NVMe disk controller
+	if devName, ok := listNVMeDataDisks(io)[lun]; ok {
+		klog.V(4).Infof("azureDisk - found nvme namespace %s for lun %d", devName, lun)
+		return getDataDiskPath(io, lun, devName), nil
+	}
+	return "", nil
+}
+
+// getDataDiskPath returns the udev link of the data disk devName, e.g. sdc or nvme0n2, at lun, so that the partitions
+// of the disk are found with a -part<N> suffix, or /dev/<devName> if the disk is not linked
+func getDataDiskPath(io azureutils.IOHandler, lun int, devName string) string {
+	if nvmeNamespaceRE.MatchString(devName) {
+		diskPath := filepath.Join(azureNVMeDataDiskLinkPath, strconv.Itoa(lun))
+		if link, err := io.Readlink(diskPath); err == nil && filepath.Base(link) == devName {
+			return diskPath
+		}
+		return "/dev/" + devName
+	}
+	for _, devLinkPath := range []string{"/dev/disk/azure/scsi1/", "/dev/disk/by-id/"} {
+		if diskPath, err := getDiskLinkByDevName(io, devLinkPath, devName); err == nil {
+			klog.V(4).Infof("azureDisk - found %s by %s under %s", diskPath, devName, devLinkPath)
+			return diskPath
+		}
+	}
+	return "/dev/" + devName
+}
+
+// listAzureNVMeControllers returns the names of the NVMe controllers exposing the data disks, e.g. nvme0
+func listAzureNVMeControllers(io azureutils.IOHandler) []string {
+	var controllers []string
+	dirs, err := io.ReadDir(sysClassNVMePath)
+	if err != nil {
+		klog.V(6).Infof("no nvme controller under %s: %v", sysClassNVMePath, err)
+		return controllers
+	}
+	for _, f := range dirs {
+		model, err := io.ReadFile(filepath.Join(sysClassNVMePath, f.Name(), "model"))
+		if err != nil {
+			klog.Warningf("failed to read model of nvme controller %s: %v", f.Name(), err)
+			continue
+		}
+		if !strings.HasPrefix(strings.TrimSpace(string(model)), azureNVMeDiskModel) {
+			klog.V(6).Infof("skip nvme controller %s of model %s", f.Name(), strings.TrimSpace(string(model)))
+			continue
+		}
+		controllers = append(controllers, f.Name())
+	}
+	return controllers
+}
+
+// listNVMeDataDisks returns the device names of the data disks exposed as namespaces of the Azure NVMe controllers by
+// LUN, the LUN of a data disk is its namespace id minus nvmeDataDiskNamespaceOffset
+func listNVMeDataDisks(io azureutils.IOHandler) map[int]string {
+	disks := map[int]string{}
+	for _, controller := range listAzureNVMeControllers(io) {
+		controllerPath := filepath.Join(sysClassNVMePath, controller)
+		dirs, err := io.ReadDir(controllerPath)
+		if err != nil {
+			klog.Warningf("failed to read %s: %v", controllerPath, err)
+			continue
+		}
+		for _, f := range dirs {
+			match := nvmeNamespaceRE.FindStringSubmatch(f.Name())
+			if match == nil || match[1] != controller || match[2] != "" {
+				continue
+			}
+			nsidBytes, err := io.ReadFile(filepath.Join(controllerPath, f.Name(), "nsid"))
+			if err != nil {
+				klog.Warningf("failed to read namespace id of %s: %v", f.Name(), err)
+				continue
+			}
+			nsid, err := strconv.Atoi(strings.TrimSpace(string(nsidBytes)))
+			if err != nil || nsid < nvmeDataDiskNamespaceOffset {
+				continue
+			}
+			disks[nsid-nvmeDataDiskNamespaceOffset] = f.Name()
+		}
+	}
+	return disks
+}
+
+// rescanNVMeController rescans the namespaces of the NVMe controller, e.g. nvme0, and their sizes
+func rescanNVMeController(io azureutils.IOHandler, controller string) error {
+	return io.WriteFile(filepath.Join(sysClassNVMePath, controller, "rescan_controller"), []byte("1"), 0666)
+}
+
+// isDataDiskSCSIDevice returns whether the SCSI device hctl, e.g. 3:0:0:1, is a data disk. The OS and resource
+// disks are the devices linked under /dev/disk/azure, or the devices on targets 0-3 when the links are not populated.
+func isDataDiskSCSIDevice(io azureutils.IOHandler, hctl string, azureDisks []string) bool {
+	arr := strings.Split(hctl, ":")
+	if len(arr) < 4 {
+		return false
+	}
+	devicePath := filepath.Join("/sys/bus/scsi/devices", hctl)
+	if len(azureDisks) == 0 {
+		if target, err := strconv.Atoi(arr[0]); err != nil || target <= 3 {
+			return false
+		}
+	} else if dev, err := io.ReadDir(filepath.Join(devicePath, "block")); err == nil && len(dev) > 0 {
+		for _, diskName := range azureDisks {
+			if dev[0].Name() == diskName {
+				return false
+			}
+		}
+	}
+	vendorBytes, err := io.ReadFile(filepath.Join(devicePath, "vendor"))
+	if err != nil || strings.ToUpper(strings.TrimSpace(string(vendorBytes))) != "MSFT" {
+		return false
+	}
+	modelBytes, err := io.ReadFile(filepath.Join(devicePath, "model"))
+	return err == nil && strings.ToUpper(strings.TrimSpace(string(modelBytes))) == "VIRTUAL DISK"
+}
+
+// listBlockDevices returns the names of the block devices of the node, e.g. sda or nvme0n1
+func listBlockDevices(io azureutils.IOHandler) ([]string, error) {
+	dirs, err := io.ReadDir(sysClassBlockPath)
+	if err != nil {
+		return nil, err
+	}
+	devNames := make([]string, 0, len(dirs))
+	for _, f := range dirs {
+		devNames = append(devNames, f.Name())
+	}
+	return devNames, nil
+}
+
+// getDataDiskLUN returns the LUN of the data disk of the block device devName, e.g. sdc or nvme0n2, or an error if
+// devName is not a data disk
+func getDataDiskLUN(io azureutils.IOHandler, devName string) (int, error) {
+	if match := nvmeNamespaceRE.FindStringSubmatch(devName); match != nil {
+		if match[2] != "" {
+			return 0, fmt.Errorf("%s is a partition", devName)
+		}
+		for _, controller := range listAzureNVMeControllers(io) {
+			if controller != match[1] {
+				continue
+			}
+			nsidBytes, err := io.ReadFile(filepath.Join(sysClassNVMePath, controller, devName, "nsid"))
+			if err != nil {
+				return 0, err
+			}
+			nsid, err := strconv.Atoi(strings.TrimSpace(string(nsidBytes)))
+			if err != nil {
+				return 0, fmt.Errorf("could not parse namespace id %q of %s: %v", strings.TrimSpace(string(nsidBytes)), devName, err)
+			}
+			if nsid < nvmeDataDiskNamespaceOffset {
+				return 0, fmt.Errorf("%s is the OS disk", devName)
+			}
+			return nsid - nvmeDataDiskNamespaceOffset, nil
+		}
+		return 0, fmt.Errorf("%s is not a namespace of an Azure nvme controller", devName)
+	}
+
+	// e.g. /sys/class/block/sdc/device -> ../../../3:0:0:1, partitions have no device link
+	link, err := io.Readlink(filepath.Join(sysClassBlockPath, devName, "device"))
+	if err != nil {
+		return 0, err
+	}
+	hctl := filepath.Base(link)
+	if !isDataDiskSCSIDevice(io, hctl, listAzureDiskPath(io)) {
+		return 0, fmt.Errorf("%s(%s) is not a data disk", devName, hctl)
+	}
+	return strconv.Atoi(hctl[strings.LastIndex(hctl, ":")+1:])
 }
 
-func formatAndMount(source, target, fstype string, options []string, m *mount.SafeFormatAndMount) error {
-	return m.FormatAndMount(source, target, fstype, options)
+// getBlockDeviceSizeBytes returns the size in bytes of the block device devName read from sysfs, the size is in 512
+// bytes sectors whatever the logical sector size of the device
+func getBlockDeviceSizeBytes(io azureutils.IOHandler, devName string) (int64, error) {
+	sizeBytes, err := io.ReadFile(filepath.Join(sysClassBlockPath, devName, "size"))
+	if err != nil {
+		return 0, err
+	}
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(sizeBytes)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse size %q of %s: %v", strings.TrimSpace(string(sizeBytes)), devName, err)
+	}
+	return sectors * 512, nil
+}
+
+// getDataDiskIdentifiers returns the identifiers of the data disk devName read from sysfs, the world wide id and the
+// unit serial number page of a SCSI device, e.g. t10.MSFT    Virtual Disk..., or the world wide id and the serial
+// number of an NVMe namespace
+func getDataDiskIdentifiers(io azureutils.IOHandler, devName string) ([]string, error) {
+	files := []string{"device/wwid", "device/vpd_pg80"}
+	if nvmeNamespaceRE.MatchString(devName) {
+		files = []string{"wwid", "device/serial"}
+	}
+	identifiers := []string{}
+	for _, file := range files {
+		if content, err := io.ReadFile(filepath.Join(sysClassBlockPath, devName, file)); err == nil {
+			if identifier := strings.TrimSpace(string(content)); identifier != "" {
+				identifiers = append(identifiers, identifier)
+			}
+		}
+	}
+	if len(identifiers) == 0 {
+		return nil, fmt.Errorf("no identifier of %s is found in %s", devName, filepath.Join(sysClassBlockPath, devName))
+	}
+	return identifiers, nil
+}
+
+// rescanDataDiskLUN rescans the Azure NVMe controllers and only the lun of the SCSI targets of the data disks
+// attached to the node, e.g. "0 0 1" is written to /sys/class/scsi_host/host3/scan for lun 1 on 3:0:0. An error is
+// returned if no target of the data disks is found.
+func rescanDataDiskLUN(io azureutils.IOHandler, lun int) error {
+	controllers := listAzureNVMeControllers(io)
+	for _, controller := range controllers {
+		if err := rescanNVMeController(io, controller); err != nil {
+			klog.Warningf("failed to rescan nvme controller %s: %v", controller, err)
+		}
+	}
+
+	sysPath := "/sys/bus/scsi/devices"
+	dirs, err := io.ReadDir(sysPath)
+	if err != nil {
+		if len(controllers) > 0 {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %v", sysPath, err)
+	}
+	azureDisks := listAzureDiskPath(io)
+	targets := map[string]bool{}
+	for _, f := range dirs {
+		if !isDataDiskSCSIDevice(io, f.Name(), azureDisks) {
+			continue
+		}
+		arr := strings.Split(f.Name(), ":")
+		target := fmt.Sprintf("host%s:%s %s", arr[0], arr[1], arr[2])
+		if targets[target] {
+			continue
+		}
+		targets[target] = true
+		host, channelAndTarget, _ := strings.Cut(target, ":")
+		name := filepath.Join("/sys/class/scsi_host", host, "scan")
+		klog.V(4).Infof("rescanning lun %d of %s on %s", lun, channelAndTarget, host)
+		if err := io.WriteFile(name, []byte(fmt.Sprintf("%s %d", channelAndTarget, lun)), 0666); err != nil {
+			return fmt.Errorf("failed to rescan %s: %v", name, err)
+		}
+	}
+	if len(targets) == 0 && len(controllers) == 0 {
+		return fmt.Errorf("no scsi target of data disks is found under %s", sysPath)
+	}
+	return nil
+}
+
+// udevMonitorGroup is the netlink group of the uevents sent by udev once it processed the kernel uevents, the kernel
+// uevents are sent to group 1 before udev creates the links of the devices
+const udevMonitorGroup = 2
+
+// watchBlockDeviceEvents calls onEvent with the uevents of the node sent by udev until ctx is done, the udev links of
+// a device exist once its uevent is received
+func watchBlockDeviceEvents(ctx context.Context, onEvent func(map[string]string)) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return fmt.Errorf("failed to open uevent socket: %v", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: udevMonitorGroup}); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("failed to bind uevent socket: %v", err)
+	}
+	// wake up every second to stop when ctx is done
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("failed to set timeout of uevent socket: %v", err)
+	}
+
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 64*1024)
+		for ctx.Err() == nil {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err != unix.EAGAIN && err != unix.EINTR {
+					klog.Warningf("failed to receive uevent: %v", err)
+				}
+				continue
+			}
+			onEvent(parseUevent(buf[:n]))
+		}
+	}()
+	return nil
+}
+
+// listDataDiskSCSIDevices returns the names of the SCSI data disks of the block device devicePath, e.g. sdc for
+// /dev/sdc, or the disks under a device mapper device, e.g. the member disks of a striped volume
+func listDataDiskSCSIDevices(io azureutils.IOHandler, devicePath string) []string {
+	devName := filepath.Base(devicePath)
+	// e.g. /dev/mapper/azdisk-1-data -> ../dm-1
+	if link, err := io.Readlink(devicePath); err == nil {
+		devName = filepath.Base(link)
+	}
+	if slaves, err := io.ReadDir(filepath.Join(sysClassBlockPath, devName, "slaves")); err == nil {
+		var devNames []string
+		for _, slave := range slaves {
+			devNames = append(devNames, listDataDiskSCSIDevices(io, "/dev/"+slave.Name())...)
+		}
+		return devNames
+	}
+	if nvmeNamespaceRE.MatchString(devName) {
+		return nil
+	}
+	if _, err := getDataDiskLUN(io, devName); err != nil {
+		return nil
+	}
+	return []string{devName}
+}
+
+// getSCSIDeviceIdentity returns the address and the world wide id of the SCSI device devName, e.g.
+// 5:0:0:1 t10.MSFT    Virtual Disk...
+func getSCSIDeviceIdentity(io azureutils.IOHandler, devName string) (string, error) {
+	link, err := io.Readlink(filepath.Join(sysClassBlockPath, devName, "device"))
+	if err != nil {
+		return "", err
+	}
+	identity := filepath.Base(link)
+	if wwid, err := io.ReadFile(filepath.Join(sysClassBlockPath, devName, "device/wwid")); err == nil {
+		identity += " " + strings.TrimSpace(string(wwid))
+	}
+	return identity, nil
+}
+
+// deleteSCSIDevice flushes the buffers of the SCSI device devName and deletes the device from the kernel if it still
+// has identity
+func deleteSCSIDevice(io azureutils.IOHandler, devName, identity string, m *mount.SafeFormatAndMount) error {
+	current, err := getSCSIDeviceIdentity(io, devName)
+	if err != nil {
+		return err
+	}
+	if current != identity {
+		return fmt.Errorf("device %s is now %s instead of %s", devName, current, identity)
+	}
+	if out, err := m.Exec.Command("blockdev", "--flushbufs", "/dev/"+devName).CombinedOutput(); err != nil {
+		return fmt.Errorf("blockdev --flushbufs /dev/%s failed with %v, output: %s", devName, err, strings.TrimSpace(string(out)))
+	}
+	// /sys/class/block/<dev>/device is the device of /sys/block/<dev>/device
+	return io.WriteFile(filepath.Join(sysClassBlockPath, devName, "device/delete"), []byte("1"), 0666)
+}
+
+func formatAndMount(source, target, fstype string, options, formatOptions []string, m *mount.SafeFormatAndMount) error {
+	return m.FormatAndMountSensitiveWithFormatOptions(source, target, fstype, options, nil, formatOptions)
+}
+
+// checkFilesystem runs a read-only check of the filesystem on source, or repairs it if repair is set, and returns
+// the output of the check tool. An error is returned if the check found errors or the repair could not fix them.
+func checkFilesystem(source, fstype string, repair bool, m *mount.SafeFormatAndMount) (string, error) {
+	var cmd string
+	var args []string
+	switch strings.ToLower(fstype) {
+	case "ext2", "ext3", "ext4":
+		cmd, args = "e2fsck", []string{"-f", "-n", source}
+		if repair {
+			args = []string{"-f", "-y", source}
+		}
+	case "xfs":
+		cmd, args = "xfs_repair", []string{"-n", source}
+		if repair {
+			args = []string{source}
+		}
+	default:
+		return "", fmt.Errorf("filesystem check is not supported for fstype %q", fstype)
+	}
+	out, err := m.Exec.Command(cmd, args...).CombinedOutput()
+	if err != nil && repair && cmd == "e2fsck" {
+		// e2fsck exits with 1 or 2 when it corrected the errors
+		if ee, ok := err.(utilexec.ExitError); ok && ee.ExitStatus() < 4 {
+			err = nil
+		}
+	}
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s failed with %v", cmd, strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// getFilesystemUUID returns the type and the UUID of the filesystem on source probed by blkid, they are empty if
+// source has no filesystem
+func getFilesystemUUID(source string, m *mount.SafeFormatAndMount) (string, string, error) {
+	out, err := m.Exec.Command("blkid", "-p", "-o", "export", source).CombinedOutput()
+	if err != nil {
+		// blkid exits with 2 when no filesystem is found
+		if ee, ok := err.(utilexec.ExitError); ok && ee.ExitStatus() == 2 {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("blkid -p -o export %s failed with %v, output: %s", source, err, strings.TrimSpace(string(out)))
+	}
+	var fstype, uuid string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "TYPE":
+			fstype = value
+		case "UUID":
+			uuid = value
+		}
+	}
+	return fstype, uuid, nil
+}
+
+// listMountedFilesystemUUIDs returns the UUIDs of the filesystems mounted on the node
+func listMountedFilesystemUUIDs(m *mount.SafeFormatAndMount) (sets.Set[string], error) {
+	out, err := m.Exec.Command("findmnt", "-rn", "-o", "UUID").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("findmnt -rn -o UUID failed with %v, output: %s", err, strings.TrimSpace(string(out)))
+	}
+	return sets.New(strings.Fields(string(out))...), nil
+}
+
+// regenerateFilesystemUUID gives the xfs or ext filesystem on source a new random UUID. xfs_admin and tune2fs refuse
+// to change the UUID of a filesystem copied from a mounted volume until its log is replayed, the log of an xfs
+// filesystem is replayed by mounting it with nouuid and an ext filesystem is checked by e2fsck in preen mode, which
+// replays its journal and only fixes what is safe to fix unattended, or repaired with e2fsck -y if repair is set.
+func regenerateFilesystemUUID(source, fstype string, repair bool, m *mount.SafeFormatAndMount) error {
+	cmd, args := "tune2fs", []string{"-U", "random", source}
+	if strings.EqualFold(fstype, "xfs") {
+		cmd, args = "xfs_admin", []string{"-U", "generate", source}
+	}
+	out, err := m.Exec.Command(cmd, args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	klog.V(2).Infof("replaying the log of %s filesystem on %s, %s %s failed with %v, output: %s", fstype, source, cmd, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	if strings.EqualFold(fstype, "xfs") {
+		dir, err := os.MkdirTemp("", "azuredisk-xfs-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(dir)
+		if err := m.Mount(source, dir, "xfs", []string{"nouuid"}); err != nil {
+			return fmt.Errorf("failed to mount %s to replay its log: %v", source, err)
+		}
+		if err := m.Unmount(dir); err != nil {
+			return fmt.Errorf("failed to unmount %s after replaying its log: %v", source, err)
+		}
+	} else if repair {
+		if _, err := checkFilesystem(source, fstype, true, m); err != nil {
+			return err
+		}
+	} else if out, err := m.Exec.Command("e2fsck", "-f", "-p", source).CombinedOutput(); err != nil {
+		// e2fsck exits with 1 or 2 when it corrected the errors, and with 4 when the errors need a manual repair
+		if ee, ok := err.(utilexec.ExitError); !ok || ee.ExitStatus() >= 4 {
+			return fmt.Errorf("e2fsck -f -p %s failed with %v, output: %s", source, err, strings.TrimSpace(string(out)))
+		}
+	}
+	if out, err := m.Exec.Command(cmd, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed with %v, output: %s", cmd, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// trimFilesystem runs fstrim on the filesystem mounted at target and returns the bytes trimmed
+func trimFilesystem(target string, m *mount.SafeFormatAndMount) (int64, error) {
+	out, err := m.Exec.Command("fstrim", "-v", target).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("fstrim -v %s failed with %v, output: %s", target, err, string(out))
+	}
+	// e.g. /mnt/data: 1.2 GiB (1287651328 bytes) trimmed
+	matches := fstrimOutputRE.FindStringSubmatch(string(out))
+	if len(matches) < 2 {
+		return 0, fmt.Errorf("could not parse fstrim output %q", strings.TrimSpace(string(out)))
+	}
+	return strconv.ParseInt(matches[1], 10, 64)
+}
+
+// stripedLogicalVolumeName is the name of the logical volume striped across the member disks of a striped volume
+const stripedLogicalVolumeName = "data"
+
+// runLVMCommand runs an LVM command and returns its combined output
+func runLVMCommand(m *mount.SafeFormatAndMount, cmd string, args ...string) (string, error) {
+	out, err := m.Exec.Command(cmd, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s failed with %v, output: %s", cmd, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// getLVMReportFields returns the fields of an LVM report without the warnings LVM prints along with it, e.g. about
+// the duplicate PVs of a cloned volume staged on the same node as its source
+func getLVMReportFields(out string) []string {
+	fields := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); !strings.HasPrefix(line, "WARNING:") {
+			fields = append(fields, strings.Fields(line)...)
+		}
+	}
+	return fields
+}
+
+// assembleStripedVolume activates the volume group vgName of the striped volume on devices, or creates it with a
+// logical volume striped across the devices if the devices are empty, grows the logical volume to the size of the
+// devices and returns the path of the logical volume. The volume group of a volume restored from a snapshot or
+// cloned from another volume carries the PV and VG UUIDs of the source volume, which may be staged on the same node,
+// so it is imported with new UUIDs as vgName.
+func assembleStripedVolume(vgName string, devices []string, m *mount.SafeFormatAndMount) (string, error) {
+	lvPath := filepath.Join("/dev", vgName, stripedLogicalVolumeName)
+	out, err := runLVMCommand(m, "pvs", "--noheadings", "-o", "vg_name", devices[0])
+	if fields := getLVMReportFields(out); err == nil && len(fields) == 1 {
+		if fields[0] != vgName {
+			klog.V(2).Infof("importing volume group %s on %v as %s", fields[0], devices, vgName)
+			if _, err := runLVMCommand(m, "vgimportclone", append([]string{"--basevgname", vgName}, devices...)...); err != nil {
+				return "", err
+			}
+		}
+		if _, err := runLVMCommand(m, "vgchange", "-ay", vgName); err != nil {
+			return "", err
+		}
+	} else {
+		for _, device := range devices {
+			format, err := m.GetDiskFormat(device)
+			if err != nil {
+				return "", err
+			}
+			if format != "" && format != "LVM2_member" {
+				return "", fmt.Errorf("device %s of striped volume is formatted with %s", device, format)
+			}
+		}
+		klog.V(2).Infof("creating volume group %s on %v", vgName, devices)
+		if _, err := runLVMCommand(m, "pvcreate", devices...); err != nil {
+			return "", err
+		}
+		if _, err := runLVMCommand(m, "vgcreate", append([]string{vgName}, devices...)...); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := runLVMCommand(m, "lvs", "--noheadings", "-o", "lv_name", vgName+"/"+stripedLogicalVolumeName); err != nil {
+		klog.V(2).Infof("creating logical volume %s striped across %d devices", lvPath, len(devices))
+		if _, err := runLVMCommand(m, "lvcreate", "-y", "-n", stripedLogicalVolumeName, "-i", strconv.Itoa(len(devices)), "-l", "100%FREE", vgName); err != nil {
+			return "", err
+		}
+		return lvPath, nil
+	}
+	return lvPath, growStripedVolume(vgName, m)
+}
+
+// growStripedVolume grows the physical volumes of the volume group vgName to the size of their devices and extends
+// the striped logical volume over the free space of the volume group
+func growStripedVolume(vgName string, m *mount.SafeFormatAndMount) error {
+	out, err := runLVMCommand(m, "pvs", "--noheadings", "-o", "pv_name", "-S", "vg_name="+vgName)
+	if err != nil {
+		return err
+	}
+	for _, pv := range getLVMReportFields(out) {
+		if _, err := runLVMCommand(m, "pvresize", pv); err != nil {
+			return err
+		}
+	}
+	out, err = runLVMCommand(m, "vgs", "--noheadings", "--units", "b", "--nosuffix", "-o", "vg_free", vgName)
+	if err != nil {
+		return err
+	}
+	vgFree := strings.Join(getLVMReportFields(out), " ")
+	free, err := strconv.ParseInt(vgFree, 10, 64)
+	if err != nil {
+		return fmt.Errorf("could not parse free space %q of volume group %s: %v", vgFree, vgName, err)
+	}
+	if free == 0 {
+		return nil
+	}
+	klog.V(2).Infof("extending logical volume %s/%s over %d free bytes", vgName, stripedLogicalVolumeName, free)
+	_, err = runLVMCommand(m, "lvextend", "-l", "+100%FREE", vgName+"/"+stripedLogicalVolumeName)
+	return err
+}
+
+// deactivateStripedVolume deactivates the volume group vgName of a striped volume before its devices are detached
+func deactivateStripedVolume(vgName string, m *mount.SafeFormatAndMount) error {
+	out, err := runLVMCommand(m, "vgchange", "-an", vgName)
+	if err != nil && strings.Contains(out, "not found") {
+		klog.V(2).Infof("volume group %s not found, it is already deactivated", vgName)
+		return nil
+	}
+	return err
+}
+
+const (
+	// localCacheVolumeGroup is the LVM volume group on the local cache device that the local caches are carved from
+	localCacheVolumeGroup = "azdisk-cache"
+	// localCacheBlockSectors is the dm-cache block size in 512 bytes sectors
+	localCacheBlockSectors = 512
+	// minLocalCacheMetadataMiB is the minimum size of the dm-cache metadata device
+	minLocalCacheMetadataMiB = 8
+)
+
+// setupLocalCache creates a dm-cache device name in writethrough mode over origin with a cache of sizeBytes carved
+// from the local cache volume group on cacheDevice, and returns the path of the device. An existing device is kept
+// if it caches origin and rebuilt if it caches another device, otherwise the cache is always built cold, dropping the cache left by a cache device that was not torn down.
+func setupLocalCache(cacheDevice, name, origin string, sizeBytes int64, m *mount.SafeFormatAndMount) (string, error) {
+	cachePath := filepath.Join("/dev/mapper", name)
+	// e.g. 0 2097152 cache 253:1 253:0 8:32 512 1 writethrough default 0
+	if out, err := runLVMCommand(m, "dmsetup", "table", name); err == nil {
+		fields := strings.Fields(out)
+		if len(fields) < 6 || fields[2] != "cache" {
+			return "", fmt.Errorf("could not parse table %q of local cache %s", strings.TrimSpace(out), name)
+		}
+		out, err = runLVMCommand(m, "lsblk", "-dno", "MAJ:MIN", origin)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(out) == fields[5] {
+			klog.V(2).Infof("local cache %s already exists", cachePath)
+			return cachePath, nil
+		}
+		// a local cache left over from a disk detached without unstaging would serve the blocks of that disk
+		klog.Warningf("local cache %s caches %s instead of %s (%s), rebuilding it", cachePath, fields[5], origin, strings.TrimSpace(out))
+		if err := teardownLocalCache(name, m); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := runLVMCommand(m, "vgs", localCacheVolumeGroup); err != nil {
+		format, err := m.GetDiskFormat(cacheDevice)
+		if err != nil {
+			return "", err
+		}
+		if format != "" && format != "LVM2_member" {
+			return "", fmt.Errorf("local cache device %s is formatted with %s, it must be dedicated to the local cache", cacheDevice, format)
+		}
+		klog.V(2).Infof("creating local cache volume group %s on %s", localCacheVolumeGroup, cacheDevice)
+		if _, err := runLVMCommand(m, "pvcreate", cacheDevice); err != nil {
+			return "", err
+		}
+		if _, err := runLVMCommand(m, "vgcreate", localCacheVolumeGroup, cacheDevice); err != nil {
+			return "", err
+		}
+	}
+	if err := removeLocalCacheSlices(name, m); err != nil {
+		return "", err
+	}
+
+	dataMiB := (sizeBytes + 1<<20 - 1) >> 20
+	metadataMiB := dataMiB / 1000
+	if metadataMiB < minLocalCacheMetadataMiB {
+		metadataMiB = minLocalCacheMetadataMiB
+	}
+	if _, err := runLVMCommand(m, "lvcreate", "-y", "-Zy", "-Wy", "-n", name+"-meta", "-L", fmt.Sprintf("%dm", metadataMiB), localCacheVolumeGroup); err != nil {
+		return "", err
+	}
+	if _, err := runLVMCommand(m, "lvcreate", "-y", "-Wy", "-n", name+"-data", "-L", fmt.Sprintf("%dm", dataMiB), localCacheVolumeGroup); err != nil {
+		return "", err
+	}
+	out, err := runLVMCommand(m, "blockdev", "--getsz", origin)
+	if err != nil {
+		return "", err
+	}
+	table := fmt.Sprintf("0 %s cache %s %s %s %d 1 writethrough default 0", strings.TrimSpace(out),
+		filepath.Join("/dev", localCacheVolumeGroup, name+"-meta"), filepath.Join("/dev", localCacheVolumeGroup, name+"-data"), origin, localCacheBlockSectors)
+	if _, err := runLVMCommand(m, "dmsetup", "create", name, "--table", table); err != nil {
+		return "", err
+	}
+	return cachePath, nil
+}
+
+// removeLocalCacheSlices removes the metadata and data logical volumes of the local cache name
+func removeLocalCacheSlices(name string, m *mount.SafeFormatAndMount) error {
+	for _, lv := range []string{name + "-meta", name + "-data"} {
+		out, err := runLVMCommand(m, "lvremove", "-y", localCacheVolumeGroup+"/"+lv)
+		if err != nil && !strings.Contains(out, "Failed to find") && !strings.Contains(out, "not found") {
+			return err
+		}
+	}
+	return nil
+}
+
+// teardownLocalCache removes the dm-cache device name and the logical volumes of its cache, the writethrough cache
+// holds no dirty blocks so the cached device is consistent without a flush
+func teardownLocalCache(name string, m *mount.SafeFormatAndMount) error {
+	out, err := runLVMCommand(m, "dmsetup", "remove", name)
+	if err != nil && !strings.Contains(out, "No such device") && !strings.Contains(out, "not exist") {
+		return err
+	}
+	if _, err := runLVMCommand(m, "vgs", localCacheVolumeGroup); err != nil {
+		return nil
+	}
+	return removeLocalCacheSlices(name, m)
+}
+
+// resizeLocalCache reloads the dm-cache device name with the current size of the device it caches
+func resizeLocalCache(name string, m *mount.SafeFormatAndMount) error {
+	// e.g. 0 2097152 cache 253:1 253:0 8:32 512 1 writethrough default 0
+	out, err := runLVMCommand(m, "dmsetup", "table", name)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(out)
+	if len(fields) < 6 || fields[2] != "cache" {
+		return fmt.Errorf("could not parse table %q of local cache %s", strings.TrimSpace(out), name)
+	}
+	out, err = runLVMCommand(m, "blockdev", "--getsz", filepath.Join("/dev/block", fields[5]))
+	if err != nil {
+		return err
+	}
+	if sectors := strings.TrimSpace(out); sectors != fields[1] {
+		klog.V(2).Infof("resizing local cache %s from %s to %s sectors", name, fields[1], sectors)
+		fields[1] = sectors
+		if _, err := runLVMCommand(m, "dmsetup", "suspend", name); err != nil {
+			return err
+		}
+		_, err = runLVMCommand(m, "dmsetup", "reload", name, "--table", strings.Join(fields, " "))
+		if _, resumeErr := runLVMCommand(m, "dmsetup", "resume", name); err == nil {
+			err = resumeErr
+		}
+	}
+	return err
+}
+
+// getLocalCacheStats returns the hits and misses of the dm-cache device name
+func getLocalCacheStats(name string, m *mount.SafeFormatAndMount) (localCacheStats, error) {
+	// e.g. 0 2097152 cache 8 27/2048 512 10/4096 1024 512 256 128 0 0 0 1 writethrough 2 migration_threshold 2048 smq 0 rw -
+	out, err := runLVMCommand(m, "dmsetup", "status", name)
+	if err != nil {
+		return localCacheStats{}, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) < 11 || fields[2] != "cache" {
+		return localCacheStats{}, fmt.Errorf("could not parse status %q of local cache %s", strings.TrimSpace(out), name)
+	}
+	values := make([]int64, 4)
+	for i := range values {
+		if values[i], err = strconv.ParseInt(fields[7+i], 10, 64); err != nil {
+			return localCacheStats{}, fmt.Errorf("could not parse status %q of local cache %s: %v", strings.TrimSpace(out), name, err)
+		}
+	}
+	return localCacheStats{readHits: values[0], readMisses: values[1], writeHits: values[2], writeMisses: values[3]}, nil
+}
+
+// setVolumeMountGroup gives the group gid ownership of the root directory of the volume mounted at path, with group
+// read, write and execute permissions and the setgid bit so that the files created in the volume inherit the group.
+// The existing files are left as is. The root directory already owned by the group is the marker that the group is
+// applied, so that publishing the volume again with the same group costs a single stat.
+func setVolumeMountGroup(path string, gid int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("could not get the owner of %s", path)
+	}
+	if int(stat.Gid) == gid {
+		klog.V(6).Infof("%s is already owned by group %d", path, gid)
+		return nil
+	}
+	klog.V(2).Infof("changing the group of %s from %d to %d", path, stat.Gid, gid)
+	// the group is changed last so that a failed change is retried on the next publish
+	if err := os.Chmod(path, info.Mode()|os.ModeSetgid|0070); err != nil {
+		return err
+	}
+	return os.Lchown(path, -1, gid)
+}
+
+// listLUNsInUse returns the LUNs of the data disks currently visible on the node.
+// The LUNs are read from the udev links under /dev/disk/azure/scsi1, and from the NVMe namespaces and the SCSI devices in
+// sysfs when the links are not populated.
+func listLUNsInUse(io azureutils.IOHandler) ([]int32, error) {
+	luns := []int32{}
+	if dirs, err := io.ReadDir("/dev/disk/azure/scsi1/"); err == nil {
+		for _, f := range dirs {
+			// skip partition links, e.g. lun0-part1
+			if lun, err := strconv.Atoi(strings.TrimPrefix(f.Name(), "lun")); err == nil && strings.HasPrefix(f.Name(), "lun") {
+				luns = append(luns, int32(lun))
+			}
+		}
+		return luns, nil
+	}
+
+	nvmeDisks := listNVMeDataDisks(io)
+	for lun := range nvmeDisks {
+		luns = append(luns, int32(lun))
+	}
+	sort.Slice(luns, func(i, j int) bool { return luns[i] < luns[j] })
+
+	sysPath := "/sys/bus/scsi/devices"
+	dirs, err := io.ReadDir(sysPath)
+	if err != nil {
+		if len(nvmeDisks) > 0 {
+			return luns, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %v", sysPath, err)
+	}
+	for _, f := range dirs {
+		name := f.Name()
+		// look for path like /sys/bus/scsi/devices/3:0:0:1
+		arr := strings.Split(name, ":")
+		if len(arr) < 4 {
+			continue
+		}
+		target, err := strconv.Atoi(arr[0])
+		// as observed, targets 0-3 are used by OS disks. Skip them
+		if err != nil || target <= 3 {
+			continue
+		}
+		lun, err := strconv.Atoi(arr[3])
+		if err != nil {
+			continue
+		}
+		vendorBytes, err := io.ReadFile(filepath.Join(sysPath, name, "vendor"))
+		if err != nil || strings.ToUpper(strings.TrimSpace(string(vendorBytes))) != "MSFT" {
+			continue
+		}
+		luns = append(luns, int32(lun))
+	}
+	return luns, nil
 }
 
 // finds a device mounted to "current" node
@@ -243,16 +1072,29 @@ func needResizeVolume(devicePath, volumePath string, m *mount.SafeFormatAndMount
 }
 
 // rescanVolume rescan device for detecting device size expansion
-// devicePath e.g. `/dev/sdc`
+// devicePath e.g. `/dev/sdc` or `/dev/nvme0n2`
 func rescanVolume(io azureutils.IOHandler, devicePath string) error {
 	klog.V(6).Infof("rescanVolume - begin to rescan %s", devicePath)
 	deviceName := filepath.Base(devicePath)
+	if match := nvmeNamespaceRE.FindStringSubmatch(deviceName); match != nil {
+		// the size of an NVMe namespace is updated by rescanning its controller
+		return rescanNVMeController(io, match[1])
+	}
+	// partitions have no device to rescan, their disk is rescanned
+	if disk, _, ok := getPartitionDisk(io, deviceName); ok {
+		deviceName = disk
+	}
 	rescanPath := filepath.Join(sysClassBlockPath, deviceName, "device/rescan")
 	return io.WriteFile(rescanPath, []byte("1"), 0666)
 }
 
-// rescanAllVolumes rescan all sd* devices under /sys/class/block/sd* starting from sdc
+// rescanAllVolumes rescan all sd* devices under /sys/class/block/sd* starting from sdc and the Azure NVMe controllers
 func rescanAllVolumes(io azureutils.IOHandler) error {
+	for _, controller := range listAzureNVMeControllers(io) {
+		if err := rescanNVMeController(io, controller); err != nil {
+			klog.Warningf("rescanVolume - rescan nvme controller %s failed with %v", controller, err)
+		}
+	}
 	dirs, err := io.ReadDir(sysClassBlockPath)
 	if err != nil {
 		return err
@@ -269,6 +1111,129 @@ func rescanAllVolumes(io azureutils.IOHandler) error {
 	return nil
 }
 
+// getPartitionDisk returns the disk and the number of the partition devName, e.g. sdc and 1 for sdc1, ok is false if
+// devName is not a partition
+func getPartitionDisk(io azureutils.IOHandler, devName string) (string, int, bool) {
+	partition, err := io.ReadFile(filepath.Join(sysClassBlockPath, devName, "partition"))
+	if err != nil {
+		return "", 0, false
+	}
+	number, err := strconv.Atoi(strings.TrimSpace(string(partition)))
+	if err != nil {
+		klog.Warningf("could not parse partition number %q of %s: %v", strings.TrimSpace(string(partition)), devName, err)
+		return "", 0, false
+	}
+	// e.g. /sys/class/block/sdc1 -> ../../devices/.../block/sdc/sdc1
+	link, err := io.Readlink(filepath.Join(sysClassBlockPath, devName))
+	if err != nil {
+		klog.Warningf("could not find the disk of partition %s: %v", devName, err)
+		return "", 0, false
+	}
+	return filepath.Base(filepath.Dir(link)), number, true
+}
+
+// readSectors returns the number of 512 bytes sectors in the sysfs attribute path, e.g. the start or size of a partition
+func readSectors(io azureutils.IOHandler, path string) (int64, error) {
+	content, err := io.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s %q: %v", path, strings.TrimSpace(string(content)), err)
+	}
+	return sectors, nil
+}
+
+// growPartition grows the partition devicePath to the end of its disk once the disk is expanded, so that the
+// filesystem on the partition can be resized. Only the last partition of a disk is grown, and only a primary
+// partition of an MBR disk. The backup header of a GPT disk is moved to the end of the disk first. It is a no-op if
+// devicePath is not a partition or if the partition already ends at the end of its disk.
+func growPartition(io azureutils.IOHandler, devicePath string, m *mount.SafeFormatAndMount) error {
+	devName := filepath.Base(devicePath)
+	// e.g. /dev/disk/azure/scsi1/lun0-part1 -> ../../../../sdc1
+	if link, err := io.Readlink(devicePath); err == nil {
+		devName = filepath.Base(link)
+	}
+	disk, number, ok := getPartitionDisk(io, devName)
+	if !ok {
+		return nil
+	}
+
+	diskSectors, err := readSectors(io, filepath.Join(sysClassBlockPath, disk, "size"))
+	if err != nil {
+		return err
+	}
+	start, err := readSectors(io, filepath.Join(sysClassBlockPath, devName, "start"))
+	if err != nil {
+		return err
+	}
+	size, err := readSectors(io, filepath.Join(sysClassBlockPath, devName, "size"))
+	if err != nil {
+		return err
+	}
+	entries, err := io.ReadDir(filepath.Join(sysClassBlockPath, disk))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == devName {
+			continue
+		}
+		// the partitions of the disk are the directories of the disk with a start, e.g. /sys/class/block/sdc/sdc2/start
+		otherStart, err := readSectors(io, filepath.Join(sysClassBlockPath, disk, entry.Name(), "start"))
+		if err != nil {
+			continue
+		}
+		if otherStart > start {
+			klog.Warningf("partition %s is not grown since it is not the last partition of %s", devName, disk)
+			return nil
+		}
+	}
+
+	diskPath := "/dev/" + disk
+	out, err := m.Exec.Command("blkid", "-p", "-s", "PTTYPE", "-o", "value", diskPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("blkid -p -s PTTYPE %s failed with %v, output: %s", diskPath, err, strings.TrimSpace(string(out)))
+	}
+	tableType := strings.TrimSpace(string(out))
+	// the backup GPT header and partition entries take the last 33 sectors of the disk
+	reservedSectors := int64(0)
+	switch tableType {
+	case "gpt":
+		reservedSectors = 33
+	case "dos":
+		if number > 4 {
+			klog.Warningf("partition %s is not grown since it is a logical partition of %s", devName, disk)
+			return nil
+		}
+	default:
+		return fmt.Errorf("partition table type %q of %s is not supported", tableType, diskPath)
+	}
+	// partitions are aligned on 1MiB, a smaller free space cannot be used
+	if diskSectors-reservedSectors-(start+size) < 2048 {
+		klog.V(4).Infof("partition %s already ends at the end of %s", devName, disk)
+		return nil
+	}
+
+	klog.V(2).Infof("growing partition %d of %s from %d sectors to the end of the disk of %d sectors", number, diskPath, size, diskSectors)
+	if tableType == "gpt" {
+		if out, err := m.Exec.Command("sfdisk", "--relocate", "gpt-bak-std", diskPath).CombinedOutput(); err != nil {
+			return fmt.Errorf("sfdisk --relocate gpt-bak-std %s failed with %v, output: %s", diskPath, err, strings.TrimSpace(string(out)))
+		}
+	}
+	// the partition table of a disk with mounted partitions cannot be reread, the kernel is told of the new size by partx
+	cmd := m.Exec.Command("sfdisk", "--no-reread", "--no-tell-kernel", "-N", strconv.Itoa(number), diskPath)
+	cmd.SetStdin(strings.NewReader(",+\n"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sfdisk -N %d %s failed with %v, output: %s", number, diskPath, err, strings.TrimSpace(string(out)))
+	}
+	if out, err := m.Exec.Command("partx", "--update", "--nr", strconv.Itoa(number), diskPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("partx --update --nr %d %s failed with %v, output: %s", number, diskPath, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 func (d *DriverCore) GetVolumeStats(_ context.Context, m *mount.SafeFormatAndMount, _, target string, hostutil hostUtil) ([]*csi.VolumeUsage, error) {
 	var volUsages []*csi.VolumeUsage
 	_, err := os.Stat(target)
@@ -17,10 +17,20 @@ limitations under the License.
 package azuredisk
 
 import (
+	"os"
+	"reflect"
 	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
 )
 
 func TestRescanAllVolumes(t *testing.T) {
@@ -32,3 +42,344 @@ func TestRescanAllVolumes(t *testing.T) {
 		t.Errorf("rescanAllVolumes failed with error: %v", err)
 	}
 }
+
+func TestListLUNsInUse(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skipf("skip test on GOOS=%s", runtime.GOOS)
+	}
+	// the fake handler does not populate /dev/disk/azure/scsi1, LUNs are parsed from sysfs
+	luns, err := listLUNsInUse(azureutils.NewFakeIOHandler())
+	if err != nil {
+		t.Errorf("listLUNsInUse failed with error: %v", err)
+	}
+	if !reflect.DeepEqual(luns, []int32{0, 1}) {
+		t.Errorf("unexpected LUNs in use: %v", luns)
+	}
+}
+
+func TestSetVolumeMountGroup(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(dir, 0755))
+	require.NoError(t, setVolumeMountGroup(dir, os.Getgid()))
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0755, info.Mode(), "a root directory owned by the group is left as is")
+
+	if os.Getuid() != 0 {
+		t.Skip("changing the group of a directory to a group of another user requires root")
+	}
+	require.NoError(t, setVolumeMountGroup(dir, 4321))
+	info, err = os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|os.ModeSetgid|0775, info.Mode())
+	assert.Equal(t, uint32(4321), info.Sys().(*syscall.Stat_t).Gid)
+
+	assert.Error(t, setVolumeMountGroup(dir+"/missing", 4321))
+}
+
+// newFakeNVMeSysfs returns the sysfs tree of a VM with the NVMe disk controller, with the OS disk and the data disks at
+// LUN 0 and 3 on nvme0 and a local disk on nvme1
+func newFakeNVMeSysfs() *azureutils.FakeSysfs {
+	return azureutils.NewFakeSysfs(map[string]string{
+		"/sys/class/nvme/nvme0/model":             "MSFT NVMe Accelerator v1.0          \n",
+		"/sys/class/nvme/nvme0/rescan_controller": "",
+		"/sys/class/nvme/nvme0/nvme0n1/nsid":      "1\n",
+		"/sys/class/nvme/nvme0/nvme0n2/nsid":      "2\n",
+		"/sys/class/nvme/nvme0/nvme0n3/nsid":      "5\n",
+		"/sys/class/nvme/nvme0/ng0n2/dev":         "241:1\n",
+		"/sys/class/nvme/nvme1/model":             "Microsoft NVMe Direct Disk          \n",
+		"/sys/class/nvme/nvme1/rescan_controller": "",
+		"/sys/class/nvme/nvme1/nvme1n1/nsid":      "2\n",
+	}, nil)
+}
+
+func TestFindDiskByLunNVMe(t *testing.T) {
+	sysfs := newFakeNVMeSysfs()
+	tests := []struct {
+		lun      int
+		expected string
+	}{
+		{0, "/dev/nvme0n2"},
+		{3, "/dev/nvme0n3"},
+		{1, ""},
+	}
+	for _, test := range tests {
+		disk, err := findDiskByLun(test.lun, sysfs, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, disk, "lun %d", test.lun)
+	}
+
+	luns, err := listLUNsInUse(sysfs)
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{0, 3}, luns)
+
+	// the by-LUN links of the Azure udev rules are preferred, their partitions are linked with a -part suffix
+	sysfs = azureutils.NewFakeSysfs(map[string]string{
+		"/sys/class/nvme/nvme0/model":        "MSFT NVMe Accelerator v1.0          \n",
+		"/sys/class/nvme/nvme0/nvme0n2/nsid": "2\n",
+		"/sys/class/nvme/nvme0/nvme0n3/nsid": "5\n",
+	}, map[string]string{
+		"/dev/disk/azure/data/by-lun/0":       "../../../../nvme0n2",
+		"/dev/disk/azure/data/by-lun/0-part1": "../../../../nvme0n2p1",
+		"/dev/disk/azure/data/by-lun/3":       "../../../../nvme0n4",
+	})
+	disk, err := findDiskByLun(0, sysfs, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/disk/azure/data/by-lun/0", disk)
+	assert.Equal(t, "/dev/disk/azure/data/by-lun/0-part1", getPartitionPath(disk, "1"))
+	disk, err = findDiskByLun(3, sysfs, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/nvme0n3", disk, "a link to another namespace is ignored")
+	assert.Equal(t, "/dev/nvme0n3p1", getPartitionPath(disk, "1"))
+}
+
+func TestRescanNVMe(t *testing.T) {
+	sysfs := newFakeNVMeSysfs()
+	scsiHostRescan(sysfs, nil)
+	assert.Equal(t, []string{"/sys/class/nvme/nvme0/rescan_controller"}, sysfs.Writes())
+
+	sysfs = newFakeNVMeSysfs()
+	assert.NoError(t, rescanVolume(sysfs, "/dev/nvme0n2"))
+	assert.NoError(t, rescanVolume(sysfs, "/dev/nvme0n3p1"))
+	assert.Error(t, rescanVolume(sysfs, "/dev/nvme2n1"))
+	assert.Equal(t, []string{"/sys/class/nvme/nvme0/rescan_controller", "/sys/class/nvme/nvme0/rescan_controller"}, sysfs.Writes())
+
+	sysfs = newFakeNVMeSysfs()
+	assert.Error(t, rescanAllVolumes(sysfs), "there is no block device")
+	assert.Equal(t, []string{"/sys/class/nvme/nvme0/rescan_controller"}, sysfs.Writes())
+}
+
+func TestGetDataDiskLUN(t *testing.T) {
+	scsi := newFakeSCSISysfs()
+	nvme := newFakeNVMeSysfs()
+	tests := []struct {
+		io          azureutils.IOHandler
+		devName     string
+		expected    int
+		expectedErr bool
+	}{
+		{scsi, "sdc", 1, false},
+		{scsi, "sda", 0, true},
+		{scsi, "sdb", 0, true},
+		{scsi, "sdc1", 0, true},
+		{nvme, "nvme0n2", 0, false},
+		{nvme, "nvme0n3", 3, false},
+		{nvme, "nvme0n1", 0, true},
+		{nvme, "nvme0n2p1", 0, true},
+		{nvme, "nvme1n1", 0, true},
+	}
+	for _, test := range tests {
+		lun, err := getDataDiskLUN(test.io, test.devName)
+		if test.expectedErr {
+			assert.Error(t, err, test.devName)
+			continue
+		}
+		assert.NoError(t, err, test.devName)
+		assert.Equal(t, test.expected, lun, test.devName)
+	}
+}
+
+func TestRescanDataDiskLUN(t *testing.T) {
+	sysfs := newFakeSCSISysfs()
+	require.NoError(t, rescanDataDiskLUN(sysfs, 2))
+	assert.Equal(t, []string{"/sys/class/scsi_host/host5/scan"}, sysfs.Writes())
+	content, err := sysfs.ReadFile("/sys/class/scsi_host/host5/scan")
+	require.NoError(t, err)
+	assert.Equal(t, "0 0 2", string(content))
+
+	sysfs = newFakeNVMeSysfs()
+	require.NoError(t, rescanDataDiskLUN(sysfs, 2))
+	assert.Equal(t, []string{"/sys/class/nvme/nvme0/rescan_controller"}, sysfs.Writes())
+
+	assert.Error(t, rescanDataDiskLUN(azureutils.NewFakeSysfs(nil, nil), 2), "no data disk target to rescan")
+}
+
+func TestDeleteSCSIDevice(t *testing.T) {
+	sysfs := azureutils.NewFakeSysfs(map[string]string{
+		"/sys/bus/scsi/devices/5:0:0:1/vendor":  "Msft    \n",
+		"/sys/bus/scsi/devices/5:0:0:1/model":   "Virtual Disk    \n",
+		"/sys/bus/scsi/devices/5:0:0:2/vendor":  "Msft    \n",
+		"/sys/bus/scsi/devices/5:0:0:2/model":   "Virtual Disk    \n",
+		"/sys/class/block/dm-1/slaves/sdc/dev":  "8:32\n",
+		"/sys/class/block/dm-1/slaves/sdd/dev":  "8:48\n",
+		"/sys/class/block/sdc/device/wwid":      "t10.MSFT    Virtual Disk    1\n",
+		"/sys/class/block/sdc/device/delete":    "",
+		"/sys/class/block/nvme0n1/device/model": "",
+	}, map[string]string{
+		"/dev/mapper/azdisk-1-data":   "../dm-1",
+		"/sys/class/block/sda/device": "../../../0:0:0:0",
+		"/sys/class/block/sdc/device": "../../../5:0:0:1",
+		"/sys/class/block/sdd/device": "../../../5:0:0:2",
+	})
+	assert.Equal(t, []string{"sdc"}, listDataDiskSCSIDevices(sysfs, "/dev/sdc"))
+	assert.Equal(t, []string{"sdc", "sdd"}, listDataDiskSCSIDevices(sysfs, "/dev/mapper/azdisk-1-data"))
+	assert.Empty(t, listDataDiskSCSIDevices(sysfs, "/dev/sda"), "OS disk is not a data disk")
+	assert.Empty(t, listDataDiskSCSIDevices(sysfs, "/dev/nvme0n1"))
+
+	identity, err := getSCSIDeviceIdentity(sysfs, "sdc")
+	require.NoError(t, err)
+	assert.Equal(t, "5:0:0:1 t10.MSFT    Virtual Disk    1", identity)
+	identity, err = getSCSIDeviceIdentity(sysfs, "sdd")
+	require.NoError(t, err)
+	assert.Equal(t, "5:0:0:2", identity)
+
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	fakeMounter.Exec.(*mounter.FakeSafeMounter).SetNextCommandOutputScripts(
+		func() ([]byte, []byte, error) { return nil, nil, nil },
+	)
+	assert.Error(t, deleteSCSIDevice(sysfs, "sdc", "5:0:0:3", fakeMounter), "sdc is now another disk")
+	assert.Empty(t, sysfs.Writes())
+	require.NoError(t, deleteSCSIDevice(sysfs, "sdc", "5:0:0:1 t10.MSFT    Virtual Disk    1", fakeMounter))
+	assert.Equal(t, []string{"/sys/class/block/sdc/device/delete"}, sysfs.Writes())
+}
+
+func TestGrowPartition(t *testing.T) {
+	newSysfs := func(diskSectors string, logical bool) *azureutils.FakeSysfs {
+		files := map[string]string{
+			"/sys/class/block/sdc/size":          diskSectors,
+			"/sys/class/block/sdc/device/rescan": "",
+			"/sys/class/block/sdc/sdc1/start":    "2048\n",
+			"/sys/class/block/sdc/sdc2/start":    "4096\n",
+			"/sys/class/block/sdc1/partition":    "1\n",
+			"/sys/class/block/sdc1/start":        "2048\n",
+			"/sys/class/block/sdc1/size":         "2048\n",
+			"/sys/class/block/sdc2/partition":    "2\n",
+			"/sys/class/block/sdc2/start":        "4096\n",
+			"/sys/class/block/sdc2/size":         "2048\n",
+		}
+		links := map[string]string{
+			"/dev/disk/azure/scsi1/lun0-part2": "../../../../sdc2",
+			"/sys/class/block/sdc1":            "../../devices/vmbus/host5/target5:0:0/5:0:0:0/block/sdc/sdc1",
+			"/sys/class/block/sdc2":            "../../devices/vmbus/host5/target5:0:0/5:0:0:0/block/sdc/sdc2",
+		}
+		if logical {
+			// sdc2 is the extended partition holding sdc5
+			files["/sys/class/block/sdc/sdc5/start"] = "6144\n"
+			files["/sys/class/block/sdc5/partition"] = "5\n"
+			files["/sys/class/block/sdc5/start"] = "6144\n"
+			files["/sys/class/block/sdc5/size"] = "2048\n"
+			links["/sys/class/block/sdc5"] = "../../devices/vmbus/host5/target5:0:0/5:0:0:0/block/sdc/sdc5"
+		}
+		return azureutils.NewFakeSysfs(files, links)
+	}
+
+	tests := []struct {
+		desc             string
+		devicePath       string
+		diskSectors      string
+		logical          bool
+		actions          []testingexec.FakeAction
+		expectedCommands []string
+		expectedErr      bool
+	}{
+		{
+			desc:        "not a partition",
+			devicePath:  "/dev/sdc",
+			diskSectors: "20971520\n",
+		},
+		{
+			desc:        "not the last partition",
+			devicePath:  "/dev/sdc1",
+			diskSectors: "20971520\n",
+		},
+		{
+			desc:        "last partition of a GPT disk",
+			devicePath:  "/dev/disk/azure/scsi1/lun0-part2",
+			diskSectors: "20971520\n",
+			actions: []testingexec.FakeAction{
+				commandOutput("gpt\n", nil),
+				commandOutput("", nil),
+				commandOutput("", nil),
+				commandOutput("", nil),
+			},
+			expectedCommands: []string{
+				"blkid -p -s PTTYPE -o value /dev/sdc",
+				"sfdisk --relocate gpt-bak-std /dev/sdc",
+				"sfdisk --no-reread --no-tell-kernel -N 2 /dev/sdc",
+				"partx --update --nr 2 /dev/sdc",
+			},
+		},
+		{
+			desc:        "last partition of an MBR disk",
+			devicePath:  "/dev/sdc2",
+			diskSectors: "20971520\n",
+			actions: []testingexec.FakeAction{
+				commandOutput("dos\n", nil),
+				commandOutput("", nil),
+				commandOutput("", nil),
+			},
+			expectedCommands: []string{
+				"blkid -p -s PTTYPE -o value /dev/sdc",
+				"sfdisk --no-reread --no-tell-kernel -N 2 /dev/sdc",
+				"partx --update --nr 2 /dev/sdc",
+			},
+		},
+		{
+			desc:        "partition at the end of the disk",
+			devicePath:  "/dev/sdc2",
+			diskSectors: "6177\n",
+			actions: []testingexec.FakeAction{
+				commandOutput("gpt\n", nil),
+			},
+			expectedCommands: []string{"blkid -p -s PTTYPE -o value /dev/sdc"},
+		},
+		{
+			desc:        "logical partition",
+			devicePath:  "/dev/sdc5",
+			diskSectors: "20971520\n",
+			logical:     true,
+			actions: []testingexec.FakeAction{
+				commandOutput("dos\n", nil),
+			},
+			expectedCommands: []string{"blkid -p -s PTTYPE -o value /dev/sdc"},
+		},
+		{
+			desc:        "failed growth",
+			devicePath:  "/dev/sdc2",
+			diskSectors: "20971520\n",
+			actions: []testingexec.FakeAction{
+				commandOutput("gpt\n", nil),
+				commandOutput("", nil),
+				commandOutput("sfdisk: failed to resize partition", &testingexec.FakeExitError{Status: 1}),
+			},
+			expectedCommands: []string{
+				"blkid -p -s PTTYPE -o value /dev/sdc",
+				"sfdisk --relocate gpt-bak-std /dev/sdc",
+				"sfdisk --no-reread --no-tell-kernel -N 2 /dev/sdc",
+			},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeMounter, err := mounter.NewFakeSafeMounter()
+			require.NoError(t, err)
+			fakeExec := fakeMounter.Exec.(*mounter.FakeSafeMounter)
+			commands := []string{}
+			if len(test.actions) > 0 {
+				fakeExec.SetNextCommandOutputScripts(test.actions...)
+				for i := range fakeExec.CommandScript {
+					cmd := fakeExec.CommandScript[i]
+					fakeExec.CommandScript[i] = func(name string, args ...string) exec.Cmd {
+						commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+						return cmd(name, args...)
+					}
+				}
+			}
+
+			err = growPartition(newSysfs(test.diskSectors, test.logical), test.devicePath, fakeMounter)
+			assert.Equal(t, test.expectedErr, err != nil, "unexpected error: %v", err)
+			if len(test.expectedCommands) == 0 {
+				assert.Empty(t, commands)
+			} else {
+				assert.Equal(t, test.expectedCommands, commands)
+			}
+		})
+	}
+
+	// partitions have no device, their disk is rescanned
+	sysfs := newSysfs("20971520\n", false)
+	require.NoError(t, rescanVolume(sysfs, "/dev/sdc2"))
+	assert.Equal(t, []string{"/sys/class/block/sdc/device/rescan"}, sysfs.Writes())
+}
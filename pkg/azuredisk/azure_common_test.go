@@ -61,3 +61,20 @@ func TestStrFirstLetterToUpper(t *testing.T) {
 		t.Errorf("result wrong")
 	}
 }
+
+func TestGetPartitionPath(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"/dev/disk/azure/scsi1/lun0", "/dev/disk/azure/scsi1/lun0-part1"},
+		{"/dev/disk/azure/data/by-lun/0", "/dev/disk/azure/data/by-lun/0-part1"},
+		{"/dev/nvme0n2", "/dev/nvme0n2p1"},
+		{"/dev/sdc", "/dev/sdc1"},
+	}
+	for _, test := range tests {
+		if result := getPartitionPath(test.source, "1"); result != test.expected {
+			t.Errorf("getPartitionPath(%s) = %s, expected %s", test.source, result, test.expected)
+		}
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 
@@ -35,7 +36,102 @@ import (
 	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
 )
 
-func formatAndMount(source, target, fstype string, options []string, m *mount.SafeFormatAndMount) error {
+func checkFilesystem(source, fstype string, repair bool, m *mount.SafeFormatAndMount) (string, error) {
+	return "", fmt.Errorf("checkFilesystem not implemented")
+}
+
+func trimFilesystem(target string, m *mount.SafeFormatAndMount) (int64, error) {
+	return 0, fmt.Errorf("trimFilesystem not implemented")
+}
+
+func assembleStripedVolume(vgName string, devices []string, m *mount.SafeFormatAndMount) (string, error) {
+	return "", fmt.Errorf("assembleStripedVolume not implemented")
+}
+
+func growStripedVolume(vgName string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("growStripedVolume not implemented")
+}
+
+func deactivateStripedVolume(vgName string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("deactivateStripedVolume not implemented")
+}
+
+func setupLocalCache(cacheDevice, name, origin string, sizeBytes int64, m *mount.SafeFormatAndMount) (string, error) {
+	return "", fmt.Errorf("setupLocalCache not implemented")
+}
+
+func teardownLocalCache(name string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("teardownLocalCache not implemented")
+}
+
+func resizeLocalCache(name string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("resizeLocalCache not implemented")
+}
+
+func getLocalCacheStats(name string, m *mount.SafeFormatAndMount) (localCacheStats, error) {
+	return localCacheStats{}, fmt.Errorf("getLocalCacheStats not implemented")
+}
+
+func getFilesystemUUID(source string, m *mount.SafeFormatAndMount) (string, string, error) {
+	return "", "", fmt.Errorf("getFilesystemUUID not implemented")
+}
+
+func listMountedFilesystemUUIDs(m *mount.SafeFormatAndMount) (sets.Set[string], error) {
+	return nil, fmt.Errorf("listMountedFilesystemUUIDs not implemented")
+}
+
+func regenerateFilesystemUUID(source, fstype string, repair bool, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("regenerateFilesystemUUID not implemented")
+}
+
+func setVolumeMountGroup(path string, gid int) error {
+	return fmt.Errorf("setVolumeMountGroup not implemented")
+}
+
+func listBlockDevices(io azureutils.IOHandler) ([]string, error) {
+	return nil, fmt.Errorf("listBlockDevices not implemented")
+}
+
+func getDataDiskLUN(io azureutils.IOHandler, devName string) (int, error) {
+	return 0, fmt.Errorf("getDataDiskLUN not implemented")
+}
+
+func getDataDiskPath(io azureutils.IOHandler, lun int, devName string) string {
+	return ""
+}
+
+func getBlockDeviceSizeBytes(io azureutils.IOHandler, devName string) (int64, error) {
+	return 0, fmt.Errorf("getBlockDeviceSizeBytes not implemented")
+}
+
+func getDataDiskIdentifiers(io azureutils.IOHandler, devName string) ([]string, error) {
+	return nil, fmt.Errorf("getDataDiskIdentifiers not implemented")
+}
+
+func rescanDataDiskLUN(io azureutils.IOHandler, lun int) error {
+	return fmt.Errorf("rescanDataDiskLUN not implemented")
+}
+
+func watchBlockDeviceEvents(ctx context.Context, onEvent func(map[string]string)) error {
+	return fmt.Errorf("watchBlockDeviceEvents not implemented")
+}
+
+func listDataDiskSCSIDevices(io azureutils.IOHandler, devicePath string) []string {
+	return nil
+}
+
+func getSCSIDeviceIdentity(io azureutils.IOHandler, devName string) (string, error) {
+	return "", fmt.Errorf("getSCSIDeviceIdentity not implemented")
+}
+
+func deleteSCSIDevice(io azureutils.IOHandler, devName, identity string, m *mount.SafeFormatAndMount) error {
+	return fmt.Errorf("deleteSCSIDevice not implemented")
+}
+
+func formatAndMount(source, target, fstype string, options, formatOptions []string, m *mount.SafeFormatAndMount) error {
+	if len(formatOptions) > 0 {
+		return fmt.Errorf("format options are not supported on Windows")
+	}
 	if proxy, ok := m.Interface.(mounter.CSIProxyMounter); ok {
 		return proxy.FormatAndMount(source, target, fstype, options)
 	}
@@ -63,6 +159,11 @@ func findDiskByLun(lun int, iohandler azureutils.IOHandler, m *mount.SafeFormatA
 	return "", fmt.Errorf("could not cast to csi proxy class")
 }
 
+// listLUNsInUse is not supported on Windows since csi-proxy can only look up a disk by a given LUN
+func listLUNsInUse(io azureutils.IOHandler) ([]int32, error) {
+	return nil, nil
+}
+
 // preparePublishPath - In case of windows, the publish code path creates a soft link
 // from global stage path to the publish path. But kubelet creates the directory in advance.
 // We work around this issue by deleting the publish path then recreating the link.
@@ -154,6 +255,11 @@ func needResizeVolume(devicePath, volumePath string, m *mount.SafeFormatAndMount
 	return false, nil
 }
 
+// growPartition is a no-op on Windows, the partition of a volume is grown by the resize of the volume
+func growPartition(io azureutils.IOHandler, devicePath string, m *mount.SafeFormatAndMount) error {
+	return nil
+}
+
 // rescanVolume rescan device for detecting device size expansion
 func rescanVolume(io azureutils.IOHandler, devicePath string) error {
 	return nil
@@ -39,6 +39,7 @@ import (
 	"k8s.io/klog/v2"
 	"k8s.io/utils/pointer"
 
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/audit"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
 	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
@@ -87,8 +88,10 @@ var (
 )
 
 type controllerCommon struct {
-	diskStateMap  sync.Map // <diskURI, attaching/detaching state>
-	lockMap       *lockMap
+	diskStateMap sync.Map // <diskURI, attaching/detaching state>
+	lockMap      *lockMap
+	// cloudLock guards cloud and clientFactory, which are replaced when the cloud config of the driver is reloaded
+	cloudLock     sync.RWMutex
 	cloud         *provider.Cloud
 	clientFactory azclient.ClientFactory
 	// disk queue that is waiting for attach or detach on specific node
@@ -102,6 +105,10 @@ type controllerCommon struct {
 	// AttachDetachInitialDelayInMs determines initial delay in milliseconds for batch disk attach/detach
 	AttachDetachInitialDelayInMs int
 	ForceDetachBackoff           bool
+	// AuditSink records every mutating Azure call, auditing is disabled when it is nil
+	AuditSink audit.Sink
+	// intentStore persists attach and detach intents before the VM update, intents are not persisted when it is nil
+	intentStore *attachIntentStore
 }
 
 // ExtendedLocation contains additional info about the location of resources.
@@ -112,10 +119,41 @@ type ExtendedLocation struct {
 	Type string `json:"type,omitempty"`
 }
 
+func (c *controllerCommon) getCloud() *provider.Cloud {
+	c.cloudLock.RLock()
+	defer c.cloudLock.RUnlock()
+	return c.cloud
+}
+
+func (c *controllerCommon) getClientFactory() azclient.ClientFactory {
+	c.cloudLock.RLock()
+	defer c.cloudLock.RUnlock()
+	return c.clientFactory
+}
+
+// setCloud replaces the cloud and client factory of the controller. The lock map, disk states and attach and
+// detach batches are kept, so that attaches and detaches in flight stay serialized per VM with later ones.
+func (c *controllerCommon) setCloud(cloud *provider.Cloud) {
+	c.cloudLock.Lock()
+	defer c.cloudLock.Unlock()
+	c.cloud = cloud
+	c.clientFactory = cloud.ComputeClientFactory
+}
+
 // AttachDisk attaches a disk to vm
 // occupiedLuns is used to avoid conflict with other disk attach in k8s VolumeAttachments
 // return (lun, error)
 func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName,
+	cachingMode armcompute.CachingTypes, disk *armcompute.Disk, occupiedLuns []int) (int32, error) {
+	// attach requests are batched per node, so every request is audited on its own
+	// regardless of which caller issued the VM update
+	ae := audit.NewEvent(ctx, c.AuditSink, "AttachDisk", diskURI).WithNode(string(nodeName))
+	lun, err := c.attachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
+	ae.Observe(err)
+	return lun, err
+}
+
+func (c *controllerCommon) attachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName,
 	cachingMode armcompute.CachingTypes, disk *armcompute.Disk, occupiedLuns []int) (int32, error) {
 	diskEncryptionSetID := ""
 	writeAcceleratorEnabled := false
@@ -124,7 +162,7 @@ func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI str
 	// don't check disk state when GetDisk is throttled
 	if disk != nil {
 		if disk.ManagedBy != nil && (disk.Properties == nil || disk.Properties.MaxShares == nil || *disk.Properties.MaxShares <= 1) {
-			vmset, err := c.cloud.GetNodeVMSet(nodeName, azcache.CacheReadTypeUnsafe)
+			vmset, err := c.getCloud().GetNodeVMSet(nodeName, azcache.CacheReadTypeUnsafe)
 			if err != nil {
 				return -1, err
 			}
@@ -225,13 +263,16 @@ func (c *controllerCommon) AttachDisk(ctx context.Context, diskName, diskURI str
 		return lun, nil
 	}
 
-	vmset, err := c.cloud.GetNodeVMSet(nodeName, azcache.CacheReadTypeUnsafe)
+	vmset, err := c.getCloud().GetNodeVMSet(nodeName, azcache.CacheReadTypeUnsafe)
 	if err != nil {
 		return -1, err
 	}
 	c.diskStateMap.Store(disk, "attaching")
 	defer c.diskStateMap.Delete(disk)
 
+	c.recordAttachIntents(ctx, node, diskMap)
+	defer c.completeIntents(ctx, node, diskMapKeys(diskMap))
+
 	defer func() {
 		// invalidate the cache if there is error in disk attach
 		if err != nil {
@@ -307,7 +348,14 @@ func (c *controllerCommon) cleanAttachDiskRequests(nodeName string) (map[string]
 
 // DetachDisk detaches a disk from VM
 func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName) error {
-	if _, err := c.cloud.InstanceID(ctx, nodeName); err != nil {
+	ae := audit.NewEvent(ctx, c.AuditSink, "DetachDisk", diskURI).WithNode(string(nodeName))
+	err := c.detachDisk(ctx, diskName, diskURI, nodeName)
+	ae.Observe(err)
+	return err
+}
+
+func (c *controllerCommon) detachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName) error {
+	if _, err := c.getCloud().InstanceID(ctx, nodeName); err != nil {
 		if errors.Is(err, cloudprovider.InstanceNotFound) {
 			// if host doesn't exist, no need to detach
 			klog.Warningf("azureDisk - failed to get azure instance id(%s), DetachDisk(%s) will assume disk is already detached",
@@ -318,7 +366,7 @@ func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI str
 		return fmt.Errorf("failed to get azure instance id for node %q: %w", nodeName, err)
 	}
 
-	vmset, err := c.cloud.GetNodeVMSet(nodeName, azcache.CacheReadTypeUnsafe)
+	vmset, err := c.getCloud().GetNodeVMSet(nodeName, azcache.CacheReadTypeUnsafe)
 	if err != nil {
 		return err
 	}
@@ -346,6 +394,8 @@ func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI str
 	if len(diskMap) > 0 {
 		c.diskStateMap.Store(disk, "detaching")
 		defer c.diskStateMap.Delete(disk)
+		c.recordDetachIntents(ctx, node, diskMap)
+		defer c.completeIntents(ctx, node, diskMapKeys(diskMap))
 		if err = vmset.DetachDisk(ctx, nodeName, diskMap, false); err != nil {
 			if isInstanceNotFoundError(err) {
 				// if host doesn't exist, no need to detach
@@ -379,7 +429,7 @@ func (c *controllerCommon) DetachDisk(ctx context.Context, diskName, diskURI str
 
 // UpdateVM updates a vm
 func (c *controllerCommon) UpdateVM(ctx context.Context, nodeName types.NodeName) error {
-	vmset, err := c.cloud.GetNodeVMSet(nodeName, azcache.CacheReadTypeUnsafe)
+	vmset, err := c.getCloud().GetNodeVMSet(nodeName, azcache.CacheReadTypeUnsafe)
 	if err != nil {
 		return err
 	}
@@ -442,7 +492,7 @@ func (c *controllerCommon) cleanDetachDiskRequests(nodeName string) (map[string]
 
 // GetNodeDataDisks invokes vmSet interfaces to get data disks for the node.
 func (c *controllerCommon) GetNodeDataDisks(nodeName types.NodeName, crt azcache.AzureCacheReadType) ([]*armcompute.DataDisk, *string, error) {
-	vmset, err := c.cloud.GetNodeVMSet(nodeName, crt)
+	vmset, err := c.getCloud().GetNodeVMSet(nodeName, crt)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -615,7 +665,7 @@ func (c *controllerCommon) checkDiskExists(ctx context.Context, diskURI string)
 		return false, err
 	}
 
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return false, err
 	}
@@ -35,6 +35,7 @@ import (
 	"k8s.io/klog/v2"
 	"k8s.io/utils/pointer"
 
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/audit"
 	"sigs.k8s.io/cloud-provider-azure/pkg/consts"
 	"sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
@@ -112,7 +113,7 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 
 	var createZones []string
 	if len(options.AvailabilityZone) > 0 {
-		requestedZone := c.cloud.GetZoneID(options.AvailabilityZone)
+		requestedZone := c.getCloud().GetZoneID(options.AvailabilityZone)
 		if requestedZone != "" {
 			createZones = append(createZones, requestedZone)
 		}
@@ -132,14 +133,14 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 	diskSizeGB := int32(options.SizeGB)
 	diskSku := options.StorageAccountType
 
-	rg := c.cloud.ResourceGroup
+	rg := c.getCloud().ResourceGroup
 	if options.ResourceGroup != "" {
 		rg = options.ResourceGroup
 	}
-	if options.SubscriptionID != "" && !strings.EqualFold(options.SubscriptionID, c.cloud.SubscriptionID) && options.ResourceGroup == "" {
+	if options.SubscriptionID != "" && !strings.EqualFold(options.SubscriptionID, c.getCloud().SubscriptionID) && options.ResourceGroup == "" {
 		return "", fmt.Errorf("resourceGroup must be specified when subscriptionID(%s) is not empty", options.SubscriptionID)
 	}
-	subsID := c.cloud.SubscriptionID
+	subsID := c.getCloud().SubscriptionID
 	if options.SubscriptionID != "" {
 		subsID = options.SubscriptionID
 	}
@@ -240,7 +241,7 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 		diskProperties.MaxShares = &options.MaxShares
 	}
 
-	location := c.cloud.Location
+	location := c.getCloud().Location
 	if options.Location != "" {
 		location = options.Location
 	}
@@ -253,26 +254,28 @@ func (c *ManagedDiskController) CreateManagedDisk(ctx context.Context, options *
 		Properties: &diskProperties,
 	}
 
-	if c.cloud.HasExtendedLocation() {
+	if c.getCloud().HasExtendedLocation() {
 		model.ExtendedLocation = &armcompute.ExtendedLocation{
-			Name: pointer.String(c.cloud.ExtendedLocationName),
-			Type: to.Ptr(armcompute.ExtendedLocationTypes(c.cloud.ExtendedLocationType)),
+			Name: pointer.String(c.getCloud().ExtendedLocationName),
+			Type: to.Ptr(armcompute.ExtendedLocationTypes(c.getCloud().ExtendedLocationType)),
 		}
 	}
 
 	if len(createZones) > 0 {
 		model.Zones = to.SliceOfPtrs(createZones...)
 	}
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return "", err
 	}
-	if _, err := diskClient.CreateOrUpdate(ctx, rg, options.DiskName, model); err != nil {
+	diskID := fmt.Sprintf(managedDiskPath, subsID, rg, options.DiskName)
+	ae := audit.NewEvent(ctx, c.AuditSink, "CreateManagedDisk", diskID)
+	_, err = diskClient.CreateOrUpdate(ae.Context(ctx), rg, options.DiskName, model)
+	ae.Observe(err)
+	if err != nil {
 		return "", err
 	}
 
-	diskID := fmt.Sprintf(managedDiskPath, subsID, rg, options.DiskName)
-
 	if options.SkipGetDiskOperation {
 		klog.Warningf("azureDisk - GetDisk(%s, StorageAccountType:%s) is throttled, unable to confirm provisioningState in poll process", options.DiskName, options.StorageAccountType)
 	} else {
@@ -315,7 +318,7 @@ func (c *ManagedDiskController) DeleteManagedDisk(ctx context.Context, diskURI s
 	}
 
 	diskName := path.Base(diskURI)
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return err
 	}
@@ -333,7 +336,10 @@ func (c *ManagedDiskController) DeleteManagedDisk(ctx context.Context, diskURI s
 		return fmt.Errorf("disk(%s) already attached to node(%s), could not be deleted", diskURI, *disk.ManagedBy)
 	}
 
-	if err = diskClient.Delete(ctx, resourceGroup, diskName); err != nil {
+	ae := audit.NewEvent(ctx, c.AuditSink, "DeleteManagedDisk", diskURI)
+	err = diskClient.Delete(ae.Context(ctx), resourceGroup, diskName)
+	ae.Observe(err)
+	if err != nil {
 		return err
 	}
 	// We don't need poll here, k8s will immediately stop referencing the disk
@@ -346,7 +352,7 @@ func (c *ManagedDiskController) DeleteManagedDisk(ctx context.Context, diskURI s
 
 // GetDisk return: disk provisionState, diskID, error
 func (c *ManagedDiskController) GetDisk(ctx context.Context, subsID, resourceGroup, diskName string) (string, string, error) {
-	diskclient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskclient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return "", "", err
 	}
@@ -369,7 +375,7 @@ func (c *ManagedDiskController) ResizeDisk(ctx context.Context, diskURI string,
 	if err != nil {
 		return oldSize, err
 	}
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return oldSize, err
 	}
@@ -406,7 +412,10 @@ func (c *ManagedDiskController) ResizeDisk(ctx context.Context, diskURI string,
 		},
 	}
 
-	if _, err := diskClient.Patch(ctx, resourceGroup, diskName, diskParameter); err != nil {
+	ae := audit.NewEvent(ctx, c.AuditSink, "ResizeDisk", diskURI)
+	_, err = diskClient.Patch(ae.Context(ctx), resourceGroup, diskName, diskParameter)
+	ae.Observe(err)
+	if err != nil {
 		return oldSize, err
 	}
 
@@ -423,7 +432,7 @@ func (c *ManagedDiskController) ModifyDisk(ctx context.Context, options *Managed
 		return err
 	}
 
-	diskClient, err := c.clientFactory.GetDiskClientForSub(subsID)
+	diskClient, err := c.getClientFactory().GetDiskClientForSub(subsID)
 	if err != nil {
 		return err
 	}
@@ -478,7 +487,10 @@ func (c *ManagedDiskController) ModifyDisk(ctx context.Context, options *Managed
 	}
 
 	if model.SKU != nil || model.Properties != nil {
-		if _, err := diskClient.Patch(ctx, rg, options.DiskName, model); err != nil {
+		ae := audit.NewEvent(ctx, c.AuditSink, "ModifyDisk", options.SourceResourceID)
+		_, err = diskClient.Patch(ae.Context(ctx), rg, options.DiskName, model)
+		ae.Observe(err)
+		if err != nil {
 			return err
 		}
 	} else {
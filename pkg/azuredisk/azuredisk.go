@@ -22,8 +22,10 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
@@ -41,11 +43,16 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume/util/hostutil"
 	"k8s.io/mount-utils"
+	"k8s.io/utils/lru"
 	"k8s.io/utils/pointer"
 
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/audit"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	csicommon "sigs.k8s.io/azuredisk-csi-driver/pkg/csi-common"
@@ -85,14 +92,18 @@ type hostUtil interface {
 // DriverCore contains fields common to both the V1 and V2 driver, and implements all interfaces of CSI drivers
 type DriverCore struct {
 	csicommon.CSIDriver
-	perfOptimizationEnabled      bool
-	cloudConfigSecretName        string
-	cloudConfigSecretNamespace   string
-	customUserAgent              string
-	userAgentSuffix              string
-	cloud                        *azure.Cloud
-	clientFactory                azclient.ClientFactory
-	diskController               *ManagedDiskController
+	perfOptimizationEnabled    bool
+	cloudConfigSecretName      string
+	cloudConfigSecretNamespace string
+	customUserAgent            string
+	userAgentSuffix            string
+	// cloudLock guards cloud, clientFactory and diskController, which are replaced together when the cloud config is reloaded
+	cloudLock      sync.RWMutex
+	cloud          *azure.Cloud
+	clientFactory  azclient.ClientFactory
+	diskController *ManagedDiskController
+	// provisioner performs the platform operations of the controller service, the ARM provisioner is used when it is nil
+	provisioner                  CloudProvisioner
 	mounter                      *mount.SafeFormatAndMount
 	deviceHelper                 optimization.Interface
 	nodeInfo                     *optimization.NodeInfo
@@ -123,6 +134,39 @@ type DriverCore struct {
 	disableAVSetNodes            bool
 	removeNotReadyTaint          bool
 	kubeClient                   kubernetes.Interface
+	// eventRecorder records the events of the node service, it is nil without a kube client
+	eventRecorder record.EventRecorder
+	// auditSink records every mutating Azure call, auditing is disabled when it is nil
+	auditSink audit.Sink
+	// scopedClouds caches the clouds built from provisioner and snapshotter secrets <secrets hash, *scopedCloud>,
+	// the least recently used cloud is evicted so that the clouds of rotated secrets are released
+	scopedClouds *lru.Cache
+	// attachIntentStore persists the attach and detach intents of the controller, it is nil if intents are not persisted
+	attachIntentStore *attachIntentStore
+	// trimScheduler trims the staged volumes of the node driver, it is nil in the controller
+	trimScheduler *trimScheduler
+	// trimInterval is the trim interval of volumes without a trimSchedule parameter, trim is disabled for them if 0
+	trimInterval time.Duration
+	// deviceIndex indexes the data disks of the node driver from the block device uevents, the data disks are
+	// discovered by rescans if it is nil
+	deviceIndex *deviceIndex
+	// localCacheDevice is the local disk that the local cache of volumes is carved from, local cache is disabled if empty
+	localCacheDevice string
+	// enableVolumeMountGroup advertises the VOLUME_MOUNT_GROUP node capability
+	enableVolumeMountGroup bool
+	// removeStaleDevices deletes the SCSI devices of volumes once they are unstaged
+	removeStaleDevices bool
+	// mountReconcileInterval is the interval at which the mount points of the node are reconciled, the mount
+	// reconciler is disabled if 0
+	mountReconcileInterval time.Duration
+	// kubeletRootDir is the root directory of kubelet holding the staging and target paths of volumes
+	kubeletRootDir string
+	// publishTracker tracks the target paths of the volumes published by the node driver
+	publishTracker *publishTracker
+	// cloudConfigReloadInterval is the interval at which the cloud config is checked for changes, reloading is disabled if 0
+	cloudConfigReloadInterval time.Duration
+	// enableCloudConfigReloadOnNode enables the reload of the cloud config in the node plug-in
+	enableCloudConfigReloadOnNode bool
 	// a timed cache storing volume stats <volumeID, volumeStats>
 	volStatsCache azcache.Resource
 }
@@ -155,7 +199,7 @@ func newDriverV1(options *DriverOptions) *Driver {
 	driver.enableDiskOnlineResize = options.EnableDiskOnlineResize
 	driver.allowEmptyCloudConfig = options.AllowEmptyCloudConfig
 	driver.enableListVolumes = options.EnableListVolumes
-	driver.enableListSnapshots = options.EnableListVolumes
+	driver.enableListSnapshots = options.EnableListSnapshots
 	driver.supportZone = options.SupportZone
 	driver.getNodeInfoFromLabels = options.GetNodeInfoFromLabels
 	driver.enableDiskCapacityCheck = options.EnableDiskCapacityCheck
@@ -175,6 +219,8 @@ func newDriverV1(options *DriverOptions) *Driver {
 	driver.endpoint = options.Endpoint
 	driver.disableAVSetNodes = options.DisableAVSetNodes
 	driver.removeNotReadyTaint = options.RemoveNotReadyTaint
+	driver.cloudConfigReloadInterval = time.Duration(options.CloudConfigReloadIntervalInSec) * time.Second
+	driver.enableCloudConfigReloadOnNode = options.EnableCloudConfigReloadOnNode
 	driver.volumeLocks = volumehelper.NewVolumeLocks()
 	driver.ioHandler = azureutils.NewOSIOHandler()
 	driver.hostUtil = hostutil.NewHostUtil()
@@ -182,6 +228,17 @@ func newDriverV1(options *DriverOptions) *Driver {
 		// nodeid is not needed in controller component
 		klog.Warning("nodeid is empty")
 	}
+	driver.trimInterval = time.Duration(options.TrimIntervalInMinutes) * time.Minute
+	driver.localCacheDevice = options.LocalCacheDevice
+	driver.enableVolumeMountGroup = options.EnableVolumeMountGroup
+	driver.removeStaleDevices = options.RemoveStaleDevices
+	driver.mountReconcileInterval = time.Duration(options.MountReconcileIntervalInMinutes) * time.Minute
+	driver.kubeletRootDir = options.KubeletRootDir
+	driver.publishTracker = newPublishTracker(driver.listPublishedTargets, driver.isTargetMounted)
+	driver.scopedClouds = lru.New(scopedCloudCacheSize)
+	if driver.NodeID != "" {
+		driver.trimScheduler = newTrimScheduler(driver.Name, options.MaxConcurrentTrims, driver.volumeLocks, driver.trimFilesystem)
+	}
 	topologyKey = fmt.Sprintf("topology.%s/zone", driver.Name)
 
 	getter := func(key string) (interface{}, error) { return nil, nil }
@@ -200,6 +257,10 @@ func newDriverV1(options *DriverOptions) *Driver {
 		klog.Fatalf("%v", err)
 	}
 
+	if driver.auditSink, err = audit.NewSink(options.AuditLogPath, options.AuditLogMaxSizeMB, options.AuditLogMaxBackups); err != nil {
+		klog.Fatalf("failed to create audit log sink: %v", err)
+	}
+
 	userAgent := GetUserAgent(driver.Name, driver.customUserAgent, driver.userAgentSuffix)
 	klog.V(2).Infof("driver userAgent: %s", userAgent)
 
@@ -208,6 +269,17 @@ func newDriverV1(options *DriverOptions) *Driver {
 		klog.Warningf("get kubeconfig(%s) failed with error: %v", options.Kubeconfig, err)
 	}
 	driver.kubeClient = kubeClient
+	if kubeClient != nil {
+		driver.eventRecorder = newEventRecorder(kubeClient, driver.Name, driver.NodeID)
+	}
+
+	if kubeClient != nil && driver.NodeID == "" && options.AttachIntentNamespace != "" {
+		owner, err := newLeaderElectionIdentity()
+		if err != nil {
+			klog.Fatalf("failed to get attach intents owner: %v", err)
+		}
+		driver.attachIntentStore = newAttachIntentStore(kubeClient, options.AttachIntentNamespace, driver.Name, owner)
+	}
 
 	cloud, err := azureutils.GetCloudProviderFromClient(context.Background(), kubeClient, driver.cloudConfigSecretName, driver.cloudConfigSecretNamespace,
 		userAgent, driver.allowEmptyCloudConfig, driver.enableTrafficManager, driver.trafficManagerPort)
@@ -216,40 +288,13 @@ func newDriverV1(options *DriverOptions) *Driver {
 	}
 	driver.cloud = cloud
 
+	if driver.provisioner, err = newProvisioner(options.Provisioner, driver.cloud, driver.NodeID); err != nil {
+		klog.Fatalf("failed to create provisioner: %v", err)
+	}
+
 	if driver.cloud != nil {
-		driver.diskController = NewManagedDiskController(driver.cloud)
-		driver.diskController.DisableUpdateCache = driver.disableUpdateCache
-		driver.diskController.AttachDetachInitialDelayInMs = int(driver.attachDetachInitialDelayInMs)
-		driver.diskController.ForceDetachBackoff = driver.forceDetachBackoff
+		driver.diskController = driver.configureCloud(driver.cloud)
 		driver.clientFactory = driver.cloud.ComputeClientFactory
-		if driver.vmType != "" {
-			klog.V(2).Infof("override VMType(%s) in cloud config as %s", driver.cloud.VMType, driver.vmType)
-			driver.cloud.VMType = driver.vmType
-		}
-
-		if driver.NodeID == "" {
-			// Disable UseInstanceMetadata for controller to mitigate a timeout issue using IMDS
-			// https://github.com/kubernetes-sigs/azuredisk-csi-driver/issues/168
-			klog.V(2).Infof("disable UseInstanceMetadata for controller")
-			driver.cloud.Config.UseInstanceMetadata = false
-
-			if driver.cloud.VMType == azurecloudconsts.VMTypeStandard && driver.cloud.DisableAvailabilitySetNodes {
-				klog.V(2).Infof("set DisableAvailabilitySetNodes as false since VMType is %s", driver.cloud.VMType)
-				driver.cloud.DisableAvailabilitySetNodes = false
-			}
-
-			if driver.cloud.VMType == azurecloudconsts.VMTypeVMSS && !driver.cloud.DisableAvailabilitySetNodes && driver.disableAVSetNodes {
-				klog.V(2).Infof("DisableAvailabilitySetNodes for controller since current VMType is vmss")
-				driver.cloud.DisableAvailabilitySetNodes = true
-			}
-			klog.V(2).Infof("cloud: %s, location: %s, rg: %s, VMType: %s, PrimaryScaleSetName: %s, PrimaryAvailabilitySetName: %s, DisableAvailabilitySetNodes: %v", driver.cloud.Cloud, driver.cloud.Location, driver.cloud.ResourceGroup, driver.cloud.VMType, driver.cloud.PrimaryScaleSetName, driver.cloud.PrimaryAvailabilitySetName, driver.cloud.DisableAvailabilitySetNodes)
-		}
-
-		if driver.vmssCacheTTLInSeconds > 0 {
-			klog.V(2).Infof("reset vmssCacheTTLInSeconds as %d", driver.vmssCacheTTLInSeconds)
-			driver.cloud.VMCacheTTLInSeconds = int(driver.vmssCacheTTLInSeconds)
-			driver.cloud.VmssCacheTTLInSeconds = int(driver.vmssCacheTTLInSeconds)
-		}
 	}
 
 	driver.deviceHelper = optimization.NewSafeDeviceHelper()
@@ -290,12 +335,16 @@ func newDriverV1(options *DriverOptions) *Driver {
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
 		})
-	driver.AddNodeServiceCapabilities([]csi.NodeServiceCapability_RPC_Type{
+	nodeCap := []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-	})
+	}
+	if driver.enableVolumeMountGroup && runtime.GOOS != "windows" {
+		nodeCap = append(nodeCap, csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP)
+	}
+	driver.AddNodeServiceCapabilities(nodeCap)
 
 	if kubeClient != nil && driver.removeNotReadyTaint {
 		// Remove taint from node to indicate driver startup success
@@ -307,6 +356,50 @@ func newDriverV1(options *DriverOptions) *Driver {
 	return &driver
 }
 
+// configureCloud applies the driver options to cloud and returns the disk controller using it
+func (d *DriverCore) configureCloud(cloud *azure.Cloud) *ManagedDiskController {
+	diskController := NewManagedDiskController(cloud)
+	diskController.DisableUpdateCache = d.disableUpdateCache
+	diskController.AttachDetachInitialDelayInMs = int(d.attachDetachInitialDelayInMs)
+	diskController.ForceDetachBackoff = d.forceDetachBackoff
+	diskController.AuditSink = d.auditSink
+	diskController.intentStore = d.attachIntentStore
+	d.applyCloudOptions(cloud)
+	return diskController
+}
+
+// applyCloudOptions overrides the cloud config of cloud with the driver options
+func (d *DriverCore) applyCloudOptions(cloud *azure.Cloud) {
+	if d.vmType != "" {
+		klog.V(2).Infof("override VMType(%s) in cloud config as %s", cloud.VMType, d.vmType)
+		cloud.VMType = d.vmType
+	}
+
+	if d.NodeID == "" {
+		// Disable UseInstanceMetadata for controller to mitigate a timeout issue using IMDS
+		// https://github.com/kubernetes-sigs/azuredisk-csi-driver/issues/168
+		klog.V(2).Infof("disable UseInstanceMetadata for controller")
+		cloud.Config.UseInstanceMetadata = false
+
+		if cloud.VMType == azurecloudconsts.VMTypeStandard && cloud.DisableAvailabilitySetNodes {
+			klog.V(2).Infof("set DisableAvailabilitySetNodes as false since VMType is %s", cloud.VMType)
+			cloud.DisableAvailabilitySetNodes = false
+		}
+
+		if cloud.VMType == azurecloudconsts.VMTypeVMSS && !cloud.DisableAvailabilitySetNodes && d.disableAVSetNodes {
+			klog.V(2).Infof("DisableAvailabilitySetNodes for controller since current VMType is vmss")
+			cloud.DisableAvailabilitySetNodes = true
+		}
+		klog.V(2).Infof("cloud: %s, location: %s, rg: %s, VMType: %s, PrimaryScaleSetName: %s, PrimaryAvailabilitySetName: %s, DisableAvailabilitySetNodes: %v", cloud.Cloud, cloud.Location, cloud.ResourceGroup, cloud.VMType, cloud.PrimaryScaleSetName, cloud.PrimaryAvailabilitySetName, cloud.DisableAvailabilitySetNodes)
+	}
+
+	if d.vmssCacheTTLInSeconds > 0 {
+		klog.V(2).Infof("reset vmssCacheTTLInSeconds as %d", d.vmssCacheTTLInSeconds)
+		cloud.VMCacheTTLInSeconds = int(d.vmssCacheTTLInSeconds)
+		cloud.VmssCacheTTLInSeconds = int(d.vmssCacheTTLInSeconds)
+	}
+}
+
 // Run driver initialization
 func (d *Driver) Run(ctx context.Context) error {
 	versionMeta, err := GetVersionYAML(d.Name)
@@ -343,6 +436,22 @@ func (d *Driver) Run(ctx context.Context) error {
 		<-ctx.Done()
 		s.GracefulStop()
 	}()
+	go d.watchCloudConfig(ctx)
+	if d.trimScheduler != nil {
+		go func() {
+			d.restoreTrimSchedule(ctx)
+			d.trimScheduler.run(ctx)
+		}()
+	}
+	if d.NodeID != "" && runtime.GOOS == "linux" {
+		d.startDeviceIndex(ctx)
+	}
+	if d.NodeID != "" && d.kubeClient != nil && d.mountReconcileInterval > 0 && runtime.GOOS == "linux" {
+		go d.runMountReconciler(ctx, d.volumeLocks)
+	}
+	if d.attachIntentStore != nil && d.provisioner == nil {
+		go d.runAttachIntentReconciler(ctx)
+	}
 	// Driver d act as IdentityServer, ControllerServer and NodeServer
 	listener, err := csicommon.Listen(ctx, d.endpoint)
 	if err != nil {
@@ -390,11 +499,7 @@ func (d *Driver) checkDiskExists(ctx context.Context, diskURI string) (*armcompu
 		return nil, nil
 	}
 	subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-	diskClient, err := d.diskController.clientFactory.GetDiskClientForSub(subsID)
-	if err != nil {
-		return nil, err
-	}
-	disk, err := diskClient.Get(ctx, resourceGroup, diskName)
+	disk, err := d.getProvisioner().GetDisk(ctx, subsID, resourceGroup, diskName)
 	if err != nil {
 		return nil, err
 	}
@@ -406,11 +511,7 @@ func (d *Driver) checkDiskCapacity(ctx context.Context, subsID, resourceGroup, d
 		klog.Warningf("skip checkDiskCapacity(%s, %s) since it's still in throttling", resourceGroup, diskName)
 		return true, nil
 	}
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
-	if err != nil {
-		return false, err
-	}
-	disk, err := diskClient.Get(ctx, resourceGroup, diskName)
+	disk, err := d.getContextProvisioner(ctx).GetDisk(ctx, subsID, resourceGroup, diskName)
 	// Because we can not judge the reason of the error. Maybe the disk does not exist.
 	// So here we do not handle the error.
 	if err == nil {
@@ -450,16 +551,27 @@ func (d *DriverCore) setVersion(version string) {
 	d.Version = version
 }
 
-// getCloud returns the value of the cloud field. It is intended for use with unit tests.
+// getCloud returns the value of the cloud field.
 func (d *DriverCore) getCloud() *azure.Cloud {
+	d.cloudLock.RLock()
+	defer d.cloudLock.RUnlock()
 	return d.cloud
 }
 
 // setCloud sets the cloud field. It is intended for use with unit tests.
 func (d *DriverCore) setCloud(cloud *azure.Cloud) {
+	d.cloudLock.Lock()
+	defer d.cloudLock.Unlock()
 	d.cloud = cloud
 }
 
+// getDiskController returns the value of the diskController field.
+func (d *DriverCore) getDiskController() *ManagedDiskController {
+	d.cloudLock.RLock()
+	defer d.cloudLock.RUnlock()
+	return d.diskController
+}
+
 // getMounter returns the value of the mounter field. It is intended for use with unit tests.
 func (d *DriverCore) getMounter() *mount.SafeFormatAndMount {
 	return d.mounter
@@ -496,11 +608,7 @@ func (d *DriverCore) getHostUtil() hostUtil {
 
 // getSnapshotCompletionPercent returns the completion percent of snapshot
 func (d *DriverCore) getSnapshotCompletionPercent(ctx context.Context, subsID, resourceGroup, snapshotName string) (float32, error) {
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
-	if err != nil {
-		return 0.0, err
-	}
-	copySnapshot, err := snapshotClient.Get(ctx, resourceGroup, snapshotName)
+	copySnapshot, err := d.getContextProvisioner(ctx).GetSnapshot(ctx, subsID, resourceGroup, snapshotName)
 	if err != nil {
 		return 0.0, err
 	}
@@ -549,7 +657,7 @@ func (d *DriverCore) waitForSnapshotReady(ctx context.Context, subsID, resourceG
 
 // getUsedLunsFromVolumeAttachments returns a list of used luns from VolumeAttachments
 func (d *DriverCore) getUsedLunsFromVolumeAttachments(ctx context.Context, nodeName string) ([]int, error) {
-	kubeClient := d.cloud.KubeClient
+	kubeClient := d.getCloud().KubeClient
 	if kubeClient == nil || kubeClient.StorageV1() == nil || kubeClient.StorageV1().VolumeAttachments() == nil {
 		return nil, fmt.Errorf("kubeClient or kubeClient.StorageV1() or kubeClient.StorageV1().VolumeAttachments() is nil")
 	}
@@ -583,7 +691,7 @@ func (d *DriverCore) getUsedLunsFromVolumeAttachments(ctx context.Context, nodeN
 
 // getUsedLunsFromNode returns a list of sorted used luns from Node
 func (d *DriverCore) getUsedLunsFromNode(nodeName types.NodeName) ([]int, error) {
-	disks, _, err := d.diskController.GetNodeDataDisks(nodeName, azcache.CacheReadTypeDefault)
+	disks, _, err := d.getProvisioner().GetNodeDataDisks(nodeName, azcache.CacheReadTypeDefault)
 	if err != nil {
 		klog.Errorf("error of getting data disks for node %s: %v", nodeName, err)
 		return nil, err
@@ -601,6 +709,13 @@ func (d *DriverCore) getUsedLunsFromNode(nodeName types.NodeName) ([]int, error)
 	return usedLuns, nil
 }
 
+// newEventRecorder returns an event recorder that records events of component on host to the API server
+func newEventRecorder(kubeClient clientset.Interface, component, host string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component, Host: host})
+}
+
 // getNodeInfoFromLabels get zone, instanceType from node labels
 func getNodeInfoFromLabels(ctx context.Context, nodeName string, kubeClient clientset.Interface) (string, string, error) {
 	if kubeClient == nil || kubeClient.CoreV1() == nil {
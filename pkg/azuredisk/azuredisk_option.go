@@ -18,49 +18,83 @@ package azuredisk
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
 
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	azurecloudconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+)
+
+const (
+	// DriverConfigurationAPIVersion is the version of the driver configuration file format
+	DriverConfigurationAPIVersion = "disk.csi.azure.com/v1alpha1"
+	// DriverConfigurationKind is the kind of the driver configuration file
+	DriverConfigurationKind = "DriverConfiguration"
 )
 
 // DriverOptions defines driver parameters specified in driver deployment
 type DriverOptions struct {
 	// Common options
-	NodeID                     string
-	DriverName                 string
-	VolumeAttachLimit          int64
-	ReservedDataDiskSlotNum    int64
-	EnablePerfOptimization     bool
-	CloudConfigSecretName      string
-	CloudConfigSecretNamespace string
-	CustomUserAgent            string
-	UserAgentSuffix            string
-	UseCSIProxyGAInterface     bool
-	EnableOtelTracing          bool
+	NodeID                         string `json:"nodeID"`
+	DriverName                     string `json:"driverName"`
+	VolumeAttachLimit              int64  `json:"volumeAttachLimit"`
+	ReservedDataDiskSlotNum        int64  `json:"reservedDataDiskSlotNum"`
+	EnablePerfOptimization         bool   `json:"enablePerfOptimization"`
+	CloudConfigSecretName          string `json:"cloudConfigSecretName"`
+	CloudConfigSecretNamespace     string `json:"cloudConfigSecretNamespace"`
+	CustomUserAgent                string `json:"customUserAgent"`
+	UserAgentSuffix                string `json:"userAgentSuffix"`
+	UseCSIProxyGAInterface         bool   `json:"useCSIProxyGAInterface"`
+	EnableOtelTracing              bool   `json:"enableOtelTracing"`
+	Provisioner                    string `json:"provisioner"`
+	CloudConfigReloadIntervalInSec int64  `json:"cloudConfigReloadIntervalInSec"`
+	EnableCloudConfigReloadOnNode  bool   `json:"enableCloudConfigReloadOnNode"`
 
 	//only used in v1
-	EnableDiskOnlineResize       bool
-	AllowEmptyCloudConfig        bool
-	EnableListVolumes            bool
-	EnableListSnapshots          bool
-	SupportZone                  bool
-	GetNodeInfoFromLabels        bool
-	EnableDiskCapacityCheck      bool
-	DisableUpdateCache           bool
-	EnableTrafficManager         bool
-	TrafficManagerPort           int64
-	AttachDetachInitialDelayInMs int64
-	VMSSCacheTTLInSeconds        int64
-	VolStatsCacheExpireInMinutes int64
-	VMType                       string
-	EnableWindowsHostProcess     bool
-	GetNodeIDFromIMDS            bool
-	WaitForSnapshotReady         bool
-	CheckDiskLUNCollision        bool
-	ForceDetachBackoff           bool
-	Kubeconfig                   string
-	Endpoint                     string
-	DisableAVSetNodes            bool
-	RemoveNotReadyTaint          bool
+	EnableDiskOnlineResize          bool   `json:"enableDiskOnlineResize"`
+	AllowEmptyCloudConfig           bool   `json:"allowEmptyCloudConfig"`
+	EnableListVolumes               bool   `json:"enableListVolumes"`
+	EnableListSnapshots             bool   `json:"enableListSnapshots"`
+	SupportZone                     bool   `json:"supportZone"`
+	GetNodeInfoFromLabels           bool   `json:"getNodeInfoFromLabels"`
+	EnableDiskCapacityCheck         bool   `json:"enableDiskCapacityCheck"`
+	DisableUpdateCache              bool   `json:"disableUpdateCache"`
+	EnableTrafficManager            bool   `json:"enableTrafficManager"`
+	TrafficManagerPort              int64  `json:"trafficManagerPort"`
+	AttachDetachInitialDelayInMs    int64  `json:"attachDetachInitialDelayInMs"`
+	VMSSCacheTTLInSeconds           int64  `json:"vmssCacheTTLInSeconds"`
+	VolStatsCacheExpireInMinutes    int64  `json:"volStatsCacheExpireInMinutes"`
+	VMType                          string `json:"vmType"`
+	EnableWindowsHostProcess        bool   `json:"enableWindowsHostProcess"`
+	GetNodeIDFromIMDS               bool   `json:"getNodeIDFromIMDS"`
+	WaitForSnapshotReady            bool   `json:"waitForSnapshotReady"`
+	CheckDiskLUNCollision           bool   `json:"checkDiskLUNCollision"`
+	ForceDetachBackoff              bool   `json:"forceDetachBackoff"`
+	Kubeconfig                      string `json:"kubeconfig"`
+	Endpoint                        string `json:"endpoint"`
+	DisableAVSetNodes               bool   `json:"disableAVSetNodes"`
+	RemoveNotReadyTaint             bool   `json:"removeNotReadyTaint"`
+	AuditLogPath                    string `json:"auditLogPath"`
+	AuditLogMaxSizeMB               int    `json:"auditLogMaxSizeMB"`
+	AuditLogMaxBackups              int    `json:"auditLogMaxBackups"`
+	AttachIntentNamespace           string `json:"attachIntentNamespace"`
+	TrimIntervalInMinutes           int64  `json:"trimIntervalInMinutes"`
+	MaxConcurrentTrims              int    `json:"maxConcurrentTrims"`
+	LocalCacheDevice                string `json:"localCacheDevice"`
+	EnableVolumeMountGroup          bool   `json:"enableVolumeMountGroup"`
+	RemoveStaleDevices              bool   `json:"removeStaleDevices"`
+	MountReconcileIntervalInMinutes int64  `json:"mountReconcileIntervalInMinutes"`
+	KubeletRootDir                  string `json:"kubeletRootDir"`
+
+	//only used in v2
+	DriverObjectNamespace   string `json:"driverObjectNamespace"`
+	HeartbeatFrequencyInSec int    `json:"heartbeatFrequencyInSec"`
 }
 
 func (o *DriverOptions) AddFlags() *flag.FlagSet {
@@ -79,6 +113,9 @@ func (o *DriverOptions) AddFlags() *flag.FlagSet {
 	fs.StringVar(&o.UserAgentSuffix, "user-agent-suffix", "", "userAgent suffix")
 	fs.BoolVar(&o.UseCSIProxyGAInterface, "use-csiproxy-ga-interface", true, "boolean flag to enable csi-proxy GA interface on Windows")
 	fs.BoolVar(&o.EnableOtelTracing, "enable-otel-tracing", false, "If set, enable opentelemetry tracing for the driver. The tracing is disabled by default. Configure the exporter endpoint with OTEL_EXPORTER_OTLP_ENDPOINT and other env variables, see https://opentelemetry.io/docs/specs/otel/configuration/sdk-environment-variables/#general-sdk-configuration.")
+	fs.Int64Var(&o.CloudConfigReloadIntervalInSec, "cloud-config-reload-interval-in-sec", 60, "interval in seconds at which the cloud config file is checked for changes, the cloud config secret is watched and the cloud is rebuilt when either changed, reloading is disabled if 0")
+	fs.BoolVar(&o.EnableCloudConfigReloadOnNode, "enable-cloud-config-reload-on-node", false, "whether the node plug-in also reloads the cloud config when it changes")
+	fs.StringVar(&o.Provisioner, "provisioner", ProvisionerARM, "backend performing the platform operations of the controller, available values: arm, inmemory (an in-process model of disks and VMs for testing)")
 	//only used in v1
 	fs.BoolVar(&o.EnableDiskOnlineResize, "enable-disk-online-resize", true, "boolean flag to enable disk online resize")
 	fs.BoolVar(&o.AllowEmptyCloudConfig, "allow-empty-cloud-config", true, "Whether allow running driver without cloud config")
@@ -104,5 +141,130 @@ func (o *DriverOptions) AddFlags() *flag.FlagSet {
 	fs.BoolVar(&o.RemoveNotReadyTaint, "remove-not-ready-taint", true, "remove NotReady taint from node when node is ready")
 	fs.StringVar(&o.Endpoint, "endpoint", "unix://tmp/csi.sock", "CSI endpoint")
 
+	fs.StringVar(&o.AuditLogPath, "audit-log-path", "", "path of the audit log recording every mutating Azure call, \"stdout\" writes to standard output, audit log is disabled if empty")
+	fs.IntVar(&o.AuditLogMaxSizeMB, "audit-log-max-size-mb", 100, "maximum size in megabytes of the audit log file before it is rotated")
+	fs.IntVar(&o.AuditLogMaxBackups, "audit-log-max-backups", 5, "maximum number of rotated audit log files to retain")
+	fs.StringVar(&o.AttachIntentNamespace, "attach-intent-namespace", "kube-system", "namespace of the ConfigMaps persisting the attach and detach intents of each node so that they are completed or rolled back after a controller restart, intents are not persisted if empty")
+	fs.Int64Var(&o.TrimIntervalInMinutes, "trim-interval-in-minutes", 0, "interval in minutes at which the node driver runs fstrim on staged volumes without a trimSchedule parameter, trim is disabled for those volumes if 0")
+	fs.IntVar(&o.MaxConcurrentTrims, "max-concurrent-trims", 1, "maximum number of volumes the node driver trims at the same time")
+	fs.StringVar(&o.LocalCacheDevice, "local-cache-device", "", "local temp or NVMe disk of the node that the read cache of volumes with a localCache parameter is carved from, the device is dedicated to the driver and initialized as an LVM physical volume, local cache is disabled if empty")
+	fs.BoolVar(&o.EnableVolumeMountGroup, "enable-volume-mount-group", false, "boolean flag to advertise the VOLUME_MOUNT_GROUP node capability on Linux nodes, the node driver then gives the fsGroup of pods ownership of the root directory of volumes instead of kubelet changing the ownership of every file")
+	fs.BoolVar(&o.RemoveStaleDevices, "remove-stale-devices", false, "boolean flag to flush and delete the SCSI devices of a volume on Linux nodes once it is unstaged and no other mount references them, so that a disk attached later at the same LUN is not mistaken for the detached one")
+	fs.Int64Var(&o.MountReconcileIntervalInMinutes, "mount-reconcile-interval-in-minutes", 0, "interval in minutes at which the node driver cleans up the staging and target paths of volumes not attached to the node and repairs the corrupted mount points of attached volumes, the mount reconciler is disabled if 0")
+	fs.StringVar(&o.KubeletRootDir, "kubelet-root-dir", "/var/lib/kubelet", "root directory of kubelet that the mount reconciler looks for staging and target paths in")
+	fs.StringVar(&o.DriverObjectNamespace, "driver-object-namespace", consts.DefaultAzureDiskCrdNamespace, "namespace where driver related custom resources are created (only used in v2)")
+	fs.IntVar(&o.HeartbeatFrequencyInSec, "heartbeat-frequency-in-sec", 30, "frequency in seconds at which node driver sends heartbeat (only used in v2)")
 	return fs
 }
+
+// DriverConfiguration is the format of the driver configuration file, the keys of DriverOptions are inlined
+type DriverConfiguration struct {
+	APIVersion    string `json:"apiVersion"`
+	Kind          string `json:"kind"`
+	DriverOptions `json:",inline"`
+}
+
+// LoadConfigFile overrides the options with the options set in the driver configuration file at path,
+// options missing in the file keep their current value. Unknown keys are rejected.
+func (o *DriverOptions) LoadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read driver configuration file: %v", err)
+	}
+	config := DriverConfiguration{DriverOptions: *o}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return fmt.Errorf("failed to parse driver configuration file %s: %v", path, err)
+	}
+	if config.APIVersion != DriverConfigurationAPIVersion {
+		return fmt.Errorf("unsupported apiVersion %q in driver configuration file %s, supported apiVersion: %s", config.APIVersion, path, DriverConfigurationAPIVersion)
+	}
+	if config.Kind != DriverConfigurationKind {
+		return fmt.Errorf("unsupported kind %q in driver configuration file %s, supported kind: %s", config.Kind, path, DriverConfigurationKind)
+	}
+	*o = config.DriverOptions
+	return nil
+}
+
+// Validate returns an error listing every invalid or conflicting option
+func (o *DriverOptions) Validate() error {
+	var errs []error
+	if o.DriverName == "" {
+		errs = append(errs, fmt.Errorf("drivername must not be empty"))
+	}
+	if o.Endpoint == "" {
+		errs = append(errs, fmt.Errorf("endpoint must not be empty"))
+	}
+	if o.ReservedDataDiskSlotNum < 0 {
+		errs = append(errs, fmt.Errorf("reserved-data-disk-slot-num(%d) must not be negative", o.ReservedDataDiskSlotNum))
+	}
+	if o.VolumeAttachLimit >= 0 && o.ReservedDataDiskSlotNum > 0 {
+		errs = append(errs, fmt.Errorf("reserved-data-disk-slot-num(%d) only applies when volume-attach-limit is negative, got volume-attach-limit(%d)", o.ReservedDataDiskSlotNum, o.VolumeAttachLimit))
+	}
+	if o.Provisioner != "" && o.Provisioner != ProvisionerARM && o.Provisioner != ProvisionerInMemory {
+		errs = append(errs, fmt.Errorf("unsupported provisioner %q, available values: %s, %s", o.Provisioner, ProvisionerARM, ProvisionerInMemory))
+	}
+	if o.CloudConfigReloadIntervalInSec < 0 {
+		errs = append(errs, fmt.Errorf("cloud-config-reload-interval-in-sec(%d) must not be negative", o.CloudConfigReloadIntervalInSec))
+	}
+	switch o.VMType {
+	case "", azurecloudconsts.VMTypeStandard, azurecloudconsts.VMTypeVMSS, azurecloudconsts.VMTypeVmssFlex:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported vm-type %q, available values: %s, %s, %s", o.VMType, azurecloudconsts.VMTypeStandard, azurecloudconsts.VMTypeVMSS, azurecloudconsts.VMTypeVmssFlex))
+	}
+	if o.EnableTrafficManager && (o.TrafficManagerPort <= 0 || o.TrafficManagerPort > 65535) {
+		errs = append(errs, fmt.Errorf("traffic-manager-port(%d) must be between 1 and 65535 when enable-traffic-manager is set", o.TrafficManagerPort))
+	}
+	if o.AttachDetachInitialDelayInMs < 0 {
+		errs = append(errs, fmt.Errorf("attach-detach-initial-delay-ms(%d) must not be negative", o.AttachDetachInitialDelayInMs))
+	}
+	if o.GetNodeIDFromIMDS && o.NodeID == "" {
+		errs = append(errs, fmt.Errorf("get-nodeid-from-imds only applies to the node driver, nodeid must be set"))
+	}
+	if o.AuditLogPath != "" {
+		if o.AuditLogMaxSizeMB <= 0 {
+			errs = append(errs, fmt.Errorf("audit-log-max-size-mb(%d) must be positive when audit-log-path is set", o.AuditLogMaxSizeMB))
+		}
+		if o.AuditLogMaxBackups < 0 {
+			errs = append(errs, fmt.Errorf("audit-log-max-backups(%d) must not be negative", o.AuditLogMaxBackups))
+		}
+	}
+	if o.TrimIntervalInMinutes < 0 || (o.TrimIntervalInMinutes > 0 && time.Duration(o.TrimIntervalInMinutes)*time.Minute < azureutils.MinimumTrimInterval) {
+		errs = append(errs, fmt.Errorf("trim-interval-in-minutes(%d) must be 0 or at least %v", o.TrimIntervalInMinutes, azureutils.MinimumTrimInterval))
+	}
+	if o.MaxConcurrentTrims <= 0 {
+		errs = append(errs, fmt.Errorf("max-concurrent-trims(%d) must be positive", o.MaxConcurrentTrims))
+	}
+	if o.LocalCacheDevice != "" {
+		if o.NodeID == "" {
+			errs = append(errs, fmt.Errorf("local-cache-device only applies to the node driver, nodeid must be set"))
+		}
+		if !filepath.IsAbs(o.LocalCacheDevice) {
+			errs = append(errs, fmt.Errorf("local-cache-device(%s) must be an absolute path", o.LocalCacheDevice))
+		}
+	}
+	if o.MountReconcileIntervalInMinutes < 0 {
+		errs = append(errs, fmt.Errorf("mount-reconcile-interval-in-minutes(%d) must not be negative", o.MountReconcileIntervalInMinutes))
+	}
+	if o.HeartbeatFrequencyInSec < 0 {
+		errs = append(errs, fmt.Errorf("heartbeat-frequency-in-sec(%d) must not be negative", o.HeartbeatFrequencyInSec))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// Warnings returns the options that are tolerated but ignored
+func (o *DriverOptions) Warnings() []string {
+	var warnings []string
+	if o.DisableAVSetNodes && o.VMType == azurecloudconsts.VMTypeStandard {
+		warnings = append(warnings, fmt.Sprintf("disable-avset-nodes is ignored since vm-type is %s", azurecloudconsts.VMTypeStandard))
+	}
+	return warnings
+}
+
+// EffectiveConfig returns the options as a driver configuration file
+func (o *DriverOptions) EffectiveConfig() ([]byte, error) {
+	return yaml.Marshal(DriverConfiguration{
+		APIVersion:    DriverConfigurationAPIVersion,
+		Kind:          DriverConfigurationKind,
+		DriverOptions: *o,
+	})
+}
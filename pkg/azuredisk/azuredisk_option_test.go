@@ -18,8 +18,14 @@ package azuredisk
 
 import (
 	"flag"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDriverOptions_AddFlags(t *testing.T) {
@@ -35,3 +41,202 @@ func TestDriverOptions_AddFlags(t *testing.T) {
 		t.Errorf("DriverOptions.AddFlags() = %v, want %v", count, typeInfo.NumField())
 	}
 }
+
+func TestDriverOptions_JSONTags(t *testing.T) {
+	typeInfo := reflect.TypeOf(DriverOptions{})
+	for i := 0; i < typeInfo.NumField(); i++ {
+		field := typeInfo.Field(i)
+		if tag := field.Tag.Get("json"); tag == "" || tag == "-" {
+			t.Errorf("DriverOptions.%s has no configuration file key", field.Name)
+		}
+	}
+}
+
+func TestDriverOptions_LoadConfigFile(t *testing.T) {
+	tests := []struct {
+		desc        string
+		content     string
+		expectedErr string
+		verify      func(t *testing.T, o *DriverOptions)
+	}{
+		{
+			desc: "keys in the file override the options, missing keys are kept",
+			content: `apiVersion: disk.csi.azure.com/v1alpha1
+kind: DriverConfiguration
+nodeID: node
+vmType: vmss
+vmssCacheTTLInSeconds: 120
+enableListSnapshots: true
+`,
+			verify: func(t *testing.T, o *DriverOptions) {
+				assert.Equal(t, "node", o.NodeID)
+				assert.Equal(t, "vmss", o.VMType)
+				assert.Equal(t, int64(120), o.VMSSCacheTTLInSeconds)
+				assert.True(t, o.EnableListSnapshots)
+				assert.False(t, o.EnableListVolumes)
+				assert.Equal(t, "disk.csi.azure.com", o.DriverName)
+				assert.Equal(t, int64(-1), o.VolumeAttachLimit)
+			},
+		},
+		{
+			desc:        "unknown key",
+			content:     "apiVersion: disk.csi.azure.com/v1alpha1\nkind: DriverConfiguration\nvmTpe: vmss\n",
+			expectedErr: `unknown field "vmTpe"`,
+		},
+		{
+			desc:        "unsupported apiVersion",
+			content:     "apiVersion: disk.csi.azure.com/v2\nkind: DriverConfiguration\n",
+			expectedErr: `unsupported apiVersion "disk.csi.azure.com/v2"`,
+		},
+		{
+			desc:        "missing kind",
+			content:     "apiVersion: disk.csi.azure.com/v1alpha1\n",
+			expectedErr: `unsupported kind ""`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			o := &DriverOptions{}
+			_ = o.AddFlags()
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(test.content), 0600))
+			err := o.LoadConfigFile(path)
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			test.verify(t, o)
+		})
+	}
+}
+
+func TestDriverOptions_Validate(t *testing.T) {
+	tests := []struct {
+		desc         string
+		update       func(o *DriverOptions)
+		expectedErrs []string
+	}{
+		{
+			desc:   "default options",
+			update: func(_ *DriverOptions) {},
+		},
+		{
+			desc: "vmss cache TTL without vm type",
+			update: func(o *DriverOptions) {
+				o.VMSSCacheTTLInSeconds = 120
+			},
+		},
+		{
+			desc: "vmss cache TTL with vm type",
+			update: func(o *DriverOptions) {
+				o.VMType = "vmss"
+				o.VMSSCacheTTLInSeconds = 120
+			},
+		},
+		{
+			desc: "all conflicts are reported",
+			update: func(o *DriverOptions) {
+				o.VMType = "standard"
+				o.DisableAVSetNodes = true
+				o.VolumeAttachLimit = 8
+				o.ReservedDataDiskSlotNum = 2
+				o.Provisioner = "unknown"
+				o.EnableTrafficManager = true
+				o.TrafficManagerPort = 0
+			},
+			expectedErrs: []string{
+				"reserved-data-disk-slot-num(2) only applies when volume-attach-limit is negative",
+				`unsupported provisioner "unknown"`,
+				"traffic-manager-port(0) must be between 1 and 65535",
+			},
+		},
+		{
+			desc: "unsupported vm type",
+			update: func(o *DriverOptions) {
+				o.VMType = "vmas"
+			},
+			expectedErrs: []string{`unsupported vm-type "vmas"`},
+		},
+		{
+			desc: "get node ID from IMDS on controller",
+			update: func(o *DriverOptions) {
+				o.GetNodeIDFromIMDS = true
+			},
+			expectedErrs: []string{"get-nodeid-from-imds only applies to the node driver"},
+		},
+		{
+			desc: "audit log without size",
+			update: func(o *DriverOptions) {
+				o.AuditLogPath = "stdout"
+				o.AuditLogMaxSizeMB = 0
+			},
+			expectedErrs: []string{"audit-log-max-size-mb(0) must be positive"},
+		},
+		{
+			desc: "trim interval below minimum",
+			update: func(o *DriverOptions) {
+				o.TrimIntervalInMinutes = 10
+				o.MaxConcurrentTrims = 0
+			},
+			expectedErrs: []string{
+				"trim-interval-in-minutes(10) must be 0 or at least 1h0m0s",
+				"max-concurrent-trims(0) must be positive",
+			},
+		},
+		{
+			desc: "local cache device on controller",
+			update: func(o *DriverOptions) {
+				o.LocalCacheDevice = "dev/nvme0n1"
+			},
+			expectedErrs: []string{
+				"local-cache-device only applies to the node driver",
+				"local-cache-device(dev/nvme0n1) must be an absolute path",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			o := &DriverOptions{}
+			_ = o.AddFlags()
+			test.update(o)
+			err := o.Validate()
+			if len(test.expectedErrs) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			for _, expectedErr := range test.expectedErrs {
+				assert.Contains(t, err.Error(), expectedErr)
+			}
+		})
+	}
+}
+
+func TestDriverOptions_Warnings(t *testing.T) {
+	o := &DriverOptions{}
+	_ = o.AddFlags()
+	assert.Empty(t, o.Warnings())
+
+	o.VMType = "standard"
+	o.DisableAVSetNodes = true
+	require.NoError(t, o.Validate())
+	assert.Equal(t, []string{"disable-avset-nodes is ignored since vm-type is standard"}, o.Warnings())
+}
+
+func TestDriverOptions_EffectiveConfig(t *testing.T) {
+	o := &DriverOptions{}
+	_ = o.AddFlags()
+	o.VMType = "vmss"
+	config, err := o.EffectiveConfig()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(config), "allowEmptyCloudConfig: true\napiVersion: disk.csi.azure.com/v1alpha1\n"))
+	assert.Contains(t, string(config), "kind: DriverConfiguration\n")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, config, 0600))
+	loaded := &DriverOptions{}
+	require.NoError(t, loaded.LoadConfigFile(path))
+	assert.Equal(t, o, loaded)
+}
@@ -21,12 +21,14 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 	"golang.org/x/sync/errgroup"
@@ -54,6 +56,25 @@ func TestNewDriverV1(t *testing.T) {
 	assert.NotNil(t, d)
 }
 
+func TestNewDriverV1VolumeMountGroup(t *testing.T) {
+	hasVolumeMountGroup := func(d *Driver) bool {
+		for _, nodeCap := range d.NSCap {
+			if nodeCap.GetRpc().GetType() == csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP {
+				return true
+			}
+		}
+		return false
+	}
+	options := &DriverOptions{
+		NodeID:                "node",
+		DriverName:            consts.DefaultDriverName,
+		AllowEmptyCloudConfig: true,
+	}
+	assert.False(t, hasVolumeMountGroup(newDriverV1(options)))
+	options.EnableVolumeMountGroup = true
+	assert.Equal(t, runtime.GOOS != "windows", hasVolumeMountGroup(newDriverV1(options)))
+}
+
 func TestCheckDiskCapacity(t *testing.T) {
 	cntl := gomock.NewController(t)
 	defer cntl.Finish()
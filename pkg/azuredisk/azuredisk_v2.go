@@ -26,6 +26,9 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -33,16 +36,20 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume/util/hostutil"
+	"k8s.io/utils/lru"
 
+	azdiskclientset "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/client/clientset/versioned"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/audit"
+	azurediskconsts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	csicommon "sigs.k8s.io/azuredisk-csi-driver/pkg/csi-common"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
 	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
-	consts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
 )
 
 var useDriverV2 = flag.Bool("temp-use-driver-v2", false, "A temporary flag to enable early test and development of Azure Disk CSI Driver V2. This will be removed in the future.")
@@ -50,7 +57,12 @@ var useDriverV2 = flag.Bool("temp-use-driver-v2", false, "A temporary flag to en
 // DriverV2 implements all interfaces of CSI drivers
 type DriverV2 struct {
 	DriverCore
-	volumeLocks *volumehelper.VolumeLocks
+	volumeLocks        *volumehelper.VolumeLocks
+	azDiskClient       azdiskclientset.Interface
+	objectNamespace    string
+	heartbeatFrequency time.Duration
+	// azVolumeAttachmentController is set when the controller plug-in manages attachments through AzVolumeAttachment objects
+	azVolumeAttachmentController *azVolumeAttachmentController
 }
 
 // NewDriver creates a Driver or DriverV2 object depending on the --temp-use-driver-v2 flag.
@@ -77,12 +89,33 @@ func newDriverV2(options *DriverOptions) *DriverV2 {
 	driver.cloudConfigSecretNamespace = options.CloudConfigSecretNamespace
 	driver.customUserAgent = options.CustomUserAgent
 	driver.userAgentSuffix = options.UserAgentSuffix
+	driver.cloudConfigReloadInterval = time.Duration(options.CloudConfigReloadIntervalInSec) * time.Second
+	driver.enableCloudConfigReloadOnNode = options.EnableCloudConfigReloadOnNode
 	driver.useCSIProxyGAInterface = options.UseCSIProxyGAInterface
 	driver.enableOtelTracing = options.EnableOtelTracing
 	driver.ioHandler = azureutils.NewOSIOHandler()
 	driver.hostUtil = hostutil.NewHostUtil()
 	driver.disableAVSetNodes = options.DisableAVSetNodes
 	driver.endpoint = options.Endpoint
+	driver.objectNamespace = options.DriverObjectNamespace
+	if driver.objectNamespace == "" {
+		driver.objectNamespace = azurediskconsts.DefaultAzureDiskCrdNamespace
+	}
+	if options.HeartbeatFrequencyInSec <= 0 {
+		options.HeartbeatFrequencyInSec = 30 // default heartbeat every 30 seconds
+	}
+	driver.heartbeatFrequency = time.Duration(options.HeartbeatFrequencyInSec) * time.Second
+	driver.trimInterval = time.Duration(options.TrimIntervalInMinutes) * time.Minute
+	driver.localCacheDevice = options.LocalCacheDevice
+	driver.enableVolumeMountGroup = options.EnableVolumeMountGroup
+	driver.removeStaleDevices = options.RemoveStaleDevices
+	driver.mountReconcileInterval = time.Duration(options.MountReconcileIntervalInMinutes) * time.Minute
+	driver.kubeletRootDir = options.KubeletRootDir
+	driver.publishTracker = newPublishTracker(driver.listPublishedTargets, driver.isTargetMounted)
+	driver.scopedClouds = lru.New(scopedCloudCacheSize)
+	if driver.NodeID != "" {
+		driver.trimScheduler = newTrimScheduler(driver.Name, options.MaxConcurrentTrims, driver.volumeLocks, driver.trimFilesystem)
+	}
 
 	topologyKey = fmt.Sprintf("topology.%s/zone", driver.Name)
 	userAgent := GetUserAgent(driver.Name, driver.customUserAgent, driver.userAgentSuffix)
@@ -97,6 +130,13 @@ func newDriverV2(options *DriverOptions) *DriverV2 {
 	}
 	driver.kubeClient = kubeClient
 
+	if kubeClient != nil {
+		driver.eventRecorder = newEventRecorder(kubeClient, driver.Name, driver.NodeID)
+		if driver.azDiskClient, err = azureutils.GetAzDiskClient(options.Kubeconfig); err != nil {
+			klog.Fatalf("failed to get AzDiskClient: %v", err)
+		}
+	}
+
 	cloud, err := azureutils.GetCloudProviderFromClient(context.Background(), kubeClient, driver.cloudConfigSecretName, driver.cloudConfigSecretNamespace,
 		userAgent, driver.allowEmptyCloudConfig, driver.enableTrafficManager, driver.trafficManagerPort)
 	if err != nil {
@@ -104,33 +144,17 @@ func newDriverV2(options *DriverOptions) *DriverV2 {
 	}
 	driver.cloud = cloud
 
+	if driver.auditSink, err = audit.NewSink(options.AuditLogPath, options.AuditLogMaxSizeMB, options.AuditLogMaxBackups); err != nil {
+		klog.Fatalf("failed to create audit log sink: %v", err)
+	}
+
+	if driver.provisioner, err = newProvisioner(options.Provisioner, driver.cloud, driver.NodeID); err != nil {
+		klog.Fatalf("failed to create provisioner: %v", err)
+	}
+
 	if driver.cloud != nil {
-		driver.diskController = NewManagedDiskController(driver.cloud)
-		driver.diskController.DisableUpdateCache = driver.disableUpdateCache
-		driver.diskController.AttachDetachInitialDelayInMs = int(driver.attachDetachInitialDelayInMs)
+		driver.diskController = driver.configureCloud(driver.cloud)
 		driver.clientFactory = driver.cloud.ComputeClientFactory
-		if driver.vmType != "" {
-			klog.V(2).Infof("override VMType(%s) in cloud config as %s", driver.cloud.VMType, driver.vmType)
-			driver.cloud.VMType = driver.vmType
-		}
-
-		if driver.NodeID == "" {
-			// Disable UseInstanceMetadata for controller to mitigate a timeout issue using IMDS
-			// https://github.com/kubernetes-sigs/azuredisk-csi-driver/issues/168
-			klog.V(2).Infof("disable UseInstanceMetadata for controller")
-			driver.cloud.Config.UseInstanceMetadata = false
-
-			if driver.cloud.VMType == consts.VMTypeStandard && driver.cloud.DisableAvailabilitySetNodes {
-				klog.V(2).Infof("set DisableAvailabilitySetNodes as false since VMType is %s", driver.cloud.VMType)
-				driver.cloud.DisableAvailabilitySetNodes = false
-			}
-
-			if driver.cloud.VMType == consts.VMTypeVMSS && !driver.cloud.DisableAvailabilitySetNodes && driver.disableAVSetNodes {
-				klog.V(2).Infof("DisableAvailabilitySetNodes for controller since current VMType is vmss")
-				driver.cloud.DisableAvailabilitySetNodes = true
-			}
-			klog.V(2).Infof("cloud: %s, location: %s, rg: %s, VMType: %s, PrimaryScaleSetName: %s, PrimaryAvailabilitySetName: %s, DisableAvailabilitySetNodes: %v", driver.cloud.Cloud, driver.cloud.Location, driver.cloud.ResourceGroup, driver.cloud.VMType, driver.cloud.PrimaryScaleSetName, driver.cloud.PrimaryAvailabilitySetName, driver.cloud.DisableAvailabilitySetNodes)
-		}
 	}
 
 	driver.deviceHelper = optimization.NewSafeDeviceHelper()
@@ -167,12 +191,16 @@ func newDriverV2(options *DriverOptions) *DriverV2 {
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
 		})
-	driver.AddNodeServiceCapabilities([]csi.NodeServiceCapability_RPC_Type{
+	nodeCap := []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-	})
+	}
+	if driver.enableVolumeMountGroup && runtime.GOOS != "windows" {
+		nodeCap = append(nodeCap, csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP)
+	}
+	driver.AddNodeServiceCapabilities(nodeCap)
 	return &driver
 }
 
@@ -201,6 +229,22 @@ func (d *DriverV2) Run(ctx context.Context) error {
 		<-ctx.Done()
 		s.GracefulStop()
 	}()
+	go d.watchCloudConfig(ctx)
+	if d.trimScheduler != nil {
+		go func() {
+			d.restoreTrimSchedule(ctx)
+			d.trimScheduler.run(ctx)
+		}()
+	}
+	if d.NodeID != "" && runtime.GOOS == "linux" {
+		d.startDeviceIndex(ctx)
+	}
+	if d.NodeID != "" && d.kubeClient != nil && d.mountReconcileInterval > 0 && runtime.GOOS == "linux" {
+		go d.runMountReconciler(ctx, d.volumeLocks)
+	}
+
+	d.runControllers(ctx)
+
 	// Driver d act as IdentityServer, ControllerServer and NodeServer
 	listener, err := csicommon.Listen(ctx, d.endpoint)
 	if err != nil {
@@ -214,6 +258,36 @@ func (d *DriverV2) Run(ctx context.Context) error {
 	return err
 }
 
+// runControllers starts the AzDriverNode heartbeat in the node plug-in and the custom resource controllers in the controller plug-in
+func (d *DriverV2) runControllers(ctx context.Context) {
+	if d.azDiskClient == nil {
+		klog.Warning("AzDiskClient is not available, driver custom resources are not managed")
+		return
+	}
+
+	if d.NodeID != "" {
+		go d.runAzDriverNodeHeartbeat(ctx)
+		return
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	azDriverNodeGC := newAzDriverNodeGarbageCollector(d.azDiskClient, d.objectNamespace, informerFactory.Core().V1().Nodes(), azDriverNodeGCPeriod)
+	d.azVolumeAttachmentController = newAzVolumeAttachmentController(d, informerFactory.Core().V1().Nodes(), replicaGCDelay)
+	informerFactory.Start(ctx.Done())
+	// only one replica of the controller plug-in attaches and detaches the disks of AzVolumeAttachment objects and
+	// deletes orphaned AzDriverNode objects
+	go runLeaderElected(ctx, d.kubeClient, d.objectNamespace, d.controllersLeaseName(), func(ctx context.Context) {
+		go azDriverNodeGC.Run(ctx)
+		d.azVolumeAttachmentController.Run(ctx)
+	})
+}
+
+// controllersLeaseName returns the name of the lease held by the replica of the controller plug-in running the
+// custom resource controllers
+func (d *DriverV2) controllersLeaseName() string {
+	return strings.ReplaceAll(d.Name, ".", "-") + "-controllers"
+}
+
 func (d *DriverV2) checkDiskExists(ctx context.Context, diskURI string) (*armcompute.Disk, error) {
 	diskName, err := azureutils.GetDiskName(diskURI)
 	if err != nil {
@@ -226,12 +300,7 @@ func (d *DriverV2) checkDiskExists(ctx context.Context, diskURI string) (*armcom
 	}
 
 	subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
-	if err != nil {
-		return nil, err
-	}
-
-	disk, err := diskClient.Get(ctx, resourceGroup, diskName)
+	disk, err := d.getProvisioner().GetDisk(ctx, subsID, resourceGroup, diskName)
 	if err != nil {
 		return nil, err
 	}
@@ -240,11 +309,7 @@ func (d *DriverV2) checkDiskExists(ctx context.Context, diskURI string) (*armcom
 }
 
 func (d *DriverV2) checkDiskCapacity(ctx context.Context, subsID, resourceGroup, diskName string, requestGiB int) (bool, error) {
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
-	if err != nil {
-		return false, err
-	}
-	disk, err := diskClient.Get(ctx, resourceGroup, diskName)
+	disk, err := d.getProvisioner().GetDisk(ctx, subsID, resourceGroup, diskName)
 	// Because we can not judge the reason of the error. Maybe the disk does not exist.
 	// So here we do not handle the error.
 	if err == nil {
@@ -0,0 +1,484 @@
+//go:build azurediskv2
+// +build azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	azdiskv1beta2 "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/azuredisk/v1beta2"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+const (
+	// replicaGCDelay is the time attachment replicas are kept after the primary attachment of a volume is removed
+	replicaGCDelay = 5 * time.Minute
+	// azVolumeAttachmentVolumeIndex indexes AzVolumeAttachment objects by the name of their volume
+	azVolumeAttachmentVolumeIndex = "volumeName"
+)
+
+// replicaGCKey is the work queue key used to garbage-collect the attachment replicas of a volume
+type replicaGCKey string
+
+// azVolumeAttachmentController attaches and detaches disks as described by AzVolumeAttachment objects.
+// Once a primary attachment is attached, it creates replica attachments to backup nodes up to the
+// maxMountReplicaCount of the volume, and it removes them if the volume is not published again within replicaGCDelay.
+type azVolumeAttachmentController struct {
+	driver         *DriverV2
+	namespace      string
+	informer       cache.SharedIndexInformer
+	nodeLister     corelisters.NodeLister
+	nodesSynced    cache.InformerSynced
+	queue          workqueue.RateLimitingInterface
+	replicaGCDelay time.Duration
+}
+
+func newAzVolumeAttachmentController(d *DriverV2, nodeInformer coreinformers.NodeInformer, replicaGCDelay time.Duration) *azVolumeAttachmentController {
+	azVolumeAttachments := d.azDiskClient.DiskV1beta2().AzVolumeAttachments(d.objectNamespace)
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return azVolumeAttachments.List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return azVolumeAttachments.Watch(context.Background(), options)
+			},
+		},
+		&azdiskv1beta2.AzVolumeAttachment{},
+		0,
+		cache.Indexers{azVolumeAttachmentVolumeIndex: indexAzVolumeAttachmentByVolume},
+	)
+
+	c := &azVolumeAttachmentController{
+		driver:         d,
+		namespace:      d.objectNamespace,
+		informer:       informer,
+		nodeLister:     nodeInformer.Lister(),
+		nodesSynced:    nodeInformer.Informer().HasSynced,
+		queue:          workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(), workqueue.RateLimitingQueueConfig{Name: "azvolumeattachment"}),
+		replicaGCDelay: replicaGCDelay,
+	}
+	_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+		DeleteFunc: c.onDelete,
+	})
+	return c
+}
+
+func indexAzVolumeAttachmentByVolume(obj interface{}) ([]string, error) {
+	attachment, ok := obj.(*azdiskv1beta2.AzVolumeAttachment)
+	if !ok {
+		return nil, fmt.Errorf("object is not an AzVolumeAttachment: %#v", obj)
+	}
+	return []string{attachment.Spec.VolumeName}, nil
+}
+
+func (c *azVolumeAttachmentController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.Errorf("failed to get key of AzVolumeAttachment %#v: %v", obj, err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *azVolumeAttachmentController) onDelete(obj interface{}) {
+	attachment, ok := obj.(*azdiskv1beta2.AzVolumeAttachment)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			klog.Errorf("couldn't get object from tombstone %#v", obj)
+			return
+		}
+		if attachment, ok = tombstone.Obj.(*azdiskv1beta2.AzVolumeAttachment); !ok {
+			klog.Errorf("tombstone contained object that is not an AzVolumeAttachment %#v", obj)
+			return
+		}
+	}
+	if attachment.Spec.RequestedRole == azdiskv1beta2.PrimaryRole {
+		klog.V(2).Infof("scheduling garbage collection of attachment replicas of volume %s in %v", attachment.Spec.VolumeName, c.replicaGCDelay)
+		c.queue.AddAfter(replicaGCKey(attachment.Spec.VolumeName), c.replicaGCDelay)
+	}
+}
+
+// Run waits for the caches to sync and reconciles AzVolumeAttachment objects until ctx is done
+func (c *azVolumeAttachmentController) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+
+	klog.V(2).Infof("starting AzVolumeAttachment controller")
+	go c.informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced, c.nodesSynced) {
+		klog.Errorf("failed to sync caches for AzVolumeAttachment controller")
+		return
+	}
+
+	go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+
+	<-ctx.Done()
+	klog.V(2).Infof("stopping AzVolumeAttachment controller")
+}
+
+func (c *azVolumeAttachmentController) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *azVolumeAttachmentController) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	var err error
+	switch k := key.(type) {
+	case replicaGCKey:
+		err = c.garbageCollectReplicas(ctx, string(k))
+	case string:
+		err = c.reconcile(ctx, k)
+	}
+	if err != nil {
+		klog.Errorf("failed to reconcile %v: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// reconcile drives the AzVolumeAttachment identified by key towards its requested state
+func (c *azVolumeAttachmentController) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	attachment, err := c.driver.azDiskClient.DiskV1beta2().AzVolumeAttachments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if isDetachRequested(attachment) {
+		return c.detach(ctx, attachment)
+	}
+
+	if attachment.Status.State != azdiskv1beta2.Attached || attachment.Status.Detail == nil {
+		if attachment, err = c.attach(ctx, attachment); err != nil {
+			return err
+		}
+	} else if attachment.Status.Detail.Role != attachment.Spec.RequestedRole {
+		updated := attachment.DeepCopy()
+		updated.Status.Detail.PreviousRole = attachment.Status.Detail.Role
+		updated.Status.Detail.Role = attachment.Spec.RequestedRole
+		if attachment, err = c.updateStatus(ctx, updated); err != nil {
+			return err
+		}
+		klog.V(2).Infof("AzVolumeAttachment(%s) changed role from %s to %s", name, attachment.Status.Detail.PreviousRole, attachment.Status.Detail.Role)
+	}
+
+	if attachment.Spec.RequestedRole == azdiskv1beta2.PrimaryRole {
+		return c.ensureReplicas(ctx, attachment)
+	}
+	return nil
+}
+
+func (c *azVolumeAttachmentController) updateStatus(ctx context.Context, attachment *azdiskv1beta2.AzVolumeAttachment) (*azdiskv1beta2.AzVolumeAttachment, error) {
+	updated, err := c.driver.azDiskClient.DiskV1beta2().AzVolumeAttachments(attachment.Namespace).UpdateStatus(ctx, attachment, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update status of AzVolumeAttachment(%s): %v", attachment.Name, err)
+	}
+	return updated, nil
+}
+
+// newAzError converts an error returned by a CSI helper to the error recorded in the AzVolumeAttachment status
+func newAzError(err error) *azdiskv1beta2.AzError {
+	s := status.Convert(err)
+	return &azdiskv1beta2.AzError{
+		Code:    s.Code().String(),
+		Message: s.Message(),
+	}
+}
+
+// attach attaches the disk with the existing batched AttachDisk and records the publish context in the status
+func (c *azVolumeAttachmentController) attach(ctx context.Context, attachment *azdiskv1beta2.AzVolumeAttachment) (*azdiskv1beta2.AzVolumeAttachment, error) {
+	if attachment.Status.State != azdiskv1beta2.Attaching {
+		updated := attachment.DeepCopy()
+		updated.Status.State = azdiskv1beta2.Attaching
+		var err error
+		if attachment, err = c.updateStatus(ctx, updated); err != nil {
+			return nil, err
+		}
+	}
+
+	nodeName := types.NodeName(attachment.Spec.NodeName)
+	publishContext, err := func() (map[string]string, error) {
+		disk, err := c.driver.checkDiskExists(ctx, attachment.Spec.VolumeID)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "Volume not found, failed with error: %v", err)
+		}
+		volumeContext := attachment.Spec.VolumeContext
+		if volumeContext == nil {
+			volumeContext = map[string]string{}
+		}
+		return c.driver.attachDiskToNode(ctx, attachment.Spec.VolumeName, attachment.Spec.VolumeID, nodeName, volumeContext, disk)
+	}()
+
+	updated := attachment.DeepCopy()
+	if err != nil {
+		updated.Status.State = azdiskv1beta2.AttachmentFailed
+		updated.Status.Error = newAzError(err)
+		if _, uerr := c.updateStatus(ctx, updated); uerr != nil {
+			klog.Errorf("%v", uerr)
+		}
+		return nil, fmt.Errorf("failed to attach volume %s to node %s: %v", attachment.Spec.VolumeID, nodeName, err)
+	}
+
+	updated.Status.State = azdiskv1beta2.Attached
+	updated.Status.Error = nil
+	detail := &azdiskv1beta2.AzVolumeAttachmentStatusDetail{
+		Role:           attachment.Spec.RequestedRole,
+		PublishContext: publishContext,
+	}
+	if attachment.Status.Detail != nil && attachment.Status.Detail.Role != detail.Role {
+		detail.PreviousRole = attachment.Status.Detail.Role
+	}
+	updated.Status.Detail = detail
+	klog.V(2).Infof("AzVolumeAttachment(%s): volume %s attached to node %s as %s", attachment.Name, attachment.Spec.VolumeID, nodeName, detail.Role)
+	return c.updateStatus(ctx, updated)
+}
+
+// detach detaches the disk and deletes the AzVolumeAttachment once the disk is detached
+func (c *azVolumeAttachmentController) detach(ctx context.Context, attachment *azdiskv1beta2.AzVolumeAttachment) error {
+	if attachment.Status.State != azdiskv1beta2.Detaching {
+		updated := attachment.DeepCopy()
+		updated.Status.State = azdiskv1beta2.Detaching
+		var err error
+		if attachment, err = c.updateStatus(ctx, updated); err != nil {
+			return err
+		}
+	}
+
+	nodeName := types.NodeName(attachment.Spec.NodeName)
+	if err := c.driver.detachDiskFromNode(ctx, attachment.Spec.VolumeName, attachment.Spec.VolumeID, nodeName); err != nil {
+		updated := attachment.DeepCopy()
+		updated.Status.State = azdiskv1beta2.DetachmentFailed
+		updated.Status.Error = newAzError(err)
+		if _, uerr := c.updateStatus(ctx, updated); uerr != nil {
+			klog.Errorf("%v", uerr)
+		}
+		return fmt.Errorf("failed to detach volume %s from node %s: %v", attachment.Spec.VolumeID, nodeName, err)
+	}
+
+	err := c.driver.azDiskClient.DiskV1beta2().AzVolumeAttachments(attachment.Namespace).Delete(ctx, attachment.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete AzVolumeAttachment(%s): %v", attachment.Name, err)
+	}
+	return nil
+}
+
+// getAzVolumeAttachments returns the AzVolumeAttachment objects of a volume from the informer cache
+func (c *azVolumeAttachmentController) getAzVolumeAttachments(volumeName string) ([]*azdiskv1beta2.AzVolumeAttachment, error) {
+	objs, err := c.informer.GetIndexer().ByIndex(azVolumeAttachmentVolumeIndex, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	attachments := make([]*azdiskv1beta2.AzVolumeAttachment, 0, len(objs))
+	for _, obj := range objs {
+		if attachment, ok := obj.(*azdiskv1beta2.AzVolumeAttachment); ok {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments, nil
+}
+
+// ensureReplicas creates replica attachments of the volume of primary until maxMountReplicaCount replicas exist
+func (c *azVolumeAttachmentController) ensureReplicas(ctx context.Context, primary *azdiskv1beta2.AzVolumeAttachment) error {
+	maxMountReplicaCount, err := strconv.Atoi(primary.Spec.VolumeContext[consts.MaxMountReplicaCountField])
+	if err != nil || maxMountReplicaCount <= 0 {
+		return nil
+	}
+
+	attachments, err := c.getAzVolumeAttachments(primary.Spec.VolumeName)
+	if err != nil {
+		return err
+	}
+	usedNodes := sets.New[string](primary.Spec.NodeName)
+	replicaCount := 0
+	for _, attachment := range attachments {
+		usedNodes.Insert(attachment.Spec.NodeName)
+		if attachment.Spec.RequestedRole == azdiskv1beta2.ReplicaRole && !isDetachRequested(attachment) {
+			replicaCount++
+		}
+	}
+	required := maxMountReplicaCount - replicaCount
+	if required <= 0 {
+		return nil
+	}
+
+	disk, err := c.driver.checkDiskExists(ctx, primary.Spec.VolumeID)
+	if err != nil {
+		return fmt.Errorf("failed to get volume %s: %v", primary.Spec.VolumeID, err)
+	}
+	var zones sets.Set[string]
+	if disk.Zones != nil && disk.Location != nil {
+		zones = sets.New[string]()
+		for _, zone := range disk.Zones {
+			if zone != nil {
+				zones.Insert(fmt.Sprintf("%s-%s", *disk.Location, *zone))
+			}
+		}
+	}
+
+	candidates, err := c.getReplicaCandidateNodes(ctx, usedNodes, zones)
+	if err != nil {
+		return err
+	}
+	if len(candidates) < required {
+		klog.V(2).Infof("only %d of %d attachment replicas of volume %s can be created", len(candidates), required, primary.Spec.VolumeID)
+		required = len(candidates)
+	}
+
+	azVolumeAttachments := c.driver.azDiskClient.DiskV1beta2().AzVolumeAttachments(primary.Namespace)
+	for _, nodeName := range candidates[:required] {
+		name := getAzVolumeAttachmentName(primary.Spec.VolumeName, types.NodeName(nodeName))
+		klog.V(2).Infof("creating replica AzVolumeAttachment(%s) for volume %s on node %s", name, primary.Spec.VolumeID, nodeName)
+		_, err := azVolumeAttachments.Create(ctx, &azdiskv1beta2.AzVolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: primary.Namespace,
+			},
+			Spec: azdiskv1beta2.AzVolumeAttachmentSpec{
+				VolumeName:    primary.Spec.VolumeName,
+				VolumeID:      primary.Spec.VolumeID,
+				NodeName:      nodeName,
+				VolumeContext: primary.Spec.VolumeContext,
+				RequestedRole: azdiskv1beta2.ReplicaRole,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create AzVolumeAttachment(%s): %v", name, err)
+		}
+	}
+	return nil
+}
+
+// getReplicaCandidateNodes returns the ready and schedulable nodes not in excluded whose node plug-in is ready for
+// volume allocation, restricted to zones if set. Nodes with fewer disks attached are preferred.
+func (c *azVolumeAttachmentController) getReplicaCandidateNodes(ctx context.Context, excluded, zones sets.Set[string]) ([]string, error) {
+	azDriverNodes, err := c.driver.azDiskClient.DiskV1beta2().AzDriverNodes(c.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list AzDriverNodes: %v", err)
+	}
+	lunsInUse := map[string]int{}
+	for _, azDriverNode := range azDriverNodes.Items {
+		nodeStatus := azDriverNode.Status
+		if nodeStatus != nil && nodeStatus.ReadyForVolumeAllocation != nil && *nodeStatus.ReadyForVolumeAllocation {
+			lunsInUse[azDriverNode.Spec.NodeName] = len(nodeStatus.LUNsInUse)
+		}
+	}
+
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	candidates := []string{}
+	for _, node := range nodes {
+		if excluded.Has(node.Name) || node.Spec.Unschedulable || !isNodeReady(node) {
+			continue
+		}
+		if _, ok := lunsInUse[node.Name]; !ok {
+			continue
+		}
+		if zones != nil && !zones.Has(node.Labels[consts.WellKnownTopologyKey]) {
+			continue
+		}
+		candidates = append(candidates, node.Name)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if lunsInUse[candidates[i]] != lunsInUse[candidates[j]] {
+			return lunsInUse[candidates[i]] < lunsInUse[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	return candidates, nil
+}
+
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// garbageCollectReplicas requests the detachment of all attachment replicas of a volume that has not been published again
+func (c *azVolumeAttachmentController) garbageCollectReplicas(ctx context.Context, volumeName string) error {
+	attachments, err := c.getAzVolumeAttachments(volumeName)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		if attachment.Spec.RequestedRole == azdiskv1beta2.PrimaryRole && !isDetachRequested(attachment) {
+			klog.V(2).Infof("volume %s was published again, keeping its attachment replicas", volumeName)
+			return nil
+		}
+	}
+
+	azVolumeAttachments := c.driver.azDiskClient.DiskV1beta2().AzVolumeAttachments(c.namespace)
+	for _, attachment := range attachments {
+		if attachment.Spec.RequestedRole != azdiskv1beta2.ReplicaRole || isDetachRequested(attachment) {
+			continue
+		}
+		klog.V(2).Infof("garbage collecting replica AzVolumeAttachment(%s)", attachment.Name)
+		updated := attachment.DeepCopy()
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[volumeDetachRequestAnnotation] = "true"
+		if _, err := azVolumeAttachments.Update(ctx, updated, metav1.UpdateOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to request detachment of AzVolumeAttachment(%s): %v", attachment.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+//go:build azurediskv2
+// +build azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2022-08-01/compute"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+
+	azdiskv1beta2 "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/azuredisk/v1beta2"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azureclients/vmclient/mockvmclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/retry"
+)
+
+// fakeVMs tracks the nodes the test disk is attached to and serves them through the VM client mock
+type fakeVMs struct {
+	mu       sync.Mutex
+	attached map[string]bool
+}
+
+func (f *fakeVMs) get(_ context.Context, _, name string, _ compute.InstanceViewTypes) (compute.VirtualMachine, *retry.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dataDisks := []compute.DataDisk{}
+	if f.attached[name] {
+		dataDisks = append(dataDisks, compute.DataDisk{Lun: pointer.Int32(0), Name: pointer.String(testVolumeName), ManagedDisk: &compute.ManagedDiskParameters{ID: pointer.String(testVolumeID)}})
+	}
+	return compute.VirtualMachine{
+		Name:     pointer.String(name),
+		ID:       pointer.String("/subscriptions/subscription/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/" + name),
+		Location: pointer.String("location"),
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			ProvisioningState: pointer.String("Succeeded"),
+			HardwareProfile:   &compute.HardwareProfile{VMSize: compute.StandardA0},
+			StorageProfile:    &compute.StorageProfile{DataDisks: &dataDisks},
+		},
+	}, nil
+}
+
+func (f *fakeVMs) update(_ context.Context, _, name string, _ compute.VirtualMachineUpdate, _ string) (*compute.VirtualMachine, *retry.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attached[name] = false
+	return nil, nil
+}
+
+func (f *fakeVMs) isAttached(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attached[name]
+}
+
+func newReadyNode(name string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func newReadyAzDriverNode(name string, lunsInUse []int32) *azdiskv1beta2.AzDriverNode {
+	return &azdiskv1beta2.AzDriverNode{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: consts.DefaultAzureDiskCrdNamespace},
+		Spec:       azdiskv1beta2.AzDriverNodeSpec{NodeName: name},
+		Status: &azdiskv1beta2.AzDriverNodeStatus{
+			ReadyForVolumeAllocation: pointer.Bool(true),
+			LUNsInUse:                lunsInUse,
+		},
+	}
+}
+
+func TestAzVolumeAttachmentController(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV2(cntl)
+	require.NoError(t, err)
+
+	disk := &armcompute.Disk{ID: pointer.String(testVolumeID), Name: pointer.String(testVolumeName)}
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(disk, nil).AnyTimes()
+	vms := &fakeVMs{attached: map[string]bool{"node1": true, "node2": true, "node3": true}}
+	mockVMsClient := d.getCloud().VirtualMachinesClient.(*mockvmclient.MockInterface)
+	mockVMsClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(vms.get).AnyTimes()
+	mockVMsClient.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(vms.update).AnyTimes()
+
+	// node3 has fewer disks attached than node2 and node1, node4 has no ready node plug-in
+	kubeClient := fake.NewSimpleClientset(newReadyNode("node1"), newReadyNode("node2"), newReadyNode("node3"), newReadyNode("node4"))
+	for _, azDriverNode := range []*azdiskv1beta2.AzDriverNode{
+		newReadyAzDriverNode("node1", []int32{0, 1, 2}),
+		newReadyAzDriverNode("node2", []int32{0, 1}),
+		newReadyAzDriverNode("node3", nil),
+	} {
+		_, err := d.azDiskClient.DiskV1beta2().AzDriverNodes(d.objectNamespace).Create(ctx, azDriverNode, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	c := newAzVolumeAttachmentController(&d.DriverV2, informerFactory.Core().V1().Nodes(), 0)
+	d.azVolumeAttachmentController = c
+	informerFactory.Start(ctx.Done())
+	go c.informer.Run(ctx.Done())
+	require.True(t, cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced, c.nodesSynced))
+
+	azVolumeAttachments := d.azDiskClient.DiskV1beta2().AzVolumeAttachments(d.objectNamespace)
+	processUntil := func(condition func() bool) {
+		require.Eventually(t, func() bool {
+			for c.queue.Len() > 0 {
+				c.processNextItem(ctx)
+			}
+			return condition()
+		}, 10*time.Second, 10*time.Millisecond)
+	}
+	isAttached := func(nodeName string, role azdiskv1beta2.Role) func() bool {
+		return func() bool {
+			attachment, err := azVolumeAttachments.Get(ctx, getAzVolumeAttachmentName(testVolumeName, types.NodeName(nodeName)), metav1.GetOptions{})
+			return err == nil && attachment.Status.State == azdiskv1beta2.Attached && attachment.Status.Detail.Role == role
+		}
+	}
+	isDeleted := func(nodeName string) func() bool {
+		return func() bool {
+			_, err := azVolumeAttachments.Get(ctx, getAzVolumeAttachmentName(testVolumeName, types.NodeName(nodeName)), metav1.GetOptions{})
+			return apierrors.IsNotFound(err)
+		}
+	}
+
+	volumeCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	volumeContext := map[string]string{
+		consts.MaxSharesField:            "3",
+		consts.MaxMountReplicaCountField: "1",
+		consts.RequestedSizeGib:          "10",
+	}
+
+	// publishing creates the primary attachment, the controller attaches it and creates a replica on the least used node
+	resp, err := d.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		NodeId:           "node1",
+		VolumeCapability: volumeCap,
+		VolumeContext:    volumeContext,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, resp.PublishContext)
+	processUntil(isAttached("node1", azdiskv1beta2.PrimaryRole))
+	processUntil(isAttached("node3", azdiskv1beta2.ReplicaRole))
+	_, err = azVolumeAttachments.Get(ctx, getAzVolumeAttachmentName(testVolumeName, "node2"), metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// the node plug-in gets the LUN from the attachment
+	d.NodeID = "node1"
+	publishContext, err := d.getAttachmentPublishContext(ctx, testVolumeID, map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, "0", publishContext[consts.LUN])
+
+	// unpublishing detaches the primary attachment once the controller has processed it
+	errCh := make(chan error)
+	go func() {
+		_, err := d.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: testVolumeID, NodeId: "node1"})
+		errCh <- err
+	}()
+	processUntil(isDeleted("node1"))
+	require.NoError(t, <-errCh)
+	assert.False(t, vms.isAttached("node1"))
+
+	// publishing to the replica node promotes it
+	_, err = d.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		NodeId:           "node3",
+		VolumeCapability: volumeCap,
+		VolumeContext:    volumeContext,
+	})
+	require.NoError(t, err)
+	processUntil(func() bool {
+		attachment, err := azVolumeAttachments.Get(ctx, getAzVolumeAttachmentName(testVolumeName, "node3"), metav1.GetOptions{})
+		return err == nil && attachment.Status.Detail.Role == azdiskv1beta2.PrimaryRole && attachment.Status.Detail.PreviousRole == azdiskv1beta2.ReplicaRole
+	})
+
+	// the replicas of a volume that is not published again are garbage collected
+	require.NoError(t, c.garbageCollectReplicas(ctx, testVolumeName))
+	assert.True(t, isAttached("node3", azdiskv1beta2.PrimaryRole)())
+	processUntil(isAttached("node2", azdiskv1beta2.ReplicaRole))
+	go func() {
+		_, err := d.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: testVolumeID, NodeId: "node3"})
+		errCh <- err
+	}()
+	processUntil(isDeleted("node3"))
+	require.NoError(t, <-errCh)
+	processUntil(isDeleted("node2"))
+	assert.False(t, vms.isAttached("node2"))
+}
+
+func TestGetAttachmentPublishContext(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV2(cntl)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// the publish context of the request is used as is if it has a LUN or if the attachment is not tracked
+	publishContext := map[string]string{consts.LUN: "1"}
+	result, err := d.getAttachmentPublishContext(ctx, testVolumeID, publishContext)
+	require.NoError(t, err)
+	assert.Equal(t, publishContext, result)
+	result, err = d.getAttachmentPublishContext(ctx, testVolumeID, nil)
+	require.NoError(t, err)
+	assert.Empty(t, result)
+
+	// a failed attachment is reported once the request times out
+	attachment := &azdiskv1beta2.AzVolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getAzVolumeAttachmentName(testVolumeName, types.NodeName(d.NodeID)),
+			Namespace: d.objectNamespace,
+		},
+		Spec: azdiskv1beta2.AzVolumeAttachmentSpec{
+			VolumeName:    testVolumeName,
+			VolumeID:      testVolumeID,
+			NodeName:      d.NodeID,
+			RequestedRole: azdiskv1beta2.PrimaryRole,
+		},
+		Status: azdiskv1beta2.AzVolumeAttachmentStatus{
+			State: azdiskv1beta2.AttachmentFailed,
+			Error: &azdiskv1beta2.AzError{Code: codes.Internal.String(), Message: "attach failed"},
+		},
+	}
+	_, err = d.azDiskClient.DiskV1beta2().AzVolumeAttachments(d.objectNamespace).Create(ctx, attachment, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = d.getAttachmentPublishContext(ctx, testVolumeID, nil)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "attach failed")
+}
@@ -0,0 +1,223 @@
+//go:build azurediskv2
+// +build azurediskv2
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	azdiskv1beta2 "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/azuredisk/v1beta2"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+const (
+	// volumeDetachRequestAnnotation marks an AzVolumeAttachment whose disk should be detached and the object deleted
+	volumeDetachRequestAnnotation = "disk.csi.azure.com/volume-detach-request"
+	// azVolumeAttachmentPollInterval is the interval at which CSI requests check the state of an AzVolumeAttachment
+	azVolumeAttachmentPollInterval = time.Second
+)
+
+// getAzVolumeAttachmentName returns the name of the AzVolumeAttachment of a disk to a node
+func getAzVolumeAttachmentName(diskName string, nodeName types.NodeName) string {
+	return strings.ReplaceAll(strings.ToLower(fmt.Sprintf("%s-%s-attachment", diskName, nodeName)), "_", "-")
+}
+
+func isDetachRequested(attachment *azdiskv1beta2.AzVolumeAttachment) bool {
+	_, ok := attachment.Annotations[volumeDetachRequestAnnotation]
+	return ok
+}
+
+// publishAzVolumeAttachment creates the primary AzVolumeAttachment of a disk to a node, or promotes an existing replica.
+// The attachment controller attaches the disk asynchronously; the returned publish context is empty until it is attached.
+func (d *DriverV2) publishAzVolumeAttachment(ctx context.Context, diskName, diskURI string, nodeName types.NodeName, volumeContext map[string]string) (map[string]string, error) {
+	azVolumeAttachments := d.azDiskClient.DiskV1beta2().AzVolumeAttachments(d.objectNamespace)
+	name := getAzVolumeAttachmentName(diskName, nodeName)
+
+	var attachment *azdiskv1beta2.AzVolumeAttachment
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := azVolumeAttachments.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			klog.V(2).Infof("creating primary AzVolumeAttachment(%s) for volume %s on node %s", name, diskURI, nodeName)
+			attachment, err = azVolumeAttachments.Create(ctx, &azdiskv1beta2.AzVolumeAttachment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: d.objectNamespace,
+				},
+				Spec: azdiskv1beta2.AzVolumeAttachmentSpec{
+					VolumeName:    diskName,
+					VolumeID:      diskURI,
+					NodeName:      string(nodeName),
+					VolumeContext: volumeContext,
+					RequestedRole: azdiskv1beta2.PrimaryRole,
+				},
+			}, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if isDetachRequested(existing) {
+			return status.Errorf(codes.Unavailable, "volume %s is being detached from node %s", diskURI, nodeName)
+		}
+		if existing.Spec.RequestedRole == azdiskv1beta2.PrimaryRole {
+			attachment = existing
+			return nil
+		}
+
+		klog.V(2).Infof("promoting replica AzVolumeAttachment(%s) for volume %s on node %s to primary", name, diskURI, nodeName)
+		updated := existing.DeepCopy()
+		updated.Spec.RequestedRole = azdiskv1beta2.PrimaryRole
+		updated.Spec.VolumeContext = volumeContext
+		attachment, err = azVolumeAttachments.Update(ctx, updated, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Internal, "failed to publish AzVolumeAttachment(%s): %v", name, err)
+	}
+
+	publishContext := map[string]string{}
+	if attachment.Status.State == azdiskv1beta2.Attached && attachment.Status.Detail != nil {
+		for k, v := range attachment.Status.Detail.PublishContext {
+			publishContext[k] = v
+		}
+	}
+	return publishContext, nil
+}
+
+// unpublishAzVolumeAttachment requests the detachment of a disk from a node and waits until the attachment controller
+// has detached it and deleted its AzVolumeAttachment
+func (d *DriverV2) unpublishAzVolumeAttachment(ctx context.Context, diskName, diskURI string, nodeName types.NodeName) error {
+	azVolumeAttachments := d.azDiskClient.DiskV1beta2().AzVolumeAttachments(d.objectNamespace)
+	name := getAzVolumeAttachmentName(diskName, nodeName)
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		attachment, err := azVolumeAttachments.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if isDetachRequested(attachment) {
+			return nil
+		}
+		klog.V(2).Infof("requesting detachment of AzVolumeAttachment(%s) for volume %s from node %s", name, diskURI, nodeName)
+		updated := attachment.DeepCopy()
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[volumeDetachRequestAnnotation] = "true"
+		_, err = azVolumeAttachments.Update(ctx, updated, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		// the disk may have been attached before attachments were tracked
+		return d.detachDiskFromNode(ctx, diskName, diskURI, nodeName)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to request detachment of AzVolumeAttachment(%s): %v", name, err)
+	}
+
+	var lastErr *azdiskv1beta2.AzError
+	err = wait.PollUntilContextCancel(ctx, azVolumeAttachmentPollInterval, true, func(ctx context.Context) (bool, error) {
+		attachment, err := azVolumeAttachments.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			klog.Warningf("failed to get AzVolumeAttachment(%s): %v", name, err)
+			return false, nil
+		}
+		lastErr = attachment.Status.Error
+		return false, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			return status.Errorf(codes.Internal, "Could not detach volume %q from node %q: %s", diskURI, nodeName, lastErr.Message)
+		}
+		return status.Errorf(codes.DeadlineExceeded, "timed out waiting for volume %q to be detached from node %q: %v", diskURI, nodeName, err)
+	}
+	klog.V(2).Infof("detach volume %s from node %s successfully", diskURI, nodeName)
+	return nil
+}
+
+// getAttachmentPublishContext returns the publish context of the volume on this node.
+// If the controller plug-in did not provide the LUN, it waits for the AzVolumeAttachment of the volume
+// to be attached and returns its publish context instead.
+func (d *DriverV2) getAttachmentPublishContext(ctx context.Context, diskURI string, publishContext map[string]string) (map[string]string, error) {
+	if _, ok := publishContext[consts.LUN]; ok || d.azDiskClient == nil {
+		return publishContext, nil
+	}
+	diskName, err := azureutils.GetDiskName(diskURI)
+	if err != nil {
+		return publishContext, nil
+	}
+
+	azVolumeAttachments := d.azDiskClient.DiskV1beta2().AzVolumeAttachments(d.objectNamespace)
+	name := getAzVolumeAttachmentName(diskName, types.NodeName(d.NodeID))
+	if _, err := azVolumeAttachments.Get(ctx, name, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+		return publishContext, nil
+	}
+
+	klog.V(2).Infof("waiting for AzVolumeAttachment(%s) to be attached", name)
+	var attachment *azdiskv1beta2.AzVolumeAttachment
+	err = wait.PollUntilContextCancel(ctx, azVolumeAttachmentPollInterval, true, func(ctx context.Context) (bool, error) {
+		attachment, err = azVolumeAttachments.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, err
+			}
+			klog.Warningf("failed to get AzVolumeAttachment(%s): %v", name, err)
+			return false, nil
+		}
+		if isDetachRequested(attachment) {
+			return false, fmt.Errorf("volume %s is being detached from node %s", diskURI, d.NodeID)
+		}
+		return attachment.Status.State == azdiskv1beta2.Attached && attachment.Status.Detail != nil, nil
+	})
+	if err != nil {
+		message := err.Error()
+		if attachment != nil && attachment.Status.Error != nil {
+			message = attachment.Status.Error.Message
+		}
+		return nil, status.Errorf(codes.Unavailable, "volume %s is not attached to node %s: %s", diskURI, d.NodeID, message)
+	}
+
+	result := make(map[string]string, len(publishContext)+len(attachment.Status.Detail.PublishContext))
+	for k, v := range publishContext {
+		result[k] = v
+	}
+	for k, v := range attachment.Status.Detail.PublishContext {
+		result[k] = v
+	}
+	return result, nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/cloud-provider-azure/pkg/metrics"
+)
+
+const (
+	cloudConfigSecretKey = "cloud-config"
+
+	cloudConfigReloadedReason     = "CloudConfigReloaded"
+	cloudConfigReloadFailedReason = "CloudConfigReloadFailed"
+)
+
+// watchCloudConfig watches the cloud config secret and checks the cloud config file at the reload interval, and
+// reloads the cloud when either changed, until ctx is done. A failed reload is retried at the next interval. The
+// node plug-in only reloads the cloud config if enableCloudConfigReloadOnNode is set.
+func (d *DriverCore) watchCloudConfig(ctx context.Context) {
+	if d.cloudConfigReloadInterval <= 0 || d.provisioner != nil || (d.NodeID != "" && !d.enableCloudConfigReloadOnNode) {
+		return
+	}
+
+	var recorder record.EventRecorder
+	var secretLister corelisters.SecretLister
+	changed := make(chan struct{}, 1)
+	if d.kubeClient != nil {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: d.kubeClient.CoreV1().Events("")})
+		defer broadcaster.Shutdown()
+		recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: d.Name, Host: d.NodeID})
+
+		// only the cloud config secret is watched
+		informerFactory := informers.NewSharedInformerFactoryWithOptions(d.kubeClient, 0,
+			informers.WithNamespace(d.cloudConfigSecretNamespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", d.cloudConfigSecretName).String()
+			}))
+		secretInformer := informerFactory.Core().V1().Secrets()
+		notify := func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+		_, _ = secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { notify() },
+			UpdateFunc: func(interface{}, interface{}) { notify() },
+			DeleteFunc: func(interface{}) { notify() },
+		})
+		informerFactory.Start(ctx.Done())
+		if !cache.WaitForCacheSync(ctx.Done(), secretInformer.Informer().HasSynced) {
+			klog.Errorf("failed to sync cache of secret %s/%s", d.cloudConfigSecretNamespace, d.cloudConfigSecretName)
+			return
+		}
+		secretLister = secretInformer.Lister()
+	}
+
+	fingerprint, err := d.getCloudConfigFingerprint(secretLister)
+	if err != nil {
+		klog.Warningf("failed to read cloud config: %v", err)
+	}
+	klog.V(2).Infof("watching cloud config secret %s/%s, checking cloud config file for changes every %v", d.cloudConfigSecretNamespace, d.cloudConfigSecretName, d.cloudConfigReloadInterval)
+	ticker := time.NewTicker(d.cloudConfigReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+		current, err := d.getCloudConfigFingerprint(secretLister)
+		if err != nil {
+			klog.Warningf("failed to read cloud config: %v", err)
+			continue
+		}
+		if current == fingerprint {
+			continue
+		}
+		klog.V(2).Infof("cloud config changed, reloading cloud")
+		if err := d.reloadCloud(ctx); err != nil {
+			klog.Errorf("failed to reload cloud config: %v", err)
+			d.recordCloudConfigEvent(recorder, v1.EventTypeWarning, cloudConfigReloadFailedReason, fmt.Sprintf("failed to reload cloud config: %v", err))
+			continue
+		}
+		fingerprint = current
+		d.recordCloudConfigEvent(recorder, v1.EventTypeNormal, cloudConfigReloadedReason, "cloud config reloaded")
+	}
+}
+
+// getCloudConfigFingerprint returns a hash of the cloud config secret in the cache of secretLister and of the cloud
+// config file the cloud of the driver is built from. A secret or file that does not exist is hashed as empty, the
+// secret is not hashed without secretLister.
+func (d *DriverCore) getCloudConfigFingerprint(secretLister corelisters.SecretLister) (string, error) {
+	h := sha256.New()
+	if secretLister != nil {
+		secret, err := secretLister.Secrets(d.cloudConfigSecretNamespace).Get(d.cloudConfigSecretName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to get secret %s/%s: %v", d.cloudConfigSecretNamespace, d.cloudConfigSecretName, err)
+		}
+		if err == nil {
+			h.Write(secret.Data[cloudConfigSecretKey])
+		}
+	}
+	h.Write([]byte{0})
+	credFile := azureutils.GetCredentialFilePath()
+	content, err := os.ReadFile(credFile)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %v", credFile, err)
+	}
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reloadCloud rebuilds the cloud from the cloud config and replaces the cloud and client factory of the driver and
+// its disk controller together. Operations in flight keep the provisioner they started with.
+func (d *DriverCore) reloadCloud(ctx context.Context) error {
+	oldCloud := d.getCloud()
+	var resourceGroup, subscriptionID string
+	if oldCloud != nil {
+		resourceGroup, subscriptionID = oldCloud.ResourceGroup, oldCloud.SubscriptionID
+	}
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "reload_cloud_config", resourceGroup, subscriptionID, d.Name)
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded)
+	}()
+
+	userAgent := GetUserAgent(d.Name, d.customUserAgent, d.userAgentSuffix)
+	cloud, err := azureutils.GetCloudProviderFromClient(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace,
+		userAgent, d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort)
+	if err != nil {
+		return err
+	}
+	if cloud.ComputeClientFactory == nil && oldCloud != nil && oldCloud.ComputeClientFactory != nil {
+		return fmt.Errorf("failed to initialize cloud from the new cloud config, keeping the current cloud")
+	}
+	diskController := d.getDiskController()
+	if diskController == nil {
+		diskController = d.configureCloud(cloud)
+	} else {
+		// the disk controller is kept so that attaches and detaches in flight on the old cloud stay serialized
+		// and batched per VM with the ones issued on the new cloud
+		d.applyCloudOptions(cloud)
+		diskController.setCloud(cloud)
+	}
+
+	d.cloudLock.Lock()
+	d.cloud = cloud
+	d.clientFactory = cloud.ComputeClientFactory
+	d.diskController = diskController
+	d.cloudLock.Unlock()
+
+	// clouds built from provisioner and snapshotter secrets inherit the cloud config of the driver
+	d.scopedClouds.Clear()
+	klog.V(2).Infof("reloaded cloud config, cloud: %s, location: %s, rg: %s, subscription: %s", cloud.Cloud, cloud.Location, cloud.ResourceGroup, cloud.SubscriptionID)
+	isOperationSucceeded = true
+	return nil
+}
+
+// recordCloudConfigEvent records an event on the cloud config secret, it is a no-op without a kube client
+func (d *DriverCore) recordCloudConfigEvent(recorder record.EventRecorder, eventType, reason, message string) {
+	if recorder == nil {
+		return
+	}
+	recorder.Event(&v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Namespace:  d.cloudConfigSecretNamespace,
+		Name:       d.cloudConfigSecretName,
+	}, eventType, reason, message)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testCloudConfig = `{
+	"cloud": "AzurePublicCloud",
+	"tenantId": "tenant",
+	"subscriptionId": "reloaded-subscription",
+	"resourceGroup": "reloaded-rg",
+	"location": "westus2",
+	"aadClientId": "client",
+	"aadClientSecret": "secret"
+}`
+
+func TestGetCloudConfigFingerprint(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.cloudConfigSecretName, d.cloudConfigSecretNamespace = "azure-cloud-provider", "kube-system"
+	credFile := filepath.Join(t.TempDir(), "azure.json")
+	t.Setenv("AZURE_CREDENTIAL_FILE", credFile)
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	secretLister := corelisters.NewSecretLister(secrets)
+
+	fingerprint, err := d.getCloudConfigFingerprint(secretLister)
+	require.NoError(t, err)
+	unchanged, err := d.getCloudConfigFingerprint(secretLister)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, unchanged)
+
+	require.NoError(t, os.WriteFile(credFile, []byte(testCloudConfig), 0600))
+	fileChanged, err := d.getCloudConfigFingerprint(secretLister)
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, fileChanged)
+
+	require.NoError(t, secrets.Add(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "azure-cloud-provider", Namespace: "kube-system"},
+		Data:       map[string][]byte{cloudConfigSecretKey: []byte(testCloudConfig)},
+	}))
+	secretChanged, err := d.getCloudConfigFingerprint(secretLister)
+	require.NoError(t, err)
+	assert.NotEqual(t, fileChanged, secretChanged)
+
+	withoutSecret, err := d.getCloudConfigFingerprint(nil)
+	require.NoError(t, err)
+	assert.Equal(t, fileChanged, withoutSecret)
+}
+
+func TestWatchCloudConfig(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.cloudConfigSecretName, d.cloudConfigSecretNamespace = "azure-cloud-provider", "kube-system"
+	d.cloudConfigReloadInterval = time.Hour
+	t.Setenv("AZURE_CREDENTIAL_FILE", filepath.Join(t.TempDir(), "azure.json"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the node plug-in does not reload the cloud config unless enabled
+	d.NodeID = fakeNodeID
+	d.watchCloudConfig(ctx)
+
+	d.NodeID = ""
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.watchCloudConfig(ctx)
+	}()
+	// the secret is watched, a change is picked up before the reload interval. The data changes on every update
+	// so that a change made before the watch started is followed by one made after.
+	secret, err := d.kubeClient.CoreV1().Secrets("kube-system").Create(ctx, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "azure-cloud-provider", Namespace: "kube-system"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	updates := 0
+	assert.Eventually(t, func() bool {
+		updates++
+		secret.Data = map[string][]byte{cloudConfigSecretKey: []byte(testCloudConfig + strings.Repeat(" ", updates))}
+		secret, err = d.kubeClient.CoreV1().Secrets("kube-system").Update(ctx, secret, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		return d.getCloud().SubscriptionID == "reloaded-subscription"
+	}, 10*time.Second, 100*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestReloadCloud(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.cloudConfigSecretName, d.cloudConfigSecretNamespace = "azure-cloud-provider", "kube-system"
+	t.Setenv("AZURE_CREDENTIAL_FILE", filepath.Join(t.TempDir(), "azure.json"))
+	ctx := context.Background()
+
+	oldCloud := d.getCloud()
+	oldDiskController := d.getDiskController()
+	inFlight := d.getProvisioner().(*armProvisioner)
+	d.scopedClouds.Add("key", d.newScopedCloud(oldCloud))
+
+	_, err = d.kubeClient.CoreV1().Secrets("kube-system").Create(ctx, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "azure-cloud-provider", Namespace: "kube-system"},
+		Data:       map[string][]byte{cloudConfigSecretKey: []byte(testCloudConfig)},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, d.reloadCloud(ctx))
+
+	cloud := d.getCloud()
+	assert.NotSame(t, oldCloud, cloud)
+	assert.Equal(t, "reloaded-subscription", cloud.SubscriptionID)
+	assert.Equal(t, "reloaded-rg", cloud.ResourceGroup)
+	assert.Equal(t, cloud.ComputeClientFactory, d.clientFactory)
+	assert.Same(t, oldDiskController, d.getDiskController(), "disk controller must keep serializing attaches and detaches in flight")
+	assert.Same(t, cloud, d.getDiskController().getCloud())
+	assert.Equal(t, cloud.ComputeClientFactory, d.getDiskController().getClientFactory())
+
+	_, ok := d.scopedClouds.Get("key")
+	assert.False(t, ok, "clouds built from secrets must be rebuilt after reload")
+
+	assert.Same(t, oldCloud, inFlight.cloud, "operations in flight must keep the old cloud")
+	assert.Same(t, cloud, d.getProvisioner().(*armProvisioner).cloud)
+}
@@ -70,6 +70,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, err
 	}
 	params := req.GetParameters()
+	ctx = d.withAuditRequestInfo(ctx, "CreateVolume", req.GetName(), params)
 	diskParams, err := azureutils.ParseDiskParameters(params)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "Failed parsing disk parameters: %v", err)
@@ -86,6 +87,12 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if err := azureutils.IsValidVolumeCapabilities(volCaps, diskParams.MaxShares); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	if err := azureutils.ValidateFormatOptions(&diskParams, volCaps, defaultLinuxFsType); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if diskParams.StripeCount > 1 {
+		return d.createStripedVolume(ctx, req, diskParams.StripeCount)
+	}
 	isAdvancedPerfProfile := strings.EqualFold(diskParams.PerfProfile, consts.PerfProfileAdvanced)
 	// If perfProfile is set to advanced and no/invalid device settings are provided, fail the request
 	if d.getPerfOptimizationEnabled() && isAdvancedPerfProfile {
@@ -117,32 +124,15 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		return nil, status.Error(codes.InvalidArgument, "After round-up, volume size exceeds the limit specified")
 	}
 
-	if diskParams.Location == "" {
-		diskParams.Location = d.cloud.Location
+	ctx, localCloud, provisioner, err := d.withScopedCloud(ctx, req.GetSecrets(), diskParams.UserAgent)
+	if err != nil {
+		return nil, err
 	}
 
-	localCloud := d.cloud
-	localDiskController := d.diskController
-
-	if diskParams.UserAgent != "" {
-		localCloud, err = azureutils.GetCloudProviderFromClient(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, diskParams.UserAgent,
-			d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "create cloud with UserAgent(%s) failed with: (%s)", diskParams.UserAgent, err)
-		}
-		localDiskController = &ManagedDiskController{
-			controllerCommon: &controllerCommon{
-				cloud:               localCloud,
-				lockMap:             newLockMap(),
-				DisableDiskLunCheck: true,
-				clientFactory:       localCloud.ComputeClientFactory,
-				ForceDetachBackoff:  d.forceDetachBackoff,
-			},
-		}
-		localDiskController.DisableUpdateCache = d.disableUpdateCache
-		localDiskController.AttachDetachInitialDelayInMs = int(d.attachDetachInitialDelayInMs)
-
+	if diskParams.Location == "" {
+		diskParams.Location = localCloud.Location
 	}
+
 	if azureutils.IsAzureStackCloud(localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud) {
 		if diskParams.MaxShares > 1 {
 			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid maxShares value: %d as Azure Stack does not support shared disk.", diskParams.MaxShares))
@@ -155,7 +145,7 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	diskParams.DiskName = azureutils.CreateValidDiskName(diskParams.DiskName)
 
 	if diskParams.ResourceGroup == "" {
-		diskParams.ResourceGroup = d.cloud.ResourceGroup
+		diskParams.ResourceGroup = localCloud.ResourceGroup
 	}
 
 	// normalize values
@@ -281,6 +271,10 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	diskParams.VolumeContext[consts.RequestedSizeGib] = strconv.Itoa(requestGiB)
+	if sourceType != "" {
+		// the node regenerates the filesystem UUID of the clone of a volume mounted on the same node
+		diskParams.VolumeContext[consts.ContentSourceTypeField] = sourceType
+	}
 	volumeOptions := &ManagedDiskOptions{
 		AvailabilityZone:    diskZone,
 		BurstingEnabled:     diskParams.EnableBursting,
@@ -313,13 +307,13 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	}
 
 	var diskURI string
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, metricsRequest, d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, metricsRequest, d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
-	diskURI, err = localDiskController.CreateManagedDisk(ctx, volumeOptions)
+	diskURI, err = provisioner.CreateDisk(ctx, volumeOptions)
 	if err != nil {
 		if strings.Contains(err.Error(), consts.NotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
@@ -351,6 +345,10 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
 		return nil, status.Errorf(codes.Internal, "invalid delete volume req: %v", req)
 	}
+	if azureutils.IsStripedVolumeID(volumeID) {
+		return d.deleteStripedVolume(ctx, req)
+	}
+	ctx = d.withAuditRequestInfo(ctx, "DeleteVolume", volumeID, nil)
 	diskURI := volumeID
 
 	if err := azureutils.IsValidDiskURI(diskURI); err != nil {
@@ -363,14 +361,19 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	ctx, _, provisioner, err := d.withScopedCloud(ctx, req.GetSecrets(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
 	klog.V(2).Infof("deleting azure disk(%s)", diskURI)
-	err := d.diskController.DeleteManagedDisk(ctx, diskURI)
+	err = provisioner.DeleteDisk(ctx, diskURI)
 	klog.V(2).Infof("delete azure disk(%s) returned with %v", diskURI, err)
 	isOperationSucceeded = (err == nil)
 	return &csi.DeleteVolumeResponse{}, err
@@ -391,6 +394,10 @@ func (d *Driver) ControllerModifyVolume(ctx context.Context, req *csi.Controller
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_MODIFY_VOLUME); err != nil {
 		return nil, status.Errorf(codes.Internal, "invalid modify volume req: %v", req)
 	}
+	if azureutils.IsStripedVolumeID(volumeID) {
+		return d.modifyStripedVolume(ctx, req)
+	}
+	ctx = d.withAuditRequestInfo(ctx, "ControllerModifyVolume", volumeID, req.GetMutableParameters())
 	diskURI := volumeID
 
 	diskName, err := azureutils.GetDiskName(diskURI)
@@ -408,7 +415,7 @@ func (d *Driver) ControllerModifyVolume(ctx context.Context, req *csi.Controller
 	}
 
 	// normalize values
-	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud)
+	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
@@ -430,13 +437,13 @@ func (d *Driver) ControllerModifyVolume(ctx context.Context, req *csi.Controller
 		SourceType:         consts.SourceVolume,
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_modify_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_modify_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
-	if err = d.diskController.ModifyDisk(ctx, volumeOptions); err != nil {
+	if err = d.getProvisioner().ModifyDisk(ctx, volumeOptions); err != nil {
 		if strings.Contains(err.Error(), consts.NotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
@@ -455,6 +462,7 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 	if len(diskURI) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
+	ctx = d.withAuditRequestInfo(ctx, "ControllerPublishVolume", diskURI, req.GetVolumeContext())
 
 	volCap := req.GetVolumeCapability()
 	if volCap == nil {
@@ -471,6 +479,13 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	if azureutils.IsStripedVolumeID(diskURI) {
+		if len(req.GetNodeId()) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "Node ID not provided")
+		}
+		return d.publishStripedVolume(ctx, req)
+	}
+
 	disk, err := d.checkDiskExists(ctx, diskURI)
 	if err != nil {
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("Volume not found, failed with error: %v", err))
@@ -487,13 +502,14 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		return nil, status.Errorf(codes.Internal, err.Error())
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_publish_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_publish_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI, consts.Node, string(nodeName))
 	}()
 
-	lun, vmState, err := d.diskController.GetDiskLun(diskName, diskURI, nodeName)
+	provisioner := d.getProvisioner()
+	lun, vmState, err := provisioner.GetDiskLun(diskName, diskURI, nodeName)
 	if err == cloudprovider.InstanceNotFound {
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("failed to get azure instance id for node %q (%v)", nodeName, err))
 	}
@@ -513,7 +529,7 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 	if err == nil {
 		if vmState != nil && strings.ToLower(*vmState) == "failed" {
 			klog.Warningf("VM(%s) is in failed state, update VM first", nodeName)
-			if err := d.diskController.UpdateVM(ctx, nodeName); err != nil {
+			if err := provisioner.UpdateVM(ctx, nodeName); err != nil {
 				return nil, status.Errorf(codes.Internal, "update instance %q failed with %v", nodeName, err)
 			}
 		}
@@ -532,11 +548,11 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		klog.V(2).Infof("Trying to attach volume %s to node %s", diskURI, nodeName)
 
 		attachDiskInitialDelay := azureutils.GetAttachDiskInitialDelay(volumeContext)
-		if attachDiskInitialDelay > 0 {
+		if diskController := d.getDiskController(); attachDiskInitialDelay > 0 && diskController != nil {
 			klog.V(2).Infof("attachDiskInitialDelayInMs is set to %d", attachDiskInitialDelay)
-			d.diskController.AttachDetachInitialDelayInMs = attachDiskInitialDelay
+			diskController.AttachDetachInitialDelayInMs = attachDiskInitialDelay
 		}
-		lun, err = d.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
+		lun, err = provisioner.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
 		if err == nil {
 			klog.V(2).Infof("Attach operation successful: volume %s attached to node %s.", diskURI, nodeName)
 		} else {
@@ -547,11 +563,11 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 					return nil, err
 				}
 				klog.Warningf("volume %s is already attached to node %s, try detach first", diskURI, derr.CurrentNode)
-				if err = d.diskController.DetachDisk(ctx, diskName, diskURI, derr.CurrentNode); err != nil {
+				if err = provisioner.DetachDisk(ctx, diskName, diskURI, derr.CurrentNode); err != nil {
 					return nil, status.Errorf(codes.Internal, "Could not detach volume %s from node %s: %v", diskURI, derr.CurrentNode, err)
 				}
 				klog.V(2).Infof("Trying to attach volume %s to node %s again", diskURI, nodeName)
-				lun, err = d.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
+				lun, err = provisioner.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
 			}
 			if err != nil {
 				klog.Errorf("Attach volume %s to instance %s failed with %v", diskURI, nodeName, err)
@@ -571,6 +587,7 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 			klog.V(6).Infof("found static PV(%s), insert disk properties to volumeattachments", diskURI)
 			azureutils.InsertDiskProperties(disk, publishContext)
 		}
+		azureutils.InsertDiskIdentity(disk, publishContext)
 	}
 	isOperationSucceeded = true
 	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
@@ -582,11 +599,15 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 	if len(diskURI) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
+	ctx = d.withAuditRequestInfo(ctx, "ControllerUnpublishVolume", diskURI, nil)
 
 	nodeID := req.GetNodeId()
 	if len(nodeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Node ID not provided")
 	}
+	if azureutils.IsStripedVolumeID(diskURI) {
+		return d.unpublishStripedVolume(ctx, req)
+	}
 	nodeName := types.NodeName(nodeID)
 
 	diskName, err := azureutils.GetDiskName(diskURI)
@@ -594,7 +615,7 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 		return nil, status.Errorf(codes.Internal, err.Error())
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_unpublish_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_unpublish_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI, consts.Node, string(nodeName))
@@ -602,7 +623,7 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 
 	klog.V(2).Infof("Trying to detach volume %s from node %s", diskURI, nodeID)
 
-	if err := d.diskController.DetachDisk(ctx, diskName, diskURI, nodeName); err != nil {
+	if err := d.getProvisioner().DetachDisk(ctx, diskName, diskURI, nodeName); err != nil {
 		if strings.Contains(err.Error(), consts.ErrDiskNotFound) {
 			klog.Warningf("volume %s already detached from node %s", diskURI, nodeID)
 		} else {
@@ -642,6 +663,10 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
 	}
 
+	if azureutils.IsStripedVolumeID(diskURI) {
+		return d.validateStripedVolumeCapabilities(ctx, req)
+	}
+
 	if _, err := d.checkDiskExists(ctx, diskURI); err != nil {
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("Volume not found, failed with error: %v", err))
 	}
@@ -713,17 +738,17 @@ func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (
 			return nil, status.Errorf(codes.Aborted, "ListVolumes starting token(%d) can not be negative", start)
 		}
 	}
-	if d.cloud.KubeClient != nil && d.cloud.KubeClient.CoreV1() != nil && d.cloud.KubeClient.CoreV1().PersistentVolumes() != nil {
+	if d.getCloud().KubeClient != nil && d.getCloud().KubeClient.CoreV1() != nil && d.getCloud().KubeClient.CoreV1().PersistentVolumes() != nil {
 		klog.V(6).Infof("List Volumes in Cluster:")
 		return d.listVolumesInCluster(ctx, start, int(req.MaxEntries))
 	}
-	klog.V(6).Infof("List Volumes in Node Resource Group: %s", d.cloud.ResourceGroup)
+	klog.V(6).Infof("List Volumes in Node Resource Group: %s", d.getCloud().ResourceGroup)
 	return d.listVolumesInNodeResourceGroup(ctx, start, int(req.MaxEntries))
 }
 
 // listVolumesInCluster is a helper function for ListVolumes used for when there is an available kubeclient
 func (d *Driver) listVolumesInCluster(ctx context.Context, start, maxEntries int) (*csi.ListVolumesResponse, error) {
-	pvList, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	pvList, err := d.getCloud().KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "ListVolumes failed while fetching PersistentVolumes List with error: %v", err.Error())
 	}
@@ -744,8 +769,8 @@ func (d *Driver) listVolumesInCluster(ctx context.Context, start, maxEntries int
 				continue
 			}
 			subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-			if !strings.EqualFold(subsID, d.cloud.SubscriptionID) {
-				klog.V(6).Infof("disk(%s) not in current subscription(%s), skip", diskURI, d.cloud.SubscriptionID)
+			if !strings.EqualFold(subsID, d.getCloud().SubscriptionID) {
+				klog.V(6).Infof("disk(%s) not in current subscription(%s), skip", diskURI, d.getCloud().SubscriptionID)
 				continue
 			}
 			rg, diskURI = strings.ToLower(rg), strings.ToLower(diskURI)
@@ -811,7 +836,7 @@ func (d *Driver) listVolumesInCluster(ctx context.Context, start, maxEntries int
 // listVolumesInNodeResourceGroup is a helper function for ListVolumes used for when there is no available kubeclient
 func (d *Driver) listVolumesInNodeResourceGroup(ctx context.Context, start, maxEntries int) (*csi.ListVolumesResponse, error) {
 	entries := []*csi.ListVolumesResponse_Entry{}
-	listStatus := d.listVolumesByResourceGroup(ctx, d.cloud.ResourceGroup, entries, start, maxEntries, nil)
+	listStatus := d.listVolumesByResourceGroup(ctx, d.getCloud().ResourceGroup, entries, start, maxEntries, nil)
 	if listStatus.err != nil {
 		return nil, listStatus.err
 	}
@@ -831,8 +856,8 @@ func (d *Driver) listVolumesInNodeResourceGroup(ctx context.Context, start, maxE
 
 // listVolumesByResourceGroup is a helper function that updates the ListVolumeResponse_Entry slice and returns number of total visited volumes, number of volumes that needs to be visited and an error if found
 func (d *Driver) listVolumesByResourceGroup(ctx context.Context, resourceGroup string, entries []*csi.ListVolumesResponse_Entry, start, maxEntries int, volSet map[string]bool) listVolumeStatus {
-	diskClient := d.clientFactory.GetDiskClient()
-	disks, derr := diskClient.List(ctx, resourceGroup)
+	provisioner := d.getProvisioner()
+	disks, derr := provisioner.ListDisks(ctx, resourceGroup)
 	if derr != nil {
 		return listVolumeStatus{err: status.Errorf(codes.Internal, "ListVolumes on rg(%s) failed with error: %v", resourceGroup, derr.Error())}
 	}
@@ -847,7 +872,7 @@ func (d *Driver) listVolumesByResourceGroup(ctx context.Context, resourceGroup s
 	if start > 0 && start >= len(disks) {
 		return listVolumeStatus{
 			numVisited: len(disks),
-			err:        status.Errorf(codes.FailedPrecondition, "ListVolumes starting token(%d) on rg(%s) is greater than total number of volumes", start, d.cloud.ResourceGroup),
+			err:        status.Errorf(codes.FailedPrecondition, "ListVolumes starting token(%d) on rg(%s) is greater than total number of volumes", start, d.getCloud().ResourceGroup),
 		}
 	}
 	if start < 0 {
@@ -872,7 +897,7 @@ func (d *Driver) listVolumesByResourceGroup(ctx context.Context, resourceGroup s
 			nodeList := []string{}
 
 			if disk.ManagedBy != nil {
-				attachedNode, err := d.cloud.VMSet.GetNodeNameByProviderID(*disk.ManagedBy)
+				attachedNode, err := provisioner.GetNodeNameByProviderID(*disk.ManagedBy)
 				if err != nil {
 					return listVolumeStatus{err: err}
 				}
@@ -912,6 +937,10 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	requestSize := *resource.NewQuantity(capacityBytes, resource.BinarySI)
 
 	diskURI := req.GetVolumeId()
+	if azureutils.IsStripedVolumeID(diskURI) {
+		return d.expandStripedVolume(ctx, req)
+	}
+	ctx = d.withAuditRequestInfo(ctx, "ControllerExpandVolume", diskURI, nil)
 	if err := azureutils.IsValidDiskURI(diskURI); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "disk URI(%s) is not valid: %v", diskURI, err)
 	}
@@ -926,11 +955,8 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	}
 
 	subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get disk client for subscription(%s) with error(%v)", subsID, err)
-	}
-	result, rerr := diskClient.Get(ctx, resourceGroup, diskName)
+	provisioner := d.getProvisioner()
+	result, rerr := provisioner.GetDisk(ctx, subsID, resourceGroup, diskName)
 	if rerr != nil {
 		return nil, status.Errorf(codes.Internal, "could not get the disk(%s) under rg(%s) with error(%v)", diskName, resourceGroup, rerr.Error())
 	}
@@ -939,14 +965,14 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	}
 	oldSize := *resource.NewQuantity(int64(*result.Properties.DiskSizeGB), resource.BinarySI)
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_expand_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_expand_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
 	klog.V(2).Infof("begin to expand azure disk(%s) with new size(%d)", diskURI, requestSize.Value())
-	newSize, err := d.diskController.ResizeDisk(ctx, diskURI, oldSize, requestSize, d.enableDiskOnlineResize)
+	newSize, err := provisioner.ResizeDisk(ctx, diskURI, oldSize, requestSize, d.enableDiskOnlineResize)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to resize disk(%s) with error(%v)", diskURI, err)
 	}
@@ -975,16 +1001,19 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	if len(snapshotName) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "snapshot name must be provided")
 	}
+	if azureutils.IsStripedVolumeID(sourceVolumeID) {
+		return d.createStripedSnapshot(ctx, req)
+	}
 
 	snapshotName = azureutils.CreateValidDiskName(snapshotName)
+	ctx = d.withAuditRequestInfo(ctx, "CreateSnapshot", req.Name, req.GetParameters())
 
 	var customTags string
 	// set incremental snapshot as true by default
 	incremental := true
-	var subsID, resourceGroup, dataAccessAuthMode, tagValueDelimiter string
+	var subsID, resourceGroup, dataAccessAuthMode, tagValueDelimiter, userAgent string
 	var err error
-	localCloud := d.cloud
-	location := d.cloud.Location
+	location := d.getCloud().Location
 
 	parameters := req.GetParameters()
 	for k, v := range parameters {
@@ -1000,12 +1029,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		case consts.LocationField:
 			location = v
 		case consts.UserAgentField:
-			newUserAgent := v
-			localCloud, err = azureutils.GetCloudProviderFromClient(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, newUserAgent,
-				d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort)
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "create cloud with UserAgent(%s) failed with: (%s)", newUserAgent, err)
-			}
+			userAgent = v
 		case consts.SubscriptionIDField:
 			subsID = v
 		case consts.DataAccessAuthModeField:
@@ -1017,6 +1041,11 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		}
 	}
 
+	ctx, localCloud, provisioner, err := d.withScopedCloud(ctx, req.GetSecrets(), userAgent)
+	if err != nil {
+		return nil, err
+	}
+
 	if azureutils.IsAzureStackCloud(localCloud.Config.Cloud, localCloud.Config.DisableAzureStackCloud) {
 		klog.V(2).Info("Use full snapshot instead as Azure Stack does not support incremental snapshot.")
 		incremental = false
@@ -1047,7 +1076,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 			},
 			Incremental: &incremental,
 		},
-		Location: &d.cloud.Location,
+		Location: &d.getCloud().Location,
 		Tags:     tags,
 	}
 
@@ -1064,7 +1093,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	defer d.volumeLocks.Release(snapshotName)
 
 	var crossRegionSnapshotName string
-	if location != "" && location != d.cloud.Location {
+	if location != "" && location != d.getCloud().Location {
 		if incremental {
 			crossRegionSnapshotName = snapshotName
 			snapshotName = azureutils.CreateValidDiskName("local_" + snapshotName)
@@ -1077,18 +1106,14 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 	if crossRegionSnapshotName != "" {
 		metricsRequest = "controller_create_snapshot_cross_region"
 	}
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, metricsRequest, d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, metricsRequest, d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.SourceResourceID, sourceVolumeID, consts.SnapshotName, snapshotName)
 	}()
 
-	klog.V(2).Infof("begin to create snapshot(%s, incremental: %v) under rg(%s) region(%s)", snapshotName, incremental, resourceGroup, d.cloud.Location)
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
-	}
-	if _, err := snapshotClient.CreateOrUpdate(ctx, resourceGroup, snapshotName, snapshot); err != nil {
+	klog.V(2).Infof("begin to create snapshot(%s, incremental: %v) under rg(%s) region(%s)", snapshotName, incremental, resourceGroup, d.getCloud().Location)
+	if err = provisioner.CreateSnapshot(ctx, subsID, resourceGroup, snapshotName, snapshot); err != nil {
 		if strings.Contains(err.Error(), "existing disk") {
 			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("request snapshot(%s) under rg(%s) already exists, but the SourceVolumeId is different, error details: %v", snapshotName, resourceGroup, err))
 		}
@@ -1102,7 +1127,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 			return nil, status.Error(codes.Internal, fmt.Sprintf("waitForSnapshotReady(%s, %s, %s) failed with %v", subsID, resourceGroup, snapshotName, err))
 		}
 	}
-	klog.V(2).Infof("create snapshot(%s) under rg(%s) region(%s) successfully", snapshotName, resourceGroup, d.cloud.Location)
+	klog.V(2).Infof("create snapshot(%s) under rg(%s) region(%s) successfully", snapshotName, resourceGroup, d.getCloud().Location)
 
 	csiSnapshot, err := d.getSnapshotByID(ctx, subsID, resourceGroup, snapshotName, sourceVolumeID)
 	if err != nil {
@@ -1122,7 +1147,7 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 		copySnapshot.Location = &location
 
 		klog.V(2).Infof("begin to create snapshot(%s, incremental: %v) under rg(%s) region(%s)", crossRegionSnapshotName, incremental, resourceGroup, location)
-		if _, err := snapshotClient.CreateOrUpdate(ctx, resourceGroup, crossRegionSnapshotName, copySnapshot); err != nil {
+		if err = provisioner.CreateSnapshot(ctx, subsID, resourceGroup, crossRegionSnapshotName, copySnapshot); err != nil {
 			if strings.Contains(err.Error(), "existing disk") {
 				return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("request snapshot(%s) under rg(%s) already exists, but the SourceVolumeId is different, error details: %v", crossRegionSnapshotName, resourceGroup, err))
 			}
@@ -1136,12 +1161,12 @@ func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequ
 			return nil, status.Error(codes.Internal, fmt.Sprintf("waitForSnapshotReady(%s, %s, %s) failed with %v", subsID, resourceGroup, crossRegionSnapshotName, err))
 		}
 
-		klog.V(2).Infof("begin to delete snapshot(%s) under rg(%s) region(%s)", snapshotName, resourceGroup, d.cloud.Location)
-		if err = snapshotClient.Delete(ctx, resourceGroup, snapshotName); err != nil {
+		klog.V(2).Infof("begin to delete snapshot(%s) under rg(%s) region(%s)", snapshotName, resourceGroup, d.getCloud().Location)
+		if err = provisioner.DeleteSnapshot(ctx, subsID, resourceGroup, snapshotName); err != nil {
 			klog.Errorf("delete snapshot error: %v", err)
 			azureutils.SleepIfThrottled(err, consts.SnapshotOpThrottlingSleepSec)
 		} else {
-			klog.V(2).Infof("delete snapshot(%s) under rg(%s) region(%s) successfully", snapshotName, resourceGroup, d.cloud.Location)
+			klog.V(2).Infof("delete snapshot(%s) under rg(%s) region(%s) successfully", snapshotName, resourceGroup, d.getCloud().Location)
 		}
 
 		csiSnapshot, err = d.getSnapshotByID(ctx, subsID, resourceGroup, crossRegionSnapshotName, sourceVolumeID)
@@ -1163,11 +1188,15 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 	if len(snapshotID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Snapshot ID must be provided")
 	}
+	if azureutils.IsStripedVolumeID(snapshotID) {
+		return d.deleteStripedSnapshot(ctx, req)
+	}
 
 	var err error
 	var subsID string
 	snapshotName := snapshotID
-	resourceGroup := d.cloud.ResourceGroup
+	resourceGroup := d.getCloud().ResourceGroup
+	ctx = d.withAuditRequestInfo(ctx, "DeleteSnapshot", snapshotID, nil)
 
 	if azureutils.IsARMResourceID(snapshotID) {
 		snapshotName, resourceGroup, subsID, err = d.getSnapshotInfo(snapshotID)
@@ -1176,18 +1205,19 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 		}
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_snapshot", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	ctx, _, provisioner, err := d.withScopedCloud(ctx, req.GetSecrets(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_snapshot", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.SnapshotID, snapshotID)
 	}()
 
 	klog.V(2).Infof("begin to delete snapshot(%s) under rg(%s)", snapshotName, resourceGroup)
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
-	}
-	if err := snapshotClient.Delete(ctx, resourceGroup, snapshotName); err != nil {
+	if err = provisioner.DeleteSnapshot(ctx, subsID, resourceGroup, snapshotName); err != nil {
 		azureutils.SleepIfThrottled(err, consts.SnapshotOpThrottlingSleepSec)
 		return nil, status.Error(codes.Internal, fmt.Sprintf("delete snapshot error: %v", err))
 	}
@@ -1200,7 +1230,7 @@ func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequ
 func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	// SnapshotId is not empty, return snapshot that match the snapshot id.
 	if len(req.GetSnapshotId()) != 0 {
-		snapshot, err := d.getSnapshotByID(ctx, "", d.cloud.ResourceGroup, req.GetSnapshotId(), req.SourceVolumeId)
+		snapshot, err := d.getSnapshotByID(ctx, "", d.getCloud().ResourceGroup, req.GetSnapshotId(), req.SourceVolumeId)
 		if err != nil {
 			if strings.Contains(err.Error(), consts.ResourceNotFound) {
 				return &csi.ListSnapshotsResponse{}, nil
@@ -1217,9 +1247,8 @@ func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReques
 		}
 		return listSnapshotResp, nil
 	}
-	snapshotClient := d.clientFactory.GetSnapshotClient()
 	// no SnapshotId is set, return all snapshots that satisfy the request.
-	snapshots, err := snapshotClient.List(ctx, d.cloud.ResourceGroup)
+	snapshots, err := d.getProvisioner().ListSnapshots(ctx, d.getCloud().ResourceGroup)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list snapshot error: %v", err.Error()))
 	}
@@ -1236,11 +1265,7 @@ func (d *Driver) getSnapshotByID(ctx context.Context, subsID, resourceGroup, sna
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
 	}
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
-	}
-	snapshot, err := snapshotClient.Get(ctx, resourceGroup, snapshotName)
+	snapshot, err := d.getContextProvisioner(ctx).GetSnapshot(ctx, subsID, resourceGroup, snapshotName)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("get snapshot %s from rg(%s) error: %v", snapshotName, resourceGroup, err))
 	}
@@ -1253,11 +1278,7 @@ func (d *Driver) GetSourceDiskSize(ctx context.Context, subsID, resourceGroup, d
 	if curDepth > maxDepth {
 		return nil, nil, status.Error(codes.Internal, fmt.Sprintf("current depth (%d) surpassed the max depth (%d) while searching for the source disk size", curDepth, maxDepth))
 	}
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
-	if err != nil {
-		return nil, nil, status.Error(codes.Internal, err.Error())
-	}
-	result, err := diskClient.Get(ctx, resourceGroup, diskName)
+	result, err := d.getContextProvisioner(ctx).GetDisk(ctx, subsID, resourceGroup, diskName)
 	if err != nil {
 		return nil, result, err
 	}
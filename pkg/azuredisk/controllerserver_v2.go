@@ -57,6 +57,7 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 	}
 
 	params := req.GetParameters()
+	ctx = d.withAuditRequestInfo(ctx, "CreateVolume", req.GetName(), params)
 	diskParams, err := azureutils.ParseDiskParameters(params)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "Failed parsing disk parameters: %v", err)
@@ -74,6 +75,24 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 	if err := azureutils.IsValidVolumeCapabilities(volCaps, diskParams.MaxShares); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	if err := azureutils.ValidateFormatOptions(&diskParams, volCaps, defaultLinuxFsType); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if diskParams.StripeCount > 1 {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not supported by the v2 driver", consts.StripeCountField)
+	}
+
+	maxShares := diskParams.MaxShares
+	if maxShares < 1 {
+		maxShares = 1
+	}
+	maxMountReplicaCount, err := azureutils.GetMaxMountReplicaCount(params, maxShares, azureutils.IsMultiWriterVolumeCapabilities(volCaps))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the attachment controller reads the resolved replica count from the volume context
+	azureutils.SetKeyValueInMap(diskParams.VolumeContext, consts.MaxMountReplicaCountField, strconv.Itoa(maxMountReplicaCount))
+
 	isAdvancedPerfProfile := strings.EqualFold(diskParams.PerfProfile, consts.PerfProfileAdvanced)
 	// If perfProfile is set to advanced and no/invalid device settings are provided, fail the request
 	if d.getPerfOptimizationEnabled() && isAdvancedPerfProfile {
@@ -105,7 +124,12 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 		return nil, status.Error(codes.InvalidArgument, "After round-up, volume size exceeds the limit specified")
 	}
 
-	if azureutils.IsAzureStackCloud(d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud) {
+	ctx, localCloud, provisioner, err := d.withScopedCloud(ctx, req.GetSecrets(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	if azureutils.IsAzureStackCloud(d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud) {
 		if diskParams.MaxShares > 1 {
 			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid maxShares value: %d as Azure Stack does not support shared disk.", diskParams.MaxShares))
 		}
@@ -117,11 +141,11 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 	diskParams.DiskName = azureutils.CreateValidDiskName(diskParams.DiskName)
 
 	if diskParams.ResourceGroup == "" {
-		diskParams.ResourceGroup = d.cloud.ResourceGroup
+		diskParams.ResourceGroup = localCloud.ResourceGroup
 	}
 
 	// normalize values
-	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud)
+	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
@@ -148,7 +172,7 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	selectedAvailabilityZone := azureutils.PickAvailabilityZone(req.GetAccessibilityRequirements(), d.cloud.Location, topologyKey)
+	selectedAvailabilityZone := azureutils.PickAvailabilityZone(req.GetAccessibilityRequirements(), d.getCloud().Location, topologyKey)
 
 	if d.enableDiskCapacityCheck {
 		if ok, err := d.checkDiskCapacity(ctx, diskParams.SubscriptionID, diskParams.ResourceGroup, diskParams.DiskName, requestGiB); !ok {
@@ -197,6 +221,10 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 	}
 
 	diskParams.VolumeContext[consts.RequestedSizeGib] = strconv.Itoa(requestGiB)
+	if sourceType != "" {
+		// the node regenerates the filesystem UUID of the clone of a volume mounted on the same node
+		diskParams.VolumeContext[consts.ContentSourceTypeField] = sourceType
+	}
 	volumeOptions := &ManagedDiskOptions{
 		AvailabilityZone:    selectedAvailabilityZone,
 		BurstingEnabled:     diskParams.EnableBursting,
@@ -217,7 +245,7 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 		PerformancePlus:     diskParams.PerformancePlus,
 	}
 	// Azure Stack Cloud does not support NetworkAccessPolicy, PublicNetworkAccess
-	if !azureutils.IsAzureStackCloud(d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud) {
+	if !azureutils.IsAzureStackCloud(d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud) {
 		volumeOptions.NetworkAccessPolicy = networkAccessPolicy
 		volumeOptions.PublicNetworkAccess = publicNetworkAccess
 		if diskParams.DiskAccessID != "" {
@@ -226,13 +254,13 @@ func (d *DriverV2) CreateVolume(ctx context.Context, req *csi.CreateVolumeReques
 	}
 
 	var diskURI string
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_create_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_create_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
-	diskURI, err = d.diskController.CreateManagedDisk(ctx, volumeOptions)
+	diskURI, err = provisioner.CreateDisk(ctx, volumeOptions)
 	if err != nil {
 		if strings.Contains(err.Error(), consts.NotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
@@ -268,6 +296,7 @@ func (d *DriverV2) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeReques
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
 		return nil, status.Errorf(codes.Internal, "invalid delete volume req: %v", req)
 	}
+	ctx = d.withAuditRequestInfo(ctx, "DeleteVolume", volumeID, nil)
 	diskURI := volumeID
 
 	if err := azureutils.IsValidDiskURI(diskURI); err != nil {
@@ -280,14 +309,19 @@ func (d *DriverV2) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeReques
 	}
 	defer d.volumeLocks.Release(volumeID)
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	ctx, _, provisioner, err := d.withScopedCloud(ctx, req.GetSecrets(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
 	klog.V(2).Infof("deleting azure disk(%s)", diskURI)
-	err := d.diskController.DeleteManagedDisk(ctx, diskURI)
+	err = provisioner.DeleteDisk(ctx, diskURI)
 	klog.V(2).Infof("delete azure disk(%s) returned with %v", diskURI, err)
 	isOperationSucceeded = (err == nil)
 	return &csi.DeleteVolumeResponse{}, err
@@ -308,6 +342,7 @@ func (d *DriverV2) ControllerModifyVolume(ctx context.Context, req *csi.Controll
 	if err := d.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_MODIFY_VOLUME); err != nil {
 		return nil, status.Errorf(codes.Internal, "invalid modify volume req: %v", req)
 	}
+	ctx = d.withAuditRequestInfo(ctx, "ControllerModifyVolume", volumeID, req.GetMutableParameters())
 	diskURI := volumeID
 
 	diskName, err := azureutils.GetDiskName(diskURI)
@@ -325,7 +360,7 @@ func (d *DriverV2) ControllerModifyVolume(ctx context.Context, req *csi.Controll
 	}
 
 	// normalize values
-	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud)
+	skuName, err := azureutils.NormalizeStorageAccountType(diskParams.AccountType, d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
@@ -347,13 +382,13 @@ func (d *DriverV2) ControllerModifyVolume(ctx context.Context, req *csi.Controll
 		SourceType:         consts.SourceVolume,
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_modify_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_modify_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
-	if err = d.diskController.ModifyDisk(ctx, volumeOptions); err != nil {
+	if err = d.getProvisioner().ModifyDisk(ctx, volumeOptions); err != nil {
 		if strings.Contains(err.Error(), consts.NotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
@@ -372,6 +407,7 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 	if len(diskURI) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
+	ctx = d.withAuditRequestInfo(ctx, "ControllerPublishVolume", diskURI, req.GetVolumeContext())
 
 	volCap := req.GetVolumeCapability()
 	if volCap == nil {
@@ -404,28 +440,48 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 		return nil, status.Errorf(codes.Internal, err.Error())
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_publish_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_publish_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI, consts.Node, string(nodeName))
 	}()
 
-	lun, vmState, err := d.diskController.GetDiskLun(diskName, diskURI, nodeName)
+	volumeContext := req.GetVolumeContext()
+	if volumeContext == nil {
+		volumeContext = map[string]string{}
+	}
+
+	if d.azVolumeAttachmentController != nil {
+		publishContext, err := d.publishAzVolumeAttachment(ctx, diskName, diskURI, nodeName, volumeContext)
+		if err != nil {
+			return nil, err
+		}
+		isOperationSucceeded = true
+		return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
+	}
+
+	publishContext, err := d.attachDiskToNode(ctx, diskName, diskURI, nodeName, volumeContext, disk)
+	if err != nil {
+		return nil, err
+	}
+	isOperationSucceeded = true
+	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
+}
+
+// attachDiskToNode attaches the disk to the node unless it is already attached and returns the publish context of the attachment
+func (d *DriverV2) attachDiskToNode(ctx context.Context, diskName, diskURI string, nodeName types.NodeName, volumeContext map[string]string, disk *armcompute.Disk) (map[string]string, error) {
+	provisioner := d.getProvisioner()
+	lun, vmState, err := provisioner.GetDiskLun(diskName, diskURI, nodeName)
 	if err == cloudprovider.InstanceNotFound {
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("failed to get azure instance id for node %q (%v)", nodeName, err))
 	}
 
 	klog.V(2).Infof("GetDiskLun returned: %v. Initiating attaching volume %s to node %s.", err, diskURI, nodeName)
 
-	volumeContext := req.GetVolumeContext()
-	if volumeContext == nil {
-		volumeContext = map[string]string{}
-	}
-
 	if err == nil {
 		if vmState != nil && strings.ToLower(*vmState) == "failed" {
 			klog.Warningf("VM(%s) is in failed state, update VM first", nodeName)
-			if err := d.diskController.UpdateVM(ctx, nodeName); err != nil {
+			if err := provisioner.UpdateVM(ctx, nodeName); err != nil {
 				return nil, status.Errorf(codes.Internal, "update instance %q failed with %v", nodeName, err)
 			}
 		}
@@ -441,17 +497,17 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 		}
 		klog.V(2).Infof("Trying to attach volume %s to node %s", diskURI, nodeName)
 
-		lun, err = d.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, nil)
+		lun, err = provisioner.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, nil)
 		if err == nil {
 			klog.V(2).Infof("Attach operation successful: volume %s attached to node %s.", diskURI, nodeName)
 		} else {
 			if derr, ok := err.(*volerr.DanglingAttachError); ok {
 				klog.Warningf("volume %s is already attached to node %s, try detach first", diskURI, derr.CurrentNode)
-				if err = d.diskController.DetachDisk(ctx, diskName, diskURI, derr.CurrentNode); err != nil {
+				if err = provisioner.DetachDisk(ctx, diskName, diskURI, derr.CurrentNode); err != nil {
 					return nil, status.Errorf(codes.Internal, "Could not detach volume %s from node %s: %v", diskURI, derr.CurrentNode, err)
 				}
 				klog.V(2).Infof("Trying to attach volume %s to node %s again", diskURI, nodeName)
-				lun, err = d.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, nil)
+				lun, err = provisioner.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, nil)
 			}
 			if err != nil {
 				klog.Errorf("Attach volume %s to instance %s failed with %v", diskURI, nodeName, err)
@@ -467,9 +523,9 @@ func (d *DriverV2) ControllerPublishVolume(ctx context.Context, req *csi.Control
 			klog.V(2).Infof("found static PV(%s), insert disk properties to volumeattachments", diskURI)
 			azureutils.InsertDiskProperties(disk, publishContext)
 		}
+		azureutils.InsertDiskIdentity(disk, publishContext)
 	}
-	isOperationSucceeded = true
-	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
+	return publishContext, nil
 }
 
 // ControllerUnpublishVolume detach an azure disk from a required node
@@ -478,6 +534,7 @@ func (d *DriverV2) ControllerUnpublishVolume(ctx context.Context, req *csi.Contr
 	if len(diskURI) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
+	ctx = d.withAuditRequestInfo(ctx, "ControllerUnpublishVolume", diskURI, nil)
 
 	nodeID := req.GetNodeId()
 	if len(nodeID) == 0 {
@@ -490,27 +547,43 @@ func (d *DriverV2) ControllerUnpublishVolume(ctx context.Context, req *csi.Contr
 		return nil, status.Errorf(codes.Internal, err.Error())
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_unpublish_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_unpublish_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI, consts.Node, string(nodeName))
 	}()
 
-	klog.V(2).Infof("Trying to detach volume %s from node %s", diskURI, nodeID)
-
-	if err := d.diskController.DetachDisk(ctx, diskName, diskURI, nodeName); err != nil {
-		if strings.Contains(err.Error(), consts.ErrDiskNotFound) {
-			klog.Warningf("volume %s already detached from node %s", diskURI, nodeID)
-		} else {
-			return nil, status.Errorf(codes.Internal, "Could not detach volume %q from node %q: %v", diskURI, nodeID, err)
+	if d.azVolumeAttachmentController != nil {
+		if err := d.unpublishAzVolumeAttachment(ctx, diskName, diskURI, nodeName); err != nil {
+			return nil, err
 		}
+		isOperationSucceeded = true
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	if err := d.detachDiskFromNode(ctx, diskName, diskURI, nodeName); err != nil {
+		return nil, err
 	}
-	klog.V(2).Infof("detach volume %s from node %s successfully", diskURI, nodeID)
 	isOperationSucceeded = true
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
+// detachDiskFromNode detaches the disk from the node, treating a disk that is no longer attached as success
+func (d *DriverV2) detachDiskFromNode(ctx context.Context, diskName, diskURI string, nodeName types.NodeName) error {
+	klog.V(2).Infof("Trying to detach volume %s from node %s", diskURI, nodeName)
+
+	if err := d.getProvisioner().DetachDisk(ctx, diskName, diskURI, nodeName); err != nil {
+		if strings.Contains(err.Error(), consts.ErrDiskNotFound) {
+			klog.Warningf("volume %s already detached from node %s", diskURI, nodeName)
+		} else {
+			return status.Errorf(codes.Internal, "Could not detach volume %q from node %q: %v", diskURI, nodeName, err)
+		}
+	}
+	klog.V(2).Infof("detach volume %s from node %s successfully", diskURI, nodeName)
+	return nil
+}
+
 // ValidateVolumeCapabilities return the capabilities of the volume
 func (d *DriverV2) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	diskURI := req.GetVolumeId()
@@ -567,17 +640,17 @@ func (d *DriverV2) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest)
 			return nil, status.Errorf(codes.Aborted, "ListVolumes starting token(%d) can not be negative", start)
 		}
 	}
-	if d.cloud.KubeClient != nil && d.cloud.KubeClient.CoreV1() != nil && d.cloud.KubeClient.CoreV1().PersistentVolumes() != nil {
+	if d.getCloud().KubeClient != nil && d.getCloud().KubeClient.CoreV1() != nil && d.getCloud().KubeClient.CoreV1().PersistentVolumes() != nil {
 		klog.V(6).Infof("List Volumes in Cluster:")
 		return d.listVolumesInCluster(ctx, start, int(req.MaxEntries))
 	}
-	klog.V(6).Infof("List Volumes in Node Resource Group: %s", d.cloud.ResourceGroup)
+	klog.V(6).Infof("List Volumes in Node Resource Group: %s", d.getCloud().ResourceGroup)
 	return d.listVolumesInNodeResourceGroup(ctx, start, int(req.MaxEntries))
 }
 
 // listVolumesInCluster is a helper function for ListVolumes used for when there is an available kubeclient
 func (d *DriverV2) listVolumesInCluster(ctx context.Context, start, maxEntries int) (*csi.ListVolumesResponse, error) {
-	pvList, err := d.cloud.KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	pvList, err := d.getCloud().KubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "ListVolumes failed while fetching PersistentVolumes List with error: %v", err.Error())
 	}
@@ -598,8 +671,8 @@ func (d *DriverV2) listVolumesInCluster(ctx context.Context, start, maxEntries i
 				continue
 			}
 			subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-			if !strings.EqualFold(subsID, d.cloud.SubscriptionID) {
-				klog.V(6).Infof("disk(%s) not in current subscription(%s), skip", diskURI, d.cloud.SubscriptionID)
+			if !strings.EqualFold(subsID, d.getCloud().SubscriptionID) {
+				klog.V(6).Infof("disk(%s) not in current subscription(%s), skip", diskURI, d.getCloud().SubscriptionID)
 				continue
 			}
 			rg, diskURI = strings.ToLower(rg), strings.ToLower(diskURI)
@@ -665,7 +738,7 @@ func (d *DriverV2) listVolumesInCluster(ctx context.Context, start, maxEntries i
 // listVolumesInNodeResourceGroup is a helper function for ListVolumes used for when there is no available kubeclient
 func (d *DriverV2) listVolumesInNodeResourceGroup(ctx context.Context, start, maxEntries int) (*csi.ListVolumesResponse, error) {
 	entries := []*csi.ListVolumesResponse_Entry{}
-	listStatus := d.listVolumesByResourceGroup(ctx, d.cloud.ResourceGroup, entries, start, maxEntries, nil)
+	listStatus := d.listVolumesByResourceGroup(ctx, d.getCloud().ResourceGroup, entries, start, maxEntries, nil)
 	if listStatus.err != nil {
 		return nil, listStatus.err
 	}
@@ -685,8 +758,8 @@ func (d *DriverV2) listVolumesInNodeResourceGroup(ctx context.Context, start, ma
 
 // listVolumesByResourceGroup is a helper function that updates the ListVolumeResponse_Entry slice and returns number of total visited volumes, number of volumes that needs to be visited and an error if found
 func (d *DriverV2) listVolumesByResourceGroup(ctx context.Context, resourceGroup string, entries []*csi.ListVolumesResponse_Entry, start, maxEntries int, volSet map[string]bool) listVolumeStatus {
-	diskClient := d.clientFactory.GetDiskClient()
-	disks, derr := diskClient.List(ctx, resourceGroup)
+	provisioner := d.getProvisioner()
+	disks, derr := provisioner.ListDisks(ctx, resourceGroup)
 	if derr != nil {
 		return listVolumeStatus{err: status.Errorf(codes.Internal, "ListVolumes on rg(%s) failed with error: %s", resourceGroup, derr.Error())}
 	}
@@ -701,7 +774,7 @@ func (d *DriverV2) listVolumesByResourceGroup(ctx context.Context, resourceGroup
 	if start > 0 && start >= len(disks) {
 		return listVolumeStatus{
 			numVisited: len(disks),
-			err:        status.Errorf(codes.FailedPrecondition, "ListVolumes starting token(%d) on rg(%s) is greater than total number of volumes", start, d.cloud.ResourceGroup),
+			err:        status.Errorf(codes.FailedPrecondition, "ListVolumes starting token(%d) on rg(%s) is greater than total number of volumes", start, d.getCloud().ResourceGroup),
 		}
 	}
 	if start < 0 {
@@ -726,7 +799,7 @@ func (d *DriverV2) listVolumesByResourceGroup(ctx context.Context, resourceGroup
 			nodeList := []string{}
 
 			if disk.ManagedBy != nil {
-				attachedNode, err := d.cloud.VMSet.GetNodeNameByProviderID(*disk.ManagedBy)
+				attachedNode, err := provisioner.GetNodeNameByProviderID(*disk.ManagedBy)
 				if err != nil {
 					return listVolumeStatus{err: err}
 				}
@@ -766,6 +839,7 @@ func (d *DriverV2) ControllerExpandVolume(ctx context.Context, req *csi.Controll
 	requestSize := *resource.NewQuantity(capacityBytes, resource.BinarySI)
 
 	diskURI := req.GetVolumeId()
+	ctx = d.withAuditRequestInfo(ctx, "ControllerExpandVolume", diskURI, nil)
 	if err := azureutils.IsValidDiskURI(diskURI); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "disk URI(%s) is not valid: %v", diskURI, err)
 	}
@@ -779,18 +853,15 @@ func (d *DriverV2) ControllerExpandVolume(ctx context.Context, req *csi.Controll
 		return nil, status.Errorf(codes.Internal, "could not get resource group from diskURI(%s) with error(%v)", diskURI, err)
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_expand_volume", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_expand_volume", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, diskURI)
 	}()
 
 	subsID := azureutils.GetSubscriptionIDFromURI(diskURI)
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get disk client for subscription(%s) with error(%v)", subsID, err)
-	}
-	result, err := diskClient.Get(ctx, resourceGroup, diskName)
+	provisioner := d.getProvisioner()
+	result, err := provisioner.GetDisk(ctx, subsID, resourceGroup, diskName)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not get the disk(%s) under rg(%s) with error(%v)", diskName, resourceGroup, err)
 	}
@@ -800,7 +871,7 @@ func (d *DriverV2) ControllerExpandVolume(ctx context.Context, req *csi.Controll
 	oldSize := *resource.NewQuantity(int64(*result.Properties.DiskSizeGB), resource.BinarySI)
 
 	klog.V(2).Infof("begin to expand azure disk(%s) with new size(%d)", diskURI, requestSize.Value())
-	newSize, err := d.diskController.ResizeDisk(ctx, diskURI, oldSize, requestSize, d.enableDiskOnlineResize)
+	newSize, err := provisioner.ResizeDisk(ctx, diskURI, oldSize, requestSize, d.enableDiskOnlineResize)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to resize disk(%s) with error(%v)", diskURI, err)
 	}
@@ -831,6 +902,7 @@ func (d *DriverV2) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRe
 	}
 
 	snapshotName = azureutils.CreateValidDiskName(snapshotName)
+	ctx = d.withAuditRequestInfo(ctx, "CreateSnapshot", req.Name, req.GetParameters())
 
 	var customTags string
 	// set incremental snapshot as true by default
@@ -860,7 +932,12 @@ func (d *DriverV2) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRe
 		}
 	}
 
-	if azureutils.IsAzureStackCloud(d.cloud.Config.Cloud, d.cloud.Config.DisableAzureStackCloud) {
+	ctx, _, provisioner, err := d.withScopedCloud(ctx, req.GetSecrets(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	if azureutils.IsAzureStackCloud(d.getCloud().Config.Cloud, d.getCloud().Config.DisableAzureStackCloud) {
 		klog.V(2).Info("Use full snapshot instead as Azure Stack does not support incremental snapshot.")
 		incremental = false
 	}
@@ -890,7 +967,7 @@ func (d *DriverV2) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRe
 			},
 			Incremental: &incremental,
 		},
-		Location: &d.cloud.Location,
+		Location: &d.getCloud().Location,
 		Tags:     tags,
 	}
 	if dataAccessAuthMode != "" {
@@ -900,18 +977,14 @@ func (d *DriverV2) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRe
 		snapshot.Properties.DataAccessAuthMode = to.Ptr(armcompute.DataAccessAuthMode(dataAccessAuthMode))
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_create_snapshot", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_create_snapshot", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.SourceResourceID, sourceVolumeID, consts.SnapshotName, snapshotName)
 	}()
 
 	klog.V(2).Infof("begin to create snapshot(%s, incremental: %v) under rg(%s)", snapshotName, incremental, resourceGroup)
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
-	}
-	if _, err := snapshotClient.CreateOrUpdate(ctx, resourceGroup, snapshotName, snapshot); err != nil {
+	if err := provisioner.CreateSnapshot(ctx, subsID, resourceGroup, snapshotName, snapshot); err != nil {
 		if strings.Contains(err.Error(), "existing disk") {
 			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("request snapshot(%s) under rg(%s) already exists, but the SourceVolumeId is different, error details: %v", snapshotName, resourceGroup, err))
 		}
@@ -945,7 +1018,8 @@ func (d *DriverV2) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRe
 	var err error
 	var subsID string
 	snapshotName := snapshotID
-	resourceGroup := d.cloud.ResourceGroup
+	resourceGroup := d.getCloud().ResourceGroup
+	ctx = d.withAuditRequestInfo(ctx, "DeleteSnapshot", snapshotID, nil)
 
 	if azureutils.IsARMResourceID(snapshotID) {
 		snapshotName, resourceGroup, subsID, err = d.getSnapshotInfo(snapshotID)
@@ -954,18 +1028,19 @@ func (d *DriverV2) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRe
 		}
 	}
 
-	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_snapshot", d.cloud.ResourceGroup, d.cloud.SubscriptionID, d.Name)
+	ctx, _, provisioner, err := d.withScopedCloud(ctx, req.GetSecrets(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "controller_delete_snapshot", d.getCloud().ResourceGroup, d.getCloud().SubscriptionID, d.Name)
 	isOperationSucceeded := false
 	defer func() {
 		mc.ObserveOperationWithResult(isOperationSucceeded, consts.SnapshotID, snapshotName)
 	}()
 
 	klog.V(2).Infof("begin to delete snapshot(%s) under rg(%s)", snapshotName, resourceGroup)
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
-	}
-	err = snapshotClient.Delete(ctx, resourceGroup, snapshotName)
+	err = provisioner.DeleteSnapshot(ctx, subsID, resourceGroup, snapshotName)
 	if err != nil {
 		azureutils.SleepIfThrottled(err, consts.SnapshotOpThrottlingSleepSec)
 		return nil, status.Error(codes.Internal, fmt.Sprintf("delete snapshot error: %v", err))
@@ -979,7 +1054,7 @@ func (d *DriverV2) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRe
 func (d *DriverV2) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	// SnapshotId is not empty, return snapshot that match the snapshot id.
 	if len(req.GetSnapshotId()) != 0 {
-		snapshot, err := d.getSnapshotByID(ctx, "", d.cloud.ResourceGroup, req.GetSnapshotId(), req.SourceVolumeId)
+		snapshot, err := d.getSnapshotByID(ctx, "", d.getCloud().ResourceGroup, req.GetSnapshotId(), req.SourceVolumeId)
 		if err != nil {
 			if strings.Contains(err.Error(), consts.ResourceNotFound) {
 				return &csi.ListSnapshotsResponse{}, nil
@@ -996,9 +1071,8 @@ func (d *DriverV2) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequ
 		}
 		return listSnapshotResp, nil
 	}
-	snapshotClient := d.clientFactory.GetSnapshotClient()
 	// no SnapshotId is set, return all snapshots that satisfy the request.
-	snapshots, err := snapshotClient.List(ctx, d.cloud.ResourceGroup)
+	snapshots, err := d.getProvisioner().ListSnapshots(ctx, d.getCloud().ResourceGroup)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list snapshot error: %v", err.Error()))
 	}
@@ -1015,11 +1089,7 @@ func (d *DriverV2) getSnapshotByID(ctx context.Context, subsID, resourceGroup, s
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
 	}
-	snapshotClient, err := d.clientFactory.GetSnapshotClientForSub(subsID)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
-	}
-	snapshot, rerr := snapshotClient.Get(ctx, resourceGroup, snapshotName)
+	snapshot, rerr := d.getContextProvisioner(ctx).GetSnapshot(ctx, subsID, resourceGroup, snapshotName)
 	if rerr != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("get snapshot %s from rg(%s) error: %v", snapshotName, resourceGroup, rerr.Error()))
 	}
@@ -1032,11 +1102,7 @@ func (d *DriverV2) GetSourceDiskSize(ctx context.Context, subsID, resourceGroup,
 	if curDepth > maxDepth {
 		return nil, nil, status.Error(codes.Internal, fmt.Sprintf("current depth (%d) surpassed the max depth (%d) while searching for the source disk size", curDepth, maxDepth))
 	}
-	diskClient, err := d.clientFactory.GetDiskClientForSub(subsID)
-	if err != nil {
-		return nil, nil, status.Error(codes.Internal, err.Error())
-	}
-	result, err := diskClient.Get(ctx, resourceGroup, diskName)
+	result, err := d.getContextProvisioner(ctx).GetDisk(ctx, subsID, resourceGroup, diskName)
 	if err != nil {
 		return nil, result, err
 	}
@@ -0,0 +1,211 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+)
+
+// deviceEventTimeout is how long the discovery of a data disk waits for the uevent of its device before rescanning its
+// LUN
+const deviceEventTimeout = 15 * time.Second
+
+// deviceIndex is a live index of the data disk devices of the node by LUN, kept up to date by the block device uevents
+type deviceIndex struct {
+	io   azureutils.IOHandler
+	lock sync.Mutex
+	// devices are the device names of the data disks by LUN, e.g. sdc or nvme0n2
+	devices map[int]string
+	// changed is closed and replaced when a device is added to the index
+	changed chan struct{}
+}
+
+func newDeviceIndex(io azureutils.IOHandler) *deviceIndex {
+	return &deviceIndex{io: io, devices: map[int]string{}, changed: make(chan struct{})}
+}
+
+// seed indexes the data disks already attached to the node
+func (i *deviceIndex) seed() error {
+	devNames, err := listBlockDevices(i.io)
+	if err != nil {
+		return err
+	}
+	for _, devName := range devNames {
+		i.add(devName)
+	}
+	return nil
+}
+
+// handleEvent updates the index with a uevent of the kernel
+func (i *deviceIndex) handleEvent(event map[string]string) {
+	if event["SUBSYSTEM"] != "block" || event["DEVTYPE"] != "disk" || event["DEVNAME"] == "" {
+		return
+	}
+	devName := filepath.Base(event["DEVNAME"])
+	switch event["ACTION"] {
+	case "add":
+		i.add(devName)
+	case "remove":
+		i.remove(devName)
+	}
+}
+
+// add indexes the block device devName if it is a data disk
+func (i *deviceIndex) add(devName string) {
+	lun, err := getDataDiskLUN(i.io, devName)
+	if err != nil {
+		klog.V(6).Infof("deviceIndex: %s is not indexed: %v", devName, err)
+		return
+	}
+	klog.V(4).Infof("deviceIndex: found data disk %s at lun %d", devName, lun)
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.devices[lun] = devName
+	close(i.changed)
+	i.changed = make(chan struct{})
+}
+
+// remove drops the block device devName from the index
+func (i *deviceIndex) remove(devName string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for lun, name := range i.devices {
+		if name == devName {
+			klog.V(4).Infof("deviceIndex: data disk %s at lun %d is removed", devName, lun)
+			delete(i.devices, lun)
+		}
+	}
+}
+
+// lookup returns the device path of the data disk at lun if it is indexed and still at lun, and the channel closed
+// when a device is added otherwise. The device path is the udev link of the disk like the path found by
+// findDiskByLun, so that the partitions of the disk are found from it.
+func (i *deviceIndex) lookup(lun int) (string, <-chan struct{}) {
+	i.lock.Lock()
+	devName, ok := i.devices[lun]
+	changed := i.changed
+	i.lock.Unlock()
+	if !ok {
+		return "", changed
+	}
+	// the device may have been replaced without the index seeing its uevents
+	if current, err := getDataDiskLUN(i.io, devName); err != nil || current != lun {
+		klog.V(4).Infof("deviceIndex: data disk %s is no longer at lun %d", devName, lun)
+		i.lock.Lock()
+		if i.devices[lun] == devName {
+			delete(i.devices, lun)
+		}
+		changed = i.changed
+		i.lock.Unlock()
+		return "", changed
+	}
+	return getDataDiskPath(i.io, lun, devName), nil
+}
+
+// wait returns the device path of the data disk at lun, waiting up to timeout for the device to be added
+func (i *deviceIndex) wait(lun int, timeout time.Duration) (string, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		devicePath, changed := i.lookup(lun)
+		if devicePath != "" {
+			return devicePath, true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return "", false
+		}
+	}
+}
+
+// udevMonitorPrefix starts the header of the uevent messages sent by udev, the header is followed by the properties
+// of the uevent at the offset and with the length in the header, e.g.
+// libudev\0<magic><header size><properties offset><properties length>...ACTION=add\0...DEVNAME=/dev/sdc\0...
+const udevMonitorPrefix = "libudev\x00"
+
+// parseUevent returns the properties of a uevent message sent by udev or by the kernel, e.g.
+// add@/devices/.../block/sdc\0ACTION=add\0DEVPATH=/devices/.../block/sdc\0SUBSYSTEM=block\0DEVNAME=sdc\0DEVTYPE=disk
+func parseUevent(msg []byte) map[string]string {
+	if bytes.HasPrefix(msg, []byte(udevMonitorPrefix)) {
+		if len(msg) < 24 {
+			return map[string]string{}
+		}
+		offset, length := binary.NativeEndian.Uint32(msg[16:20]), binary.NativeEndian.Uint32(msg[20:24])
+		if uint64(offset)+uint64(length) > uint64(len(msg)) {
+			return map[string]string{}
+		}
+		msg = msg[offset : offset+length]
+	}
+	event := map[string]string{}
+	for _, field := range strings.Split(string(msg), "\x00") {
+		if key, value, found := strings.Cut(field, "="); found {
+			event[key] = value
+		}
+	}
+	return event
+}
+
+// startDeviceIndex starts indexing the data disks of the node from the block device uevents, the data disks are
+// discovered by rescans if the uevents cannot be watched
+func (d *DriverCore) startDeviceIndex(ctx context.Context) {
+	index := newDeviceIndex(d.ioHandler)
+	if err := watchBlockDeviceEvents(ctx, index.handleEvent); err != nil {
+		klog.Warningf("data disks are discovered by rescans, failed to watch block device uevents: %v", err)
+		return
+	}
+	if err := index.seed(); err != nil {
+		klog.Warningf("failed to index the data disks attached to the node: %v", err)
+	}
+	d.deviceIndex = index
+}
+
+// discoverDeviceWithLUN returns the device path of the data disk at lun found by the device index, or "" after
+// rescanning for the disk. Without device index, all the SCSI hosts are rescanned. With the device index, the index
+// is waited on for up to deviceEventTimeout and only the LUN is rescanned.
+func (d *DriverCore) discoverDeviceWithLUN(lun int) string {
+	if d.deviceIndex == nil {
+		scsiHostRescan(d.ioHandler, d.mounter)
+		return ""
+	}
+	if devicePath, _ := d.deviceIndex.lookup(lun); devicePath != "" {
+		return devicePath
+	}
+	// the uevents are not received when the node driver is not in the host network namespace
+	if devicePath, err := findDiskByLun(lun, d.ioHandler, d.mounter); err == nil && devicePath != "" {
+		return devicePath
+	}
+	if devicePath, ok := d.deviceIndex.wait(lun, deviceEventTimeout); ok {
+		return devicePath
+	}
+	klog.Warningf("data disk at lun %d is not discovered within %v, rescanning lun %d", lun, deviceEventTimeout, lun)
+	if err := rescanDataDiskLUN(d.ioHandler, lun); err != nil {
+		klog.Warningf("failed to rescan lun %d, rescanning all scsi hosts: %v", lun, err)
+		scsiHostRescan(d.ioHandler, d.mounter)
+	}
+	return ""
+}
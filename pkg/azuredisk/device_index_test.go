@@ -0,0 +1,200 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+)
+
+// newFakeSCSISysfs returns the sysfs of a node with the OS disk sda, the resource disk sdb and the data disk sdc at
+// lun 1
+func newFakeSCSISysfs() *azureutils.FakeSysfs {
+	return newFakeSCSISysfsWithLinks(nil)
+}
+
+// newFakeSCSISysfsWithLinks returns the sysfs of newFakeSCSISysfs with the udev links of the devices
+func newFakeSCSISysfsWithLinks(udevLinks map[string]string) *azureutils.FakeSysfs {
+	links := map[string]string{
+		"/sys/class/block/sda/device": "../../../0:0:0:0",
+		"/sys/class/block/sdb/device": "../../../1:0:1:0",
+		"/sys/class/block/sdc/device": "../../../5:0:0:1",
+	}
+	for path, target := range udevLinks {
+		links[path] = target
+	}
+	return azureutils.NewFakeSysfs(map[string]string{
+		"/sys/bus/scsi/devices/0:0:0:0/vendor":     "Msft    \n",
+		"/sys/bus/scsi/devices/0:0:0:0/model":      "Virtual Disk    \n",
+		"/sys/bus/scsi/devices/1:0:1:0/vendor":     "Msft    \n",
+		"/sys/bus/scsi/devices/1:0:1:0/model":      "Virtual Disk    \n",
+		"/sys/bus/scsi/devices/5:0:0:1/vendor":     "Msft    \n",
+		"/sys/bus/scsi/devices/5:0:0:1/model":      "Virtual Disk    \n",
+		"/sys/class/block/sdc/device/wwid":         "t10.MSFT    Virtual Disk    6002248031a1e2d1\n",
+		"/sys/class/block/sdc/device/vpd_pg80":     "\x00\x80\x00\x203F2504E04F8941D39A0C0305E82C3301",
+		"/sys/class/block/sdc/device/delete":       "",
+		"/sys/class/block/sdc/size":                "20971520\n",
+		"/sys/class/block/sdc1/partition":          "1\n",
+		"/sys/class/scsi_host/host0/scan":          "",
+		"/sys/class/scsi_host/host1/scan":          "",
+		"/sys/class/scsi_host/host5/scan":          "",
+		"/sys/bus/scsi/devices/host5/scsi_host":    "",
+		"/sys/bus/scsi/devices/target5:0:0/uevent": "",
+	}, links)
+}
+
+func TestParseUevent(t *testing.T) {
+	msg := []byte("add@/devices/LNXSYSTM:00/VMBUS:00/block/sdc\x00ACTION=add\x00DEVPATH=/devices/LNXSYSTM:00/VMBUS:00/block/sdc\x00SUBSYSTEM=block\x00DEVNAME=sdc\x00DEVTYPE=disk\x00SEQNUM=4211\x00")
+	assert.Equal(t, map[string]string{
+		"ACTION":    "add",
+		"DEVPATH":   "/devices/LNXSYSTM:00/VMBUS:00/block/sdc",
+		"SUBSYSTEM": "block",
+		"DEVNAME":   "sdc",
+		"DEVTYPE":   "disk",
+		"SEQNUM":    "4211",
+	}, parseUevent(msg))
+
+	// a udev message carries the properties after its header
+	properties := "ACTION=add\x00DEVPATH=/devices/LNXSYSTM:00/VMBUS:00/block/sdc\x00SUBSYSTEM=block\x00DEVNAME=/dev/sdc\x00DEVTYPE=disk\x00DEVLINKS=/dev/disk/azure/scsi1/lun1\x00"
+	header := make([]byte, 40)
+	copy(header, udevMonitorPrefix)
+	binary.BigEndian.PutUint32(header[8:12], 0xfeedcafe)
+	binary.NativeEndian.PutUint32(header[12:16], 40)
+	binary.NativeEndian.PutUint32(header[16:20], 40)
+	binary.NativeEndian.PutUint32(header[20:24], uint32(len(properties)))
+	assert.Equal(t, map[string]string{
+		"ACTION":    "add",
+		"DEVPATH":   "/devices/LNXSYSTM:00/VMBUS:00/block/sdc",
+		"SUBSYSTEM": "block",
+		"DEVNAME":   "/dev/sdc",
+		"DEVTYPE":   "disk",
+		"DEVLINKS":  "/dev/disk/azure/scsi1/lun1",
+	}, parseUevent(append(header, properties...)))
+	assert.Empty(t, parseUevent(header[:20]), "truncated udev message")
+	binary.NativeEndian.PutUint32(header[20:24], 1024)
+	assert.Empty(t, parseUevent(header), "properties beyond the udev message")
+}
+
+func TestDeviceIndex(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("device index is only supported on Linux")
+	}
+	index := newDeviceIndex(newFakeSCSISysfs())
+	assert.NoError(t, index.seed())
+	assert.Equal(t, map[int]string{1: "sdc"}, index.devices)
+
+	devicePath, ok := index.wait(1, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "/dev/sdc", devicePath)
+	_, ok = index.wait(2, 10*time.Millisecond)
+	assert.False(t, ok, "lun 2 is not attached")
+
+	index.handleEvent(map[string]string{"ACTION": "remove", "SUBSYSTEM": "block", "DEVTYPE": "disk", "DEVNAME": "sdc"})
+	assert.Empty(t, index.devices)
+
+	// the data disk is added while staging waits on the index
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		index.handleEvent(map[string]string{"ACTION": "add", "SUBSYSTEM": "block", "DEVTYPE": "partition", "DEVNAME": "sdc1"})
+		index.handleEvent(map[string]string{"ACTION": "add", "SUBSYSTEM": "block", "DEVTYPE": "disk", "DEVNAME": "sda"})
+		index.handleEvent(map[string]string{"ACTION": "add", "SUBSYSTEM": "block", "DEVTYPE": "disk", "DEVNAME": "sdc"})
+	}()
+	devicePath, ok = index.wait(1, 5*time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "/dev/sdc", devicePath)
+
+	// the device is replaced without its uevents
+	index.io = azureutils.NewFakeSysfs(nil, nil)
+	_, ok = index.wait(1, 10*time.Millisecond)
+	assert.False(t, ok)
+	assert.Empty(t, index.devices, "stale device is removed from the index")
+}
+
+func TestDeviceIndexUdevLinks(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("device index is only supported on Linux")
+	}
+	index := newDeviceIndex(newFakeSCSISysfsWithLinks(map[string]string{
+		"/dev/disk/azure/scsi1/lun1":       "../../../../sdc",
+		"/dev/disk/azure/scsi1/lun1-part1": "../../../../sdc1",
+	}))
+	assert.NoError(t, index.seed())
+	devicePath, ok := index.wait(1, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "/dev/disk/azure/scsi1/lun1", devicePath, "the udev link of the disk is returned like findDiskByLun does")
+}
+
+func TestNodeStageVolumePartitionWithDeviceIndex(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("device index is only supported on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	d.ioHandler = newFakeSCSISysfsWithLinks(map[string]string{
+		"/dev/disk/azure/scsi1/lun1":       "../../../../sdc",
+		"/dev/disk/azure/scsi1/lun1-part1": "../../../../sdc1",
+	})
+	d.deviceIndex = newDeviceIndex(d.ioHandler)
+	require.NoError(t, d.deviceIndex.seed())
+
+	fakeExec := fakeMounter.Exec.(*mounter.FakeSafeMounter)
+	commands := []string{}
+	for _, out := range []string{"DEVNAME=/dev/sdc1\nTYPE=ext4\n", "", "", ""} {
+		fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) exec.Cmd {
+			commands = append(commands, strings.Join(append([]string{cmd}, args...), " "))
+			fakeCmd := &testingexec.FakeCmd{
+				CombinedOutputScript: []testingexec.FakeAction{func() ([]byte, []byte, error) { return []byte(out), nil, nil }},
+			}
+			return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+		})
+	}
+
+	_, err = d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "vol_1",
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		PublishContext: map[string]string{consts.LUN: "1"},
+		VolumeContext:  map[string]string{consts.VolumeAttributePartition: "1"},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, commands)
+	assert.Contains(t, commands[0], "/dev/disk/azure/scsi1/lun1-part1", "the partition of the indexed disk must be staged")
+}
@@ -27,6 +27,7 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/mount-utils"
 	testingexec "k8s.io/utils/exec/testing"
+	"k8s.io/utils/lru"
 
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	csicommon "sigs.k8s.io/azuredisk-csi-driver/pkg/csi-common"
@@ -79,6 +80,7 @@ type FakeDriver interface {
 	setVersion(version string)
 	getCloud() *azure.Cloud
 	setCloud(*azure.Cloud)
+	setProvisioner(CloudProvisioner)
 	getClientFactory() azclient.ClientFactory
 	getMounter() *mount.SafeFormatAndMount
 	setMounter(*mount.SafeFormatAndMount)
@@ -120,6 +122,8 @@ func newFakeDriverV1(ctrl *gomock.Controller) (*fakeDriverV1, error) {
 	driver.endpoint = "tcp://127.0.0.1:0"
 	driver.disableAVSetNodes = true
 	driver.kubeClient = fake.NewSimpleClientset()
+	driver.publishTracker = newPublishTracker(driver.listPublishedTargets, driver.isTargetMounted)
+	driver.scopedClouds = lru.New(scopedCloudCacheSize)
 
 	driver.cloud = azure.GetTestCloud(ctrl)
 	driver.diskController = NewManagedDiskController(driver.cloud)
@@ -20,11 +20,16 @@ limitations under the License.
 package azuredisk
 
 import (
+	"time"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"go.uber.org/mock/gomock"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/klog/v2"
 	testingexec "k8s.io/utils/exec/testing"
+	"k8s.io/utils/lru"
+	azdiskfake "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/client/clientset/versioned/fake"
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 	csicommon "sigs.k8s.io/azuredisk-csi-driver/pkg/csi-common"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
@@ -69,6 +74,11 @@ func newFakeDriverV2(ctrl *gomock.Controller) (*fakeDriverV2, error) {
 	driver.endpoint = "tcp://127.0.0.1:0"
 	driver.disableAVSetNodes = true
 	driver.kubeClient = fake.NewSimpleClientset()
+	driver.publishTracker = newPublishTracker(driver.listPublishedTargets, driver.isTargetMounted)
+	driver.scopedClouds = lru.New(scopedCloudCacheSize)
+	driver.azDiskClient = azdiskfake.NewSimpleClientset()
+	driver.objectNamespace = consts.DefaultAzureDiskCrdNamespace
+	driver.heartbeatFrequency = 30 * time.Second
 
 	driver.cloud = azure.GetTestCloud(ctrl)
 	driver.diskController = NewManagedDiskController(driver.cloud)
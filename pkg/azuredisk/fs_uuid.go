@@ -0,0 +1,104 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	csicommon "sigs.k8s.io/azuredisk-csi-driver/pkg/csi-common"
+)
+
+const filesystemUUIDRegeneratedReason = "FilesystemUUIDRegenerated"
+
+// fsTypesWithUUIDRegeneration are the filesystems whose UUID is regenerated on volumes created from a snapshot or
+// another volume
+var fsTypesWithUUIDRegeneration = sets.New("xfs", "ext2", "ext3", "ext4")
+
+// getFilesystemUUIDMarkerPath returns the path of the marker recording that the filesystem UUID of the volume was
+// regenerated. The markers are kept in the plugin directory of the node driver next to its unix socket so that they
+// outlive the driver pod, the path is empty if the driver does not listen on a unix socket.
+func (d *DriverCore) getFilesystemUUIDMarkerPath(volumeID string) string {
+	scheme, addr, err := csicommon.ParseEndpoint(d.endpoint)
+	if err != nil || scheme != "unix" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(strings.ToLower(volumeID)))
+	return filepath.Join(filepath.Dir(addr), "fsuuid", hex.EncodeToString(hash[:])[:16])
+}
+
+// regenerateFilesystemUUID gives the filesystem on source of a volume created from a snapshot or another volume a
+// new UUID if a filesystem mounted on the node has the same UUID, xfs refuses to mount two filesystems with the same
+// UUID and the ext tools confuse them. The UUID is regenerated once per volume before the volume is mounted, the
+// regeneration is recorded in a marker and in an event. An ext filesystem that needs more than a safe check before
+// its UUID can be changed is only repaired with the repair fsckPolicy.
+func (d *DriverCore) regenerateFilesystemUUID(volumeID, source, fsckPolicy string, volumeContext map[string]string) error {
+	sourceType := volumeContext[consts.ContentSourceTypeField]
+	if sourceType == "" || runtime.GOOS != "linux" {
+		return nil
+	}
+	markerPath := d.getFilesystemUUIDMarkerPath(volumeID)
+	if markerPath != "" {
+		if _, err := os.Stat(markerPath); err == nil {
+			klog.V(4).Infof("filesystem UUID of volume %s was already regenerated, marker %s", volumeID, markerPath)
+			return nil
+		}
+	}
+
+	fstype, uuid, err := getFilesystemUUID(source, d.mounter)
+	if err != nil {
+		return err
+	}
+	if uuid == "" || !fsTypesWithUUIDRegeneration.Has(fstype) {
+		return nil
+	}
+	mountedUUIDs, err := listMountedFilesystemUUIDs(d.mounter)
+	if err != nil {
+		return err
+	}
+	if !mountedUUIDs.Has(uuid) {
+		return nil
+	}
+
+	klog.V(2).Infof("regenerating UUID %s of %s filesystem of volume %s on %s, a mounted filesystem has the same UUID", uuid, fstype, volumeID, source)
+	if err := regenerateFilesystemUUID(source, fstype, fsckPolicy == consts.FsckPolicyRepair, d.mounter); err != nil {
+		return fmt.Errorf("failed to regenerate UUID %s of %s filesystem of volume %s: %v", uuid, fstype, volumeID, err)
+	}
+	message := fmt.Sprintf("UUID %s of %s filesystem of volume %s created from a %s was regenerated, a filesystem mounted on node %s has the same UUID", uuid, fstype, volumeID, sourceType, d.NodeID)
+	klog.V(2).Info(message)
+	if markerPath != "" {
+		err := os.MkdirAll(filepath.Dir(markerPath), 0750)
+		if err == nil {
+			err = os.WriteFile(markerPath, []byte(message+"\n"), 0640)
+		}
+		if err != nil {
+			klog.Warningf("failed to write marker %s of volume %s: %v", markerPath, volumeID, err)
+		}
+	}
+	d.recordVolumeEvent(volumeContext, v1.EventTypeNormal, filesystemUUIDRegeneratedReason, message)
+	return nil
+}
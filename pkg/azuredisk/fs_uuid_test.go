@@ -0,0 +1,187 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/client-go/tools/record"
+	testingexec "k8s.io/utils/exec/testing"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+)
+
+func TestGetFilesystemUUIDMarkerPath(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	d.endpoint = "unix:///csi/csi.sock"
+	assert.Regexp(t, "^/csi/fsuuid/[0-9a-f]{16}$", d.getFilesystemUUIDMarkerPath("vol-1"))
+	assert.Equal(t, d.getFilesystemUUIDMarkerPath("vol-1"), d.getFilesystemUUIDMarkerPath("VOL-1"))
+	d.endpoint = "tcp://127.0.0.1:10000"
+	assert.Empty(t, d.getFilesystemUUIDMarkerPath("vol-1"))
+}
+
+func TestRegenerateFilesystemUUID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("filesystem UUIDs are only regenerated on Linux")
+	}
+	clonedContext := map[string]string{consts.PvNameKey: "pv-1", consts.ContentSourceTypeField: consts.SourceSnapshot}
+	failed := &testingexec.FakeExitError{Status: 1}
+	xfsBlkid := commandOutput("DEVNAME=/dev/sdc\nUUID=5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\nBLOCK_SIZE=512\nTYPE=xfs\nUSAGE=filesystem\n", nil)
+
+	tests := []struct {
+		desc          string
+		volumeContext map[string]string
+		fsckPolicy    string
+		marker        bool
+		actions       []testingexec.FakeAction
+		expectedEvent bool
+		expectedErr   bool
+	}{
+		{
+			desc:          "volume not created from a snapshot or volume",
+			volumeContext: map[string]string{},
+		},
+		{
+			desc:          "unformatted volume",
+			volumeContext: clonedContext,
+			actions: []testingexec.FakeAction{
+				commandOutput("", &testingexec.FakeExitError{Status: 2}),
+			},
+		},
+		{
+			desc:          "no mounted filesystem with the same UUID",
+			volumeContext: clonedContext,
+			actions: []testingexec.FakeAction{
+				xfsBlkid,
+				commandOutput("\n1b4e28ba-2fa1-11d2-883f-0016d3cca427\n", nil),
+			},
+		},
+		{
+			desc:          "mounted filesystem with the same UUID",
+			volumeContext: clonedContext,
+			actions: []testingexec.FakeAction{
+				xfsBlkid,
+				commandOutput("1b4e28ba-2fa1-11d2-883f-0016d3cca427\n5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\n", nil),
+				commandOutput("Clearing log and setting UUID\nwriting all SBs\n", nil),
+			},
+			expectedEvent: true,
+		},
+		{
+			desc:          "xfs filesystem with a dirty log",
+			volumeContext: clonedContext,
+			actions: []testingexec.FakeAction{
+				xfsBlkid,
+				commandOutput("5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\n", nil),
+				commandOutput("ERROR: The filesystem has valuable metadata changes in a log which needs to be replayed.", failed),
+				commandOutput("Clearing log and setting UUID\nwriting all SBs\n", nil),
+			},
+			expectedEvent: true,
+		},
+		{
+			desc:          "ext4 filesystem with a journal to replay",
+			volumeContext: clonedContext,
+			actions: []testingexec.FakeAction{
+				commandOutput("UUID=5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\nTYPE=ext4\n", nil),
+				commandOutput("5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\n", nil),
+				commandOutput("This operation requires a freshly checked filesystem.", failed),
+				commandOutput("/dev/sdc: recovering journal\n/dev/sdc: 11/65536 files (0.0% non-contiguous)", failed),
+				commandOutput("", nil),
+			},
+			expectedEvent: true,
+		},
+		{
+			desc:          "ext4 filesystem repaired with the repair fsckPolicy",
+			volumeContext: clonedContext,
+			fsckPolicy:    consts.FsckPolicyRepair,
+			actions: []testingexec.FakeAction{
+				commandOutput("UUID=5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\nTYPE=ext4\n", nil),
+				commandOutput("5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\n", nil),
+				commandOutput("This operation requires a freshly checked filesystem.", failed),
+				commandOutput("Inode 12 has an invalid extent\nFix? yes\n", failed),
+				commandOutput("", nil),
+			},
+			expectedEvent: true,
+		},
+		{
+			desc:          "ext4 filesystem that cannot be repaired",
+			volumeContext: clonedContext,
+			actions: []testingexec.FakeAction{
+				commandOutput("UUID=5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\nTYPE=ext4\n", nil),
+				commandOutput("5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21\n", nil),
+				commandOutput("This operation requires a freshly checked filesystem.", failed),
+				commandOutput("UNEXPECTED INCONSISTENCY; RUN fsck MANUALLY.", &testingexec.FakeExitError{Status: 4}),
+			},
+			expectedErr: true,
+		},
+		{
+			desc:          "UUID already regenerated",
+			volumeContext: clonedContext,
+			marker:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cntl := gomock.NewController(t)
+			defer cntl.Finish()
+			d, err := newFakeDriverV1(cntl)
+			require.NoError(t, err)
+			fakeMounter, err := mounter.NewFakeSafeMounter()
+			require.NoError(t, err)
+			d.setMounter(fakeMounter)
+			if len(test.actions) > 0 {
+				d.setNextCommandOutputScripts(test.actions...)
+			}
+			recorder := record.NewFakeRecorder(1)
+			d.eventRecorder = recorder
+			d.endpoint = "unix://" + filepath.Join(t.TempDir(), "csi.sock")
+			markerPath := d.getFilesystemUUIDMarkerPath("vol-1")
+			if test.marker {
+				require.NoError(t, os.MkdirAll(filepath.Dir(markerPath), 0750))
+				require.NoError(t, os.WriteFile(markerPath, nil, 0640))
+			}
+
+			err = d.regenerateFilesystemUUID("vol-1", "/dev/sdc", test.fsckPolicy, test.volumeContext)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if !test.expectedEvent {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.True(t, strings.HasPrefix(<-recorder.Events, "Normal "+filesystemUUIDRegeneratedReason))
+			marker, err := os.ReadFile(markerPath)
+			require.NoError(t, err)
+			assert.Regexp(t, "UUID 5f2a9c3e-0d7b-4c1e-9b1a-3f0e6f7d8a21 of (xfs|ext4) filesystem of volume vol-1", string(marker))
+		})
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/cloud-provider-azure/pkg/metrics"
+)
+
+const (
+	filesystemCheckedReason      = "FilesystemChecked"
+	filesystemCheckFailedReason  = "FilesystemCheckFailed"
+	filesystemRepairedReason     = "FilesystemRepaired"
+	filesystemRepairFailedReason = "FilesystemRepairFailed"
+
+	// maxFsckEventOutputLength is the maximum length of the check tool output included in an event
+	maxFsckEventOutputLength = 512
+)
+
+// filesystemCorruptionSignatures are the lower case messages of a mount failing on a corrupted filesystem, EUCLEAN
+// is returned by xfs as EFSCORRUPTED
+var filesystemCorruptionSignatures = []string{"structure needs cleaning", "corrupt"}
+
+// isFilesystemCorrupted returns whether err of formatAndMount is caused by a corrupted filesystem.
+// mount-utils runs fsck before mounting an ext filesystem, an xfs filesystem is only checked by mounting it, so a
+// failed xfs mount is only taken as corruption when its output carries a corruption signature.
+func isFilesystemCorrupted(err error, fstype string) bool {
+	var mountErr mount.MountError
+	if !errors.As(err, &mountErr) {
+		return false
+	}
+	if mountErr.Type == mount.HasFilesystemErrors {
+		return true
+	}
+	if !strings.EqualFold(fstype, "xfs") || mountErr.Type != mount.UnknownMountError {
+		return false
+	}
+	message := strings.ToLower(mountErr.Message)
+	for _, signature := range filesystemCorruptionSignatures {
+		if strings.Contains(message, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyFsckPolicy checks or repairs the filesystem on source according to policy after it failed to mount, records
+// the outcome and duration in an event and the node metrics, and returns whether the filesystem was repaired and
+// the mount should be retried.
+func (d *DriverCore) applyFsckPolicy(policy, volumeID, source, fstype string, volumeContext map[string]string) bool {
+	if policy != consts.FsckPolicyCheck && policy != consts.FsckPolicyRepair {
+		return false
+	}
+	repair := policy == consts.FsckPolicyRepair
+
+	klog.V(2).Infof("running filesystem %s of volume %s on %s(%s)", policy, volumeID, source, fstype)
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "node_fsck_"+policy, "", "", d.Name)
+	start := time.Now()
+	output, err := checkFilesystem(source, fstype, repair, d.mounter)
+	duration := time.Since(start).Round(time.Millisecond)
+	mc.ObserveOperationWithResult(err == nil, consts.VolumeID, volumeID, "fstype", fstype)
+
+	eventType, reason, message := v1.EventTypeNormal, filesystemCheckedReason, fmt.Sprintf("filesystem check of volume %s found no errors in %v", volumeID, duration)
+	switch {
+	case repair && err == nil:
+		reason, message = filesystemRepairedReason, fmt.Sprintf("filesystem of volume %s repaired in %v", volumeID, duration)
+	case repair:
+		eventType, reason, message = v1.EventTypeWarning, filesystemRepairFailedReason, fmt.Sprintf("filesystem repair of volume %s failed after %v: %v", volumeID, duration, err)
+	case err != nil:
+		eventType, reason, message = v1.EventTypeWarning, filesystemCheckFailedReason, fmt.Sprintf("filesystem check of volume %s found errors in %v, set %s to %s to repair it: %v",
+			volumeID, duration, consts.FsckPolicyField, consts.FsckPolicyRepair, err)
+	}
+	if err != nil {
+		klog.Errorf("%s, output: %s", message, output)
+		if output = strings.TrimSpace(output); len(output) > maxFsckEventOutputLength {
+			output = "..." + output[len(output)-maxFsckEventOutputLength:]
+		}
+		if output != "" {
+			message = fmt.Sprintf("%s, output: %s", message, output)
+		}
+	} else {
+		klog.V(2).Info(message)
+	}
+	d.recordVolumeEvent(volumeContext, eventType, reason, message)
+	return repair && err == nil
+}
+
+// recordVolumeEvent records an event on the persistent volume of volumeContext, or on the node if the volume context
+// does not carry the persistent volume name. It is a no-op without an event recorder.
+func (d *DriverCore) recordVolumeEvent(volumeContext map[string]string, eventType, reason, message string) {
+	if d.eventRecorder == nil {
+		return
+	}
+	// the kubelet records node events with the node name as UID
+	ref := &v1.ObjectReference{APIVersion: "v1", Kind: "Node", Name: d.NodeID, UID: types.UID(d.NodeID)}
+	if pvName := volumeContext[consts.PvNameKey]; pvName != "" {
+		ref = &v1.ObjectReference{APIVersion: "v1", Kind: "PersistentVolume", Name: pvName}
+	}
+	d.eventRecorder.Event(ref, eventType, reason, message)
+}
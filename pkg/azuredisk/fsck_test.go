@@ -0,0 +1,152 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/client-go/tools/record"
+	mount "k8s.io/mount-utils"
+	testingexec "k8s.io/utils/exec/testing"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+)
+
+func TestIsFilesystemCorrupted(t *testing.T) {
+	tests := []struct {
+		err      error
+		fstype   string
+		expected bool
+	}{
+		{fmt.Errorf("error"), "ext4", false},
+		{mount.NewMountError(mount.HasFilesystemErrors, "error"), "ext4", true},
+		{mount.NewMountError(mount.UnknownMountError, "error"), "ext4", false},
+		{mount.NewMountError(mount.UnknownMountError, "error"), "xfs", false},
+		{mount.NewMountError(mount.UnknownMountError, "mount failed: exit status 32\nOutput: mount: /mnt: wrong fs type, bad option, bad superblock on /dev/sdc, missing codepage or helper program, or other error."), "xfs", false},
+		{mount.NewMountError(mount.UnknownMountError, "mount failed: exit status 32\nOutput: mount: /mnt: mount(2) system call failed: Structure needs cleaning."), "xfs", true},
+		{mount.NewMountError(mount.UnknownMountError, "mount failed: exit status 32\nOutput: XFS (sdc): Metadata corruption detected"), "xfs", true},
+		{mount.NewMountError(mount.UnknownMountError, "Structure needs cleaning"), "ext4", false},
+		{mount.NewMountError(mount.FilesystemMismatch, "error"), "xfs", false},
+		{fmt.Errorf("wrapped: %w", mount.NewMountError(mount.HasFilesystemErrors, "error")), "ext4", true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isFilesystemCorrupted(test.err, test.fstype), "%v(%s)", test.err, test.fstype)
+	}
+}
+
+func TestApplyFsckPolicy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("filesystem check is only supported on Linux")
+	}
+	succeeded := func() ([]byte, []byte, error) { return []byte("clean"), nil, nil }
+	corrected := func() ([]byte, []byte, error) {
+		return []byte("errors corrected"), nil, &testingexec.FakeExitError{Status: 1}
+	}
+	uncorrected := func() ([]byte, []byte, error) {
+		return []byte("errors found"), nil, &testingexec.FakeExitError{Status: 4}
+	}
+
+	tests := []struct {
+		desc          string
+		policy        string
+		fstype        string
+		action        testingexec.FakeAction
+		expectedRetry bool
+		expectedEvent string
+	}{
+		{
+			desc:   "none",
+			policy: consts.FsckPolicyNone,
+			fstype: "ext4",
+		},
+		{
+			desc:          "check clean",
+			policy:        consts.FsckPolicyCheck,
+			fstype:        "ext4",
+			action:        succeeded,
+			expectedEvent: "Normal " + filesystemCheckedReason,
+		},
+		{
+			desc:          "check found errors",
+			policy:        consts.FsckPolicyCheck,
+			fstype:        "xfs",
+			action:        uncorrected,
+			expectedEvent: "Warning " + filesystemCheckFailedReason,
+		},
+		{
+			desc:          "repair corrected errors",
+			policy:        consts.FsckPolicyRepair,
+			fstype:        "ext4",
+			action:        corrected,
+			expectedRetry: true,
+			expectedEvent: "Normal " + filesystemRepairedReason,
+		},
+		{
+			desc:          "repair failed",
+			policy:        consts.FsckPolicyRepair,
+			fstype:        "ext4",
+			action:        uncorrected,
+			expectedEvent: "Warning " + filesystemRepairFailedReason,
+		},
+		{
+			desc:          "xfs repair exits with an error",
+			policy:        consts.FsckPolicyRepair,
+			fstype:        "xfs",
+			action:        corrected,
+			expectedEvent: "Warning " + filesystemRepairFailedReason,
+		},
+		{
+			desc:          "unsupported fstype",
+			policy:        consts.FsckPolicyRepair,
+			fstype:        "btrfs",
+			expectedEvent: "Warning " + filesystemRepairFailedReason,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			cntl := gomock.NewController(t)
+			defer cntl.Finish()
+			d, err := newFakeDriverV1(cntl)
+			require.NoError(t, err)
+			fakeMounter, err := mounter.NewFakeSafeMounter()
+			require.NoError(t, err)
+			d.setMounter(fakeMounter)
+			if test.action != nil {
+				d.setNextCommandOutputScripts(test.action)
+			}
+			recorder := record.NewFakeRecorder(1)
+			d.eventRecorder = recorder
+
+			retry := d.applyFsckPolicy(test.policy, "vol_1", "/dev/sdc", test.fstype, map[string]string{consts.PvNameKey: "pv"})
+			assert.Equal(t, test.expectedRetry, retry)
+			if test.expectedEvent == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.True(t, strings.HasPrefix(<-recorder.Events, test.expectedEvent))
+		})
+	}
+}
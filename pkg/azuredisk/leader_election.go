@@ -0,0 +1,85 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	leaderElectionLeaseDuration = 15 * time.Second
+	leaderElectionRenewDeadline = 10 * time.Second
+	leaderElectionRetryPeriod   = 2 * time.Second
+)
+
+// newLeaderElectionIdentity returns the identity of this process in the leases of the controller plug-in. The
+// hostname is kept by the restarts of a pod, so a random suffix tells the processes of the same pod apart.
+func newLeaderElectionIdentity() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return hostname + "_" + string(uuid.NewUUID()), nil
+}
+
+// runLeaderElected runs run once this process acquires the lease name in namespace, so that a single replica of the
+// controller plug-in runs it at a time. run is not restarted, the process exits once it loses the lease before ctx is done.
+func runLeaderElected(ctx context.Context, kubeClient kubernetes.Interface, namespace, name string, run func(context.Context)) {
+	identity, err := newLeaderElectionIdentity()
+	if err != nil {
+		klog.Fatalf("failed to get leader election identity: %v", err)
+	}
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		namespace,
+		name,
+		kubeClient.CoreV1(),
+		kubeClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: identity},
+	)
+	if err != nil {
+		klog.Fatalf("failed to create lease %s/%s: %v", namespace, name, err)
+	}
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaderElectionLeaseDuration,
+		RenewDeadline:   leaderElectionRenewDeadline,
+		RetryPeriod:     leaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.V(2).Infof("acquired lease %s/%s as %s", namespace, name, identity)
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() == nil {
+					klog.Fatalf("lost lease %s/%s", namespace, name)
+				}
+				klog.V(2).Infof("released lease %s/%s", namespace, name)
+			},
+		},
+	})
+}
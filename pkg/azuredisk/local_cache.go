@@ -0,0 +1,157 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	basemetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/cloud-provider-azure/pkg/metrics"
+)
+
+const localCacheUnavailableReason = "LocalCacheUnavailable"
+
+var (
+	localCacheHits = basemetrics.NewGaugeVec(
+		&basemetrics.GaugeOpts{
+			Subsystem:      consts.AzureDiskCSIDriverName,
+			Name:           "local_cache_hits",
+			Help:           "Hits of the local cache of staged volumes since the cache was built by volume and operation.",
+			StabilityLevel: basemetrics.ALPHA,
+		},
+		[]string{"volume", "operation"},
+	)
+	localCacheMisses = basemetrics.NewGaugeVec(
+		&basemetrics.GaugeOpts{
+			Subsystem:      consts.AzureDiskCSIDriverName,
+			Name:           "local_cache_misses",
+			Help:           "Misses of the local cache of staged volumes since the cache was built by volume and operation.",
+			StabilityLevel: basemetrics.ALPHA,
+		},
+		[]string{"volume", "operation"},
+	)
+
+	// localCacheLock serializes the changes of the local cache volume group shared by all volumes
+	localCacheLock sync.Mutex
+)
+
+func init() {
+	legacyregistry.MustRegister(localCacheHits, localCacheMisses)
+}
+
+// localCacheStats are the hits and misses counted by the local cache of a volume
+type localCacheStats struct {
+	readHits    int64
+	readMisses  int64
+	writeHits   int64
+	writeMisses int64
+}
+
+// getLocalCacheName returns the name of the device mapper device of the local cache of a volume
+func getLocalCacheName(volumeID string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(volumeID)))
+	return "azdisk-cache-" + hex.EncodeToString(hash[:])[:16]
+}
+
+// isLocalCacheDevice returns whether devicePath is the local cache device of the volume
+func isLocalCacheDevice(volumeID, devicePath string) bool {
+	return devicePath == filepath.Join("/dev/mapper", getLocalCacheName(volumeID))
+}
+
+// setupLocalCache layers the local cache requested by the localCache parameter of the volume over source and returns
+// the path of the cached device, or source if no local cache is requested. A volume is staged without cache if the
+// node driver has no local cache device.
+func (d *DriverCore) setupLocalCache(volumeID, source string, volumeContext map[string]string) (string, error) {
+	cacheType, sizeBytes, err := azureutils.GetLocalCache(volumeContext)
+	if err != nil {
+		return "", err
+	}
+	if cacheType == consts.LocalCacheNone {
+		return source, nil
+	}
+	if d.localCacheDevice == "" {
+		msg := fmt.Sprintf("volume %s is staged without %s %s since the node driver of %s has no local cache device", volumeID, consts.LocalCacheField, cacheType, d.NodeID)
+		klog.Warning(msg)
+		d.recordVolumeEvent(volumeContext, v1.EventTypeWarning, localCacheUnavailableReason, msg)
+		return source, nil
+	}
+
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "node_setup_local_cache", "", "", d.Name)
+	localCacheLock.Lock()
+	defer localCacheLock.Unlock()
+	name := getLocalCacheName(volumeID)
+	klog.V(2).Infof("setting up %s %s of %d bytes on %s for volume %s on %s", cacheType, name, sizeBytes, d.localCacheDevice, volumeID, source)
+	cachePath, err := setupLocalCache(d.localCacheDevice, name, source, sizeBytes, d.mounter)
+	mc.ObserveOperationWithResult(err == nil, consts.VolumeID, volumeID)
+	if err != nil {
+		return "", fmt.Errorf("failed to set up %s %s for volume %s: %v", cacheType, name, volumeID, err)
+	}
+	return cachePath, nil
+}
+
+// teardownLocalCache removes the local cache of the volume, it is a no-op if the volume has no local cache
+func (d *DriverCore) teardownLocalCache(volumeID string) error {
+	if d.localCacheDevice == "" {
+		return nil
+	}
+	localCacheLock.Lock()
+	defer localCacheLock.Unlock()
+	name := getLocalCacheName(volumeID)
+	if err := teardownLocalCache(name, d.mounter); err != nil {
+		return fmt.Errorf("failed to tear down local cache %s of volume %s: %v", name, volumeID, err)
+	}
+	for _, operation := range []string{"read", "write"} {
+		localCacheHits.DeleteLabelValues(volumeID, operation)
+		localCacheMisses.DeleteLabelValues(volumeID, operation)
+	}
+	return nil
+}
+
+// resizeLocalCache grows the local cache device of the volume to the size of the device it caches
+func (d *DriverCore) resizeLocalCache(volumeID string) error {
+	localCacheLock.Lock()
+	defer localCacheLock.Unlock()
+	return resizeLocalCache(getLocalCacheName(volumeID), d.mounter)
+}
+
+// updateLocalCacheMetrics records the hits and misses of the local cache of the volume, it is a no-op if the volume
+// has no local cache
+func (d *DriverCore) updateLocalCacheMetrics(volumeID string) {
+	if d.localCacheDevice == "" {
+		return
+	}
+	stats, err := getLocalCacheStats(getLocalCacheName(volumeID), d.mounter)
+	if err != nil {
+		klog.V(6).Infof("no local cache stats for volume %s: %v", volumeID, err)
+		return
+	}
+	localCacheHits.WithLabelValues(volumeID, "read").Set(float64(stats.readHits))
+	localCacheMisses.WithLabelValues(volumeID, "read").Set(float64(stats.readMisses))
+	localCacheHits.WithLabelValues(volumeID, "write").Set(float64(stats.writeHits))
+	localCacheMisses.WithLabelValues(volumeID, "write").Set(float64(stats.writeMisses))
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+)
+
+func commandOutput(out string, err error) testingexec.FakeAction {
+	return func() ([]byte, []byte, error) { return []byte(out), nil, err }
+}
+
+func TestIsLocalCacheDevice(t *testing.T) {
+	name := getLocalCacheName("vol-1")
+	assert.Regexp(t, "^azdisk-cache-[0-9a-f]{16}$", name)
+	assert.True(t, isLocalCacheDevice("vol-1", "/dev/mapper/"+name))
+	assert.False(t, isLocalCacheDevice("vol-2", "/dev/mapper/"+name))
+	assert.False(t, isLocalCacheDevice("vol-1", "/dev/sdc"))
+}
+
+func TestSetupLocalCache(t *testing.T) {
+	cacheContext := map[string]string{consts.PvNameKey: "pv-1", "localCache": "dm-cache", "localCacheSize": "1Gi"}
+	failed := &testingexec.FakeExitError{Status: 1}
+
+	tests := []struct {
+		desc          string
+		cacheDevice   string
+		volumeContext map[string]string
+		actions       []testingexec.FakeAction
+		expected      string
+		expectedEvent string
+		expectedErr   bool
+		linuxOnly     bool
+	}{
+		{
+			desc:          "no local cache",
+			cacheDevice:   "/dev/nvme0n1",
+			volumeContext: map[string]string{},
+			expected:      "/dev/sdc",
+		},
+		{
+			desc:          "invalid local cache",
+			cacheDevice:   "/dev/nvme0n1",
+			volumeContext: map[string]string{"localCache": "dm-cache", "localCacheSize": "1Gi", "localCacheMode": "writeback"},
+			expectedErr:   true,
+		},
+		{
+			desc:          "no local cache device",
+			volumeContext: cacheContext,
+			expected:      "/dev/sdc",
+			expectedEvent: "Warning " + localCacheUnavailableReason,
+		},
+		{
+			desc:          "new local cache",
+			cacheDevice:   "/dev/nvme0n1",
+			volumeContext: cacheContext,
+			actions: []testingexec.FakeAction{
+				commandOutput("Device does not exist.", failed), // dmsetup table
+				commandOutput("Volume group \"azdisk-cache\" not found", failed),
+				commandOutput("", nil), // blkid
+				commandOutput("", nil), // pvcreate
+				commandOutput("", nil), // vgcreate
+				commandOutput("Failed to find logical volume", failed),
+				commandOutput("Failed to find logical volume", failed),
+				commandOutput("", nil), // lvcreate meta
+				commandOutput("", nil), // lvcreate data
+				commandOutput("2097152\n", nil),
+				commandOutput("", nil), // dmsetup create
+			},
+			expected:  "/dev/mapper/" + getLocalCacheName("vol-1"),
+			linuxOnly: true,
+		},
+		{
+			desc:          "existing local cache",
+			cacheDevice:   "/dev/nvme0n1",
+			volumeContext: cacheContext,
+			actions: []testingexec.FakeAction{
+				commandOutput("0 2097152 cache 253:1 253:0 8:32 512 1 writethrough default 0\n", nil),
+				commandOutput("  8:32\n", nil), // lsblk
+			},
+			expected:  "/dev/mapper/" + getLocalCacheName("vol-1"),
+			linuxOnly: true,
+		},
+		{
+			desc:          "existing local cache of another device",
+			cacheDevice:   "/dev/nvme0n1",
+			volumeContext: cacheContext,
+			actions: []testingexec.FakeAction{
+				commandOutput("0 2097152 cache 253:1 253:0 8:48 512 1 writethrough default 0\n", nil),
+				commandOutput("  8:32\n", nil), // lsblk
+				commandOutput("", nil),         // dmsetup remove
+				commandOutput("", nil),         // vgs
+				commandOutput("", nil),         // lvremove meta
+				commandOutput("", nil),         // lvremove data
+				commandOutput("", nil),         // vgs
+				commandOutput("", nil),         // lvremove meta
+				commandOutput("", nil),         // lvremove data
+				commandOutput("", nil),         // lvcreate meta
+				commandOutput("", nil),         // lvcreate data
+				commandOutput("2097152\n", nil),
+				commandOutput("", nil), // dmsetup create
+			},
+			expected:  "/dev/mapper/" + getLocalCacheName("vol-1"),
+			linuxOnly: true,
+		},
+		{
+			desc:          "existing local cache with unparsable table",
+			cacheDevice:   "/dev/nvme0n1",
+			volumeContext: cacheContext,
+			actions: []testingexec.FakeAction{
+				commandOutput("0 2097152 linear 8:32 0\n", nil),
+			},
+			expectedErr: true,
+			linuxOnly:   true,
+		},
+		{
+			desc:          "formatted local cache device",
+			cacheDevice:   "/dev/nvme0n1",
+			volumeContext: cacheContext,
+			actions: []testingexec.FakeAction{
+				commandOutput("Device does not exist.", failed),
+				commandOutput("Volume group \"azdisk-cache\" not found", failed),
+				commandOutput("DEVNAME=/dev/nvme0n1\nTYPE=ext4\n", nil),
+			},
+			expectedErr: true,
+			linuxOnly:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if test.linuxOnly && runtime.GOOS != "linux" {
+				t.Skip("local cache is only supported on Linux")
+			}
+			cntl := gomock.NewController(t)
+			defer cntl.Finish()
+			d, err := newFakeDriverV1(cntl)
+			require.NoError(t, err)
+			fakeMounter, err := mounter.NewFakeSafeMounter()
+			require.NoError(t, err)
+			d.setMounter(fakeMounter)
+			if len(test.actions) > 0 {
+				d.setNextCommandOutputScripts(test.actions...)
+			}
+			recorder := record.NewFakeRecorder(1)
+			d.eventRecorder = recorder
+			d.localCacheDevice = test.cacheDevice
+
+			source, err := d.setupLocalCache("vol-1", "/dev/sdc", test.volumeContext)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, source)
+			if test.expectedEvent == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.True(t, strings.HasPrefix(<-recorder.Events, test.expectedEvent))
+		})
+	}
+}
+
+func TestTeardownLocalCache(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("local cache is only supported on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+
+	assert.NoError(t, d.teardownLocalCache("vol-1"), "teardown is a no-op without local cache device")
+
+	d.localCacheDevice = "/dev/nvme0n1"
+	failed := &testingexec.FakeExitError{Status: 1}
+	d.setNextCommandOutputScripts(
+		commandOutput("", nil), // dmsetup remove
+		commandOutput("", nil), // vgs
+		commandOutput("", nil), // lvremove meta
+		commandOutput("  Failed to find logical volume", failed),
+		commandOutput("device-mapper: remove ioctl failed: No such device or address", failed),
+		commandOutput("Volume group \"azdisk-cache\" not found", failed),
+		commandOutput("Device or resource busy", failed),
+	)
+	assert.NoError(t, d.teardownLocalCache("vol-1"))
+	assert.NoError(t, d.teardownLocalCache("vol-1"), "teardown of a missing local cache succeeds")
+	assert.Error(t, d.teardownLocalCache("vol-1"))
+}
+
+func TestUpdateLocalCacheMetrics(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("local cache is only supported on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	d.localCacheDevice = "/dev/nvme0n1"
+	d.setNextCommandOutputScripts(
+		commandOutput("0 2097152 cache 8 27/2048 512 10/4096 1024 512 256 128 0 0 0 1 writethrough 2 migration_threshold 2048 smq 0 rw -", nil),
+		commandOutput("0 2097152 linear", nil),
+	)
+
+	d.updateLocalCacheMetrics("vol-metrics")
+	value, err := testutil.GetGaugeMetricValue(localCacheHits.WithLabelValues("vol-metrics", "read"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(1024), value)
+	value, err = testutil.GetGaugeMetricValue(localCacheMisses.WithLabelValues("vol-metrics", "write"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(128), value)
+
+	_, err = getLocalCacheStats("azdisk-cache-1", d.mounter)
+	assert.Error(t, err)
+}
+
+func TestResizeLocalCache(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("local cache is only supported on Linux")
+	}
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	var reloadArgs []string
+	fakeExec := fakeMounter.Exec.(*mounter.FakeSafeMounter)
+	fakeExec.SetNextCommandOutputScripts(
+		commandOutput("0 2097152 cache 253:1 253:0 8:32 512 1 writethrough default 0\n", nil),
+		commandOutput("4194304\n", nil),
+		commandOutput("", nil), // suspend
+		commandOutput("", nil), // reload
+		commandOutput("", nil), // resume
+		commandOutput("0 2097152 cache 253:1 253:0 8:32 512 1 writethrough default 0\n", nil),
+		commandOutput("2097152\n", nil),
+	)
+	for i := range fakeExec.CommandScript {
+		cmd := fakeExec.CommandScript[i]
+		fakeExec.CommandScript[i] = func(name string, args ...string) exec.Cmd {
+			if len(args) > 0 && args[0] == "reload" {
+				reloadArgs = args
+			}
+			return cmd(name, args...)
+		}
+	}
+
+	require.NoError(t, resizeLocalCache("azdisk-cache-1", fakeMounter))
+	assert.Equal(t, []string{"reload", "azdisk-cache-1", "--table", "0 4194304 cache 253:1 253:0 8:32 512 1 writethrough default 0"}, reloadArgs)
+	assert.NoError(t, resizeLocalCache("azdisk-cache-1", fakeMounter), "a local cache of the size of its device is not reloaded")
+}
@@ -0,0 +1,456 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	basemetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+const (
+	staleMountCleanedReason      = "StaleMountCleaned"
+	corruptedMountRepairedReason = "CorruptedMountRepaired"
+	mountReconcileFailedReason   = "MountReconcileFailed"
+
+	mountReconcileCleanup = "cleanup"
+	mountReconcileRepair  = "repair"
+)
+
+// isCorruptedMountPoint is replaced in unit tests since corrupted mount points cannot be faked
+var isCorruptedMountPoint = azureutils.IsCorruptedDir
+
+var mountReconcilerActions = basemetrics.NewCounterVec(
+	&basemetrics.CounterOpts{
+		Subsystem:      consts.AzureDiskCSIDriverName,
+		Name:           "mount_reconciler_actions_total",
+		Help:           "Mount points of the node cleaned up for volumes not attached to the node or repaired for attached volumes by action and result.",
+		StabilityLevel: basemetrics.ALPHA,
+	},
+	[]string{"action", "result"},
+)
+
+func init() {
+	legacyregistry.MustRegister(mountReconcilerActions)
+}
+
+// volumeData is the vol_data.json file kubelet writes next to the staging and target paths of a CSI volume
+type volumeData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+	// SpecVolID is the persistent volume name, it is only written for target paths
+	SpecVolID string `json:"specVolID"`
+}
+
+// mountedVolume is a volume of the driver with a staging or target path on the node
+type mountedVolume struct {
+	volumeID    string
+	pvName      string
+	stagingPath string
+	targetPaths []string
+}
+
+// readVolumeData reads the vol_data.json file of kubelet in dir
+func readVolumeData(dir string) (*volumeData, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "vol_data.json"))
+	if err != nil {
+		return nil, err
+	}
+	data := &volumeData{}
+	if err := json.Unmarshal(content, data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filepath.Join(dir, "vol_data.json"), err)
+	}
+	return data, nil
+}
+
+// listMountedVolumes returns the volumes of the driver with a staging or target path under the kubelet root
+// directory, sorted by volume ID
+func (d *DriverCore) listMountedVolumes() []*mountedVolume {
+	volumes := map[string]*mountedVolume{}
+	scan := func(pattern string) map[string]*volumeData {
+		paths, _ := filepath.Glob(filepath.Join(d.kubeletRootDir, pattern))
+		found := map[string]*volumeData{}
+		for _, path := range paths {
+			data, err := readVolumeData(filepath.Dir(path))
+			if err != nil {
+				klog.V(4).Infof("mount reconciler: skipping %s: %v", path, err)
+				continue
+			}
+			if data.DriverName != d.Name || data.VolumeHandle == "" {
+				continue
+			}
+			if _, ok := volumes[data.VolumeHandle]; !ok {
+				volumes[data.VolumeHandle] = &mountedVolume{volumeID: data.VolumeHandle}
+			}
+			found[path] = data
+		}
+		return found
+	}
+
+	// the staging paths are <kubelet>/plugins/kubernetes.io/csi/<driver>/<sha256 of volume handle>/globalmount, or
+	// <kubelet>/plugins/kubernetes.io/csi/pv/<pv name>/globalmount before kubernetes 1.24
+	for _, pattern := range []string{
+		filepath.Join("plugins", "kubernetes.io", "csi", d.Name, "*", "globalmount"),
+		filepath.Join("plugins", "kubernetes.io", "csi", "pv", "*", "globalmount"),
+	} {
+		for path, data := range scan(pattern) {
+			volumes[data.VolumeHandle].stagingPath = path
+		}
+	}
+	for path, data := range scan(filepath.Join("pods", "*", "volumes", "kubernetes.io~csi", "*", "mount")) {
+		v := volumes[data.VolumeHandle]
+		v.targetPaths = append(v.targetPaths, path)
+		if data.SpecVolID != "" {
+			v.pvName = data.SpecVolID
+		}
+	}
+
+	result := make([]*mountedVolume, 0, len(volumes))
+	for _, v := range volumes {
+		sort.Strings(v.targetPaths)
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].volumeID < result[j].volumeID })
+	return result
+}
+
+// attachedVolumes are the volumes of the driver with a VolumeAttachment on the node
+type attachedVolumes struct {
+	// metadata is the attachment metadata of the volumes by lower case volume handle, it is nil until the volume is
+	// attached
+	metadata map[string]map[string]string
+	// unresolvedPVs are the persistent volumes of the attachments whose volume handle could not be resolved, "" for
+	// an inline volume
+	unresolvedPVs map[string]bool
+}
+
+// isUnresolved returns whether v may be the volume of an attachment whose volume handle could not be resolved. Only
+// the target paths record the persistent volume name of a volume, a volume without one may be any of them.
+func (a *attachedVolumes) isUnresolved(v *mountedVolume) bool {
+	if len(a.unresolvedPVs) == 0 {
+		return false
+	}
+	return v.pvName == "" || a.unresolvedPVs[v.pvName] || a.unresolvedPVs[""]
+}
+
+// getVolumeHandle returns the volume handle of a volume of the driver, or of an in-tree azure disk volume migrated
+// to the driver, or "" if it is neither
+func (d *DriverCore) getVolumeHandle(source *v1.PersistentVolumeSource) string {
+	switch {
+	case source.CSI != nil && source.CSI.Driver == d.Name:
+		return source.CSI.VolumeHandle
+	case source.AzureDisk != nil:
+		return source.AzureDisk.DataDiskURI
+	}
+	return ""
+}
+
+// getAttachedVolumes returns the volumes of the driver with a VolumeAttachment on the node. The persistent volumes
+// are only read for the attachments of the node.
+func (d *DriverCore) getAttachedVolumes(ctx context.Context, attachmentLister storagelisters.VolumeAttachmentLister) (*attachedVolumes, error) {
+	attachments, err := attachmentLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volume attachments: %v", err)
+	}
+
+	attached := &attachedVolumes{metadata: map[string]map[string]string{}, unresolvedPVs: map[string]bool{}}
+	for _, attachment := range attachments {
+		if attachment.Spec.Attacher != d.Name || attachment.Spec.NodeName != d.NodeID {
+			continue
+		}
+		volumeHandle, pvName := "", ""
+		if attachment.Spec.Source.PersistentVolumeName != nil {
+			pvName = *attachment.Spec.Source.PersistentVolumeName
+			pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+			if err != nil {
+				klog.Warningf("mount reconciler: failed to get persistent volume %s of volume attachment %s: %v", pvName, attachment.Name, err)
+			} else {
+				volumeHandle = d.getVolumeHandle(&pv.Spec.PersistentVolumeSource)
+			}
+		} else if spec := attachment.Spec.Source.InlineVolumeSpec; spec != nil {
+			volumeHandle = d.getVolumeHandle(&spec.PersistentVolumeSource)
+		}
+		if volumeHandle == "" {
+			klog.Warningf("mount reconciler: volume of volume attachment %s is not resolved, its volume is not cleaned up", attachment.Name)
+			attached.unresolvedPVs[pvName] = true
+			continue
+		}
+		var metadata map[string]string
+		if attachment.Status.Attached {
+			metadata = attachment.Status.AttachmentMetadata
+		}
+		attached.metadata[strings.ToLower(volumeHandle)] = metadata
+	}
+	return attached, nil
+}
+
+// runMountReconciler reconciles the mount points of the node every mountReconcileInterval until ctx is done
+func (d *DriverCore) runMountReconciler(ctx context.Context, volumeLocks *volumehelper.VolumeLocks) {
+	// the volume attachments cannot be selected by node, they are watched instead of being listed on every pass
+	informerFactory := informers.NewSharedInformerFactory(d.kubeClient, 0)
+	attachmentInformer := informerFactory.Storage().V1().VolumeAttachments()
+	attachmentLister := attachmentInformer.Lister()
+	informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), attachmentInformer.Informer().HasSynced) {
+		klog.Errorf("mount reconciler: failed to sync cache of volume attachments")
+		return
+	}
+
+	klog.V(2).Infof("reconciling the mount points under %s every %v", d.kubeletRootDir, d.mountReconcileInterval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		d.reconcileMounts(ctx, volumeLocks, attachmentLister)
+	}, d.mountReconcileInterval)
+}
+
+// getVolumeAttachmentName returns the name of the VolumeAttachment of a volume of the driver on the node, it is named
+// by kubelet after the sha256 of the volume handle, the attacher and the node
+func (d *DriverCore) getVolumeAttachmentName(volumeID string) string {
+	return fmt.Sprintf("csi-%x", sha256.Sum256([]byte(volumeID+d.Name+d.NodeID)))
+}
+
+// isVolumeAttachmentAbsent returns whether the VolumeAttachment of the volume on the node is not found on the API
+// server. The cache of the volume attachments may lag behind a volume attached and staged since the cache was read.
+func (d *DriverCore) isVolumeAttachmentAbsent(ctx context.Context, volumeID string) bool {
+	name := d.getVolumeAttachmentName(volumeID)
+	_, err := d.kubeClient.StorageV1().VolumeAttachments().Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		klog.V(4).Infof("mount reconciler: volume attachment %s of volume %s is not in the cache yet", name, volumeID)
+		return false
+	}
+	if !apierrors.IsNotFound(err) {
+		klog.Warningf("mount reconciler: failed to get volume attachment %s of volume %s: %v", name, volumeID, err)
+		return false
+	}
+	return true
+}
+
+// reconcileMounts cleans up the staging and target paths of the volumes that are not attached to the node and
+// repairs the corrupted staging and target paths of the attached volumes. A volume is skipped while a node
+// operation holds its lock, or if it may be the volume of an attachment of the node that could not be resolved. A
+// volume without attachment in the cache is only cleaned up once its attachment is not found on the API server.
+func (d *DriverCore) reconcileMounts(ctx context.Context, volumeLocks *volumehelper.VolumeLocks, attachmentLister storagelisters.VolumeAttachmentLister) {
+	volumes := d.listMountedVolumes()
+	if len(volumes) == 0 {
+		return
+	}
+	attached, err := d.getAttachedVolumes(ctx, attachmentLister)
+	if err != nil {
+		klog.Errorf("mount reconciler: %v", err)
+		return
+	}
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		klog.Errorf("mount reconciler: failed to list mount points: %v", err)
+		return
+	}
+
+	for _, v := range volumes {
+		metadata, isAttached := attached.metadata[strings.ToLower(v.volumeID)]
+		if !isAttached && attached.isUnresolved(v) {
+			klog.V(4).Infof("mount reconciler: skipping volume %s that may be attached to the node", v.volumeID)
+			continue
+		}
+		if acquired := volumeLocks.TryAcquire(v.volumeID); !acquired {
+			klog.V(4).Infof("mount reconciler: skipping volume %s with an operation in progress", v.volumeID)
+			continue
+		}
+		if isAttached {
+			d.repairCorruptedMounts(v, metadata, mountPoints)
+		} else if d.isVolumeAttachmentAbsent(ctx, v.volumeID) {
+			d.cleanupStaleMounts(v, mountPoints)
+		}
+		volumeLocks.Release(v.volumeID)
+	}
+}
+
+// cleanupStaleMounts unmounts and removes the target and staging paths of a volume that is not attached to the node,
+// the paths that are not mounted are left to kubelet
+func (d *DriverCore) cleanupStaleMounts(v *mountedVolume, mountPoints []mount.MountPoint) {
+	for _, target := range v.targetPaths {
+		if !isMountedPath(mountPoints, target) && !isCorruptedMountPoint(target) {
+			continue
+		}
+		err := CleanupMountPoint(target, d.mounter, true /*extensiveMountPointCheck*/)
+		if err == nil {
+			d.publishTracker.remove(v.volumeID, target)
+		}
+		d.recordMountReconcileAction(v, mountReconcileCleanup, target, err)
+	}
+	if v.stagingPath == "" || (!isMountedPath(mountPoints, v.stagingPath) && !isCorruptedMountPoint(v.stagingPath)) {
+		return
+	}
+
+	d.trimScheduler.remove(v.volumeID)
+	devices := d.getStagedDevices(v.stagingPath)
+	err := CleanupMountPoint(v.stagingPath, d.mounter, true /*extensiveMountPointCheck*/)
+	if err == nil {
+		err = d.teardownLocalCache(v.volumeID)
+	}
+	if err == nil && azureutils.IsStripedVolumeID(v.volumeID) {
+		err = deactivateStripedVolume(getStripedVolumeGroupName(v.volumeID), d.mounter)
+	}
+	if err == nil {
+		d.deleteStaleDevices(v.volumeID, devices)
+	}
+	d.recordMountReconcileAction(v, mountReconcileCleanup, v.stagingPath, err)
+}
+
+// repairCorruptedMounts remounts the corrupted staging path of an attached volume from the data disk in its attachment
+// metadata with the filesystem type and options it was mounted with, and bind mounts its corrupted target paths
+// again. The data disk is never formatted, a staging path is not repaired if the filesystem of the disk is not the
+// one that was mounted. The staging paths of striped volumes and of volumes with local cache are not repaired.
+func (d *DriverCore) repairCorruptedMounts(v *mountedVolume, metadata map[string]string, mountPoints []mount.MountPoint) {
+	if v.stagingPath != "" && isCorruptedMountPoint(v.stagingPath) {
+		err := d.remountStagingPath(v, metadata, mountPoints)
+		d.recordMountReconcileAction(v, mountReconcileRepair, v.stagingPath, err)
+		if err != nil {
+			return
+		}
+	}
+	for _, target := range v.targetPaths {
+		if !isCorruptedMountPoint(target) {
+			continue
+		}
+		err := d.rebindTargetPath(v, target, mountPoints)
+		d.recordMountReconcileAction(v, mountReconcileRepair, target, err)
+	}
+}
+
+// remountStagingPath mounts the data disk in the attachment metadata at the corrupted staging path of the volume
+func (d *DriverCore) remountStagingPath(v *mountedVolume, metadata map[string]string, mountPoints []mount.MountPoint) error {
+	mountPoint := findMountPoint(mountPoints, v.stagingPath)
+	if mountPoint == nil {
+		return fmt.Errorf("mount point of staging path %s is not found", v.stagingPath)
+	}
+	if azureutils.IsStripedVolumeID(v.volumeID) || isLocalCacheDevice(v.volumeID, mountPoint.Device) {
+		return fmt.Errorf("staging path %s of volume %s on %s is not repaired, only the staging paths of data disks are repaired", v.stagingPath, v.volumeID, mountPoint.Device)
+	}
+	lun := metadata[consts.LUN]
+	lunNum, err := strconv.Atoi(lun)
+	if err != nil {
+		return fmt.Errorf("lun(%s) of volume %s is invalid: %v", lun, v.volumeID, err)
+	}
+	devicePath := d.findDataDiskWithLUN(lunNum)
+	if devicePath == "" {
+		return fmt.Errorf("no data disk is found at lun %d", lunNum)
+	}
+	if err := d.verifyStagingDevice(v.volumeID, devicePath, lun, metadata[consts.DiskUniqueIDField], 0); err != nil {
+		return err
+	}
+	format, _, err := getFilesystemUUID(devicePath, d.mounter)
+	if err != nil {
+		return fmt.Errorf("failed to get the filesystem of %s: %v", devicePath, err)
+	}
+	if format != mountPoint.Type {
+		return fmt.Errorf("filesystem of %s is %q instead of %q", devicePath, format, mountPoint.Type)
+	}
+
+	klog.V(2).Infof("mount reconciler: remounting corrupted staging path %s of volume %s from %s", v.stagingPath, v.volumeID, devicePath)
+	if err := d.mounter.Unmount(v.stagingPath); err != nil {
+		return fmt.Errorf("failed to unmount %s: %v", v.stagingPath, err)
+	}
+	return d.mounter.Mount(devicePath, v.stagingPath, mountPoint.Type, mountPoint.Opts)
+}
+
+// rebindTargetPath bind mounts the staging path of the volume at its corrupted target path, read-only if it was
+func (d *DriverCore) rebindTargetPath(v *mountedVolume, target string, mountPoints []mount.MountPoint) error {
+	if v.stagingPath == "" {
+		return fmt.Errorf("staging path of volume %s is not found", v.volumeID)
+	}
+	options := []string{"bind"}
+	if mountPoint := findMountPoint(mountPoints, target); mountPoint != nil && slices.Contains(mountPoint.Opts, "ro") {
+		options = append(options, "ro")
+	}
+	klog.V(2).Infof("mount reconciler: bind mounting %s at corrupted target path %s of volume %s", v.stagingPath, target, v.volumeID)
+	if err := d.mounter.Unmount(target); err != nil {
+		return fmt.Errorf("failed to unmount %s: %v", target, err)
+	}
+	return d.mounter.Mount(v.stagingPath, target, "", options)
+}
+
+// findDataDiskWithLUN returns the device path of the data disk at lun without rescanning, or "" if it is not found
+func (d *DriverCore) findDataDiskWithLUN(lun int) string {
+	if d.deviceIndex != nil {
+		if devicePath, _ := d.deviceIndex.lookup(lun); devicePath != "" {
+			return devicePath
+		}
+	}
+	devicePath, err := findDiskByLun(lun, d.ioHandler, d.mounter)
+	if err != nil {
+		klog.Warningf("failed to find data disk at lun %d: %v", lun, err)
+	}
+	return devicePath
+}
+
+// recordMountReconcileAction logs, counts and records as an event the result of an action on a path of the volume
+func (d *DriverCore) recordMountReconcileAction(v *mountedVolume, action, path string, err error) {
+	volumeContext := map[string]string{consts.PvNameKey: v.pvName}
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+		msg := fmt.Sprintf("mount reconciler failed to %s %s of volume %s: %v", action, path, v.volumeID, err)
+		klog.Error(msg)
+		d.recordVolumeEvent(volumeContext, v1.EventTypeWarning, mountReconcileFailedReason, msg)
+	} else {
+		reason, msg := staleMountCleanedReason, fmt.Sprintf("cleaned up %s of volume %s that is not attached to node %s", path, v.volumeID, d.NodeID)
+		if action == mountReconcileRepair {
+			reason, msg = corruptedMountRepairedReason, fmt.Sprintf("repaired corrupted mount point %s of volume %s", path, v.volumeID)
+		}
+		klog.V(2).Infof("mount reconciler: %s", msg)
+		d.recordVolumeEvent(volumeContext, v1.EventTypeNormal, reason, msg)
+	}
+	mountReconcilerActions.WithLabelValues(action, result).Inc()
+}
+
+// findMountPoint returns the mount point at path, or nil if path is not mounted
+func findMountPoint(mountPoints []mount.MountPoint, path string) *mount.MountPoint {
+	for i := range mountPoints {
+		if mountPoints[i].Path == path {
+			return &mountPoints[i]
+		}
+	}
+	return nil
+}
+
+// isMountedPath returns whether path is a mount point
+func isMountedPath(mountPoints []mount.MountPoint, path string) bool {
+	return findMountPoint(mountPoints, path) != nil
+}
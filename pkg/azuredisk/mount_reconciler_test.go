@@ -0,0 +1,336 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+	mount "k8s.io/mount-utils"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+)
+
+// writeVolumeData creates path and the vol_data.json file of kubelet next to it
+func writeVolumeData(t *testing.T, path string, data volumeData) {
+	require.NoError(t, os.MkdirAll(path, 0750))
+	content, err := json.Marshal(data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(path), "vol_data.json"), content, 0600))
+}
+
+func newFakePV(name, volumeHandle string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: fakeDriverName, VolumeHandle: volumeHandle}},
+		},
+	}
+}
+
+func newFakeVolumeAttachment(pvName, nodeName, lun string) *storagev1.VolumeAttachment {
+	return &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-" + pvName},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: fakeDriverName,
+			NodeName: nodeName,
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+		},
+		Status: storagev1.VolumeAttachmentStatus{Attached: true, AttachmentMetadata: map[string]string{consts.LUN: lun}},
+	}
+}
+
+func newFakeVolumeAttachmentLister(t *testing.T, attachments ...*storagev1.VolumeAttachment) storagelisters.VolumeAttachmentLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, attachment := range attachments {
+		require.NoError(t, indexer.Add(attachment))
+	}
+	return storagelisters.NewVolumeAttachmentLister(indexer)
+}
+
+func TestListMountedVolumes(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.kubeletRootDir = t.TempDir()
+
+	staging := filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi", fakeDriverName, "0123abcd/globalmount")
+	legacyStaging := filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi/pv/pv-2/globalmount")
+	target := filepath.Join(d.kubeletRootDir, "pods/uid-1/volumes/kubernetes.io~csi/pv-1/mount")
+	writeVolumeData(t, staging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-1"})
+	writeVolumeData(t, legacyStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-2"})
+	writeVolumeData(t, target, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-1", SpecVolID: "pv-1"})
+	writeVolumeData(t, filepath.Join(d.kubeletRootDir, "pods/uid-1/volumes/kubernetes.io~csi/pv-3/mount"), volumeData{DriverName: "file.csi.azure.com", VolumeHandle: "vol-3"})
+	require.NoError(t, os.MkdirAll(filepath.Join(d.kubeletRootDir, "pods/uid-2/volumes/kubernetes.io~csi/pv-4/mount"), 0750))
+
+	assert.Equal(t, []*mountedVolume{
+		{volumeID: "vol-1", pvName: "pv-1", stagingPath: staging, targetPaths: []string{target}},
+		{volumeID: "vol-2", stagingPath: legacyStaging},
+	}, d.listMountedVolumes())
+}
+
+func TestReconcileMounts(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mount reconciler is only supported on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	sysfs := newFakeSCSISysfs()
+	d.ioHandler = sysfs
+	d.deviceIndex = newDeviceIndex(sysfs)
+	require.NoError(t, d.deviceIndex.seed())
+	d.kubeletRootDir = t.TempDir()
+
+	csiDir := filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi", fakeDriverName)
+	attachedStaging := filepath.Join(csiDir, "attached/globalmount")
+	attachedTarget := filepath.Join(d.kubeletRootDir, "pods/uid-1/volumes/kubernetes.io~csi/pv-attached/mount")
+	missingStaging := filepath.Join(csiDir, "missing/globalmount")
+	staleStaging := filepath.Join(csiDir, "stale/globalmount")
+	staleTarget := filepath.Join(d.kubeletRootDir, "pods/uid-2/volumes/kubernetes.io~csi/pv-stale/mount")
+	unmountedTarget := filepath.Join(d.kubeletRootDir, "pods/uid-3/volumes/kubernetes.io~csi/pv-unmounted/mount")
+	writeVolumeData(t, attachedStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-attached"})
+	writeVolumeData(t, attachedTarget, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-attached", SpecVolID: "pv-attached"})
+	writeVolumeData(t, missingStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-missing"})
+	writeVolumeData(t, staleStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-stale"})
+	writeVolumeData(t, staleTarget, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-stale", SpecVolID: "pv-stale"})
+	writeVolumeData(t, unmountedTarget, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-unmounted", SpecVolID: "pv-unmounted"})
+
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdb", Path: attachedStaging, Type: "ext4", Opts: []string{"rw", "relatime"}},
+		{Device: "/dev/sdb", Path: attachedTarget, Type: "ext4", Opts: []string{"ro", "relatime"}},
+		{Device: "/dev/sdd", Path: missingStaging, Type: "ext4", Opts: []string{"rw"}},
+		{Device: "/dev/sde", Path: staleStaging, Type: "ext4", Opts: []string{"rw"}},
+		{Device: "/dev/sde", Path: staleTarget, Type: "ext4", Opts: []string{"rw"}},
+	}
+	d.setNextCommandOutputScripts(commandOutput("DEVNAME=/dev/sdc\nTYPE=ext4\n", nil))
+	corrupted := map[string]bool{attachedStaging: true, attachedTarget: true, missingStaging: true}
+	defer func(f func(string) bool) { isCorruptedMountPoint = f }(isCorruptedMountPoint)
+	isCorruptedMountPoint = func(path string) bool { return corrupted[path] }
+
+	d.kubeClient = fake.NewSimpleClientset(
+		newFakePV("pv-attached", "vol-attached"),
+		newFakePV("pv-missing", "vol-missing"),
+		newFakePV("pv-stale", "vol-stale"),
+		newFakePV("pv-unmounted", "vol-unmounted"),
+	)
+	attachmentLister := newFakeVolumeAttachmentLister(t,
+		newFakeVolumeAttachment("pv-attached", fakeNodeID, "1"),
+		newFakeVolumeAttachment("pv-missing", fakeNodeID, "2"),
+		newFakeVolumeAttachment("pv-stale", "otherNode", "1"),
+	)
+
+	getActions := func(action, result string) float64 {
+		value, err := testutil.GetCounterMetricValue(mountReconcilerActions.WithLabelValues(action, result))
+		require.NoError(t, err)
+		return value
+	}
+	cleanups, repairs, failures := getActions(mountReconcileCleanup, "succeeded"), getActions(mountReconcileRepair, "succeeded"), getActions(mountReconcileRepair, "failed")
+
+	d.reconcileMounts(context.Background(), d.volumeLocks, attachmentLister)
+
+	assert.Equal(t, cleanups+2, getActions(mountReconcileCleanup, "succeeded"))
+	assert.Equal(t, repairs+2, getActions(mountReconcileRepair, "succeeded"))
+	assert.Equal(t, failures+1, getActions(mountReconcileRepair, "failed"))
+	for _, path := range []string{staleStaging, staleTarget} {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "%s is removed", path)
+	}
+	assert.DirExists(t, unmountedTarget, "a target path that is not mounted is left to kubelet")
+	assert.DirExists(t, attachedStaging)
+	mountPoints := fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints
+	assert.Nil(t, findMountPoint(mountPoints, attachedStaging), "corrupted staging path is unmounted before it is mounted again")
+	assert.Nil(t, findMountPoint(mountPoints, attachedTarget), "corrupted target path is unmounted before it is mounted again")
+	assert.NotNil(t, findMountPoint(mountPoints, missingStaging), "staging path is not unmounted without data disk")
+
+	expectedEvents := []string{
+		"Normal " + corruptedMountRepairedReason,
+		"Normal " + corruptedMountRepairedReason,
+		"Warning " + mountReconcileFailedReason,
+		"Normal " + staleMountCleanedReason,
+		"Normal " + staleMountCleanedReason,
+	}
+	require.Len(t, recorder.Events, len(expectedEvents))
+	for _, expected := range expectedEvents {
+		assert.True(t, strings.HasPrefix(<-recorder.Events, expected), expected)
+	}
+
+	// a volume with an operation in progress is skipped
+	writeVolumeData(t, staleStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-stale"})
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{{Device: "/dev/sde", Path: staleStaging, Type: "ext4"}}
+	require.True(t, d.volumeLocks.TryAcquire("vol-stale"))
+	d.reconcileMounts(context.Background(), d.volumeLocks, attachmentLister)
+	d.volumeLocks.Release("vol-stale")
+	assert.DirExists(t, staleStaging)
+}
+
+func TestGetAttachedVolumes(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	inTreePV := newFakePV("pv-intree", "")
+	inTreePV.Spec.PersistentVolumeSource = v1.PersistentVolumeSource{AzureDisk: &v1.AzureDiskVolumeSource{DataDiskURI: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/intree"}}
+	d.kubeClient = fake.NewSimpleClientset(newFakePV("pv-csi", "vol-CSI"), newFakePV("pv-pending", "vol-pending"), newFakePV("pv-other", "vol-other"), inTreePV)
+
+	pending := newFakeVolumeAttachment("pv-pending", fakeNodeID, "")
+	pending.Status = storagev1.VolumeAttachmentStatus{}
+	otherAttacher := newFakeVolumeAttachment("pv-other", fakeNodeID, "3")
+	otherAttacher.Spec.Attacher = "file.csi.azure.com"
+	inline := newFakeVolumeAttachment("inline", fakeNodeID, "4")
+	inline.Spec.Source = storagev1.VolumeAttachmentSource{InlineVolumeSpec: &v1.PersistentVolumeSpec{
+		PersistentVolumeSource: v1.PersistentVolumeSource{CSI: &v1.CSIPersistentVolumeSource{Driver: fakeDriverName, VolumeHandle: "vol-inline"}},
+	}}
+	inlineInTree := newFakeVolumeAttachment("inline-intree", fakeNodeID, "5")
+	inlineInTree.Spec.Source = storagev1.VolumeAttachmentSource{InlineVolumeSpec: &v1.PersistentVolumeSpec{
+		PersistentVolumeSource: v1.PersistentVolumeSource{AzureDisk: &v1.AzureDiskVolumeSource{DataDiskURI: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/inline-intree"}},
+	}}
+	attachmentLister := newFakeVolumeAttachmentLister(t,
+		newFakeVolumeAttachment("pv-csi", fakeNodeID, "1"),
+		newFakeVolumeAttachment("pv-intree", fakeNodeID, "2"),
+		newFakeVolumeAttachment("pv-deleted", fakeNodeID, "6"),
+		newFakeVolumeAttachment("pv-other", "otherNode", "1"),
+		pending,
+		otherAttacher,
+		inline,
+		inlineInTree,
+	)
+
+	attached, err := d.getAttachedVolumes(context.Background(), attachmentLister)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{
+		"vol-csi":     {consts.LUN: "1"},
+		"vol-pending": nil,
+		"vol-inline":  {consts.LUN: "4"},
+		"/subscriptions/sub/resourcegroups/rg/providers/microsoft.compute/disks/intree":        {consts.LUN: "2"},
+		"/subscriptions/sub/resourcegroups/rg/providers/microsoft.compute/disks/inline-intree": {consts.LUN: "5"},
+	}, attached.metadata)
+	assert.Equal(t, map[string]bool{"pv-deleted": true}, attached.unresolvedPVs)
+
+	assert.True(t, attached.isUnresolved(&mountedVolume{volumeID: "vol-1"}), "a volume without persistent volume name may be the unresolved one")
+	assert.True(t, attached.isUnresolved(&mountedVolume{volumeID: "vol-1", pvName: "pv-deleted"}))
+	assert.False(t, attached.isUnresolved(&mountedVolume{volumeID: "vol-1", pvName: "pv-1"}))
+}
+
+func TestReconcileMountsUnresolvedAttachment(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mount reconciler is only supported on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	d.eventRecorder = record.NewFakeRecorder(10)
+	d.kubeletRootDir = t.TempDir()
+
+	csiDir := filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi", fakeDriverName)
+	unresolvedStaging := filepath.Join(csiDir, "unresolved/globalmount")
+	staleStaging := filepath.Join(csiDir, "stale/globalmount")
+	staleTarget := filepath.Join(d.kubeletRootDir, "pods/uid-1/volumes/kubernetes.io~csi/pv-stale/mount")
+	writeVolumeData(t, unresolvedStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-unresolved"})
+	writeVolumeData(t, staleStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-stale"})
+	writeVolumeData(t, staleTarget, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-stale", SpecVolID: "pv-stale"})
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdd", Path: unresolvedStaging, Type: "ext4"},
+		{Device: "/dev/sde", Path: staleStaging, Type: "ext4"},
+		{Device: "/dev/sde", Path: staleTarget, Type: "ext4"},
+	}
+	defer func(f func(string) bool) { isCorruptedMountPoint = f }(isCorruptedMountPoint)
+	isCorruptedMountPoint = func(string) bool { return false }
+
+	// the persistent volume of the attachment of the node cannot be read
+	d.kubeClient = fake.NewSimpleClientset(newFakePV("pv-stale", "vol-stale"))
+	d.reconcileMounts(context.Background(), d.volumeLocks, newFakeVolumeAttachmentLister(t, newFakeVolumeAttachment("pv-unresolved", fakeNodeID, "1")))
+
+	assert.DirExists(t, unresolvedStaging, "a volume that may be attached is not cleaned up")
+	for _, path := range []string{staleStaging, staleTarget} {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "%s of a volume of another persistent volume is removed", path)
+	}
+	assert.NotNil(t, findMountPoint(fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints, unresolvedStaging))
+}
+
+func TestReconcileMountsAttachmentNotInCache(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mount reconciler is only supported on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	d.eventRecorder = record.NewFakeRecorder(10)
+	d.kubeletRootDir = t.TempDir()
+
+	staging := filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi", fakeDriverName, "staged/globalmount")
+	writeVolumeData(t, staging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-staged"})
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{{Device: "/dev/sdc", Path: staging, Type: "ext4"}}
+	defer func(f func(string) bool) { isCorruptedMountPoint = f }(isCorruptedMountPoint)
+	isCorruptedMountPoint = func(string) bool { return false }
+
+	// the volume was attached and staged after the cache of the volume attachments was read
+	attachment := newFakeVolumeAttachment("pv-staged", fakeNodeID, "1")
+	attachment.Name = d.getVolumeAttachmentName("vol-staged")
+	d.kubeClient = fake.NewSimpleClientset(newFakePV("pv-staged", "vol-staged"), attachment)
+	d.reconcileMounts(context.Background(), d.volumeLocks, newFakeVolumeAttachmentLister(t))
+	assert.DirExists(t, staging, "a volume with an attachment on the API server is not cleaned up")
+	assert.NotNil(t, findMountPoint(fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints, staging))
+
+	// the attachment is not found on the API server either
+	d.kubeClient = fake.NewSimpleClientset(newFakePV("pv-staged", "vol-staged"))
+	d.reconcileMounts(context.Background(), d.volumeLocks, newFakeVolumeAttachmentLister(t))
+	_, err = os.Stat(staging)
+	assert.True(t, os.IsNotExist(err), "%s is removed", staging)
+}
+
+func TestGetVolumeAttachmentName(t *testing.T) {
+	d := &DriverCore{}
+	d.Name = "disk.csi.azure.com"
+	d.NodeID = "node-0"
+	// the name kubelet gives the attachment of the volume on the node
+	assert.Equal(t, "csi-18378093b528c9367f287657f0d5cc96ef778a0b21a018f315cfed43aacd38cc", d.getVolumeAttachmentName("vol-1"))
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -34,10 +35,13 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
 )
@@ -47,6 +51,7 @@ const (
 	defaultWindowsFsType            = "ntfs"
 	defaultAzureVolumeLimit         = 16
 	volumeOperationAlreadyExistsFmt = "An operation with the given Volume ID %s already exists"
+	diskIdentityUnverifiedReason    = "DiskIdentityUnverified"
 )
 
 func getDefaultFsType() string {
@@ -94,14 +99,25 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		return nil, status.Error(codes.InvalidArgument, "lun not provided")
 	}
 
-	source, err := d.getDevicePathWithLUN(lun)
-	if err != nil {
+	striped := azureutils.IsStripedVolumeID(diskURI)
+	expectedSizeBytes := getExpectedVolumeSizeBytes(req.GetPublishContext(), req.GetVolumeContext())
+	var source string
+	if striped {
+		if volumeCapability.GetBlock() != nil {
+			return nil, status.Error(codes.InvalidArgument, "block volumes are not supported by striped volumes")
+		}
+		if source, err = d.assembleStripedVolume(diskURI, lun, req.GetPublishContext()[consts.DiskUniqueIDField], expectedSizeBytes); err != nil {
+			return nil, err
+		}
+	} else if source, err = d.getDevicePathWithLUN(lun); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to find disk on lun %s. %v", lun, err)
+	} else if err = d.verifyStagingDevice(diskURI, source, lun, req.GetPublishContext()[consts.DiskUniqueIDField], expectedSizeBytes); err != nil {
+		return nil, err
 	}
 
 	// If perf optimizations are enabled
-	// tweak device settings to enhance performance
-	if d.getPerfOptimizationEnabled() {
+	// tweak device settings to enhance performance, the device settings of the member disks of a striped volume are not tuned
+	if d.getPerfOptimizationEnabled() && !striped {
 		profile, accountType, diskSizeGibStr, diskIopsStr, diskBwMbpsStr, deviceSettings, err := optimization.GetDiskPerfAttributes(req.GetVolumeContext())
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to get perf attributes for %s. Error: %v", source, err)
@@ -123,12 +139,24 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
+	volumeMountGroup, err := getVolumeMountGroup(volumeCapability)
+	if err != nil {
+		return nil, err
+	}
+
 	mnt, err := d.ensureMountPoint(target)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not mount target %q: %v", target, err)
 	}
 	if mnt {
 		klog.V(2).Infof("NodeStageVolume: already mounted on target %s", target)
+		if err := d.checkSELinuxMountContext(diskURI, target, getSELinuxMountContext(volumeCapability.GetMount().GetMountFlags())); err != nil {
+			return nil, err
+		}
+		if err := applyVolumeMountGroup(diskURI, target, volumeMountGroup); err != nil {
+			return nil, err
+		}
+		d.scheduleVolumeTrim(diskURI, target, req.GetVolumeContext())
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -147,19 +175,53 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		// respect "fstype" setting in storage class parameters
 		fstype = volContextFSType
 	}
+	if volumeMountGroup >= 0 && fsTypesWithGIDMountOption.Has(fstype) {
+		options = append(options, fmt.Sprintf("gid=%d", volumeMountGroup), "umask=002")
+	}
 
 	// If partition is specified, should mount it only instead of the entire disk.
-	if partition, ok := req.GetVolumeContext()[consts.VolumeAttributePartition]; ok {
-		source = source + "-part" + partition
+	if partition, ok := req.GetVolumeContext()[consts.VolumeAttributePartition]; ok && !striped {
+		source = getPartitionPath(source, partition)
+	}
+
+	if source, err = d.setupLocalCache(diskURI, source, req.GetVolumeContext()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	formatOptions, err := getFormatOptions(fstype, req.GetVolumeContext(), req.GetPublishContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	fsckPolicy, err := azureutils.GetFsckPolicy(req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := d.regenerateFilesystemUUID(diskURI, source, fsckPolicy, req.GetVolumeContext()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	// FormatAndMount will format only if needed
-	klog.V(2).Infof("NodeStageVolume: formatting %s and mounting at %s with mount options(%s)", source, target, options)
-	if err := d.formatAndMount(source, target, fstype, options); err != nil {
+	klog.V(2).Infof("NodeStageVolume: formatting %s and mounting at %s with mount options(%s) and format options(%s)", source, target, options, formatOptions)
+	err = d.formatAndMount(source, target, fstype, options, formatOptions)
+	if err != nil && isFilesystemCorrupted(err, fstype) && d.applyFsckPolicy(fsckPolicy, diskURI, source, fstype, req.GetVolumeContext()) {
+		klog.V(2).Infof("NodeStageVolume: mounting %s at %s again after the filesystem was repaired", source, target)
+		err = d.formatAndMount(source, target, fstype, options, formatOptions)
+	}
+	if err != nil {
+		if cacheErr := d.teardownLocalCache(diskURI); cacheErr != nil {
+			klog.Warningf("NodeStageVolume: %v", cacheErr)
+		}
 		return nil, status.Errorf(codes.Internal, "could not format %s(lun: %s), and mount it at %s, failed with %v", source, lun, target, err)
 	}
 	klog.V(2).Infof("NodeStageVolume: format %s and mounting at %s successfully.", source, target)
 
+	// a static volume on a partition is resized once the partition is grown to the end of its expanded disk
+	if _, ok := req.GetVolumeContext()[consts.VolumeAttributePartition]; ok {
+		if err := growPartition(d.ioHandler, source, d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeStageVolume: could not grow partition %s of volume %s: %v", source, diskURI, err)
+		}
+	}
 	var needResize bool
 	if required, ok := req.GetVolumeContext()[consts.ResizeRequired]; ok && strings.EqualFold(required, consts.TrueValue) {
 		needResize = true
@@ -178,6 +240,10 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		}
 		klog.V(2).Infof("NodeStageVolume: fs resize successful on target(%s) volumeid(%s).", target, diskURI)
 	}
+	if err := applyVolumeMountGroup(diskURI, target, volumeMountGroup); err != nil {
+		return nil, err
+	}
+	d.scheduleVolumeTrim(diskURI, target, req.GetVolumeContext())
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -198,6 +264,8 @@ func (d *Driver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolume
 	}
 	defer d.volumeLocks.Release(volumeID)
 
+	d.trimScheduler.remove(volumeID)
+	devices := d.getStagedDevices(stagingTargetPath)
 	klog.V(2).Infof("NodeUnstageVolume: unmounting %s", stagingTargetPath)
 	err := CleanupMountPoint(stagingTargetPath, d.mounter, true /*extensiveMountPointCheck*/)
 	if err != nil {
@@ -205,6 +273,18 @@ func (d *Driver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolume
 	}
 	klog.V(2).Infof("NodeUnstageVolume: unmount %s successfully", stagingTargetPath)
 
+	if err := d.teardownLocalCache(volumeID); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if azureutils.IsStripedVolumeID(volumeID) {
+		vgName := getStripedVolumeGroupName(volumeID)
+		klog.V(2).Infof("NodeUnstageVolume: deactivating volume group %s of striped volume %s", vgName, volumeID)
+		if err := deactivateStripedVolume(vgName, d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to deactivate volume group %s of striped volume %s: %v", vgName, volumeID, err)
+		}
+	}
+	d.deleteStaleDevices(volumeID, devices)
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
@@ -261,11 +341,28 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to find device path with lun %s. %v", lun, err)
 		}
+		if err = d.verifyStagingDevice(volumeID, source, lun, req.GetPublishContext()[consts.DiskUniqueIDField], getExpectedVolumeSizeBytes(req.GetPublishContext(), req.GetVolumeContext())); err != nil {
+			return nil, err
+		}
 		klog.V(2).Infof("NodePublishVolume [block]: found device path %s with lun %s", source, lun)
 		if err = d.ensureBlockTargetFile(target); err != nil {
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
 	case *csi.VolumeCapability_Mount:
+		// the SELinux context of a bind mount must be the context the volume is staged with
+		if seLinuxContext := getSELinuxMountContext(volumeCapability.GetMount().GetMountFlags()); seLinuxContext != "" {
+			if err := d.checkSELinuxMountContext(volumeID, source, seLinuxContext); err != nil {
+				return nil, err
+			}
+			mountOptions = append(mountOptions, seLinuxMountOption(seLinuxContext))
+		}
+		volumeMountGroup, err := getVolumeMountGroup(volumeCapability)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyVolumeMountGroup(volumeID, source, volumeMountGroup); err != nil {
+			return nil, err
+		}
 		mnt, err := d.ensureMountPoint(target)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "could not mount target %q: %v", target, err)
@@ -276,8 +373,15 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 		}
 	}
 
+	added, err := d.publishTracker.add(volumeID, target, req.GetReadonly(), isSingleWriter(volumeCapability))
+	if err != nil {
+		return nil, err
+	}
 	klog.V(2).Infof("NodePublishVolume: mounting %s at %s", source, target)
 	if err := d.mounter.Mount(source, target, "", mountOptions); err != nil {
+		if added {
+			d.publishTracker.remove(volumeID, target)
+		}
 		return nil, status.Errorf(codes.Internal, "could not mount %q at %q: %v", source, target, err)
 	}
 
@@ -303,6 +407,7 @@ func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVo
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount target %q: %v", targetPath, err)
 	}
+	d.publishTracker.remove(volumeID, targetPath)
 
 	klog.V(2).Infof("NodeUnpublishVolume: unmount volume %s on %s successfully", volumeID, targetPath)
 
@@ -328,16 +433,16 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 	if d.supportZone {
 		var zone cloudprovider.Zone
 		if d.getNodeInfoFromLabels {
-			failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+			failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 		} else {
-			if runtime.GOOS == "windows" && (!d.cloud.UseInstanceMetadata || d.cloud.Metadata == nil) {
-				zone, err = d.cloud.VMSet.GetZoneByNodeName(d.NodeID)
+			if runtime.GOOS == "windows" && (!d.getCloud().UseInstanceMetadata || d.getCloud().Metadata == nil) {
+				zone, err = d.getCloud().VMSet.GetZoneByNodeName(d.NodeID)
 			} else {
-				zone, err = d.cloud.GetZone(ctx)
+				zone, err = d.getCloud().GetZone(ctx)
 			}
 			if err != nil {
 				klog.Warningf("get zone(%s) failed with: %v, fall back to get zone from node labels", d.NodeID, err)
-				failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		}
 		if err != nil {
@@ -348,7 +453,7 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 		}
 
 		klog.V(2).Infof("NodeGetInfo, nodeName: %s, failureDomain: %s", d.NodeID, zone.FailureDomain)
-		if azureutils.IsValidAvailabilityZone(zone.FailureDomain, d.cloud.Location) {
+		if azureutils.IsValidAvailabilityZone(zone.FailureDomain, d.getCloud().Location) {
 			topology.Segments[topologyKey] = zone.FailureDomain
 			topology.Segments[consts.WellKnownTopologyKey] = zone.FailureDomain
 		}
@@ -360,18 +465,18 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 		var err error
 		if d.getNodeInfoFromLabels {
 			if instanceTypeFromLabels == "" {
-				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		} else {
-			if runtime.GOOS == "windows" && d.cloud.UseInstanceMetadata && d.cloud.Metadata != nil {
+			if runtime.GOOS == "windows" && d.getCloud().UseInstanceMetadata && d.getCloud().Metadata != nil {
 				var metadata *azure.InstanceMetadata
-				metadata, err = d.cloud.Metadata.GetMetadata(azcache.CacheReadTypeDefault)
+				metadata, err = d.getCloud().Metadata.GetMetadata(azcache.CacheReadTypeDefault)
 				if err == nil && metadata != nil && metadata.Compute != nil {
 					instanceType = metadata.Compute.VMSize
 					klog.V(2).Infof("NodeGetInfo: nodeName(%s), VM Size(%s)", d.NodeID, instanceType)
 				}
 			} else {
-				instances, ok := d.cloud.Instances()
+				instances, ok := d.getCloud().Instances()
 				if !ok {
 					klog.Warningf("failed to get instances from cloud provider")
 				} else {
@@ -383,7 +488,7 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 			}
 			if instanceType == "" && instanceTypeFromLabels == "" {
 				klog.Warningf("fall back to get instance type from node labels")
-				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		}
 		if err != nil {
@@ -396,8 +501,8 @@ func (d *Driver) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*c
 	}
 
 	nodeID := d.NodeID
-	if d.getNodeIDFromIMDS && d.cloud.UseInstanceMetadata && d.cloud.Metadata != nil {
-		metadata, err := d.cloud.Metadata.GetMetadata(azcache.CacheReadTypeDefault)
+	if d.getNodeIDFromIMDS && d.getCloud().UseInstanceMetadata && d.getCloud().Metadata != nil {
+		metadata, err := d.getCloud().Metadata.GetMetadata(azcache.CacheReadTypeDefault)
 		if err == nil && metadata != nil && metadata.Compute != nil {
 			klog.V(2).Infof("NodeGetInfo: NodeID(%s), metadata.Compute.Name(%s)", d.NodeID, metadata.Compute.Name)
 			if metadata.Compute.Name != "" {
@@ -448,6 +553,7 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 	if err != nil {
 		klog.Errorf("NodeGetVolumeStats: failed to get volume stats for volume %s path %s: %v", req.VolumeId, req.VolumePath, err)
 	}
+	d.updateLocalCacheMetrics(req.VolumeId)
 	return &csi.NodeGetVolumeStatsResponse{
 		Usage: volUsage,
 	}, err
@@ -500,10 +606,36 @@ func (d *Driver) NodeExpandVolume(_ context.Context, req *csi.NodeExpandVolumeRe
 		return nil, status.Errorf(codes.NotFound, err.Error())
 	}
 
+	striped := azureutils.IsStripedVolumeID(volumeID)
+	cached := isLocalCacheDevice(volumeID, devicePath)
 	if d.enableDiskOnlineResize {
-		klog.V(2).Infof("NodeExpandVolume begin to rescan device %s on volume(%s)", devicePath, volumeID)
-		if err := rescanVolume(d.ioHandler, devicePath); err != nil {
-			klog.Errorf("NodeExpandVolume rescanVolume failed with error: %v", err)
+		if striped || cached {
+			// the disks are not the device mounted at the volume path
+			klog.V(2).Infof("NodeExpandVolume begin to rescan all devices on volume(%s)", volumeID)
+			if err := rescanAllVolumes(d.ioHandler); err != nil {
+				klog.Errorf("NodeExpandVolume rescanAllVolumes failed with error: %v", err)
+			}
+		} else {
+			klog.V(2).Infof("NodeExpandVolume begin to rescan device %s on volume(%s)", devicePath, volumeID)
+			if err := rescanVolume(d.ioHandler, devicePath); err != nil {
+				klog.Errorf("NodeExpandVolume rescanVolume failed with error: %v", err)
+			}
+		}
+	}
+	if striped {
+		if err := growStripedVolume(getStripedVolumeGroupName(volumeID), d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not grow striped volume %q (%q): %v", volumeID, devicePath, err)
+		}
+	}
+	if cached {
+		if err := d.resizeLocalCache(volumeID); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not resize local cache of volume %q (%q): %v", volumeID, devicePath, err)
+		}
+	}
+
+	if !striped && !cached {
+		if err := growPartition(d.ioHandler, devicePath, d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not grow partition of volume %q (%q): %v", volumeID, devicePath, err)
 		}
 	}
 
@@ -599,8 +731,8 @@ func (d *Driver) ensureMountPoint(target string) (bool, error) {
 	return !notMnt, nil
 }
 
-func (d *Driver) formatAndMount(source, target, fstype string, options []string) error {
-	return formatAndMount(source, target, fstype, options, d.mounter)
+func (d *Driver) formatAndMount(source, target, fstype string, options, formatOptions []string) error {
+	return formatAndMount(source, target, fstype, options, formatOptions, d.mounter)
 }
 
 func (d *Driver) getDevicePathWithLUN(lunStr string) (string, error) {
@@ -609,7 +741,9 @@ func (d *Driver) getDevicePathWithLUN(lunStr string) (string, error) {
 		return "", err
 	}
 
-	scsiHostRescan(d.ioHandler, d.mounter)
+	if devicePath := d.discoverDeviceWithLUN(int(lun)); devicePath != "" {
+		return devicePath, nil
+	}
 
 	newDevicePath := ""
 	err = wait.PollImmediate(1*time.Second, 2*time.Minute, func() (bool, error) {
@@ -661,3 +795,271 @@ func collectMountOptions(fsType string, mntFlags []string) []string {
 	}
 	return options
 }
+
+// getSELinuxMountContext returns the SELinux context set by the context mount option in options, e.g. the option
+// added by kubelet when the SELinuxMount feature is enabled on the CSIDriver object. A quoted context may hold commas
+// and be split across options, as in the options listed in /proc/mounts.
+func getSELinuxMountContext(options []string) string {
+	const prefix = "context="
+	opts := strings.Join(options, ",")
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if !strings.HasPrefix(opt, prefix) {
+			continue
+		}
+		value := strings.TrimPrefix(opt, prefix)
+		if !strings.HasPrefix(value, `"`) {
+			return value
+		}
+		value = strings.TrimPrefix(value+","+opts, `"`)
+		if end := strings.Index(value, `"`); end >= 0 {
+			return value[:end]
+		}
+		return strings.TrimSuffix(value, ",")
+	}
+	return ""
+}
+
+// seLinuxMountOption returns the mount option that mounts a volume with the SELinux context seLinuxContext
+func seLinuxMountOption(seLinuxContext string) string {
+	return fmt.Sprintf("context=%q", seLinuxContext)
+}
+
+// checkSELinuxMountContext returns a FailedPrecondition error if the volume is mounted at stagingPath with an SELinux
+// context other than seLinuxContext. All the mounts of a staged volume share the context of the staging mount, the
+// kernel refuses to mount the volume with another context.
+func (d *DriverCore) checkSELinuxMountContext(volumeID, stagingPath, seLinuxContext string) error {
+	if seLinuxContext == "" || runtime.GOOS == "windows" {
+		return nil
+	}
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to list mount points: %v", err)
+	}
+	stagingPathAbs, err := filepath.Abs(stagingPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get absolute path of %s: %v", stagingPath, err)
+	}
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Path != stagingPathAbs {
+			continue
+		}
+		stagedContext := getSELinuxMountContext(mountPoint.Opts)
+		if stagedContext == "" {
+			return status.Errorf(codes.FailedPrecondition, "volume %s is staged at %s without SELinux mount context, it cannot be mounted with SELinux mount context %q",
+				volumeID, stagingPath, seLinuxContext)
+		}
+		if stagedContext != seLinuxContext {
+			return status.Errorf(codes.FailedPrecondition, "volume %s is staged at %s with SELinux mount context %q, it cannot be mounted with the conflicting SELinux mount context %q",
+				volumeID, stagingPath, stagedContext, seLinuxContext)
+		}
+		return nil
+	}
+	return nil
+}
+
+// fsTypesWithGIDMountOption are the filesystems without unix ownership, the group of their files is set by the gid
+// mount option
+var fsTypesWithGIDMountOption = sets.New("vfat", "msdos", "exfat", "ntfs")
+
+// getVolumeMountGroup returns the group id set by the volume_mount_group of volumeCapability, it is -1 if no group is
+// set. kubelet sets the fsGroup of the pod as volume_mount_group when the node advertises VOLUME_MOUNT_GROUP.
+func getVolumeMountGroup(volumeCapability *csi.VolumeCapability) (int, error) {
+	volumeMountGroup := volumeCapability.GetMount().GetVolumeMountGroup()
+	if volumeMountGroup == "" {
+		return -1, nil
+	}
+	gid, err := strconv.Atoi(volumeMountGroup)
+	if err != nil || gid < 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "volume_mount_group(%s) must be a group id", volumeMountGroup)
+	}
+	return gid, nil
+}
+
+// applyVolumeMountGroup gives the group gid ownership of the volume mounted at path, it is a no-op if gid is -1
+func applyVolumeMountGroup(volumeID, path string, gid int) error {
+	if gid < 0 {
+		return nil
+	}
+	if err := setVolumeMountGroup(path, gid); err != nil {
+		return status.Errorf(codes.Internal, "failed to apply volume mount group %d to volume %s at %s: %v", gid, volumeID, path, err)
+	}
+	return nil
+}
+
+// getStagedDevices returns the identities of the SCSI data disks of the volume mounted at stagingTargetPath by device
+// name, they are deleted by deleteStaleDevices once the volume is unstaged. It returns nil if stale devices are not
+// removed.
+func (d *DriverCore) getStagedDevices(stagingTargetPath string) map[string]string {
+	if !d.removeStaleDevices || runtime.GOOS != "linux" {
+		return nil
+	}
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		klog.Warningf("failed to list mount points: %v", err)
+		return nil
+	}
+	stagingPathAbs, err := filepath.Abs(stagingTargetPath)
+	if err != nil {
+		klog.Warningf("failed to get absolute path of %s: %v", stagingTargetPath, err)
+		return nil
+	}
+	devices := map[string]string{}
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Path != stagingPathAbs {
+			continue
+		}
+		for _, devName := range listDataDiskSCSIDevices(d.ioHandler, mountPoint.Device) {
+			identity, err := getSCSIDeviceIdentity(d.ioHandler, devName)
+			if err != nil {
+				klog.Warningf("device %s of staging target %s is not removed after unstage, failed to get its identity: %v", devName, stagingTargetPath, err)
+				continue
+			}
+			klog.V(2).Infof("device %s(%s) of staging target %s is removed after unstage", devName, identity, stagingTargetPath)
+			devices[devName] = identity
+		}
+	}
+	return devices
+}
+
+// deleteStaleDevices flushes and deletes the SCSI devices of an unstaged volume so that a disk attached later at the
+// same LUN is not mistaken for them, a device is kept if another mount still references it or if it is no longer the
+// device of the same identity
+func (d *DriverCore) deleteStaleDevices(volumeID string, devices map[string]string) {
+	if len(devices) == 0 {
+		return
+	}
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		klog.Warningf("devices of volume %s are not removed, failed to list mount points: %v", volumeID, err)
+		return
+	}
+	for devName, identity := range devices {
+		if isDeviceMounted(mountPoints, devName) {
+			klog.V(2).Infof("device %s of volume %s is not removed, it is still mounted", devName, volumeID)
+			continue
+		}
+		klog.V(2).Infof("removing device %s(%s) of volume %s", devName, identity, volumeID)
+		if err := deleteSCSIDevice(d.ioHandler, devName, identity, d.mounter); err != nil {
+			klog.Warningf("failed to remove device %s of volume %s: %v", devName, volumeID, err)
+		}
+	}
+}
+
+// isDeviceMounted returns whether the block device devName, e.g. sdc, or one of its partitions is mounted
+func isDeviceMounted(mountPoints []mount.MountPoint, devName string) bool {
+	for _, mountPoint := range mountPoints {
+		partition, found := strings.CutPrefix(mountPoint.Device, "/dev/"+devName)
+		if !found {
+			continue
+		}
+		if _, err := strconv.Atoi(partition); partition == "" || err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// getExpectedVolumeSizeBytes returns the size of the volume set by the requestedsizegib of a dynamically provisioned
+// volume, or by the disk size inserted in publishContext for a static volume, it is 0 if the size is unknown
+func getExpectedVolumeSizeBytes(publishContext, volumeContext map[string]string) int64 {
+	for _, size := range []string{volumeContext[consts.RequestedSizeGib], publishContext[consts.DiskSizeGBField]} {
+		if sizeGiB, err := strconv.ParseInt(size, 10, 64); err == nil && sizeGiB > 0 {
+			return volumehelper.GiBToBytes(sizeGiB)
+		}
+	}
+	return 0
+}
+
+// verifyStagingDevice checks that devicePath is the data disk attached at lun and that its capacity is at least
+// expectedSizeBytes before it is formatted or mounted, so that a device left at the LUN by a detached disk or a disk
+// of a racing attach is never formatted. The capacity may be larger since volumes are expanded while attached. The
+// device is refused if a check cannot be done because the device properties are not found in sysfs, the capacity is
+// not checked if expectedSizeBytes is 0. Whether one of the device identifiers carries diskUniqueID, the unique ID of
+// the disk published by the controller, is only reported with a warning event: the device identifiers are not known
+// to carry the unique ID on every Azure VM size.
+func (d *DriverCore) verifyStagingDevice(volumeID, devicePath, lun, diskUniqueID string, expectedSizeBytes int64) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	expectedLUN, err := azureutils.GetDiskLUN(lun)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	devName := filepath.Base(devicePath)
+	// e.g. /dev/disk/azure/scsi1/lun1 -> ../../../sdc
+	if link, err := d.ioHandler.Readlink(devicePath); err == nil {
+		devName = filepath.Base(link)
+	}
+
+	deviceLUN, err := getDataDiskLUN(d.ioHandler, devName)
+	if err != nil {
+		return status.Errorf(codes.FailedPrecondition, "failed to verify the lun of device %s(%s) of volume %s: %v", devicePath, devName, volumeID, err)
+	}
+	if deviceLUN != int(expectedLUN) {
+		return status.Errorf(codes.FailedPrecondition, "device %s(%s) of volume %s is the data disk at lun %d instead of lun %d", devicePath, devName, volumeID, deviceLUN, expectedLUN)
+	}
+
+	d.verifyDiskIdentity(volumeID, devicePath, devName, diskUniqueID)
+
+	if expectedSizeBytes == 0 {
+		return nil
+	}
+	sizeBytes, err := getBlockDeviceSizeBytes(d.ioHandler, devName)
+	if err != nil {
+		return status.Errorf(codes.FailedPrecondition, "failed to verify the capacity of device %s(%s) of volume %s: %v", devicePath, devName, volumeID, err)
+	}
+	if sizeBytes < expectedSizeBytes {
+		return status.Errorf(codes.FailedPrecondition, "device %s(%s) of volume %s has %d bytes, less than the %d bytes of the volume", devicePath, devName, volumeID, sizeBytes, expectedSizeBytes)
+	}
+	return nil
+}
+
+// verifyDiskIdentity warns with an event on the node if no identifier of device devName carries diskUniqueID. The
+// identity is not verified if diskUniqueID is empty, e.g. for a volume published before the controller published it.
+func (d *DriverCore) verifyDiskIdentity(volumeID, devicePath, devName, diskUniqueID string) {
+	if diskUniqueID == "" {
+		klog.V(2).Infof("identity of device %s(%s) of volume %s is not verified, %s is not in the publish context", devicePath, devName, volumeID, consts.DiskUniqueIDField)
+		return
+	}
+	identifiers, err := getDataDiskIdentifiers(d.ioHandler, devName)
+	msg := ""
+	if err != nil {
+		msg = fmt.Sprintf("failed to verify the identity of device %s(%s) of volume %s: %v", devicePath, devName, volumeID, err)
+	} else if !hasDiskIdentifier(identifiers, diskUniqueID) {
+		msg = fmt.Sprintf("no identifier of device %s(%s) of volume %s in %q carries the unique ID %s of the disk", devicePath, devName, volumeID, identifiers, diskUniqueID)
+	}
+	if msg != "" {
+		klog.Warning(msg)
+		d.recordVolumeEvent(nil, v1.EventTypeWarning, diskIdentityUnverifiedReason, msg)
+	}
+}
+
+// hasDiskIdentifier returns whether one of the device identifiers carries the disk unique ID, the identifiers and the
+// ID are compared on their letters and digits only, e.g. the unique ID 3f2504e0-4f89-41d3-9a0c-0305e82c3301 is carried
+// by the serial 3F2504E04F8941D39A0C0305E82C3301
+func hasDiskIdentifier(identifiers []string, diskUniqueID string) bool {
+	uniqueID := normalizeDiskIdentifier(diskUniqueID)
+	if uniqueID == "" {
+		return false
+	}
+	for _, identifier := range identifiers {
+		if strings.Contains(normalizeDiskIdentifier(identifier), uniqueID) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDiskIdentifier(identifier string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'z':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return -1
+	}, identifier)
+}
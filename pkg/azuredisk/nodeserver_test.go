@@ -26,6 +26,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"syscall"
 	"testing"
 
@@ -36,6 +37,8 @@ import (
 	"go.uber.org/mock/gomock"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/record"
+	mount "k8s.io/mount-utils"
 	testingexec "k8s.io/utils/exec/testing"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
@@ -1469,3 +1472,254 @@ func TestNodePublishVolumeIdempotentMount(t *testing.T) {
 	err = os.RemoveAll(targetTest)
 	assert.NoError(t, err)
 }
+
+func TestGetSELinuxMountContext(t *testing.T) {
+	tests := []struct {
+		options  []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"rw", "relatime"}, ""},
+		{[]string{"rw", "fscontext=system_u:object_r:nfs_t:s0"}, ""},
+		{[]string{"context=system_u:object_r:container_file_t:s0"}, "system_u:object_r:container_file_t:s0"},
+		{[]string{"noatime", `context="system_u:object_r:container_file_t:s0:c0,c1"`}, "system_u:object_r:container_file_t:s0:c0,c1"},
+		{[]string{"rw", `context="system_u:object_r:container_file_t:s0:c0`, `c1"`, "relatime"}, "system_u:object_r:container_file_t:s0:c0,c1"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getSELinuxMountContext(test.options), "%v", test.options)
+	}
+	assert.Equal(t, `context="system_u:object_r:container_file_t:s0:c0,c1"`, seLinuxMountOption("system_u:object_r:container_file_t:s0:c0,c1"))
+}
+
+func TestNodePublishVolumeSELinuxMountContext(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SELinux mount context is only supported on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := NewFakeDriver(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	stagingPath, err := filepath.Abs(sourceTest)
+	require.NoError(t, err)
+	defer os.RemoveAll(targetTest)
+
+	publish := func(seLinuxContext string) error {
+		mountFlags := []string{}
+		if seLinuxContext != "" {
+			mountFlags = append(mountFlags, seLinuxMountOption(seLinuxContext))
+		}
+		_, err := d.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:          "vol_1",
+			TargetPath:        targetTest,
+			StagingTargetPath: sourceTest,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: mountFlags}},
+			},
+		})
+		return err
+	}
+
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdc", Path: stagingPath, Type: "ext4", Opts: []string{"rw", `context="system_u:object_r:container_file_t:s0:c0`, `c1"`}},
+	}
+	assert.NoError(t, publish(""))
+	assert.NoError(t, publish("system_u:object_r:container_file_t:s0:c0,c1"))
+	err = publish("system_u:object_r:container_file_t:s0:c2,c3")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.ErrorContains(t, err, `staged at `+sourceTest+` with SELinux mount context "system_u:object_r:container_file_t:s0:c0,c1"`)
+
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdc", Path: stagingPath, Type: "ext4", Opts: []string{"rw"}},
+	}
+	err = publish("system_u:object_r:container_file_t:s0:c0,c1")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.ErrorContains(t, err, "without SELinux mount context")
+}
+
+func TestGetVolumeMountGroup(t *testing.T) {
+	tests := []struct {
+		volumeCapability *csi.VolumeCapability
+		expected         int
+		expectedErr      bool
+	}{
+		{&csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}, -1, false},
+		{&csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}, -1, false},
+		{&csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: "2000"}}}, 2000, false},
+		{&csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: "-1"}}}, -1, true},
+		{&csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: "users"}}}, -1, true},
+	}
+	for _, test := range tests {
+		gid, err := getVolumeMountGroup(test.volumeCapability)
+		assert.Equal(t, test.expected, gid, "%v", test.volumeCapability)
+		if test.expectedErr {
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", test.volumeCapability)
+		} else {
+			assert.NoError(t, err, "%v", test.volumeCapability)
+		}
+	}
+	assert.NoError(t, applyVolumeMountGroup("vol_1", "/nonexistent", -1))
+}
+
+func TestDeleteStaleDevices(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("stale devices are only removed on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	sysfs := newFakeSCSISysfs()
+	d.ioHandler = sysfs
+	stagingPath, err := filepath.Abs("staging")
+	require.NoError(t, err)
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdc", Path: stagingPath, Type: "ext4"},
+		{Device: "/dev/sdc", Path: "/var/lib/kubelet/plugins/other", Type: "ext4"},
+	}
+
+	assert.Nil(t, d.getStagedDevices(stagingPath), "stale devices are not removed by default")
+	d.removeStaleDevices = true
+	devices := d.getStagedDevices(stagingPath)
+	assert.Equal(t, map[string]string{"sdc": "5:0:0:1 t10.MSFT    Virtual Disk    6002248031a1e2d1"}, devices)
+
+	d.deleteStaleDevices("vol_1", devices)
+	assert.Empty(t, sysfs.Writes(), "device mounted at another path is not removed")
+
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = nil
+	d.setNextCommandOutputScripts(commandOutput("", nil))
+	d.deleteStaleDevices("vol_1", devices)
+	assert.Equal(t, []string{"/sys/class/block/sdc/device/delete"}, sysfs.Writes())
+}
+
+func TestIsDeviceMounted(t *testing.T) {
+	mountPoints := []mount.MountPoint{{Device: "/dev/sdc1", Path: "/mnt/a"}, {Device: "/dev/sdd", Path: "/mnt/b"}}
+	assert.True(t, isDeviceMounted(mountPoints, "sdc"))
+	assert.True(t, isDeviceMounted(mountPoints, "sdd"))
+	assert.False(t, isDeviceMounted(mountPoints, "sde"))
+	assert.False(t, isDeviceMounted(mountPoints, "sd"))
+}
+
+func TestGetExpectedVolumeSizeBytes(t *testing.T) {
+	assert.Equal(t, int64(0), getExpectedVolumeSizeBytes(nil, nil))
+	assert.Equal(t, volumehelper.GiBToBytes(10), getExpectedVolumeSizeBytes(nil, map[string]string{consts.RequestedSizeGib: "10"}))
+	assert.Equal(t, volumehelper.GiBToBytes(4), getExpectedVolumeSizeBytes(map[string]string{consts.DiskSizeGBField: "4"}, map[string]string{}))
+	assert.Equal(t, int64(0), getExpectedVolumeSizeBytes(map[string]string{consts.DiskSizeGBField: "x"}, map[string]string{}))
+}
+
+func TestVerifyStagingDevice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("staging devices are only verified on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.ioHandler = newFakeSCSISysfs()
+	uniqueID := "3f2504e0-4f89-41d3-9a0c-0305e82c3301"
+
+	tests := []struct {
+		desc              string
+		devicePath        string
+		lun               string
+		diskUniqueID      string
+		expectedSizeBytes int64
+		expectedCode      codes.Code
+	}{
+		{
+			desc:              "device of the attachment",
+			devicePath:        "/dev/sdc",
+			lun:               "1",
+			diskUniqueID:      uniqueID,
+			expectedSizeBytes: volumehelper.GiBToBytes(10),
+		},
+		{
+			desc:              "device of an expanded volume",
+			devicePath:        "/dev/sdc",
+			lun:               "1",
+			diskUniqueID:      uniqueID,
+			expectedSizeBytes: volumehelper.GiBToBytes(8),
+		},
+		{
+			desc:         "volume of unknown size",
+			devicePath:   "/dev/sdc",
+			lun:          "1",
+			diskUniqueID: uniqueID,
+		},
+		{
+			desc:       "volume published without disk unique ID",
+			devicePath: "/dev/sdc",
+			lun:        "1",
+		},
+		{
+			desc:         "device with identifiers without the disk unique ID",
+			devicePath:   "/dev/sdc",
+			lun:          "1",
+			diskUniqueID: "5b2e7a4c-0d4e-4f8a-8a5e-1c2d3e4f5a6b",
+		},
+		{
+			desc:         "device at another lun",
+			devicePath:   "/dev/sdc",
+			lun:          "2",
+			diskUniqueID: uniqueID,
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			desc:              "device smaller than the volume",
+			devicePath:        "/dev/sdc",
+			lun:               "1",
+			diskUniqueID:      uniqueID,
+			expectedSizeBytes: volumehelper.GiBToBytes(16),
+			expectedCode:      codes.FailedPrecondition,
+		},
+		{
+			desc:              "device without sysfs properties",
+			devicePath:        "/dev/sdz",
+			lun:               "1",
+			diskUniqueID:      uniqueID,
+			expectedSizeBytes: volumehelper.GiBToBytes(16),
+			expectedCode:      codes.FailedPrecondition,
+		},
+		{
+			desc:         "invalid lun",
+			devicePath:   "/dev/sdc",
+			lun:          "x",
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		err := d.verifyStagingDevice("vol_1", test.devicePath, test.lun, test.diskUniqueID, test.expectedSizeBytes)
+		assert.Equal(t, test.expectedCode, status.Code(err), "%s: %v", test.desc, err)
+	}
+
+	// an unverified identity is only reported while the capacity of a device without the sysfs properties is not
+	// verified
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+	d.ioHandler = azureutils.NewFakeSysfs(map[string]string{
+		"/sys/bus/scsi/devices/5:0:0:1/vendor": "Msft    \n",
+		"/sys/bus/scsi/devices/5:0:0:1/model":  "Virtual Disk    \n",
+	}, map[string]string{
+		"/sys/class/block/sdc/device": "../../../5:0:0:1",
+	})
+	err = d.verifyStagingDevice("vol_1", "/dev/sdc", "1", uniqueID, 0)
+	assert.NoError(t, err)
+	require.Len(t, recorder.Events, 1)
+	assert.True(t, strings.HasPrefix(<-recorder.Events, "Warning "+diskIdentityUnverifiedReason))
+	err = d.verifyStagingDevice("vol_1", "/dev/sdc", "1", "", volumehelper.GiBToBytes(10))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "%v", err)
+	assert.Empty(t, recorder.Events)
+}
+
+func TestHasDiskIdentifier(t *testing.T) {
+	assert.True(t, hasDiskIdentifier([]string{"t10.MSFT", "3F2504E04F8941D39A0C0305E82C3301"}, "3f2504e0-4f89-41d3-9a0c-0305e82c3301"))
+	assert.True(t, hasDiskIdentifier([]string{"eui.3f2504e0-4f89-41d3-9a0c-0305e82c3301"}, "3F2504E0-4F89-41D3-9A0C-0305E82C3301"))
+	assert.False(t, hasDiskIdentifier([]string{"t10.MSFT    Virtual Disk    6002248031a1e2d1"}, "3f2504e0-4f89-41d3-9a0c-0305e82c3301"))
+	assert.False(t, hasDiskIdentifier([]string{"t10.MSFT"}, "-"))
+}
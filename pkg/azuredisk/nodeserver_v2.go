@@ -76,7 +76,13 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 	}
 	defer d.volumeLocks.Release(diskURI)
 
-	lun, ok := req.PublishContext[consts.LUN]
+	// wait for the attachment controller to attach the disk if the controller plug-in did not
+	publishContext, err := d.getAttachmentPublishContext(ctx, diskURI, req.GetPublishContext())
+	if err != nil {
+		return nil, err
+	}
+
+	lun, ok := publishContext[consts.LUN]
 	if !ok {
 		return nil, status.Error(codes.InvalidArgument, "lun not provided")
 	}
@@ -85,6 +91,9 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to find disk on lun %s. %v", lun, err)
 	}
+	if err = d.verifyStagingDevice(diskURI, source, lun, req.GetPublishContext()[consts.DiskUniqueIDField], getExpectedVolumeSizeBytes(req.GetPublishContext(), req.GetVolumeContext())); err != nil {
+		return nil, err
+	}
 
 	// If perf optimizations are enabled
 	// tweak device settings to enhance performance
@@ -110,12 +119,24 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
+	volumeMountGroup, err := getVolumeMountGroup(volumeCapability)
+	if err != nil {
+		return nil, err
+	}
+
 	mnt, err := d.ensureMountPoint(target)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not mount target %q: %v", target, err)
 	}
 	if mnt {
 		klog.V(2).Infof("NodeStageVolume: already mounted on target %s", target)
+		if err := d.checkSELinuxMountContext(diskURI, target, getSELinuxMountContext(volumeCapability.GetMount().GetMountFlags())); err != nil {
+			return nil, err
+		}
+		if err := applyVolumeMountGroup(diskURI, target, volumeMountGroup); err != nil {
+			return nil, err
+		}
+		d.scheduleVolumeTrim(diskURI, target, req.GetVolumeContext())
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -134,19 +155,53 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 		// respect "fstype" setting in storage class parameters
 		fstype = volContextFSType
 	}
+	if volumeMountGroup >= 0 && fsTypesWithGIDMountOption.Has(fstype) {
+		options = append(options, fmt.Sprintf("gid=%d", volumeMountGroup), "umask=002")
+	}
 
 	// If partition is specified, should mount it only instead of the entire disk.
 	if partition, ok := req.GetVolumeContext()[consts.VolumeAttributePartition]; ok {
-		source = source + "-part" + partition
+		source = getPartitionPath(source, partition)
+	}
+
+	if source, err = d.setupLocalCache(diskURI, source, req.GetVolumeContext()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	formatOptions, err := getFormatOptions(fstype, req.GetVolumeContext(), req.GetPublishContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	fsckPolicy, err := azureutils.GetFsckPolicy(req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := d.regenerateFilesystemUUID(diskURI, source, fsckPolicy, req.GetVolumeContext()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	// FormatAndMount will format only if needed
-	klog.V(2).Infof("NodeStageVolume: formatting %s and mounting at %s with mount options(%s)", source, target, options)
-	if err := d.formatAndMount(source, target, fstype, options); err != nil {
+	klog.V(2).Infof("NodeStageVolume: formatting %s and mounting at %s with mount options(%s) and format options(%s)", source, target, options, formatOptions)
+	err = d.formatAndMount(source, target, fstype, options, formatOptions)
+	if err != nil && isFilesystemCorrupted(err, fstype) && d.applyFsckPolicy(fsckPolicy, diskURI, source, fstype, req.GetVolumeContext()) {
+		klog.V(2).Infof("NodeStageVolume: mounting %s at %s again after the filesystem was repaired", source, target)
+		err = d.formatAndMount(source, target, fstype, options, formatOptions)
+	}
+	if err != nil {
+		if cacheErr := d.teardownLocalCache(diskURI); cacheErr != nil {
+			klog.Warningf("NodeStageVolume: %v", cacheErr)
+		}
 		return nil, status.Errorf(codes.Internal, "could not format %s(lun: %s), and mount it at %s, failed with %v", source, lun, target, err)
 	}
 	klog.V(2).Infof("NodeStageVolume: format %s and mounting at %s successfully.", source, target)
 
+	// a static volume on a partition is resized once the partition is grown to the end of its expanded disk
+	if _, ok := req.GetVolumeContext()[consts.VolumeAttributePartition]; ok {
+		if err := growPartition(d.ioHandler, source, d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "NodeStageVolume: could not grow partition %s of volume %s: %v", source, diskURI, err)
+		}
+	}
 	var needResize bool
 	if required, ok := req.GetVolumeContext()[consts.ResizeRequired]; ok && strings.EqualFold(required, consts.TrueValue) {
 		needResize = true
@@ -165,6 +220,10 @@ func (d *DriverV2) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 		}
 		klog.V(2).Infof("NodeStageVolume: fs resize successful on target(%s) volumeid(%s).", target, diskURI)
 	}
+	if err := applyVolumeMountGroup(diskURI, target, volumeMountGroup); err != nil {
+		return nil, err
+	}
+	d.scheduleVolumeTrim(diskURI, target, req.GetVolumeContext())
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -185,6 +244,8 @@ func (d *DriverV2) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVo
 	}
 	defer d.volumeLocks.Release(volumeID)
 
+	d.trimScheduler.remove(volumeID)
+	devices := d.getStagedDevices(stagingTargetPath)
 	klog.V(2).Infof("NodeUnstageVolume: unmounting %s", stagingTargetPath)
 	err := CleanupMountPoint(stagingTargetPath, d.mounter, false)
 	if err != nil {
@@ -192,6 +253,11 @@ func (d *DriverV2) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVo
 	}
 	klog.V(2).Infof("NodeUnstageVolume: unmount %s successfully", stagingTargetPath)
 
+	if err := d.teardownLocalCache(volumeID); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	d.deleteStaleDevices(volumeID, devices)
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
@@ -244,20 +310,40 @@ func (d *DriverV2) NodePublishVolume(ctx context.Context, req *csi.NodePublishVo
 
 	switch req.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
-		lun, ok := req.PublishContext[consts.LUN]
+		publishContext, err := d.getAttachmentPublishContext(ctx, volumeID, req.GetPublishContext())
+		if err != nil {
+			return nil, err
+		}
+		lun, ok := publishContext[consts.LUN]
 		if !ok {
 			return nil, status.Error(codes.InvalidArgument, "lun not provided")
 		}
-		var err error
 		source, err = d.getDevicePathWithLUN(lun)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to find device path with lun %s. %v", lun, err)
 		}
+		if err = d.verifyStagingDevice(volumeID, source, lun, req.GetPublishContext()[consts.DiskUniqueIDField], getExpectedVolumeSizeBytes(req.GetPublishContext(), req.GetVolumeContext())); err != nil {
+			return nil, err
+		}
 		klog.V(2).Infof("NodePublishVolume [block]: found device path %s with lun %s", source, lun)
 		if err = d.ensureBlockTargetFile(target); err != nil {
 			return nil, status.Errorf(codes.Internal, err.Error())
 		}
 	case *csi.VolumeCapability_Mount:
+		// the SELinux context of a bind mount must be the context the volume is staged with
+		if seLinuxContext := getSELinuxMountContext(volumeCapability.GetMount().GetMountFlags()); seLinuxContext != "" {
+			if err := d.checkSELinuxMountContext(volumeID, source, seLinuxContext); err != nil {
+				return nil, err
+			}
+			mountOptions = append(mountOptions, seLinuxMountOption(seLinuxContext))
+		}
+		volumeMountGroup, err := getVolumeMountGroup(volumeCapability)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyVolumeMountGroup(volumeID, source, volumeMountGroup); err != nil {
+			return nil, err
+		}
 		mnt, err := d.ensureMountPoint(target)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "could not mount target %q: %v", target, err)
@@ -268,8 +354,15 @@ func (d *DriverV2) NodePublishVolume(ctx context.Context, req *csi.NodePublishVo
 		}
 	}
 
+	added, err := d.publishTracker.add(volumeID, target, req.GetReadonly(), isSingleWriter(volumeCapability))
+	if err != nil {
+		return nil, err
+	}
 	klog.V(2).Infof("NodePublishVolume: mounting %s at %s", source, target)
 	if err := d.mounter.Mount(source, target, "", mountOptions); err != nil {
+		if added {
+			d.publishTracker.remove(volumeID, target)
+		}
 		return nil, status.Errorf(codes.Internal, "could not mount %q at %q: %v", source, target, err)
 	}
 
@@ -294,6 +387,7 @@ func (d *DriverV2) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpubli
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount target %q: %v", targetPath, err)
 	}
+	d.publishTracker.remove(volumeID, targetPath)
 
 	if acquired := d.volumeLocks.TryAcquire(volumeID); !acquired {
 		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, volumeID)
@@ -324,16 +418,16 @@ func (d *DriverV2) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest)
 	if d.supportZone {
 		var zone cloudprovider.Zone
 		if d.getNodeInfoFromLabels {
-			failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+			failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 		} else {
-			if runtime.GOOS == "windows" && (!d.cloud.UseInstanceMetadata || d.cloud.Metadata == nil) {
-				zone, err = d.cloud.VMSet.GetZoneByNodeName(d.NodeID)
+			if runtime.GOOS == "windows" && (!d.getCloud().UseInstanceMetadata || d.getCloud().Metadata == nil) {
+				zone, err = d.getCloud().VMSet.GetZoneByNodeName(d.NodeID)
 			} else {
-				zone, err = d.cloud.GetZone(ctx)
+				zone, err = d.getCloud().GetZone(ctx)
 			}
 			if err != nil {
 				klog.Warningf("get zone(%s) failed with: %v, fall back to get zone from node labels", d.NodeID, err)
-				failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				failureDomainFromLabels, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		}
 		if err != nil {
@@ -344,7 +438,7 @@ func (d *DriverV2) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest)
 		}
 
 		klog.V(2).Infof("NodeGetInfo, nodeName: %s, failureDomain: %s", d.NodeID, zone.FailureDomain)
-		if azureutils.IsValidAvailabilityZone(zone.FailureDomain, d.cloud.Location) {
+		if azureutils.IsValidAvailabilityZone(zone.FailureDomain, d.getCloud().Location) {
 			topology.Segments[topologyKey] = zone.FailureDomain
 			topology.Segments[consts.WellKnownTopologyKey] = zone.FailureDomain
 		}
@@ -356,17 +450,17 @@ func (d *DriverV2) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest)
 		var err error
 		if d.getNodeInfoFromLabels {
 			if instanceTypeFromLabels == "" {
-				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		} else {
-			if runtime.GOOS == "windows" && d.cloud.UseInstanceMetadata && d.cloud.Metadata != nil {
-				metadata, err := d.cloud.Metadata.GetMetadata(azcache.CacheReadTypeDefault)
+			if runtime.GOOS == "windows" && d.getCloud().UseInstanceMetadata && d.getCloud().Metadata != nil {
+				metadata, err := d.getCloud().Metadata.GetMetadata(azcache.CacheReadTypeDefault)
 				if err == nil && metadata.Compute != nil {
 					instanceType = metadata.Compute.VMSize
 					klog.V(5).Infof("NodeGetInfo: nodeName(%s), VM Size(%s)", d.NodeID, instanceType)
 				}
 			} else {
-				instances, ok := d.cloud.Instances()
+				instances, ok := d.getCloud().Instances()
 				if !ok {
 					klog.Warningf("failed to get instances from cloud provider")
 				} else {
@@ -378,7 +472,7 @@ func (d *DriverV2) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest)
 			}
 			if instanceType == "" && instanceTypeFromLabels == "" {
 				klog.Warningf("fall back to get instance type from node labels")
-				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.cloud.KubeClient)
+				_, instanceTypeFromLabels, err = getNodeInfoFromLabels(ctx, d.NodeID, d.getCloud().KubeClient)
 			}
 		}
 		if err != nil {
@@ -406,6 +500,7 @@ func (d *DriverV2) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolum
 	}
 
 	volUsage, err := d.GetVolumeStats(ctx, d.mounter, req.VolumeId, req.VolumePath, d.hostUtil)
+	d.updateLocalCacheMetrics(req.VolumeId)
 	return &csi.NodeGetVolumeStatsResponse{
 		Usage: volUsage,
 	}, err
@@ -458,10 +553,30 @@ func (d *DriverV2) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolu
 		return nil, status.Errorf(codes.NotFound, err.Error())
 	}
 
+	cached := isLocalCacheDevice(volumeID, devicePath)
 	if d.enableDiskOnlineResize {
-		klog.V(2).Infof("NodeExpandVolume begin to rescan device %s on volume(%s)", devicePath, volumeID)
-		if err := rescanVolume(d.ioHandler, devicePath); err != nil {
-			klog.Errorf("NodeExpandVolume rescanVolume failed with error: %v", err)
+		if cached {
+			// the disk is not the device mounted at the volume path
+			klog.V(2).Infof("NodeExpandVolume begin to rescan all devices on volume(%s)", volumeID)
+			if err := rescanAllVolumes(d.ioHandler); err != nil {
+				klog.Errorf("NodeExpandVolume rescanAllVolumes failed with error: %v", err)
+			}
+		} else {
+			klog.V(2).Infof("NodeExpandVolume begin to rescan device %s on volume(%s)", devicePath, volumeID)
+			if err := rescanVolume(d.ioHandler, devicePath); err != nil {
+				klog.Errorf("NodeExpandVolume rescanVolume failed with error: %v", err)
+			}
+		}
+	}
+	if cached {
+		if err := d.resizeLocalCache(volumeID); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not resize local cache of volume %q (%q): %v", volumeID, devicePath, err)
+		}
+	}
+
+	if !cached {
+		if err := growPartition(d.ioHandler, devicePath, d.mounter); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not grow partition of volume %q (%q): %v", volumeID, devicePath, err)
 		}
 	}
 
@@ -552,8 +667,8 @@ func (d *DriverV2) ensureMountPoint(target string) (bool, error) {
 	return !notMnt, nil
 }
 
-func (d *DriverV2) formatAndMount(source, target, fstype string, options []string) error {
-	return formatAndMount(source, target, fstype, options, d.mounter)
+func (d *DriverV2) formatAndMount(source, target, fstype string, options, formatOptions []string) error {
+	return formatAndMount(source, target, fstype, options, formatOptions, d.mounter)
 }
 
 func (d *DriverV2) getDevicePathWithLUN(lunStr string) (string, error) {
@@ -562,7 +677,9 @@ func (d *DriverV2) getDevicePathWithLUN(lunStr string) (string, error) {
 		return "", err
 	}
 
-	scsiHostRescan(d.ioHandler, d.mounter)
+	if devicePath := d.discoverDeviceWithLUN(int(lun)); devicePath != "" {
+		return devicePath, nil
+	}
 
 	newDevicePath := ""
 	err = wait.PollImmediate(1*time.Second, 2*time.Minute, func() (bool, error) {
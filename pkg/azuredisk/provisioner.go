@@ -0,0 +1,228 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/audit"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+const (
+	// ProvisionerARM provisions volumes through Azure Resource Manager
+	ProvisionerARM = "arm"
+	// ProvisionerInMemory provisions volumes in an in-process model of disks, VMs and snapshots
+	ProvisionerInMemory = "inmemory"
+)
+
+// CloudProvisioner performs the platform operations behind the CSI controller service. It is the
+// Provisioner Library described in docs/design-v2.md: the CSI handlers validate requests and translate
+// them into calls on this interface, and the implementation talks to the platform.
+type CloudProvisioner interface {
+	// CreateDisk creates a managed disk and returns its URI
+	CreateDisk(ctx context.Context, options *ManagedDiskOptions) (string, error)
+	// DeleteDisk deletes a managed disk, deleting a disk that does not exist succeeds
+	DeleteDisk(ctx context.Context, diskURI string) error
+	// GetDisk returns a managed disk
+	GetDisk(ctx context.Context, subsID, resourceGroup, diskName string) (*armcompute.Disk, error)
+	// ListDisks returns the managed disks of a resource group
+	ListDisks(ctx context.Context, resourceGroup string) ([]*armcompute.Disk, error)
+	// ResizeDisk expands a managed disk and returns its new size
+	ResizeDisk(ctx context.Context, diskURI string, oldSize, newSize resource.Quantity, supportOnlineResize bool) (resource.Quantity, error)
+	// ModifyDisk updates the SKU and performance settings of a managed disk
+	ModifyDisk(ctx context.Context, options *ManagedDiskOptions) error
+
+	// AttachDisk attaches a disk to a node and returns its lun, occupiedLuns are not used for the attachment
+	AttachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName, cachingMode armcompute.CachingTypes, disk *armcompute.Disk, occupiedLuns []int) (int32, error)
+	// DetachDisk detaches a disk from a node, detaching a disk that is not attached succeeds
+	DetachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName) error
+	// GetDiskLun returns the lun of a disk attached to a node and the provisioning state of the node
+	GetDiskLun(diskName, diskURI string, nodeName types.NodeName) (int32, *string, error)
+	// GetNodeDataDisks returns the data disks attached to a node and the provisioning state of the node
+	GetNodeDataDisks(nodeName types.NodeName, crt azcache.AzureCacheReadType) ([]*armcompute.DataDisk, *string, error)
+	// GetNodeNameByProviderID returns the name of the node with the given provider or resource ID
+	GetNodeNameByProviderID(providerID string) (types.NodeName, error)
+	// UpdateVM updates a node to recover it from a failed provisioning state
+	UpdateVM(ctx context.Context, nodeName types.NodeName) error
+
+	// CreateSnapshot creates or updates a snapshot
+	CreateSnapshot(ctx context.Context, subsID, resourceGroup, snapshotName string, snapshot armcompute.Snapshot) error
+	// GetSnapshot returns a snapshot
+	GetSnapshot(ctx context.Context, subsID, resourceGroup, snapshotName string) (*armcompute.Snapshot, error)
+	// DeleteSnapshot deletes a snapshot
+	DeleteSnapshot(ctx context.Context, subsID, resourceGroup, snapshotName string) error
+	// ListSnapshots returns the snapshots of a resource group
+	ListSnapshots(ctx context.Context, resourceGroup string) ([]*armcompute.Snapshot, error)
+}
+
+// newProvisioner returns the provisioner with the given name, nil selects the ARM provisioner built from the
+// cloud of the driver. The in-memory provisioner adds a VM for the node the driver runs on.
+func newProvisioner(name string, cloud *azure.Cloud, nodeID string) (CloudProvisioner, error) {
+	switch name {
+	case "", ProvisionerARM:
+		return nil, nil
+	case ProvisionerInMemory:
+		var subscriptionID, resourceGroup, location string
+		if cloud != nil {
+			subscriptionID, resourceGroup, location = cloud.SubscriptionID, cloud.ResourceGroup, cloud.Location
+		}
+		provisioner := NewInMemoryProvisioner(subscriptionID, resourceGroup, location)
+		if nodeID != "" {
+			provisioner.AddVM(nodeID, "", 0)
+		}
+		klog.V(2).Infof("using in-memory provisioner, subscription: %s, rg: %s, location: %s", subscriptionID, resourceGroup, location)
+		return provisioner, nil
+	default:
+		return nil, fmt.Errorf("unsupported provisioner %q, available values: %s, %s", name, ProvisionerARM, ProvisionerInMemory)
+	}
+}
+
+// getProvisioner returns the provisioner of the driver. Unless a provisioner was plugged in, the ARM provisioner
+// is built from the current cloud, client factory and disk controller of the driver.
+func (d *DriverCore) getProvisioner() CloudProvisioner {
+	if d.provisioner != nil {
+		return d.provisioner
+	}
+	d.cloudLock.RLock()
+	defer d.cloudLock.RUnlock()
+	return newARMProvisioner(d.cloud, d.clientFactory, d.diskController, d.auditSink)
+}
+
+// setProvisioner sets the provisioner field. It is intended for use with unit tests.
+func (d *DriverCore) setProvisioner(provisioner CloudProvisioner) {
+	d.provisioner = provisioner
+}
+
+// armProvisioner implements CloudProvisioner with Azure Resource Manager
+type armProvisioner struct {
+	cloud          *azure.Cloud
+	clientFactory  azclient.ClientFactory
+	diskController *ManagedDiskController
+	auditSink      audit.Sink
+}
+
+func newARMProvisioner(cloud *azure.Cloud, clientFactory azclient.ClientFactory, diskController *ManagedDiskController, auditSink audit.Sink) *armProvisioner {
+	return &armProvisioner{
+		cloud:          cloud,
+		clientFactory:  clientFactory,
+		diskController: diskController,
+		auditSink:      auditSink,
+	}
+}
+
+func (p *armProvisioner) CreateDisk(ctx context.Context, options *ManagedDiskOptions) (string, error) {
+	return p.diskController.CreateManagedDisk(ctx, options)
+}
+
+func (p *armProvisioner) DeleteDisk(ctx context.Context, diskURI string) error {
+	return p.diskController.DeleteManagedDisk(ctx, diskURI)
+}
+
+func (p *armProvisioner) GetDisk(ctx context.Context, subsID, resourceGroup, diskName string) (*armcompute.Disk, error) {
+	diskClient, err := p.clientFactory.GetDiskClientForSub(subsID)
+	if err != nil {
+		return nil, err
+	}
+	return diskClient.Get(ctx, resourceGroup, diskName)
+}
+
+func (p *armProvisioner) ListDisks(ctx context.Context, resourceGroup string) ([]*armcompute.Disk, error) {
+	return p.clientFactory.GetDiskClient().List(ctx, resourceGroup)
+}
+
+func (p *armProvisioner) ResizeDisk(ctx context.Context, diskURI string, oldSize, newSize resource.Quantity, supportOnlineResize bool) (resource.Quantity, error) {
+	return p.diskController.ResizeDisk(ctx, diskURI, oldSize, newSize, supportOnlineResize)
+}
+
+func (p *armProvisioner) ModifyDisk(ctx context.Context, options *ManagedDiskOptions) error {
+	return p.diskController.ModifyDisk(ctx, options)
+}
+
+func (p *armProvisioner) AttachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName, cachingMode armcompute.CachingTypes, disk *armcompute.Disk, occupiedLuns []int) (int32, error) {
+	return p.diskController.AttachDisk(ctx, diskName, diskURI, nodeName, cachingMode, disk, occupiedLuns)
+}
+
+func (p *armProvisioner) DetachDisk(ctx context.Context, diskName, diskURI string, nodeName types.NodeName) error {
+	return p.diskController.DetachDisk(ctx, diskName, diskURI, nodeName)
+}
+
+func (p *armProvisioner) GetDiskLun(diskName, diskURI string, nodeName types.NodeName) (int32, *string, error) {
+	return p.diskController.GetDiskLun(diskName, diskURI, nodeName)
+}
+
+func (p *armProvisioner) GetNodeDataDisks(nodeName types.NodeName, crt azcache.AzureCacheReadType) ([]*armcompute.DataDisk, *string, error) {
+	return p.diskController.GetNodeDataDisks(nodeName, crt)
+}
+
+func (p *armProvisioner) GetNodeNameByProviderID(providerID string) (types.NodeName, error) {
+	return p.cloud.VMSet.GetNodeNameByProviderID(providerID)
+}
+
+func (p *armProvisioner) UpdateVM(ctx context.Context, nodeName types.NodeName) error {
+	return p.diskController.UpdateVM(ctx, nodeName)
+}
+
+func (p *armProvisioner) CreateSnapshot(ctx context.Context, subsID, resourceGroup, snapshotName string, snapshot armcompute.Snapshot) error {
+	snapshotClient, err := p.clientFactory.GetSnapshotClientForSub(subsID)
+	if err != nil {
+		return fmt.Errorf("could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
+	}
+	ae := audit.NewEvent(ctx, p.auditSink, "CreateSnapshot", p.snapshotResourceID(subsID, resourceGroup, snapshotName))
+	_, err = snapshotClient.CreateOrUpdate(ae.Context(ctx), resourceGroup, snapshotName, snapshot)
+	ae.Observe(err)
+	return err
+}
+
+func (p *armProvisioner) GetSnapshot(ctx context.Context, subsID, resourceGroup, snapshotName string) (*armcompute.Snapshot, error) {
+	snapshotClient, err := p.clientFactory.GetSnapshotClientForSub(subsID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
+	}
+	return snapshotClient.Get(ctx, resourceGroup, snapshotName)
+}
+
+func (p *armProvisioner) DeleteSnapshot(ctx context.Context, subsID, resourceGroup, snapshotName string) error {
+	snapshotClient, err := p.clientFactory.GetSnapshotClientForSub(subsID)
+	if err != nil {
+		return fmt.Errorf("could not get snapshot client for subscription(%s) with error(%v)", subsID, err)
+	}
+	ae := audit.NewEvent(ctx, p.auditSink, "DeleteSnapshot", p.snapshotResourceID(subsID, resourceGroup, snapshotName))
+	err = snapshotClient.Delete(ae.Context(ctx), resourceGroup, snapshotName)
+	ae.Observe(err)
+	return err
+}
+
+func (p *armProvisioner) ListSnapshots(ctx context.Context, resourceGroup string) ([]*armcompute.Snapshot, error) {
+	return p.clientFactory.GetSnapshotClient().List(ctx, resourceGroup)
+}
+
+// snapshotResourceID returns the ARM resource ID of a snapshot, defaulting to the subscription of the driver
+func (p *armProvisioner) snapshotResourceID(subsID, resourceGroup, snapshotName string) string {
+	if subsID == "" {
+		subsID = p.cloud.SubscriptionID
+	}
+	return fmt.Sprintf(diskSnapshotPath, subsID, resourceGroup, snapshotName)
+}
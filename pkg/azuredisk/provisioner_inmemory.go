@@ -0,0 +1,646 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	volerr "k8s.io/cloud-provider/volume/errors"
+	volumehelpers "k8s.io/cloud-provider/volume/helpers"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+	azureconsts "sigs.k8s.io/cloud-provider-azure/pkg/consts"
+)
+
+const (
+	// defaultInMemoryMaxDataDiskCount is the number of data disks a VM of the in-memory provisioner accepts by default
+	defaultInMemoryMaxDataDiskCount = 8
+
+	virtualMachinePath = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s"
+)
+
+// inMemoryVM is a virtual machine of the in-memory provisioner
+type inMemoryVM struct {
+	name              string
+	id                string
+	zone              string
+	provisioningState string
+	maxDataDiskCount  int
+	dataDisks         []*armcompute.DataDisk
+}
+
+// InMemoryProvisioner implements CloudProvisioner with an in-process model of managed disks, virtual machines
+// and snapshots, so that the driver can run end-to-end without an Azure subscription. It keeps the semantics
+// of Azure the CSI handlers rely on: lun allocation and data disk limits of VMs, zonal disks only attaching to
+// VMs in the same zone, dangling attachments of unshared disks, and snapshots completing their background copy
+// before disks can be created from them.
+type InMemoryProvisioner struct {
+	mu             sync.Mutex
+	subscriptionID string
+	resourceGroup  string
+	location       string
+	// snapshotCompletionDelay is the time a snapshot takes to complete its background copy
+	snapshotCompletionDelay time.Duration
+	now                     func() time.Time
+
+	// disks, vms and snapshots are keyed by their lower case resource ID or, for VMs, node name
+	disks     map[string]*armcompute.Disk
+	vms       map[string]*inMemoryVM
+	snapshots map[string]*armcompute.Snapshot
+}
+
+// NewInMemoryProvisioner returns an in-memory provisioner placing resources in the given subscription,
+// resource group and location unless a request specifies otherwise
+func NewInMemoryProvisioner(subscriptionID, resourceGroup, location string) *InMemoryProvisioner {
+	return &InMemoryProvisioner{
+		subscriptionID: subscriptionID,
+		resourceGroup:  resourceGroup,
+		location:       location,
+		now:            time.Now,
+		disks:          map[string]*armcompute.Disk{},
+		vms:            map[string]*inMemoryVM{},
+		snapshots:      map[string]*armcompute.Snapshot{},
+	}
+}
+
+// AddVM adds a virtual machine for the node, zone is the zone number and empty for VMs outside availability zones.
+// A maxDataDiskCount of zero or less uses the default data disk limit.
+func (p *InMemoryProvisioner) AddVM(nodeName, zone string, maxDataDiskCount int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if maxDataDiskCount <= 0 {
+		maxDataDiskCount = defaultInMemoryMaxDataDiskCount
+	}
+	p.vms[strings.ToLower(nodeName)] = &inMemoryVM{
+		name:              nodeName,
+		id:                fmt.Sprintf(virtualMachinePath, p.subscriptionID, p.resourceGroup, nodeName),
+		zone:              zone,
+		provisioningState: "Succeeded",
+		maxDataDiskCount:  maxDataDiskCount,
+	}
+}
+
+// SetVMProvisioningState sets the provisioning state of the VM of the node
+func (p *InMemoryProvisioner) SetVMProvisioningState(nodeName, state string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	vm, ok := p.vms[strings.ToLower(nodeName)]
+	if !ok {
+		return cloudprovider.InstanceNotFound
+	}
+	vm.provisioningState = state
+	return nil
+}
+
+// SetSnapshotCompletionDelay sets the time new snapshots take to complete their background copy
+func (p *InMemoryProvisioner) SetSnapshotCompletionDelay(delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.snapshotCompletionDelay = delay
+}
+
+// newNotFoundError returns an error matching the ResourceNotFound errors of Azure Resource Manager
+func newNotFoundError(resourceType, resourceID string) error {
+	return fmt.Errorf("%s(%s) not found: %w", resourceType, resourceID, &azcore.ResponseError{
+		StatusCode: http.StatusNotFound,
+		ErrorCode:  consts.ResourceNotFound,
+	})
+}
+
+func (p *InMemoryProvisioner) defaultScope(subsID, resourceGroup string) (string, string) {
+	if subsID == "" {
+		subsID = p.subscriptionID
+	}
+	if resourceGroup == "" {
+		resourceGroup = p.resourceGroup
+	}
+	return subsID, resourceGroup
+}
+
+// zoneID returns the zone number of a zone in the "<location>-<number>" format
+func (p *InMemoryProvisioner) zoneID(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 && strings.EqualFold(zone[:i], p.location) {
+		return zone[i+1:]
+	}
+	return ""
+}
+
+// cloneDisk returns a copy of a disk the caller can use without holding the lock, disks are
+// updated by replacing their fields instead of writing through them
+func cloneDisk(disk *armcompute.Disk) *armcompute.Disk {
+	clone := *disk
+	properties := *disk.Properties
+	clone.Properties = &properties
+	clone.ManagedByExtended = append([]*string{}, disk.ManagedByExtended...)
+	return &clone
+}
+
+// snapshotCompletionPercent returns the completion percent of the background copy of a snapshot
+func (p *InMemoryProvisioner) snapshotCompletionPercent(snapshot *armcompute.Snapshot) float32 {
+	if p.snapshotCompletionDelay <= 0 {
+		return 100.0
+	}
+	elapsed := p.now().Sub(*snapshot.Properties.TimeCreated)
+	if elapsed >= p.snapshotCompletionDelay {
+		return 100.0
+	}
+	return float32(elapsed) / float32(p.snapshotCompletionDelay) * 100.0
+}
+
+// CreateDisk creates a managed disk, creating an existing disk updates its size if it grows
+func (p *InMemoryProvisioner) CreateDisk(_ context.Context, options *ManagedDiskOptions) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subsID, rg := p.defaultScope(options.SubscriptionID, options.ResourceGroup)
+	diskURI := fmt.Sprintf(managedDiskPath, subsID, rg, options.DiskName)
+	sizeGB := int32(options.SizeGB)
+
+	if existing, ok := p.disks[strings.ToLower(diskURI)]; ok {
+		if *existing.Properties.DiskSizeGB > sizeGB {
+			return "", fmt.Errorf("disk(%s) already exists with size(%d), its size cannot be reduced to %d", diskURI, *existing.Properties.DiskSizeGB, sizeGB)
+		}
+		existing.Properties.DiskSizeGB = to.Ptr(sizeGB)
+		return *existing.ID, nil
+	}
+
+	creationData, err := getValidCreationData(subsID, rg, options)
+	if err != nil {
+		return "", err
+	}
+	if creationData.SourceResourceID != nil {
+		sourceSizeGB, err := p.getSourceSize(options.SourceType, *creationData.SourceResourceID)
+		if err != nil {
+			return "", err
+		}
+		if sourceSizeGB > sizeGB {
+			return "", fmt.Errorf("disk(%s) size(%d) is smaller than its source(%s) size(%d)", diskURI, sizeGB, *creationData.SourceResourceID, sourceSizeGB)
+		}
+	}
+
+	var zones []*string
+	if zone := p.zoneID(options.AvailabilityZone); zone != "" {
+		zones = append(zones, to.Ptr(zone))
+	}
+	location := options.Location
+	if location == "" {
+		location = p.location
+	}
+	tags := map[string]*string{azureconsts.CreatedByTag: to.Ptr("kubernetes-azure-dd")}
+	for k, v := range options.Tags {
+		tags[k] = to.Ptr(v)
+	}
+	disk := &armcompute.Disk{
+		ID:       to.Ptr(diskURI),
+		Name:     to.Ptr(options.DiskName),
+		Location: to.Ptr(location),
+		Tags:     tags,
+		Zones:    zones,
+		SKU:      &armcompute.DiskSKU{Name: to.Ptr(options.StorageAccountType)},
+		Properties: &armcompute.DiskProperties{
+			CreationData:      &creationData,
+			DiskSizeGB:        to.Ptr(sizeGB),
+			DiskState:         to.Ptr(armcompute.DiskStateUnattached),
+			ProvisioningState: to.Ptr("Succeeded"),
+			TimeCreated:       to.Ptr(p.now()),
+			BurstingEnabled:   options.BurstingEnabled,
+		},
+	}
+	if options.MaxShares > 0 {
+		disk.Properties.MaxShares = to.Ptr(options.MaxShares)
+	}
+	if options.LogicalSectorSize > 0 {
+		disk.Properties.CreationData.LogicalSectorSize = to.Ptr(options.LogicalSectorSize)
+	}
+	if err := setInMemoryDiskPerformance(disk.Properties, options); err != nil {
+		return "", err
+	}
+	p.disks[strings.ToLower(diskURI)] = disk
+	klog.V(2).Infof("inMemoryProvisioner - created disk(%s) size(%d) zones(%v)", diskURI, sizeGB, options.AvailabilityZone)
+	return diskURI, nil
+}
+
+// getSourceSize returns the size of the snapshot or disk a disk is created from
+func (p *InMemoryProvisioner) getSourceSize(sourceType, sourceResourceID string) (int32, error) {
+	if sourceType == sourceSnapshot {
+		snapshot, ok := p.snapshots[strings.ToLower(sourceResourceID)]
+		if !ok {
+			return 0, newNotFoundError("snapshot", sourceResourceID)
+		}
+		if completionPercent := p.snapshotCompletionPercent(snapshot); completionPercent < 100.0 {
+			return 0, fmt.Errorf("snapshot(%s) is not ready, completion percent: %f", sourceResourceID, completionPercent)
+		}
+		return *snapshot.Properties.DiskSizeGB, nil
+	}
+	disk, ok := p.disks[strings.ToLower(sourceResourceID)]
+	if !ok {
+		return 0, newNotFoundError("disk", sourceResourceID)
+	}
+	return *disk.Properties.DiskSizeGB, nil
+}
+
+// setInMemoryDiskPerformance sets the provisioned IOPS and throughput of UltraSSD_LRS and PremiumV2_LRS disks
+func setInMemoryDiskPerformance(properties *armcompute.DiskProperties, options *ManagedDiskOptions) error {
+	if options.StorageAccountType != armcompute.DiskStorageAccountTypesUltraSSDLRS && options.StorageAccountType != armcompute.DiskStorageAccountTypesPremiumV2LRS {
+		if options.DiskIOPSReadWrite != "" || options.DiskMBpsReadWrite != "" {
+			return fmt.Errorf("AzureDisk - DiskIOPSReadWrite and DiskMBpsReadWrite parameters are only applicable in UltraSSD_LRS or PremiumV2_LRS disk type")
+		}
+		return nil
+	}
+	if options.DiskIOPSReadWrite != "" {
+		v, err := strconv.Atoi(options.DiskIOPSReadWrite)
+		if err != nil {
+			return fmt.Errorf("AzureDisk - failed to parse DiskIOPSReadWrite: %w", err)
+		}
+		properties.DiskIOPSReadWrite = pointer.Int64(int64(v))
+	}
+	if options.DiskMBpsReadWrite != "" {
+		v, err := strconv.Atoi(options.DiskMBpsReadWrite)
+		if err != nil {
+			return fmt.Errorf("AzureDisk - failed to parse DiskMBpsReadWrite: %w", err)
+		}
+		properties.DiskMBpsReadWrite = pointer.Int64(int64(v))
+	}
+	return nil
+}
+
+// DeleteDisk deletes a managed disk unless it is attached
+func (p *InMemoryProvisioner) DeleteDisk(_ context.Context, diskURI string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	disk, ok := p.disks[strings.ToLower(diskURI)]
+	if !ok {
+		klog.V(2).Infof("inMemoryProvisioner - disk(%s) is already deleted", diskURI)
+		return nil
+	}
+	if disk.ManagedBy != nil {
+		return fmt.Errorf("disk(%s) already attached to node(%s), could not be deleted", diskURI, *disk.ManagedBy)
+	}
+	delete(p.disks, strings.ToLower(diskURI))
+	return nil
+}
+
+// GetDisk returns a managed disk
+func (p *InMemoryProvisioner) GetDisk(_ context.Context, subsID, resourceGroup, diskName string) (*armcompute.Disk, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	subsID, resourceGroup = p.defaultScope(subsID, resourceGroup)
+	diskURI := fmt.Sprintf(managedDiskPath, subsID, resourceGroup, diskName)
+	disk, ok := p.disks[strings.ToLower(diskURI)]
+	if !ok {
+		return nil, newNotFoundError("disk", diskURI)
+	}
+	return cloneDisk(disk), nil
+}
+
+// ListDisks returns the managed disks of a resource group in the default subscription ordered by name
+func (p *InMemoryProvisioner) ListDisks(_ context.Context, resourceGroup string) ([]*armcompute.Disk, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prefix := strings.ToLower(fmt.Sprintf(managedDiskPath, p.subscriptionID, resourceGroup, ""))
+	disks := []*armcompute.Disk{}
+	for key, disk := range p.disks {
+		if strings.HasPrefix(key, prefix) {
+			disks = append(disks, cloneDisk(disk))
+		}
+	}
+	sort.Slice(disks, func(i, j int) bool { return *disks[i].Name < *disks[j].Name })
+	return disks, nil
+}
+
+// ResizeDisk expands a managed disk, attached disks are only expanded when online resize is supported
+func (p *InMemoryProvisioner) ResizeDisk(_ context.Context, diskURI string, oldSize, newSize resource.Quantity, supportOnlineResize bool) (resource.Quantity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	disk, ok := p.disks[strings.ToLower(diskURI)]
+	if !ok {
+		return oldSize, newNotFoundError("disk", diskURI)
+	}
+
+	requestGiB, err := volumehelpers.RoundUpToGiBInt32(newSize)
+	if err != nil {
+		return oldSize, err
+	}
+	newSizeQuant := resource.MustParse(fmt.Sprintf("%dGi", requestGiB))
+	if *disk.Properties.DiskSizeGB >= requestGiB {
+		return newSizeQuant, nil
+	}
+	if !supportOnlineResize && *disk.Properties.DiskState != armcompute.DiskStateUnattached {
+		return oldSize, fmt.Errorf("azureDisk - disk resize is only supported on Unattached disk, current disk state: %s, already attached to %s", *disk.Properties.DiskState, pointer.StringDeref(disk.ManagedBy, ""))
+	}
+	disk.Properties.DiskSizeGB = to.Ptr(requestGiB)
+	return newSizeQuant, nil
+}
+
+// ModifyDisk updates the SKU and performance settings of a managed disk
+func (p *InMemoryProvisioner) ModifyDisk(_ context.Context, options *ManagedDiskOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rg, subsID, err := getInfoFromDiskURI(options.SourceResourceID)
+	if err != nil {
+		return err
+	}
+	diskURI := fmt.Sprintf(managedDiskPath, subsID, rg, options.DiskName)
+	disk, ok := p.disks[strings.ToLower(diskURI)]
+	if !ok {
+		return newNotFoundError("disk", diskURI)
+	}
+
+	modified := *options
+	if modified.StorageAccountType == "" {
+		modified.StorageAccountType = *disk.SKU.Name
+	}
+	properties := *disk.Properties
+	if err := setInMemoryDiskPerformance(&properties, &modified); err != nil {
+		return err
+	}
+	disk.SKU = &armcompute.DiskSKU{Name: to.Ptr(modified.StorageAccountType)}
+	disk.Properties = &properties
+	return nil
+}
+
+// AttachDisk attaches a disk to the VM of a node at the lowest lun not in use and not in occupiedLuns
+func (p *InMemoryProvisioner) AttachDisk(_ context.Context, diskName, diskURI string, nodeName types.NodeName, cachingMode armcompute.CachingTypes, _ *armcompute.Disk, occupiedLuns []int) (int32, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	vm, ok := p.vms[strings.ToLower(string(nodeName))]
+	if !ok {
+		return -1, cloudprovider.InstanceNotFound
+	}
+	disk, ok := p.disks[strings.ToLower(diskURI)]
+	if !ok {
+		return -1, newNotFoundError("disk", diskURI)
+	}
+
+	for _, dataDisk := range vm.dataDisks {
+		if strings.EqualFold(*dataDisk.ManagedDisk.ID, diskURI) {
+			return *dataDisk.Lun, nil
+		}
+	}
+
+	maxShares := pointer.Int32Deref(disk.Properties.MaxShares, 1)
+	if disk.ManagedBy != nil && maxShares <= 1 {
+		attachedNode, err := p.getNodeNameByProviderID(*disk.ManagedBy)
+		if err != nil {
+			return -1, err
+		}
+		attachErr := fmt.Sprintf("disk(%s) already attached to node(%s), could not be attached to node(%s)", diskURI, *disk.ManagedBy, nodeName)
+		return -1, volerr.NewDanglingError(attachErr, attachedNode, "")
+	}
+	if int32(len(disk.ManagedByExtended)) >= maxShares {
+		return -1, fmt.Errorf("disk(%s) is already attached to %d nodes, the maximum number of shares", diskURI, maxShares)
+	}
+	if len(disk.Zones) > 0 && !strings.EqualFold(*disk.Zones[0], vm.zone) {
+		return -1, fmt.Errorf("disk(%s) in zone(%s) could not be attached to node(%s) in zone(%s)", diskURI, *disk.Zones[0], nodeName, vm.zone)
+	}
+	if len(vm.dataDisks) >= vm.maxDataDiskCount {
+		return -1, fmt.Errorf("node(%s) already has the maximum number of data disks(%d) attached", nodeName, vm.maxDataDiskCount)
+	}
+
+	usedLuns := map[int32]bool{}
+	for _, dataDisk := range vm.dataDisks {
+		usedLuns[*dataDisk.Lun] = true
+	}
+	for _, lun := range occupiedLuns {
+		usedLuns[int32(lun)] = true
+	}
+	lun := int32(0)
+	for usedLuns[lun] {
+		lun++
+	}
+	if int(lun) >= vm.maxDataDiskCount {
+		return -1, fmt.Errorf("no lun available on node(%s) for disk(%s)", nodeName, diskURI)
+	}
+
+	vm.dataDisks = append(vm.dataDisks, &armcompute.DataDisk{
+		Lun:          to.Ptr(lun),
+		Name:         to.Ptr(diskName),
+		Caching:      to.Ptr(cachingMode),
+		CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesAttach),
+		ManagedDisk:  &armcompute.ManagedDiskParameters{ID: disk.ID},
+	})
+	properties := *disk.Properties
+	properties.DiskState = to.Ptr(armcompute.DiskStateAttached)
+	disk.Properties = &properties
+	disk.ManagedByExtended = append(append([]*string{}, disk.ManagedByExtended...), to.Ptr(vm.id))
+	disk.ManagedBy = disk.ManagedByExtended[0]
+	klog.V(2).Infof("inMemoryProvisioner - attached disk(%s) to node(%s) at lun %d", diskURI, nodeName, lun)
+	return lun, nil
+}
+
+// DetachDisk detaches a disk from the VM of a node
+func (p *InMemoryProvisioner) DetachDisk(_ context.Context, _, diskURI string, nodeName types.NodeName) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	vm, ok := p.vms[strings.ToLower(string(nodeName))]
+	if !ok {
+		klog.Warningf("inMemoryProvisioner - node(%s) not found, DetachDisk(%s) will assume disk is already detached", nodeName, diskURI)
+		return nil
+	}
+
+	dataDisks := []*armcompute.DataDisk{}
+	for _, dataDisk := range vm.dataDisks {
+		if !strings.EqualFold(*dataDisk.ManagedDisk.ID, diskURI) {
+			dataDisks = append(dataDisks, dataDisk)
+		}
+	}
+	vm.dataDisks = dataDisks
+
+	disk, ok := p.disks[strings.ToLower(diskURI)]
+	if !ok {
+		return nil
+	}
+	managedBy := []*string{}
+	for _, id := range disk.ManagedByExtended {
+		if !strings.EqualFold(*id, vm.id) {
+			managedBy = append(managedBy, id)
+		}
+	}
+	properties := *disk.Properties
+	disk.ManagedByExtended = managedBy
+	disk.ManagedBy = nil
+	properties.DiskState = to.Ptr(armcompute.DiskStateUnattached)
+	if len(managedBy) > 0 {
+		disk.ManagedBy = managedBy[0]
+		properties.DiskState = to.Ptr(armcompute.DiskStateAttached)
+	}
+	disk.Properties = &properties
+	return nil
+}
+
+// GetDiskLun returns the lun of a disk attached to the VM of a node
+func (p *InMemoryProvisioner) GetDiskLun(diskName, diskURI string, nodeName types.NodeName) (int32, *string, error) {
+	dataDisks, provisioningState, err := p.GetNodeDataDisks(nodeName, azcache.CacheReadTypeDefault)
+	if err != nil {
+		return -1, provisioningState, err
+	}
+	for _, dataDisk := range dataDisks {
+		if strings.EqualFold(*dataDisk.ManagedDisk.ID, diskURI) {
+			return *dataDisk.Lun, provisioningState, nil
+		}
+	}
+	return -1, provisioningState, fmt.Errorf("%s for disk %s", azureconsts.CannotFindDiskLUN, diskName)
+}
+
+// GetNodeDataDisks returns the data disks attached to the VM of a node
+func (p *InMemoryProvisioner) GetNodeDataDisks(nodeName types.NodeName, _ azcache.AzureCacheReadType) ([]*armcompute.DataDisk, *string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	vm, ok := p.vms[strings.ToLower(string(nodeName))]
+	if !ok {
+		return nil, nil, cloudprovider.InstanceNotFound
+	}
+	dataDisks := make([]*armcompute.DataDisk, 0, len(vm.dataDisks))
+	for _, dataDisk := range vm.dataDisks {
+		clone := *dataDisk
+		dataDisks = append(dataDisks, &clone)
+	}
+	return dataDisks, to.Ptr(vm.provisioningState), nil
+}
+
+// GetNodeNameByProviderID returns the name of the node whose VM has the given provider or resource ID
+func (p *InMemoryProvisioner) GetNodeNameByProviderID(providerID string) (types.NodeName, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.getNodeNameByProviderID(providerID)
+}
+
+func (p *InMemoryProvisioner) getNodeNameByProviderID(providerID string) (types.NodeName, error) {
+	id := strings.TrimPrefix(providerID, "azure://")
+	vm, ok := p.vms[strings.ToLower(path.Base(id))]
+	if !ok || !strings.EqualFold(vm.id, id) {
+		return "", cloudprovider.InstanceNotFound
+	}
+	return types.NodeName(vm.name), nil
+}
+
+// UpdateVM recovers the VM of a node from a failed provisioning state
+func (p *InMemoryProvisioner) UpdateVM(_ context.Context, nodeName types.NodeName) error {
+	return p.SetVMProvisioningState(string(nodeName), "Succeeded")
+}
+
+// CreateSnapshot creates a snapshot of a disk, or copies a snapshot to another location
+func (p *InMemoryProvisioner) CreateSnapshot(_ context.Context, subsID, resourceGroup, snapshotName string, snapshot armcompute.Snapshot) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	subsID, resourceGroup = p.defaultScope(subsID, resourceGroup)
+	snapshotID := fmt.Sprintf(diskSnapshotPath, subsID, resourceGroup, snapshotName)
+	if snapshot.Properties == nil || snapshot.Properties.CreationData == nil || snapshot.Properties.CreationData.SourceResourceID == nil {
+		return fmt.Errorf("snapshot(%s) has no source", snapshotID)
+	}
+	sourceID := *snapshot.Properties.CreationData.SourceResourceID
+
+	if existing, ok := p.snapshots[strings.ToLower(snapshotID)]; ok {
+		if !strings.EqualFold(*existing.Properties.CreationData.SourceResourceID, sourceID) {
+			return fmt.Errorf("snapshot(%s) was created from %s, the source of an existing disk cannot be changed to %s", snapshotID, *existing.Properties.CreationData.SourceResourceID, sourceID)
+		}
+		return nil
+	}
+
+	var sizeGB int32
+	if source, ok := p.snapshots[strings.ToLower(sourceID)]; ok {
+		sizeGB = *source.Properties.DiskSizeGB
+	} else if source, ok := p.disks[strings.ToLower(sourceID)]; ok {
+		sizeGB = *source.Properties.DiskSizeGB
+	} else {
+		return newNotFoundError("source resource", sourceID)
+	}
+
+	creationData := *snapshot.Properties.CreationData
+	properties := *snapshot.Properties
+	properties.CreationData = &creationData
+	properties.DiskSizeGB = to.Ptr(sizeGB)
+	properties.ProvisioningState = to.Ptr("Succeeded")
+	properties.TimeCreated = to.Ptr(p.now())
+	location := pointer.StringDeref(snapshot.Location, "")
+	if location == "" {
+		location = p.location
+	}
+	p.snapshots[strings.ToLower(snapshotID)] = &armcompute.Snapshot{
+		ID:         to.Ptr(snapshotID),
+		Name:       to.Ptr(snapshotName),
+		Location:   to.Ptr(location),
+		Tags:       snapshot.Tags,
+		Properties: &properties,
+	}
+	klog.V(2).Infof("inMemoryProvisioner - created snapshot(%s) of %s", snapshotID, sourceID)
+	return nil
+}
+
+func (p *InMemoryProvisioner) cloneSnapshot(snapshot *armcompute.Snapshot) *armcompute.Snapshot {
+	clone := *snapshot
+	properties := *snapshot.Properties
+	if completionPercent := p.snapshotCompletionPercent(snapshot); completionPercent < 100.0 {
+		properties.CompletionPercent = to.Ptr(completionPercent)
+	}
+	clone.Properties = &properties
+	return &clone
+}
+
+// GetSnapshot returns a snapshot with the completion percent of its background copy
+func (p *InMemoryProvisioner) GetSnapshot(_ context.Context, subsID, resourceGroup, snapshotName string) (*armcompute.Snapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	subsID, resourceGroup = p.defaultScope(subsID, resourceGroup)
+	snapshotID := fmt.Sprintf(diskSnapshotPath, subsID, resourceGroup, snapshotName)
+	snapshot, ok := p.snapshots[strings.ToLower(snapshotID)]
+	if !ok {
+		return nil, newNotFoundError("snapshot", snapshotID)
+	}
+	return p.cloneSnapshot(snapshot), nil
+}
+
+// DeleteSnapshot deletes a snapshot, deleting a snapshot that does not exist succeeds
+func (p *InMemoryProvisioner) DeleteSnapshot(_ context.Context, subsID, resourceGroup, snapshotName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	subsID, resourceGroup = p.defaultScope(subsID, resourceGroup)
+	delete(p.snapshots, strings.ToLower(fmt.Sprintf(diskSnapshotPath, subsID, resourceGroup, snapshotName)))
+	return nil
+}
+
+// ListSnapshots returns the snapshots of a resource group in the default subscription ordered by name
+func (p *InMemoryProvisioner) ListSnapshots(_ context.Context, resourceGroup string) ([]*armcompute.Snapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prefix := strings.ToLower(fmt.Sprintf(diskSnapshotPath, p.subscriptionID, resourceGroup, ""))
+	snapshots := []*armcompute.Snapshot{}
+	for key, snapshot := range p.snapshots {
+		if strings.HasPrefix(key, prefix) {
+			snapshots = append(snapshots, p.cloneSnapshot(snapshot))
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return *snapshots[i].Name < *snapshots[j].Name })
+	return snapshots, nil
+}
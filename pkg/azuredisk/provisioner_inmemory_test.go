@@ -0,0 +1,340 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+	volerr "k8s.io/cloud-provider/volume/errors"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+	azcache "sigs.k8s.io/cloud-provider-azure/pkg/cache"
+)
+
+func isNotFoundError(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+func newTestInMemoryProvisioner() *InMemoryProvisioner {
+	p := NewInMemoryProvisioner("subs", "rg", "westus")
+	p.AddVM("node-1", "1", 2)
+	p.AddVM("node-2", "2", 0)
+	p.AddVM("node-3", "", 0)
+	return p
+}
+
+func TestNewProvisioner(t *testing.T) {
+	p, err := newProvisioner("", nil, "")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = newProvisioner(ProvisionerARM, nil, "")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = newProvisioner(ProvisionerInMemory, nil, "node")
+	assert.NoError(t, err)
+	_, _, err = p.GetNodeDataDisks("node", azcache.CacheReadTypeDefault)
+	assert.NoError(t, err)
+
+	_, err = newProvisioner("unknown", nil, "")
+	assert.Error(t, err)
+}
+
+func TestInMemoryProvisionerDisk(t *testing.T) {
+	ctx := context.Background()
+	p := newTestInMemoryProvisioner()
+
+	diskURI, err := p.CreateDisk(ctx, &ManagedDiskOptions{
+		DiskName:           "disk",
+		SizeGB:             10,
+		StorageAccountType: armcompute.DiskStorageAccountTypesPremiumLRS,
+		AvailabilityZone:   "westus-1",
+		Tags:               map[string]string{"key": "value"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(managedDiskPath, "subs", "rg", "disk"), diskURI)
+
+	disk, err := p.GetDisk(ctx, "", "rg", "disk")
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), *disk.Properties.DiskSizeGB)
+	assert.Equal(t, []*string{to.Ptr("1")}, disk.Zones)
+	assert.Equal(t, "value", *disk.Tags["key"])
+
+	// creating the disk again is idempotent and cannot shrink it
+	_, err = p.CreateDisk(ctx, &ManagedDiskOptions{DiskName: "disk", SizeGB: 10})
+	assert.NoError(t, err)
+	_, err = p.CreateDisk(ctx, &ManagedDiskOptions{DiskName: "disk", SizeGB: 5})
+	assert.Error(t, err)
+
+	// performance settings are only accepted by UltraSSD_LRS and PremiumV2_LRS disks
+	err = p.ModifyDisk(ctx, &ManagedDiskOptions{DiskName: "disk", SourceResourceID: diskURI, DiskIOPSReadWrite: "100"})
+	assert.Error(t, err)
+	err = p.ModifyDisk(ctx, &ManagedDiskOptions{
+		DiskName:           "disk",
+		SourceResourceID:   diskURI,
+		StorageAccountType: armcompute.DiskStorageAccountTypesPremiumV2LRS,
+		DiskIOPSReadWrite:  "5000",
+	})
+	require.NoError(t, err)
+	disk, err = p.GetDisk(ctx, "subs", "rg", "disk")
+	require.NoError(t, err)
+	assert.Equal(t, armcompute.DiskStorageAccountTypesPremiumV2LRS, *disk.SKU.Name)
+	assert.Equal(t, int64(5000), *disk.Properties.DiskIOPSReadWrite)
+
+	newSize, err := p.ResizeDisk(ctx, diskURI, resource.MustParse("10Gi"), resource.MustParse("20Gi"), false)
+	require.NoError(t, err)
+	assert.Equal(t, resource.MustParse("20Gi"), newSize)
+
+	disks, err := p.ListDisks(ctx, "rg")
+	require.NoError(t, err)
+	assert.Len(t, disks, 1)
+
+	assert.NoError(t, p.DeleteDisk(ctx, diskURI))
+	_, err = p.GetDisk(ctx, "subs", "rg", "disk")
+	assert.True(t, isNotFoundError(err))
+	assert.NoError(t, p.DeleteDisk(ctx, diskURI))
+}
+
+func TestInMemoryProvisionerAttachDetach(t *testing.T) {
+	ctx := context.Background()
+	p := newTestInMemoryProvisioner()
+
+	createDisk := func(name, zone string, maxShares int32) string {
+		diskURI, err := p.CreateDisk(ctx, &ManagedDiskOptions{
+			DiskName:           name,
+			SizeGB:             10,
+			StorageAccountType: armcompute.DiskStorageAccountTypesPremiumLRS,
+			AvailabilityZone:   zone,
+			MaxShares:          maxShares,
+		})
+		require.NoError(t, err)
+		return diskURI
+	}
+	zonalDisk := createDisk("zonal", "westus-1", 0)
+	sharedDisk := createDisk("shared", "", 2)
+	otherDisk := createDisk("other", "", 0)
+	thirdDisk := createDisk("third", "", 0)
+
+	_, err := p.AttachDisk(ctx, "zonal", zonalDisk, "unknown", armcompute.CachingTypesNone, nil, nil)
+	assert.Equal(t, cloudprovider.InstanceNotFound, err)
+	_, err = p.AttachDisk(ctx, "zonal", zonalDisk, "node-2", armcompute.CachingTypesNone, nil, nil)
+	assert.Error(t, err, "zonal disk must not attach to a VM in another zone")
+
+	lun, err := p.AttachDisk(ctx, "zonal", zonalDisk, "node-1", armcompute.CachingTypesNone, nil, []int{0})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), lun, "occupied luns must be skipped")
+	lun, err = p.AttachDisk(ctx, "zonal", zonalDisk, "node-1", armcompute.CachingTypesNone, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), lun, "attaching an attached disk returns its lun")
+
+	lun, err = p.AttachDisk(ctx, "shared", sharedDisk, "node-1", armcompute.CachingTypesNone, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), lun)
+	_, err = p.AttachDisk(ctx, "other", otherDisk, "node-1", armcompute.CachingTypesNone, nil, nil)
+	assert.Error(t, err, "VM must not exceed its data disk limit")
+
+	_, err = p.AttachDisk(ctx, "shared", sharedDisk, "node-2", armcompute.CachingTypesNone, nil, nil)
+	assert.NoError(t, err, "shared disk attaches to several VMs")
+	_, err = p.AttachDisk(ctx, "shared", sharedDisk, "node-3", armcompute.CachingTypesNone, nil, nil)
+	assert.Error(t, err, "shared disk must not exceed its maximum number of shares")
+
+	_, err = p.AttachDisk(ctx, "other", otherDisk, "node-2", armcompute.CachingTypesNone, nil, nil)
+	require.NoError(t, err)
+	_, err = p.AttachDisk(ctx, "other", otherDisk, "node-3", armcompute.CachingTypesNone, nil, nil)
+	var danglingErr *volerr.DanglingAttachError
+	require.ErrorAs(t, err, &danglingErr)
+	assert.Equal(t, types.NodeName("node-2"), danglingErr.CurrentNode)
+
+	lun, state, err := p.GetDiskLun("zonal", zonalDisk, "node-1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), lun)
+	assert.Equal(t, "Succeeded", *state)
+	_, _, err = p.GetDiskLun("third", thirdDisk, "node-1")
+	assert.ErrorContains(t, err, "cannot find Lun")
+
+	assert.Error(t, p.DeleteDisk(ctx, otherDisk), "attached disk must not be deleted")
+	assert.NoError(t, p.DetachDisk(ctx, "other", otherDisk, "node-2"))
+	assert.NoError(t, p.DetachDisk(ctx, "other", otherDisk, "node-2"))
+	assert.NoError(t, p.DetachDisk(ctx, "other", otherDisk, "unknown"))
+	disk, err := p.GetDisk(ctx, "subs", "rg", "other")
+	require.NoError(t, err)
+	assert.Equal(t, armcompute.DiskStateUnattached, *disk.Properties.DiskState)
+	assert.Nil(t, disk.ManagedBy)
+
+	assert.NoError(t, p.DetachDisk(ctx, "shared", sharedDisk, "node-1"))
+	disk, err = p.GetDisk(ctx, "subs", "rg", "shared")
+	require.NoError(t, err)
+	assert.Equal(t, armcompute.DiskStateAttached, *disk.Properties.DiskState)
+	assert.Len(t, disk.ManagedByExtended, 1)
+
+	nodeName, err := p.GetNodeNameByProviderID("azure://" + *disk.ManagedBy)
+	require.NoError(t, err)
+	assert.Equal(t, types.NodeName("node-2"), nodeName)
+
+	assert.NoError(t, p.SetVMProvisioningState("node-1", "Failed"))
+	_, state, err = p.GetNodeDataDisks("node-1", azcache.CacheReadTypeDefault)
+	require.NoError(t, err)
+	assert.Equal(t, "Failed", *state)
+	assert.NoError(t, p.UpdateVM(ctx, "node-1"))
+	_, state, err = p.GetNodeDataDisks("node-1", azcache.CacheReadTypeDefault)
+	require.NoError(t, err)
+	assert.Equal(t, "Succeeded", *state)
+}
+
+func TestInMemoryProvisionerSnapshot(t *testing.T) {
+	ctx := context.Background()
+	p := newTestInMemoryProvisioner()
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	p.SetSnapshotCompletionDelay(time.Minute)
+
+	diskURI, err := p.CreateDisk(ctx, &ManagedDiskOptions{DiskName: "disk", SizeGB: 10})
+	require.NoError(t, err)
+	snapshot := armcompute.Snapshot{
+		Properties: &armcompute.SnapshotProperties{
+			CreationData: &armcompute.CreationData{
+				CreateOption:     to.Ptr(armcompute.DiskCreateOptionCopy),
+				SourceResourceID: to.Ptr(diskURI),
+			},
+		},
+	}
+	require.NoError(t, p.CreateSnapshot(ctx, "", "rg", "snapshot", snapshot))
+	require.NoError(t, p.CreateSnapshot(ctx, "", "rg", "snapshot", snapshot))
+
+	otherSnapshot := snapshot
+	otherSnapshot.Properties = &armcompute.SnapshotProperties{
+		CreationData: &armcompute.CreationData{SourceResourceID: to.Ptr(fmt.Sprintf(managedDiskPath, "subs", "rg", "other"))},
+	}
+	assert.Error(t, p.CreateSnapshot(ctx, "", "rg", "snapshot", otherSnapshot), "existing snapshot must keep its source")
+	assert.Error(t, p.CreateSnapshot(ctx, "", "rg", "missing", otherSnapshot), "snapshot source must exist")
+
+	snapshotID := fmt.Sprintf(diskSnapshotPath, "subs", "rg", "snapshot")
+	options := &ManagedDiskOptions{
+		DiskName:         "restored",
+		SizeGB:           10,
+		SourceType:       sourceSnapshot,
+		SourceResourceID: snapshotID,
+	}
+	now = now.Add(30 * time.Second)
+	result, err := p.GetSnapshot(ctx, "subs", "rg", "snapshot")
+	require.NoError(t, err)
+	assert.Equal(t, float32(50.0), *result.Properties.CompletionPercent)
+	_, err = p.CreateDisk(ctx, options)
+	assert.Error(t, err, "disk must not be created from an incomplete snapshot")
+
+	now = now.Add(time.Minute)
+	result, err = p.GetSnapshot(ctx, "subs", "rg", "snapshot")
+	require.NoError(t, err)
+	assert.Nil(t, result.Properties.CompletionPercent)
+	assert.Equal(t, int32(10), *result.Properties.DiskSizeGB)
+	_, err = p.CreateDisk(ctx, options)
+	assert.NoError(t, err)
+
+	snapshots, err := p.ListSnapshots(ctx, "rg")
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	assert.NoError(t, p.DeleteSnapshot(ctx, "", "rg", "snapshot"))
+	_, err = p.GetSnapshot(ctx, "subs", "rg", "snapshot")
+	assert.True(t, isNotFoundError(err))
+	assert.NoError(t, p.DeleteSnapshot(ctx, "", "rg", "snapshot"))
+}
+
+func TestInMemoryProvisionerControllerLifecycle(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := NewFakeDriver(cntl)
+	require.NoError(t, err)
+
+	cloud := d.getCloud()
+	p := NewInMemoryProvisioner(cloud.SubscriptionID, cloud.ResourceGroup, cloud.Location)
+	p.AddVM(fakeNodeID, "", 0)
+	d.setProvisioner(p)
+
+	ctx := context.Background()
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+
+	createResp, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "lifecycle",
+		VolumeCapabilities: volumeCapabilities,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: volumehelper.GiBToBytes(10)},
+		Parameters:         map[string]string{consts.SkuNameField: "Premium_LRS"},
+	})
+	require.NoError(t, err)
+	volumeID := createResp.Volume.VolumeId
+
+	publishResp, err := d.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volumeID,
+		NodeId:           fakeNodeID,
+		VolumeCapability: volumeCapabilities[0],
+		VolumeContext:    createResp.Volume.VolumeContext,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0", publishResp.PublishContext[consts.LUN])
+
+	snapshotResp, err := d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		SourceVolumeId: volumeID,
+		Name:           "lifecycle-snapshot",
+	})
+	require.NoError(t, err)
+	assert.True(t, snapshotResp.Snapshot.ReadyToUse)
+
+	_, err = d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "lifecycle-restored",
+		VolumeCapabilities: volumeCapabilities,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: volumehelper.GiBToBytes(10)},
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotResp.Snapshot.SnapshotId},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = d.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID, NodeId: fakeNodeID})
+	require.NoError(t, err)
+	_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snapshotResp.Snapshot.SnapshotId})
+	require.NoError(t, err)
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	require.NoError(t, err)
+
+	_, err = p.GetDisk(ctx, cloud.SubscriptionID, cloud.ResourceGroup, "lifecycle")
+	assert.True(t, isNotFoundError(err))
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// publishTracker tracks the target paths the volumes are published at on the node, so that a volume published with
+// the SINGLE_NODE_SINGLE_WRITER access mode is published read/write at most at one target path. The targets are
+// loaded from the mount table before the first publish, so that the volumes published before a restart of the node
+// driver are tracked, and a conflicting target that is no longer mounted, e.g. removed by a node reboot without
+// NodeUnpublishVolume, is dropped.
+type publishTracker struct {
+	sync.Mutex
+	// targets are the target paths of the published volumes and whether they are read-only
+	// <lower case volume ID, <target, readOnly>>
+	targets map[string]map[string]bool
+	// list returns the targets of the volumes published on the node from the mount table
+	list func() (map[string]map[string]bool, error)
+	// isMounted returns whether a target path is still mounted
+	isMounted func(target string) bool
+	loaded    bool
+}
+
+func newPublishTracker(list func() (map[string]map[string]bool, error), isMounted func(target string) bool) *publishTracker {
+	return &publishTracker{targets: map[string]map[string]bool{}, list: list, isMounted: isMounted}
+}
+
+// add records that the volume is published at target. The publish of a volume with a single writer that is not
+// read-only fails with FailedPrecondition if the volume is published read/write at another target. add returns
+// whether target was not tracked yet, so that a failed publish removes only the target it added.
+func (p *publishTracker) add(volumeID, target string, readOnly, singleWriter bool) (bool, error) {
+	if p == nil {
+		return false, nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	if !p.loaded {
+		targets, err := p.list()
+		if err != nil {
+			if singleWriter && !readOnly {
+				return false, status.Errorf(codes.Internal, "failed to list the targets of published volumes: %v", err)
+			}
+			klog.Warningf("failed to list the targets of published volumes: %v", err)
+		} else {
+			for id, volumeTargets := range targets {
+				for path, ro := range volumeTargets {
+					p.set(id, path, ro)
+				}
+			}
+			p.loaded = true
+		}
+	}
+
+	key := strings.ToLower(volumeID)
+	if singleWriter && !readOnly {
+		for path, ro := range p.targets[key] {
+			if path != target && !ro {
+				if !p.isMounted(path) {
+					klog.Warningf("volume %s is no longer mounted at %s, dropping the target", volumeID, path)
+					delete(p.targets[key], path)
+					continue
+				}
+				return false, status.Errorf(codes.FailedPrecondition, "volume %s with a single writer is already published read/write at %s", volumeID, path)
+			}
+		}
+	}
+	_, tracked := p.targets[key][target]
+	p.set(key, target, readOnly)
+	return !tracked, nil
+}
+
+// remove records that the volume is no longer published at target
+func (p *publishTracker) remove(volumeID, target string) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	key := strings.ToLower(volumeID)
+	delete(p.targets[key], target)
+	if len(p.targets[key]) == 0 {
+		delete(p.targets, key)
+	}
+}
+
+func (p *publishTracker) set(volumeID, target string, readOnly bool) {
+	key := strings.ToLower(volumeID)
+	if p.targets[key] == nil {
+		p.targets[key] = map[string]bool{}
+	}
+	p.targets[key][target] = readOnly
+}
+
+// isSingleWriter returns whether the access mode of the volume capability allows a single writer on the node
+func isSingleWriter(volumeCapability *csi.VolumeCapability) bool {
+	return volumeCapability.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER
+}
+
+// getPublishedVolumeDataDir returns the directory of the vol_data.json file of kubelet for the target path of a
+// volume published under the kubelet root directory, ok is false if target is not the target path of a CSI volume
+func getPublishedVolumeDataDir(kubeletRootDir, target string) (string, bool) {
+	// filesystem volumes are published at <kubelet>/pods/<pod uid>/volumes/kubernetes.io~csi/<pv name>/mount
+	if ok, _ := filepath.Match(filepath.Join(kubeletRootDir, "pods", "*", "volumes", "kubernetes.io~csi", "*", "mount"), target); ok {
+		return filepath.Dir(target), true
+	}
+	// block volumes are published at <kubelet>/plugins/kubernetes.io/csi/volumeDevices/publish/<pv name>/<pod uid>
+	// with their data in <kubelet>/plugins/kubernetes.io/csi/volumeDevices/<pv name>/data
+	volumeDevicesDir := filepath.Join(kubeletRootDir, "plugins", "kubernetes.io", "csi", "volumeDevices")
+	if ok, _ := filepath.Match(filepath.Join(volumeDevicesDir, "publish", "*", "*"), target); ok {
+		return filepath.Join(volumeDevicesDir, filepath.Base(filepath.Dir(target)), "data"), true
+	}
+	return "", false
+}
+
+// isTargetMounted returns whether target is a mount point, it returns true if it fails to check so that a target
+// is only dropped by the publish tracker once it is known to be gone
+func (d *DriverCore) isTargetMounted(target string) bool {
+	if runtime.GOOS == "windows" {
+		mnt, err := d.mounter.IsMountPoint(target)
+		if err != nil && !os.IsNotExist(err) {
+			klog.Warningf("failed to check whether %s is a mount point: %v", target, err)
+			return true
+		}
+		return mnt
+	}
+	// the mount table lists the bind mounts IsLikelyNotMountPoint misses
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		klog.Warningf("failed to check whether %s is a mount point: %v", target, err)
+		return true
+	}
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Path == target {
+			return true
+		}
+	}
+	return false
+}
+
+// listPublishedTargets returns the target paths of the volumes of the driver in the mount table of the node and
+// whether they are read-only <volume ID, <target, readOnly>>
+func (d *DriverCore) listPublishedTargets() (map[string]map[string]bool, error) {
+	targets := map[string]map[string]bool{}
+	if runtime.GOOS == "windows" {
+		return targets, nil
+	}
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, mountPoint := range mountPoints {
+		dir, ok := getPublishedVolumeDataDir(d.kubeletRootDir, mountPoint.Path)
+		if !ok {
+			continue
+		}
+		data, err := readVolumeData(dir)
+		if err != nil {
+			klog.Warningf("failed to find the volume published at %s: %v", mountPoint.Path, err)
+			continue
+		}
+		if data.DriverName != d.Name || data.VolumeHandle == "" {
+			continue
+		}
+		if targets[data.VolumeHandle] == nil {
+			targets[data.VolumeHandle] = map[string]bool{}
+		}
+		targets[data.VolumeHandle][mountPoint.Path] = slices.Contains(mountPoint.Opts, "ro")
+	}
+	return targets, nil
+}
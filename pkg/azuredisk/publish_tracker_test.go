@@ -0,0 +1,183 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+)
+
+func TestPublishTracker(t *testing.T) {
+	unmounted := map[string]bool{}
+	isMounted := func(target string) bool { return !unmounted[target] }
+	tracker := newPublishTracker(func() (map[string]map[string]bool, error) {
+		return map[string]map[string]bool{"vol-1": {"/target-1": false}}, nil
+	}, isMounted)
+
+	_, err := tracker.add("vol-1", "/target-2", false, true)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "vol-1 is published read/write at the target loaded from the mount table")
+	added, err := tracker.add("VOL-1", "/target-1", false, true)
+	assert.NoError(t, err)
+	assert.False(t, added, "a publish at the same target is idempotent")
+	added, err = tracker.add("vol-1", "/target-2", true, true)
+	assert.NoError(t, err)
+	assert.True(t, added, "a read-only publish is allowed")
+
+	tracker.remove("vol-1", "/target-1")
+	_, err = tracker.add("vol-1", "/target-3", false, true)
+	assert.NoError(t, err)
+	_, err = tracker.add("vol-1", "/target-4", false, false)
+	assert.NoError(t, err, "a publish with several writers is not checked")
+
+	tracker.remove("vol-1", "/target-4")
+
+	_, err = tracker.add("vol-1", "/target-5", false, true)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "vol-1 is published read/write at /target-3")
+	unmounted["/target-3"] = true
+	added, err = tracker.add("vol-1", "/target-5", false, true)
+	assert.NoError(t, err, "a target that is no longer mounted is dropped")
+	assert.True(t, added)
+	assert.NotContains(t, tracker.targets["vol-1"], "/target-3")
+
+	for _, target := range []string{"/target-2", "/target-5"} {
+		tracker.remove("vol-1", target)
+	}
+	assert.Empty(t, tracker.targets)
+
+	tracker = newPublishTracker(func() (map[string]map[string]bool, error) {
+		return nil, fmt.Errorf("mount table not found")
+	}, isMounted)
+	_, err = tracker.add("vol-1", "/target-1", false, true)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	_, err = tracker.add("vol-1", "/target-1", true, true)
+	assert.NoError(t, err)
+	assert.False(t, tracker.loaded)
+
+	var nilTracker *publishTracker
+	_, err = nilTracker.add("vol-1", "/target-1", false, true)
+	assert.NoError(t, err)
+}
+
+func TestGetPublishedVolumeDataDir(t *testing.T) {
+	tests := []struct {
+		target      string
+		expectedDir string
+	}{
+		{"/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv-1/mount", "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv-1"},
+		{"/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/pv-1/uid", "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/pv-1/data"},
+		{"/var/lib/kubelet/plugins/kubernetes.io/csi/disk.csi.azure.com/0123abcd/globalmount", ""},
+		{"/mnt/pv-1/mount", ""},
+	}
+	for _, test := range tests {
+		dir, ok := getPublishedVolumeDataDir("/var/lib/kubelet", test.target)
+		assert.Equal(t, test.expectedDir != "", ok, test.target)
+		assert.Equal(t, test.expectedDir, dir, test.target)
+	}
+}
+
+func TestListPublishedTargets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("published targets are not listed on Windows")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	d.kubeletRootDir = t.TempDir()
+
+	target := filepath.Join(d.kubeletRootDir, "pods/uid-1/volumes/kubernetes.io~csi/pv-1/mount")
+	blockTarget := filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi/volumeDevices/publish/pv-2/uid-1")
+	otherTarget := filepath.Join(d.kubeletRootDir, "pods/uid-1/volumes/kubernetes.io~csi/pv-3/mount")
+	writeVolumeData(t, target, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-1"})
+	blockData := filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi/volumeDevices/pv-2/data")
+	require.NoError(t, os.MkdirAll(blockData, 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(blockData, "vol_data.json"), []byte(`{"driverName":"`+fakeDriverName+`","volumeHandle":"vol-2"}`), 0600))
+	writeVolumeData(t, otherTarget, volumeData{DriverName: "file.csi.azure.com", VolumeHandle: "vol-3"})
+	fakeMounter.Interface.(*mounter.FakeSafeMounter).MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdc", Path: filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi", fakeDriverName, "0123abcd/globalmount")},
+		{Device: "/dev/sdc", Path: target, Opts: []string{"rw", "relatime"}},
+		{Device: "devtmpfs", Path: blockTarget, Opts: []string{"ro"}},
+		{Device: "//account/share", Path: otherTarget},
+	}
+
+	targets, err := d.listPublishedTargets()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]bool{
+		"vol-1": {target: false},
+		"vol-2": {blockTarget: true},
+	}, targets)
+}
+
+func TestNodePublishVolumeSingleWriter(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("published targets are only listed on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	d.kubeletRootDir = t.TempDir()
+
+	dir := t.TempDir()
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:          "vol-1",
+		StagingTargetPath: filepath.Join(dir, "staging"),
+		TargetPath:        filepath.Join(dir, "target-1"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER},
+		},
+	}
+	_, err = d.NodePublishVolume(context.Background(), req)
+	require.NoError(t, err)
+	fakeSafeMounter := fakeMounter.Interface.(*mounter.FakeSafeMounter)
+	fakeSafeMounter.MountPoints = []mount.MountPoint{{Device: "/dev/sdc", Path: req.TargetPath}}
+
+	req.TargetPath = filepath.Join(dir, "target-2")
+	_, err = d.NodePublishVolume(context.Background(), req)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = d.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol-1", TargetPath: filepath.Join(dir, "target-1")})
+	require.NoError(t, err)
+	fakeSafeMounter.MountPoints = nil
+	_, err = d.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err, "the volume is published again once it is unpublished")
+
+	req.TargetPath = filepath.Join(dir, "target-3")
+	_, err = d.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err, "target-2 is dropped since it is no longer mounted")
+}
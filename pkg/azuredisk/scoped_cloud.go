@@ -0,0 +1,134 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+)
+
+// scopedCloud is a cloud with a different identity or user agent than the cloud of the driver, together with
+// the provisioner that manages disks and snapshots with it
+type scopedCloud struct {
+	cloud       *azure.Cloud
+	provisioner CloudProvisioner
+}
+
+// scopedCloudCacheSize is the number of clouds built from secrets that are cached, secrets are rotated by
+// replacing their values so the clouds of the previous values are evicted once they are no longer used
+const scopedCloudCacheSize = 32
+
+type scopedProvisionerKey struct{}
+
+// withScopedCloud returns the cloud and provisioner a controller operation runs with. Operations with
+// provisioner or snapshotter secrets run with a cloud built from the credentials of the secrets, which is
+// cached per secret so that each tenant keeps its clients and caches. Operations with only a user agent run
+// with a cloud rebuilt from the cloud config secret of the driver. The returned ctx carries the provisioner
+// so that helpers called on behalf of the operation use it as well.
+func (d *DriverCore) withScopedCloud(ctx context.Context, secrets map[string]string, userAgent string) (context.Context, *azure.Cloud, CloudProvisioner, error) {
+	// secrets and user agent only apply to requests sent to Azure Resource Manager
+	if d.provisioner != nil || (len(secrets) == 0 && userAgent == "") {
+		return ctx, d.getCloud(), d.getProvisioner(), nil
+	}
+
+	var scoped *scopedCloud
+	if len(secrets) == 0 {
+		cloud, err := azureutils.GetCloudProviderFromClient(ctx, d.kubeClient, d.cloudConfigSecretName, d.cloudConfigSecretNamespace, userAgent,
+			d.allowEmptyCloudConfig, d.enableTrafficManager, d.trafficManagerPort)
+		if err != nil {
+			return ctx, nil, nil, status.Errorf(codes.Internal, "create cloud with UserAgent(%s) failed with: (%s)", userAgent, err)
+		}
+		scoped = d.newScopedCloud(cloud)
+	} else {
+		key := scopedCloudKey(secrets, userAgent)
+		if cached, ok := d.scopedClouds.Get(key); ok {
+			scoped = cached.(*scopedCloud)
+		} else {
+			if userAgent == "" {
+				userAgent = GetUserAgent(d.Name, d.customUserAgent, d.userAgentSuffix)
+			}
+			var baseConfig *azure.Config
+			if cloud := d.getCloud(); cloud != nil {
+				baseConfig = &cloud.Config
+			}
+			cloud, err := azureutils.GetCloudProviderFromSecrets(ctx, baseConfig, secrets, userAgent, d.enableTrafficManager, d.trafficManagerPort)
+			if err != nil {
+				return ctx, nil, nil, status.Errorf(codes.InvalidArgument, "create cloud from secrets failed with: (%v)", err)
+			}
+			klog.V(2).Infof("created cloud from secrets, subscription: %s, rg: %s", cloud.SubscriptionID, cloud.ResourceGroup)
+			scoped = d.newScopedCloud(cloud)
+			d.scopedClouds.Add(key, scoped)
+		}
+	}
+	return context.WithValue(ctx, scopedProvisionerKey{}, scoped.provisioner), scoped.cloud, scoped.provisioner, nil
+}
+
+// getContextProvisioner returns the provisioner of the operation ctx belongs to, see withScopedCloud
+func (d *DriverCore) getContextProvisioner(ctx context.Context) CloudProvisioner {
+	if provisioner, ok := ctx.Value(scopedProvisionerKey{}).(CloudProvisioner); ok {
+		return provisioner
+	}
+	return d.getProvisioner()
+}
+
+// newScopedCloud returns the ARM provisioner for cloud, configured like the disk controller of the driver
+func (d *DriverCore) newScopedCloud(cloud *azure.Cloud) *scopedCloud {
+	diskController := &ManagedDiskController{
+		controllerCommon: &controllerCommon{
+			cloud:               cloud,
+			lockMap:             newLockMap(),
+			DisableDiskLunCheck: true,
+			clientFactory:       cloud.ComputeClientFactory,
+			ForceDetachBackoff:  d.forceDetachBackoff,
+		},
+	}
+	diskController.DisableUpdateCache = d.disableUpdateCache
+	diskController.AttachDetachInitialDelayInMs = int(d.attachDetachInitialDelayInMs)
+	diskController.AuditSink = d.auditSink
+	return &scopedCloud{
+		cloud:       cloud,
+		provisioner: newARMProvisioner(cloud, cloud.ComputeClientFactory, diskController, d.auditSink),
+	}
+}
+
+// scopedCloudKey returns the cache key of the cloud built from secrets and userAgent, the secrets are hashed
+// so that they are not kept in memory in plain text beyond the cloud config
+func scopedCloudKey(secrets map[string]string, userAgent string) string {
+	keys := make([]string, 0, len(secrets))
+	for k := range secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(secrets[k]))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte(userAgent))
+	return hex.EncodeToString(h.Sum(nil))
+}
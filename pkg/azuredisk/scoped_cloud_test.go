@@ -0,0 +1,94 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestScopedCloudKey(t *testing.T) {
+	secrets := map[string]string{consts.SecretAADClientIDField: "client", consts.SecretAADClientSecretField: "secret"}
+	key := scopedCloudKey(secrets, "")
+	assert.Equal(t, key, scopedCloudKey(map[string]string{consts.SecretAADClientSecretField: "secret", consts.SecretAADClientIDField: "client"}, ""))
+	assert.NotEqual(t, key, scopedCloudKey(secrets, "useragent"))
+	assert.NotEqual(t, key, scopedCloudKey(map[string]string{consts.SecretAADClientIDField: "client", consts.SecretAADClientSecretField: "rotated"}, ""))
+	assert.NotContains(t, key, "secret")
+}
+
+func TestWithScopedCloud(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	scopedCtx, cloud, provisioner, err := d.withScopedCloud(ctx, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, ctx, scopedCtx)
+	assert.Equal(t, d.cloud, cloud)
+	assert.NotNil(t, provisioner)
+
+	secrets := map[string]string{
+		consts.SecretSubscriptionIDField:  "tenant-subscription",
+		consts.SecretAADClientIDField:     "client",
+		consts.SecretAADClientSecretField: "secret",
+	}
+	scopedCtx, cloud, provisioner, err = d.withScopedCloud(ctx, secrets, "")
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-subscription", cloud.SubscriptionID)
+	assert.Equal(t, d.cloud.ResourceGroup, cloud.ResourceGroup)
+	assert.Equal(t, provisioner, d.getContextProvisioner(scopedCtx))
+
+	_, cachedCloud, cachedProvisioner, err := d.withScopedCloud(ctx, secrets, "")
+	require.NoError(t, err)
+	assert.Same(t, cloud, cachedCloud, "cloud must be cached per secret")
+	assert.Equal(t, provisioner, cachedProvisioner)
+
+	// the clouds of rotated secrets are evicted once the cache is full
+	for i := 0; i < scopedCloudCacheSize; i++ {
+		rotated := map[string]string{
+			consts.SecretSubscriptionIDField:  "tenant-subscription",
+			consts.SecretAADClientIDField:     "client",
+			consts.SecretAADClientSecretField: fmt.Sprintf("rotated-%d", i),
+		}
+		_, _, _, err = d.withScopedCloud(ctx, rotated, "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, scopedCloudCacheSize, d.scopedClouds.Len())
+	_, ok := d.scopedClouds.Get(scopedCloudKey(secrets, ""))
+	assert.False(t, ok, "least recently used cloud must be evicted")
+
+	_, _, _, err = d.withScopedCloud(ctx, map[string]string{"unknown": "value"}, "")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	inMemory := NewInMemoryProvisioner("subs", "rg", "westus")
+	d.setProvisioner(inMemory)
+	_, cloud, provisioner, err = d.withScopedCloud(ctx, secrets, "")
+	require.NoError(t, err)
+	assert.Equal(t, d.cloud, cloud)
+	assert.Equal(t, inMemory, provisioner)
+}
@@ -0,0 +1,372 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+// A striped volume is made of stripeCount member disks created with the same parameters. Its volume ID joins the
+// URIs of the member disks and the ID of its snapshots joins the IDs of the member snapshots. Controller operations
+// are applied to every member, and the node assembles the members in an LVM volume group with a single logical
+// volume striped across them.
+
+// getStripedVolumeGroupName returns the name of the LVM volume group of a striped volume
+func getStripedVolumeGroupName(volumeID string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(volumeID)))
+	return "azdisk-" + hex.EncodeToString(hash[:])[:16]
+}
+
+// getStripeMemberBytes returns the size of each member disk of a striped volume of totalBytes
+func getStripeMemberBytes(totalBytes int64, stripeCount int) int64 {
+	memberGiB := volumehelper.RoundUpGiB((totalBytes + int64(stripeCount) - 1) / int64(stripeCount))
+	return volumehelper.GiBToBytes(memberGiB)
+}
+
+// getStripeMemberParameters returns a copy of parameters for the member disk at index, without the stripe count and
+// with the disk name of the member if a disk name is set
+func getStripeMemberParameters(parameters map[string]string, index int) map[string]string {
+	memberParameters := make(map[string]string, len(parameters))
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case consts.StripeCountField:
+			continue
+		case consts.DiskNameField:
+			v = azureutils.GetStripeMemberName(v, index)
+		}
+		memberParameters[k] = v
+	}
+	return memberParameters
+}
+
+// getStripeMemberContentSource returns the content source of the member disk at index, the snapshot or volume of the
+// content source must be striped across stripeCount members
+func getStripeMemberContentSource(content *csi.VolumeContentSource, index, stripeCount int) (*csi.VolumeContentSource, error) {
+	if content == nil {
+		return nil, nil
+	}
+	if snapshot := content.GetSnapshot(); snapshot != nil {
+		members := azureutils.GetStripeMembers(snapshot.GetSnapshotId())
+		if len(members) != stripeCount {
+			return nil, status.Errorf(codes.InvalidArgument, "snapshot %s has %d members, a striped volume with %s %d can only be restored from a snapshot with the same number of members",
+				snapshot.GetSnapshotId(), len(members), consts.StripeCountField, stripeCount)
+		}
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: members[index]},
+			},
+		}, nil
+	}
+	members := azureutils.GetStripeMembers(content.GetVolume().GetVolumeId())
+	if len(members) != stripeCount {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s has %d members, a striped volume with %s %d can only be cloned from a volume with the same number of members",
+			content.GetVolume().GetVolumeId(), len(members), consts.StripeCountField, stripeCount)
+	}
+	return &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: members[index]},
+		},
+	}, nil
+}
+
+// createStripedVolume provisions the member disks of a striped volume with the parameters of req. The first member
+// picks the zone of the volume and the other members are created in the same zone. Members that were created before
+// a failure are kept since the CO retries CreateVolume with the same name until it succeeds or deletes the volume.
+func (d *Driver) createStripedVolume(ctx context.Context, req *csi.CreateVolumeRequest, stripeCount int) (*csi.CreateVolumeResponse, error) {
+	for _, c := range req.GetVolumeCapabilities() {
+		if c.GetBlock() != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "block volumes are not supported with %s %d", consts.StripeCountField, stripeCount)
+		}
+	}
+	name := req.GetName()
+	if acquired := d.volumeLocks.TryAcquire(name); !acquired {
+		return nil, status.Errorf(codes.Aborted, volumeOperationAlreadyExistsFmt, name)
+	}
+	defer d.volumeLocks.Release(name)
+
+	memberBytes := getStripeMemberBytes(req.GetCapacityRange().GetRequiredBytes(), stripeCount)
+	if limitBytes := req.GetCapacityRange().GetLimitBytes(); limitBytes > 0 && memberBytes*int64(stripeCount) > limitBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "size of the %d member disks(%d) exceeds the limit specified(%d)", stripeCount, memberBytes*int64(stripeCount), limitBytes)
+	}
+
+	klog.V(2).Infof("begin to create striped volume(%s) with %d member disks of %d bytes", name, stripeCount, memberBytes)
+	members := make([]*csi.Volume, stripeCount)
+	accessibilityRequirements := req.GetAccessibilityRequirements()
+	for i := 0; i < stripeCount; i++ {
+		contentSource, err := getStripeMemberContentSource(req.GetVolumeContentSource(), i, stripeCount)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:                      azureutils.GetStripeMemberName(name, i),
+			CapacityRange:             &csi.CapacityRange{RequiredBytes: memberBytes},
+			VolumeCapabilities:        req.GetVolumeCapabilities(),
+			Parameters:                getStripeMemberParameters(req.GetParameters(), i),
+			Secrets:                   req.GetSecrets(),
+			VolumeContentSource:       contentSource,
+			AccessibilityRequirements: accessibilityRequirements,
+		})
+		if err != nil {
+			return nil, err
+		}
+		members[i] = resp.GetVolume()
+		if i == 0 {
+			accessibilityRequirements = &csi.TopologyRequirement{
+				Requisite: members[0].GetAccessibleTopology(),
+				Preferred: members[0].GetAccessibleTopology(),
+			}
+		}
+	}
+
+	memberIDs := make([]string, stripeCount)
+	var capacityBytes int64
+	for i, member := range members {
+		memberIDs[i] = member.GetVolumeId()
+		capacityBytes += member.GetCapacityBytes()
+	}
+	volumeContext := members[0].GetVolumeContext()
+	volumeContext[consts.StripeCountField] = strconv.Itoa(stripeCount)
+	volumeContext[consts.RequestedSizeGib] = strconv.FormatInt(volumehelper.RoundUpGiB(capacityBytes), 10)
+	volumeID := azureutils.JoinStripeMembers(memberIDs)
+	klog.V(2).Infof("create striped volume(%s) with member disks(%s) successfully", name, volumeID)
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:           volumeID,
+			CapacityBytes:      capacityBytes,
+			VolumeContext:      volumeContext,
+			ContentSource:      req.GetVolumeContentSource(),
+			AccessibleTopology: members[0].GetAccessibleTopology(),
+		},
+	}, nil
+}
+
+// deleteStripedVolume deletes the member disks of a striped volume
+func (d *Driver) deleteStripedVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	for _, member := range azureutils.GetStripeMembers(req.GetVolumeId()) {
+		if _, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: member, Secrets: req.GetSecrets()}); err != nil {
+			return nil, err
+		}
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// modifyStripedVolume applies the mutable parameters to the member disks of a striped volume
+func (d *Driver) modifyStripedVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	for _, member := range azureutils.GetStripeMembers(req.GetVolumeId()) {
+		if _, err := d.ControllerModifyVolume(ctx, &csi.ControllerModifyVolumeRequest{
+			VolumeId:          member,
+			Secrets:           req.GetSecrets(),
+			MutableParameters: req.GetMutableParameters(),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// publishStripedVolume attaches the member disks of a striped volume to the node. The members are attached
+// concurrently so that the disk controller batches them in a single update of the VM. The LUN and the disk unique ID
+// of the publish context join the LUNs and the unique IDs of the members in the order of the volume ID.
+func (d *Driver) publishStripedVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	members := azureutils.GetStripeMembers(req.GetVolumeId())
+	responses := make([]*csi.ControllerPublishVolumeResponse, len(members))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, member := range members {
+		i, member := i, member
+		g.Go(func() error {
+			memberReq := *req
+			memberReq.VolumeId = member
+			resp, err := d.ControllerPublishVolume(gctx, &memberReq)
+			responses[i] = resp
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	luns := make([]string, len(members))
+	uniqueIDs := make([]string, len(members))
+	for i, resp := range responses {
+		luns[i] = resp.GetPublishContext()[consts.LUN]
+		uniqueIDs[i] = resp.GetPublishContext()[consts.DiskUniqueIDField]
+	}
+	publishContext := responses[0].GetPublishContext()
+	publishContext[consts.LUN] = strings.Join(luns, consts.StripedVolumeIDSeparator)
+	publishContext[consts.DiskUniqueIDField] = strings.Join(uniqueIDs, consts.StripedVolumeIDSeparator)
+	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
+}
+
+// unpublishStripedVolume detaches the member disks of a striped volume from the node
+func (d *Driver) unpublishStripedVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	members := azureutils.GetStripeMembers(req.GetVolumeId())
+	g, gctx := errgroup.WithContext(ctx)
+	for _, member := range members {
+		member := member
+		g.Go(func() error {
+			memberReq := *req
+			memberReq.VolumeId = member
+			_, err := d.ControllerUnpublishVolume(gctx, &memberReq)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+// validateStripedVolumeCapabilities validates the capabilities of every member disk of a striped volume
+func (d *Driver) validateStripedVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	for _, c := range req.GetVolumeCapabilities() {
+		if c.GetBlock() != nil {
+			return &csi.ValidateVolumeCapabilitiesResponse{Message: "block volumes are not supported by striped volumes"}, nil
+		}
+	}
+	var resp *csi.ValidateVolumeCapabilitiesResponse
+	for _, member := range azureutils.GetStripeMembers(req.GetVolumeId()) {
+		memberReq := *req
+		memberReq.VolumeId = member
+		var err error
+		if resp, err = d.ValidateVolumeCapabilities(ctx, &memberReq); err != nil || resp.GetConfirmed() == nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// expandStripedVolume grows every member disk of a striped volume to its share of the requested size
+func (d *Driver) expandStripedVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	members := azureutils.GetStripeMembers(req.GetVolumeId())
+	memberBytes := getStripeMemberBytes(req.GetCapacityRange().GetRequiredBytes(), len(members))
+	var capacityBytes int64
+	for _, member := range members {
+		resp, err := d.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+			VolumeId:         member,
+			CapacityRange:    &csi.CapacityRange{RequiredBytes: memberBytes},
+			Secrets:          req.GetSecrets(),
+			VolumeCapability: req.GetVolumeCapability(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		capacityBytes += resp.GetCapacityBytes()
+	}
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         capacityBytes,
+		NodeExpansionRequired: true,
+	}, nil
+}
+
+// createStripedSnapshot snapshots the member disks of a striped volume together. The member snapshots are created
+// concurrently to keep them as close in time as possible, the writes of a running workload should still be frozen
+// by the application for a consistent snapshot of the volume.
+func (d *Driver) createStripedSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	members := azureutils.GetStripeMembers(req.GetSourceVolumeId())
+	snapshots := make([]*csi.Snapshot, len(members))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, member := range members {
+		i, member := i, member
+		g.Go(func() error {
+			resp, err := d.CreateSnapshot(gctx, &csi.CreateSnapshotRequest{
+				SourceVolumeId: member,
+				Name:           azureutils.GetStripeMemberName(req.GetName(), i),
+				Secrets:        req.GetSecrets(),
+				Parameters:     req.GetParameters(),
+			})
+			snapshots[i] = resp.GetSnapshot()
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	snapshotIDs := make([]string, len(snapshots))
+	snapshot := &csi.Snapshot{
+		SourceVolumeId: req.GetSourceVolumeId(),
+		CreationTime:   snapshots[0].GetCreationTime(),
+		ReadyToUse:     true,
+	}
+	for i, s := range snapshots {
+		snapshotIDs[i] = s.GetSnapshotId()
+		snapshot.SizeBytes += s.GetSizeBytes()
+		snapshot.ReadyToUse = snapshot.ReadyToUse && s.GetReadyToUse()
+	}
+	snapshot.SnapshotId = azureutils.JoinStripeMembers(snapshotIDs)
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}, nil
+}
+
+// deleteStripedSnapshot deletes the member snapshots of a snapshot of a striped volume
+func (d *Driver) deleteStripedSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	for _, member := range azureutils.GetStripeMembers(req.GetSnapshotId()) {
+		if _, err := d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: member, Secrets: req.GetSecrets()}); err != nil {
+			return nil, err
+		}
+	}
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// assembleStripedVolume assembles the member disks attached at the LUNs of a striped volume and returns the path of
+// its logical volume, the member disks must have the unique IDs of diskUniqueID and be at least the size of a stripe
+// of the volume of expectedSizeBytes
+func (d *Driver) assembleStripedVolume(volumeID, lun, diskUniqueID string, expectedSizeBytes int64) (string, error) {
+	luns := strings.Split(lun, consts.StripedVolumeIDSeparator)
+	if len(luns) != len(azureutils.GetStripeMembers(volumeID)) {
+		return "", status.Errorf(codes.InvalidArgument, "lun %s does not match the member disks of striped volume %s", lun, volumeID)
+	}
+	uniqueIDs := make([]string, len(luns))
+	if diskUniqueID != "" {
+		if uniqueIDs = strings.Split(diskUniqueID, consts.StripedVolumeIDSeparator); len(uniqueIDs) != len(luns) {
+			return "", status.Errorf(codes.InvalidArgument, "%s %s does not match the member disks of striped volume %s", consts.DiskUniqueIDField, diskUniqueID, volumeID)
+		}
+	}
+	memberSizeBytes := getStripeMemberBytes(expectedSizeBytes, len(luns))
+	devices := make([]string, len(luns))
+	for i, l := range luns {
+		device, err := d.getDevicePathWithLUN(l)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "failed to find disk on lun %s. %v", l, err)
+		}
+		if err := d.verifyStagingDevice(volumeID, device, l, uniqueIDs[i], memberSizeBytes); err != nil {
+			return "", err
+		}
+		devices[i] = device
+	}
+	vgName := getStripedVolumeGroupName(volumeID)
+	klog.V(2).Infof("assembling striped volume %s from devices %v in volume group %s", volumeID, devices, vgName)
+	source, err := assembleStripedVolume(vgName, devices, d.mounter)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to assemble striped volume %s from devices %v: %v", volumeID, devices, err)
+	}
+	return source, nil
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/diskclient/mock_diskclient"
+	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/mock_azclient"
+)
+
+func TestGetStripeMemberBytes(t *testing.T) {
+	tests := []struct {
+		totalBytes  int64
+		stripeCount int
+		expected    int64
+	}{
+		{volumehelper.GiBToBytes(10), 2, volumehelper.GiBToBytes(5)},
+		{volumehelper.GiBToBytes(10), 3, volumehelper.GiBToBytes(4)},
+		{volumehelper.GiBToBytes(10) + 1, 2, volumehelper.GiBToBytes(6)},
+		{0, 4, 0},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, getStripeMemberBytes(test.totalBytes, test.stripeCount), "%d/%d", test.totalBytes, test.stripeCount)
+	}
+}
+
+func TestGetStripeMemberParameters(t *testing.T) {
+	parameters := map[string]string{"stripeCount": "2", "diskName": "data", "skuName": "Premium_LRS"}
+	assert.Equal(t, map[string]string{"diskName": "data-stripe1", "skuName": "Premium_LRS"}, getStripeMemberParameters(parameters, 1))
+	assert.Equal(t, "2", parameters["stripeCount"], "parameters are not modified")
+}
+
+func TestGetStripeMemberContentSource(t *testing.T) {
+	snapshotSource := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snapshot-0,snapshot-1"},
+		},
+	}
+	volumeSource := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "volume-0,volume-1"},
+		},
+	}
+
+	source, err := getStripeMemberContentSource(nil, 0, 2)
+	assert.NoError(t, err)
+	assert.Nil(t, source)
+
+	source, err = getStripeMemberContentSource(snapshotSource, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot-1", source.GetSnapshot().GetSnapshotId())
+
+	source, err = getStripeMemberContentSource(volumeSource, 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "volume-0", source.GetVolume().GetVolumeId())
+
+	_, err = getStripeMemberContentSource(snapshotSource, 0, 3)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = getStripeMemberContentSource(volumeSource, 0, 1)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGetStripedVolumeGroupName(t *testing.T) {
+	name := getStripedVolumeGroupName("/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/d-stripe0,/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/d-stripe1")
+	assert.Regexp(t, "^azdisk-[0-9a-f]{16}$", name)
+	assert.Equal(t, name, getStripedVolumeGroupName("/subscriptions/subs/resourcegroups/rg/providers/Microsoft.Compute/disks/D-stripe0,/subscriptions/subs/resourcegroups/rg/providers/Microsoft.Compute/disks/D-stripe1"))
+}
+
+func TestCreateAndDeleteStripedVolume(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	deleted := []string{}
+	diskClient := mock_diskclient.NewMockInterface(cntl)
+	d.getClientFactory().(*mock_azclient.MockClientFactory).EXPECT().GetDiskClientForSub(gomock.Any()).Return(diskClient, nil).AnyTimes()
+	getDisk := func(_ context.Context, rg, name string) (*armcompute.Disk, error) {
+		id := fmt.Sprintf(consts.ManagedDiskPath, "subs", rg, name)
+		size := int32(5)
+		state := "Succeeded"
+		return &armcompute.Disk{ID: &id, Name: &name, Properties: &armcompute.DiskProperties{DiskSizeGB: &size, ProvisioningState: &state}}, nil
+	}
+	diskClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(getDisk).AnyTimes()
+	diskClient.EXPECT().CreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, rg, name string, _ armcompute.Disk) (*armcompute.Disk, error) {
+			return getDisk(ctx, rg, name)
+		}).Times(2)
+	diskClient.EXPECT().Delete(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _, name string) error {
+			lock.Lock()
+			defer lock.Unlock()
+			deleted = append(deleted, name)
+			return nil
+		}).Times(2)
+
+	req := &csi.CreateVolumeRequest{
+		Name:               testVolumeName,
+		VolumeCapabilities: stdVolumeCapabilities,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: volumehelper.GiBToBytes(10)},
+		Parameters:         map[string]string{"stripeCount": "2"},
+	}
+	resp, err := d.CreateVolume(context.Background(), req)
+	require.NoError(t, err)
+	members := azureutils.GetStripeMembers(resp.GetVolume().GetVolumeId())
+	require.Len(t, members, 2)
+	assert.Equal(t, fmt.Sprintf(consts.ManagedDiskPath, "subs", d.getCloud().ResourceGroup, testVolumeName+"-stripe0"), members[0])
+	assert.Equal(t, fmt.Sprintf(consts.ManagedDiskPath, "subs", d.getCloud().ResourceGroup, testVolumeName+"-stripe1"), members[1])
+	assert.Equal(t, volumehelper.GiBToBytes(10), resp.GetVolume().GetCapacityBytes())
+	assert.Equal(t, "2", resp.GetVolume().GetVolumeContext()[consts.StripeCountField])
+
+	_, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()})
+	require.NoError(t, err)
+	sort.Strings(deleted)
+	assert.Equal(t, []string{testVolumeName + "-stripe0", testVolumeName + "-stripe1"}, deleted)
+
+	req.VolumeCapabilities = []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	_, err = d.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAssembleStripedVolume(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("striped volumes are only supported on Linux")
+	}
+	output := func(out string, err error) testingexec.FakeAction {
+		return func() ([]byte, []byte, error) { return []byte(out), nil, err }
+	}
+	failed := &testingexec.FakeExitError{Status: 5}
+	devices := []string{"/dev/sdc", "/dev/sdd"}
+
+	tests := []struct {
+		desc        string
+		actions     []testingexec.FakeAction
+		expectedErr bool
+	}{
+		{
+			desc: "new volume group",
+			actions: []testingexec.FakeAction{
+				output("Failed to find physical volume \"/dev/sdc\".", failed),
+				output("", nil), // blkid /dev/sdc
+				output("", nil), // blkid /dev/sdd
+				output("", nil), // pvcreate
+				output("", nil), // vgcreate
+				output("Failed to find logical volume \"azdisk-1/data\"", failed),
+				output("", nil), // lvcreate
+			},
+		},
+		{
+			desc: "existing volume group of a restored volume",
+			actions: []testingexec.FakeAction{
+				output("  azdisk-0\n", nil),
+				output("", nil), // vgimportclone
+				output("", nil), // vgchange
+				output("  data\n", nil),
+				output("  /dev/sdc\n  /dev/sdd\n", nil),
+				output("", nil),               // pvresize /dev/sdc
+				output("", nil),               // pvresize /dev/sdd
+				output("  2147483648\n", nil), // vgs
+				output("", nil),               // lvextend
+			},
+		},
+		{
+			desc: "formatted device",
+			actions: []testingexec.FakeAction{
+				output("Failed to find physical volume \"/dev/sdc\".", failed),
+				output("DEVNAME=/dev/sdc\nTYPE=ext4\n", nil),
+			},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fakeMounter, err := mounter.NewFakeSafeMounter()
+			require.NoError(t, err)
+			fakeMounter.Exec.(*mounter.FakeSafeMounter).SetNextCommandOutputScripts(test.actions...)
+			source, err := assembleStripedVolume("azdisk-1", devices, fakeMounter)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "/dev/azdisk-1/data", source)
+		})
+	}
+}
+
+func TestAssembleClonedStripedVolumeOnSourceNode(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("striped volumes are only supported on Linux")
+	}
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	fakeExec := fakeMounter.Exec.(*mounter.FakeSafeMounter)
+	commands := []string{}
+	// the members of the clone on /dev/sde and /dev/sdf carry the PV and VG UUIDs of the source volume staged on
+	// /dev/sdc and /dev/sdd
+	for _, out := range []string{
+		"  WARNING: Not using device /dev/sde for PV Vd1R2p-pv0.\n  WARNING: PV Vd1R2p-pv0 prefers device /dev/sdc because device is used by LV.\n  azdisk-0\n",
+		"", // vgimportclone
+		"", // vgchange
+		"  data\n",
+		"  WARNING: duplicate PVs were found\n  /dev/sde\n  /dev/sdf\n",
+		"", // pvresize /dev/sde
+		"", // pvresize /dev/sdf
+		"  0\n",
+	} {
+		fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) exec.Cmd {
+			commands = append(commands, strings.Join(append([]string{cmd}, args...), " "))
+			fakeCmd := &testingexec.FakeCmd{
+				CombinedOutputScript: []testingexec.FakeAction{func() ([]byte, []byte, error) { return []byte(out), nil, nil }},
+			}
+			return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+		})
+	}
+
+	source, err := assembleStripedVolume("azdisk-2", []string{"/dev/sde", "/dev/sdf"}, fakeMounter)
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/azdisk-2/data", source)
+	assert.Equal(t, []string{
+		"pvs --noheadings -o vg_name /dev/sde",
+		"vgimportclone --basevgname azdisk-2 /dev/sde /dev/sdf",
+		"vgchange -ay azdisk-2",
+		"lvs --noheadings -o lv_name azdisk-2/data",
+		"pvs --noheadings -o pv_name -S vg_name=azdisk-2",
+		"pvresize /dev/sde",
+		"pvresize /dev/sdf",
+		"vgs --noheadings --units b --nosuffix -o vg_free azdisk-2",
+	}, commands, "the clone must be imported with new UUIDs instead of renaming the volume group of the source")
+}
+
+func TestDeactivateStripedVolume(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("striped volumes are only supported on Linux")
+	}
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	fakeMounter.Exec.(*mounter.FakeSafeMounter).SetNextCommandOutputScripts(
+		func() ([]byte, []byte, error) { return nil, nil, nil },
+		func() ([]byte, []byte, error) {
+			return []byte("Volume group \"azdisk-1\" not found"), nil, &testingexec.FakeExitError{Status: 5}
+		},
+		func() ([]byte, []byte, error) {
+			return []byte("Logical volume azdisk-1/data in use."), nil, &testingexec.FakeExitError{Status: 5}
+		},
+	)
+	assert.NoError(t, deactivateStripedVolume("azdisk-1", fakeMounter))
+	assert.NoError(t, deactivateStripedVolume("azdisk-1", fakeMounter))
+	assert.Error(t, deactivateStripedVolume("azdisk-1", fakeMounter))
+}
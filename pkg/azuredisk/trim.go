@@ -0,0 +1,270 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	basemetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azureutils"
+	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+	"sigs.k8s.io/cloud-provider-azure/pkg/metrics"
+)
+
+// trimCheckInterval is the interval at which the trim scheduler looks for volumes due for a trim
+var trimCheckInterval = time.Minute
+
+var trimmedBytes = basemetrics.NewCounterVec(
+	&basemetrics.CounterOpts{
+		Subsystem:      consts.AzureDiskCSIDriverName,
+		Name:           "trimmed_bytes_total",
+		Help:           "Bytes discarded by fstrim on staged volumes by volume.",
+		StabilityLevel: basemetrics.ALPHA,
+	},
+	[]string{"volume"},
+)
+
+func init() {
+	legacyregistry.MustRegister(trimmedBytes)
+}
+
+// trimVolume is a staged volume trimmed by the trim scheduler
+type trimVolume struct {
+	volumeID string
+	// name is the persistent volume name if known, the volume ID otherwise
+	name     string
+	target   string
+	interval time.Duration
+	next     time.Time
+}
+
+// trimScheduler runs fstrim on the staging paths of the staged volumes at the trim interval of each volume,
+// at most maxConcurrent volumes at the same time. A volume is trimmed under its volume lock, so that it is not
+// trimmed while it is staged or unstaged.
+type trimScheduler struct {
+	sync.Mutex
+	driverName    string
+	maxConcurrent int
+	volumeLocks   *volumehelper.VolumeLocks
+	// volumes are the scheduled volumes <volumeID, *trimVolume>
+	volumes map[string]*trimVolume
+	now     func() time.Time
+	trim    func(target string) (int64, error)
+}
+
+func newTrimScheduler(driverName string, maxConcurrent int, volumeLocks *volumehelper.VolumeLocks, trim func(target string) (int64, error)) *trimScheduler {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &trimScheduler{
+		driverName:    driverName,
+		maxConcurrent: maxConcurrent,
+		volumeLocks:   volumeLocks,
+		volumes:       map[string]*trimVolume{},
+		now:           time.Now,
+		trim:          trim,
+	}
+}
+
+// add schedules the volume staged at target for a trim every interval, a volume that is already scheduled keeps its
+// next trim time unless the interval changed. The volume is removed from the schedule if interval is 0.
+func (s *trimScheduler) add(volumeID, name, target string, interval time.Duration) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if interval <= 0 {
+		s.delete(volumeID)
+		return
+	}
+	if v, ok := s.volumes[volumeID]; ok && v.interval == interval && v.target == target {
+		return
+	}
+	klog.V(2).Infof("scheduling trim of volume %s at %s every %v", volumeID, target, interval)
+	s.volumes[volumeID] = &trimVolume{volumeID: volumeID, name: name, target: target, interval: interval, next: s.now().Add(interval)}
+}
+
+// remove removes the volume from the schedule
+func (s *trimScheduler) remove(volumeID string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.delete(volumeID)
+}
+
+// delete removes the volume from the schedule and its trimmed bytes from the node metrics, s must be locked
+func (s *trimScheduler) delete(volumeID string) {
+	if v, ok := s.volumes[volumeID]; ok {
+		trimmedBytes.DeleteLabelValues(v.name)
+		delete(s.volumes, volumeID)
+	}
+}
+
+// retry schedules the next trim of the volume at the next check
+func (s *trimScheduler) retry(volumeID string) {
+	s.Lock()
+	defer s.Unlock()
+	if v, ok := s.volumes[volumeID]; ok {
+		v.next = s.now()
+	}
+}
+
+// due returns the volumes due for a trim, most overdue first, and schedules their next trim
+func (s *trimScheduler) due() []trimVolume {
+	s.Lock()
+	defer s.Unlock()
+	now := s.now()
+	volumes := []trimVolume{}
+	for _, v := range s.volumes {
+		if !now.Before(v.next) {
+			volumes = append(volumes, *v)
+			v.next = now.Add(v.interval)
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].next.Before(volumes[j].next) })
+	return volumes
+}
+
+// run trims the volumes when they are due until ctx is done
+func (s *trimScheduler) run(ctx context.Context) {
+	klog.V(2).Infof("starting trim scheduler with at most %d concurrent trims", s.maxConcurrent)
+	wait.UntilWithContext(ctx, s.trimDue, trimCheckInterval)
+}
+
+// trimDue trims the volumes due for a trim and returns when all trims are done
+func (s *trimScheduler) trimDue(ctx context.Context) {
+	sem := make(chan struct{}, s.maxConcurrent)
+	var wg sync.WaitGroup
+	for _, v := range s.due() {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(v trimVolume) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.trimVolume(v)
+		}(v)
+	}
+	wg.Wait()
+}
+
+// trimVolume trims the volume and records the bytes trimmed and the duration in the node metrics. The trim is
+// retried at the next check if an operation on the volume is in progress.
+func (s *trimScheduler) trimVolume(v trimVolume) {
+	if s.volumeLocks != nil {
+		if acquired := s.volumeLocks.TryAcquire(v.volumeID); !acquired {
+			klog.V(2).Infof("trim of volume %s is postponed, an operation on the volume is in progress", v.volumeID)
+			s.retry(v.volumeID)
+			return
+		}
+		defer s.volumeLocks.Release(v.volumeID)
+	}
+
+	mc := metrics.NewMetricContext(consts.AzureDiskCSIDriverName, "node_fstrim", "", "", s.driverName)
+	isOperationSucceeded := false
+	defer func() {
+		mc.ObserveOperationWithResult(isOperationSucceeded, consts.VolumeID, v.volumeID)
+	}()
+
+	bytes, err := s.trim(v.target)
+	if err != nil {
+		klog.Errorf("trim of volume %s at %s failed with %v", v.volumeID, v.target, err)
+		return
+	}
+	trimmedBytes.WithLabelValues(v.name).Add(float64(bytes))
+	klog.V(2).Infof("trimmed %d bytes of volume %s at %s", bytes, v.volumeID, v.target)
+	isOperationSucceeded = true
+}
+
+// trimFilesystem discards the unused blocks of the filesystem mounted at target and returns the bytes trimmed, it
+// fails if target is no longer a mount point
+func (d *DriverCore) trimFilesystem(target string) (int64, error) {
+	notMnt, err := d.mounter.IsLikelyNotMountPoint(target)
+	if err != nil {
+		return 0, err
+	}
+	if notMnt {
+		return 0, fmt.Errorf("%s is not a mount point", target)
+	}
+	return trimFilesystem(target, d.mounter)
+}
+
+// scheduleVolumeTrim schedules the trim of the volume staged at target according to the trimSchedule parameter of
+// the volume or the trim interval of the driver
+func (d *DriverCore) scheduleVolumeTrim(volumeID, target string, volumeContext map[string]string) {
+	interval, ok, err := azureutils.GetTrimSchedule(volumeContext)
+	if err != nil {
+		klog.Warningf("volume %s is not trimmed: %v", volumeID, err)
+		return
+	}
+	if !ok {
+		interval = d.trimInterval
+	}
+	name := volumeContext[consts.PvNameKey]
+	if name == "" {
+		name = volumeID
+	}
+	d.trimScheduler.add(volumeID, name, target, interval)
+}
+
+// restoreTrimSchedule schedules the trim of the volumes staged on the node before the driver started, since kubelet
+// does not stage them again. The trimSchedule parameter of a volume is read from its persistent volume, the trim
+// interval of the driver applies to the volumes whose persistent volume is not known from a target path.
+func (d *DriverCore) restoreTrimSchedule(ctx context.Context) {
+	for _, v := range d.listMountedVolumes() {
+		if v.stagingPath == "" {
+			continue
+		}
+		if notMnt, err := d.mounter.IsLikelyNotMountPoint(v.stagingPath); err != nil || notMnt {
+			continue
+		}
+		volumeContext := map[string]string{}
+		if v.pvName != "" && d.kubeClient != nil {
+			pv, err := d.kubeClient.CoreV1().PersistentVolumes().Get(ctx, v.pvName, metav1.GetOptions{})
+			if err != nil {
+				klog.Warningf("trim of volume %s is not scheduled, failed to get persistent volume %s: %v", v.volumeID, v.pvName, err)
+				continue
+			}
+			if pv.Spec.CSI != nil {
+				for key, value := range pv.Spec.CSI.VolumeAttributes {
+					volumeContext[key] = value
+				}
+			}
+			volumeContext[consts.PvNameKey] = v.pvName
+		}
+		d.scheduleVolumeTrim(v.volumeID, v.stagingPath, volumeContext)
+	}
+}
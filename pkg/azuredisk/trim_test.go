@@ -0,0 +1,243 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azuredisk
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/mounter"
+	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+func TestTrimScheduler(t *testing.T) {
+	now := time.Now()
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	trimmed := []string{}
+	volumeLocks := volumehelper.NewVolumeLocks()
+	scheduler := newTrimScheduler("disk.csi.azure.com", 2, volumeLocks, func(target string) (int64, error) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		defer lock.Unlock()
+		running--
+		trimmed = append(trimmed, target)
+		if target == "/staging/failed" {
+			return 0, fmt.Errorf("fstrim failed")
+		}
+		return 1024, nil
+	})
+	scheduler.now = func() time.Time { return now }
+
+	scheduler.add("vol-1", "pv-1", "/staging/1", time.Hour)
+	scheduler.add("vol-2", "pv-2", "/staging/2", time.Hour)
+	scheduler.add("vol-3", "pv-3", "/staging/3", time.Hour)
+	scheduler.add("vol-4", "pv-4", "/staging/failed", time.Hour)
+	scheduler.add("vol-5", "pv-5", "/staging/5", 2*time.Hour)
+	scheduler.add("vol-6", "pv-6", "/staging/6", time.Hour)
+	scheduler.remove("vol-6")
+	scheduler.add("vol-7", "pv-7", "/staging/7", time.Hour)
+	scheduler.add("vol-7", "pv-7", "/staging/7", 0)
+
+	scheduler.trimDue(context.Background())
+	assert.Empty(t, trimmed, "volumes are trimmed one interval after they are staged")
+
+	now = now.Add(time.Hour)
+	// staging a scheduled volume again keeps its schedule
+	scheduler.add("vol-1", "pv-1", "/staging/1", time.Hour)
+	scheduler.trimDue(context.Background())
+	assert.ElementsMatch(t, []string{"/staging/1", "/staging/2", "/staging/3", "/staging/failed"}, trimmed)
+	assert.LessOrEqual(t, maxRunning, 2)
+	assert.Equal(t, float64(1024), counterValue(t, "pv-1"))
+	assert.Equal(t, float64(0), counterValue(t, "pv-4"))
+
+	trimmed = []string{}
+	now = now.Add(time.Hour)
+	scheduler.trimDue(context.Background())
+	assert.ElementsMatch(t, []string{"/staging/1", "/staging/2", "/staging/3", "/staging/failed", "/staging/5"}, trimmed)
+	assert.Equal(t, float64(2048), counterValue(t, "pv-1"))
+
+	// a volume with an operation in progress is trimmed at the next check once the operation is done
+	trimmed = []string{}
+	now = now.Add(time.Hour)
+	require.True(t, volumeLocks.TryAcquire("vol-1"))
+	scheduler.trimDue(context.Background())
+	assert.NotContains(t, trimmed, "/staging/1")
+	volumeLocks.Release("vol-1")
+	trimmed = []string{}
+	scheduler.trimDue(context.Background())
+	assert.Equal(t, []string{"/staging/1"}, trimmed)
+
+	scheduler.remove("vol-1")
+	scheduler.add("vol-2", "pv-2", "/staging/2", 0)
+	assert.False(t, hasTrimmedBytes(t, "pv-1"), "trimmed bytes of an unstaged volume must be deleted")
+	assert.False(t, hasTrimmedBytes(t, "pv-2"), "trimmed bytes of a volume no longer trimmed must be deleted")
+	assert.True(t, hasTrimmedBytes(t, "pv-3"))
+
+	var nilScheduler *trimScheduler
+	nilScheduler.add("vol-1", "pv-1", "/staging/1", time.Hour)
+	nilScheduler.remove("vol-1")
+}
+
+func counterValue(t *testing.T, volume string) float64 {
+	value, err := testutil.GetCounterMetricValue(trimmedBytes.WithLabelValues(volume))
+	require.NoError(t, err)
+	return value
+}
+
+func hasTrimmedBytes(t *testing.T, volume string) bool {
+	metrics, err := legacyregistry.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range metrics {
+		if family.GetName() != consts.AzureDiskCSIDriverName+"_trimmed_bytes_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "volume" && label.GetValue() == volume {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func TestScheduleVolumeTrim(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	d.trimScheduler = newTrimScheduler(d.Name, 1, d.volumeLocks, d.trimFilesystem)
+
+	d.scheduleVolumeTrim("vol-1", "/staging/1", map[string]string{consts.PvNameKey: "pv-1"})
+	assert.Empty(t, d.trimScheduler.volumes, "trim is disabled by default")
+
+	d.trimInterval = 24 * time.Hour
+	d.scheduleVolumeTrim("vol-1", "/staging/1", map[string]string{consts.PvNameKey: "pv-1"})
+	d.scheduleVolumeTrim("vol-2", "/staging/2", map[string]string{"trimSchedule": "none"})
+	d.scheduleVolumeTrim("vol-3", "/staging/3", map[string]string{"trimSchedule": "2h"})
+	d.scheduleVolumeTrim("vol-4", "/staging/4", map[string]string{"trimSchedule": "1m"})
+	require.Len(t, d.trimScheduler.volumes, 2)
+	assert.Equal(t, 24*time.Hour, d.trimScheduler.volumes["vol-1"].interval)
+	assert.Equal(t, "pv-1", d.trimScheduler.volumes["vol-1"].name)
+	assert.Equal(t, 2*time.Hour, d.trimScheduler.volumes["vol-3"].interval)
+	assert.Equal(t, "vol-3", d.trimScheduler.volumes["vol-3"].name)
+}
+
+func TestRestoreTrimSchedule(t *testing.T) {
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	d.trimScheduler = newTrimScheduler(d.Name, 1, d.volumeLocks, d.trimFilesystem)
+	d.trimInterval = 24 * time.Hour
+	d.kubeletRootDir = t.TempDir()
+
+	// the fake mounter only reports the paths containing false_is_likely as mount points
+	csiDir := filepath.Join(d.kubeletRootDir, "plugins/kubernetes.io/csi", fakeDriverName)
+	scheduledStaging := filepath.Join(csiDir, "false_is_likely_scheduled/globalmount")
+	defaultStaging := filepath.Join(csiDir, "false_is_likely_default/globalmount")
+	writeVolumeData(t, scheduledStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-scheduled"})
+	writeVolumeData(t, filepath.Join(d.kubeletRootDir, "pods/uid-1/volumes/kubernetes.io~csi/pv-scheduled/mount"), volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-scheduled", SpecVolID: "pv-scheduled"})
+	writeVolumeData(t, defaultStaging, volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-default"})
+	writeVolumeData(t, filepath.Join(csiDir, "false_is_likely_missing/globalmount"), volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-missing"})
+	writeVolumeData(t, filepath.Join(d.kubeletRootDir, "pods/uid-2/volumes/kubernetes.io~csi/pv-missing/mount"), volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-missing", SpecVolID: "pv-missing"})
+	writeVolumeData(t, filepath.Join(csiDir, "unstaged/globalmount"), volumeData{DriverName: fakeDriverName, VolumeHandle: "vol-unstaged"})
+
+	pv := newFakePV("pv-scheduled", "vol-scheduled")
+	pv.Spec.CSI.VolumeAttributes = map[string]string{"trimSchedule": "2h"}
+	d.kubeClient = fake.NewSimpleClientset(pv)
+
+	d.restoreTrimSchedule(context.Background())
+	require.Len(t, d.trimScheduler.volumes, 2, "the volumes not mounted or of a persistent volume not found are not scheduled")
+	assert.Equal(t, trimVolume{volumeID: "vol-scheduled", name: "pv-scheduled", target: scheduledStaging, interval: 2 * time.Hour, next: d.trimScheduler.volumes["vol-scheduled"].next}, *d.trimScheduler.volumes["vol-scheduled"])
+	assert.Equal(t, trimVolume{volumeID: "vol-default", name: "vol-default", target: defaultStaging, interval: 24 * time.Hour, next: d.trimScheduler.volumes["vol-default"].next}, *d.trimScheduler.volumes["vol-default"])
+}
+
+func TestTrimFilesystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fstrim is only supported on Linux")
+	}
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	fakeMounter.Exec.(*mounter.FakeSafeMounter).SetNextCommandOutputScripts(
+		func() ([]byte, []byte, error) {
+			return []byte("/staging/1: 1.2 GiB (1287651328 bytes) trimmed\n"), nil, nil
+		},
+		func() ([]byte, []byte, error) { return []byte("unexpected"), nil, nil },
+		func() ([]byte, []byte, error) {
+			return []byte("fstrim: /staging/1: the discard operation is not supported"), nil, fmt.Errorf("exit status 1")
+		},
+	)
+
+	bytes, err := trimFilesystem("/staging/1", fakeMounter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1287651328), bytes)
+	_, err = trimFilesystem("/staging/1", fakeMounter)
+	assert.Error(t, err)
+	_, err = trimFilesystem("/staging/1", fakeMounter)
+	assert.Error(t, err)
+}
+
+func TestDriverTrimFilesystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fstrim is only supported on Linux")
+	}
+	cntl := gomock.NewController(t)
+	defer cntl.Finish()
+	d, err := newFakeDriverV1(cntl)
+	require.NoError(t, err)
+	fakeMounter, err := mounter.NewFakeSafeMounter()
+	require.NoError(t, err)
+	d.setMounter(fakeMounter)
+	fakeMounter.Exec.(*mounter.FakeSafeMounter).SetNextCommandOutputScripts(
+		func() ([]byte, []byte, error) {
+			return []byte("/staging/false_is_likely: 4 KiB (4096 bytes) trimmed\n"), nil, nil
+		},
+	)
+
+	_, err = d.trimFilesystem("/staging/1")
+	assert.Error(t, err, "a target that is no longer mounted must not be trimmed")
+	_, err = d.trimFilesystem("/staging/error_is_likely")
+	assert.Error(t, err)
+	bytes, err := d.trimFilesystem("/staging/false_is_likely")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4096), bytes)
+}
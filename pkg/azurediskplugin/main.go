@@ -40,6 +40,8 @@ func init() {
 var (
 	version        = flag.Bool("version", false, "Print the version and exit.")
 	metricsAddress = flag.String("metrics-address", "", "export the metrics")
+	configFile     = flag.String("config", "", "path of the driver configuration file, options set on the command line take precedence over the file")
+	printConfig    = flag.Bool("print-config", false, "Print the effective driver configuration and exit.")
 	driverOptions  azuredisk.DriverOptions
 )
 
@@ -54,11 +56,50 @@ func main() {
 		os.Exit(0)
 	}
 
+	if err := loadDriverOptions(); err != nil {
+		klog.Fatalf("invalid driver configuration: %v", err)
+	}
+	config, err := driverOptions.EffectiveConfig()
+	if err != nil {
+		klog.Fatalf("failed to marshal driver configuration: %v", err)
+	}
+	if *printConfig {
+		fmt.Print(string(config)) // nolint
+		os.Exit(0)
+	}
+	klog.V(2).Infof("driver configuration:\n%s", config)
+
 	exportMetrics()
 	handle()
 	os.Exit(0)
 }
 
+// loadDriverOptions applies the driver configuration file to the driver options and validates them
+func loadDriverOptions() error {
+	if *configFile != "" {
+		// options set on the command line take precedence over the configuration file
+		setFlags := map[string]string{}
+		flag.Visit(func(f *flag.Flag) {
+			setFlags[f.Name] = f.Value.String()
+		})
+		if err := driverOptions.LoadConfigFile(*configFile); err != nil {
+			return err
+		}
+		for name, value := range setFlags {
+			if err := flag.Set(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	if err := driverOptions.Validate(); err != nil {
+		return err
+	}
+	for _, warning := range driverOptions.Warnings() {
+		klog.Warning(warning)
+	}
+	return nil
+}
+
 func handle() {
 	driver := azuredisk.NewDriver(&driverOptions)
 	if driver == nil {
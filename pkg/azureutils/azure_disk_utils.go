@@ -42,12 +42,14 @@ import (
 	volumeUtil "k8s.io/kubernetes/pkg/volume/util"
 	"k8s.io/mount-utils"
 	"k8s.io/utils/pointer"
+	azdiskclientset "sigs.k8s.io/azuredisk-csi-driver/pkg/apis/client/clientset/versioned"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/optimization"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/util"
 	"sigs.k8s.io/cloud-provider-azure/pkg/azclient/configloader"
 	azclients "sigs.k8s.io/cloud-provider-azure/pkg/azureclients"
 	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -117,13 +119,17 @@ type ManagedDiskParameters struct {
 	DiskName                string
 	EnableBursting          *bool
 	PerformancePlus         *bool
+	FormatOptions           string
+	FsckPolicy              string
 	FsType                  string
 	Location                string
 	LogicalSectorSize       int
 	MaxShares               int
+	MaxMountReplicaCount    *int
 	NetworkAccessPolicy     string
 	PublicNetworkAccess     string
 	PerfProfile             string
+	StripeCount             int
 	SubscriptionID          string
 	ResourceGroup           string
 	Tags                    map[string]string
@@ -192,17 +198,8 @@ func GetCloudProviderFromClient(ctx context.Context, kubeClient clientset.Interf
 
 	if config == nil {
 		klog.V(2).Infof("could not read cloud config from secret %s/%s", secretNamespace, secretName)
-		credFile, ok := os.LookupEnv(consts.DefaultAzureCredentialFileEnv)
-		if ok && strings.TrimSpace(credFile) != "" {
-			klog.V(2).Infof("%s env var set as %v", consts.DefaultAzureCredentialFileEnv, credFile)
-		} else {
-			if util.IsWindowsOS() {
-				credFile = consts.DefaultCredFilePathWindows
-			} else {
-				credFile = consts.DefaultCredFilePathLinux
-			}
-			klog.V(2).Infof("use default %s env var: %v", consts.DefaultAzureCredentialFileEnv, credFile)
-		}
+		credFile := GetCredentialFilePath()
+		klog.V(2).Infof("reading cloud config from file %s", credFile)
 		config, err = configloader.Load[azure.Config](ctx, nil, &configloader.FileLoaderConfig{FilePath: credFile})
 		if err != nil {
 			klog.Warningf("load azure config from file(%s) failed with %v", credFile, err)
@@ -216,24 +213,6 @@ func GetCloudProviderFromClient(ctx context.Context, kubeClient clientset.Interf
 			return nil, fmt.Errorf("no cloud config provided, error: %v", err)
 		}
 	} else {
-		// Location may be either upper case with spaces (e.g. "East US") or lower case without spaces (e.g. "eastus")
-		// Kubernetes does not allow whitespaces in label values, e.g. for topology keys
-		// ensure Kubernetes compatible format for Location by enforcing lowercase-no-space format
-		config.Location = strings.ToLower(strings.ReplaceAll(config.Location, " ", ""))
-
-		// disable disk related rate limit
-		config.DiskRateLimit = &azclients.RateLimitConfig{
-			CloudProviderRateLimit: false,
-		}
-		config.SnapshotRateLimit = &azclients.RateLimitConfig{
-			CloudProviderRateLimit: false,
-		}
-		config.UserAgent = userAgent
-		if enableTrafficMgr && trafficMgrPort > 0 {
-			trafficMgrAddr := fmt.Sprintf("http://localhost:%d/", trafficMgrPort)
-			klog.V(2).Infof("set ResourceManagerEndpoint as %s", trafficMgrAddr)
-			config.ResourceManagerEndpoint = trafficMgrAddr
-		}
 		// these environment variables are injected by workload identity webhook
 		if tenantID := os.Getenv("AZURE_TENANT_ID"); tenantID != "" {
 			config.TenantID = tenantID
@@ -245,7 +224,7 @@ func GetCloudProviderFromClient(ctx context.Context, kubeClient clientset.Interf
 			config.AADFederatedTokenFile = federatedTokenFile
 			config.UseFederatedWorkloadIdentityExtension = true
 		}
-		if err = az.InitializeCloudFromConfig(ctx, config, fromSecret, false); err != nil {
+		if err = initializeCloudFromConfig(ctx, az, config, fromSecret, userAgent, enableTrafficMgr, trafficMgrPort); err != nil {
 			klog.Warningf("InitializeCloudFromConfig failed with error: %v", err)
 		}
 	}
@@ -257,6 +236,88 @@ func GetCloudProviderFromClient(ctx context.Context, kubeClient clientset.Interf
 	return az, nil
 }
 
+// GetCredentialFilePath returns the path of the cloud config file that is read when the cloud config secret is not available
+func GetCredentialFilePath() string {
+	if credFile, ok := os.LookupEnv(consts.DefaultAzureCredentialFileEnv); ok && strings.TrimSpace(credFile) != "" {
+		return credFile
+	}
+	if util.IsWindowsOS() {
+		return consts.DefaultCredFilePathWindows
+	}
+	return consts.DefaultCredFilePathLinux
+}
+
+// GetCloudProviderFromSecrets get Azure Cloud Provider scoped to the credentials of a provisioner or snapshotter secret.
+// A complete cloud config is read from the cloud-config key, otherwise the identity keys of the secret override a copy
+// of the base config so that the rest of the cloud config of the driver still applies.
+func GetCloudProviderFromSecrets(ctx context.Context, baseConfig *azure.Config, secrets map[string]string, userAgent string,
+	enableTrafficMgr bool, trafficMgrPort int64) (*azure.Cloud, error) {
+	config := &azure.Config{}
+	if cloudConfig, ok := secrets[consts.SecretCloudConfigField]; ok {
+		if err := yaml.Unmarshal([]byte(strings.TrimSpace(cloudConfig)), config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s in secret: %v", consts.SecretCloudConfigField, err)
+		}
+	} else {
+		if baseConfig != nil {
+			*config = *baseConfig
+		}
+		for k, v := range secrets {
+			switch k {
+			case consts.SecretTenantIDField:
+				config.TenantID = v
+			case consts.SecretSubscriptionIDField:
+				config.SubscriptionID = v
+			case consts.SecretResourceGroupField:
+				config.ResourceGroup = v
+			case consts.SecretAADClientIDField:
+				config.AADClientID = v
+			case consts.SecretAADClientSecretField:
+				config.AADClientSecret = v
+				// a client secret replaces the identity the driver runs with
+				config.UseManagedIdentityExtension = false
+				config.UserAssignedIdentityID = ""
+				config.UseFederatedWorkloadIdentityExtension = false
+				config.AADFederatedTokenFile = ""
+			default:
+				return nil, fmt.Errorf("invalid key %s in secret", k)
+			}
+		}
+	}
+	if config.AADClientSecret == "" && config.AADClientCertPath == "" && !config.UseManagedIdentityExtension && !config.UseFederatedWorkloadIdentityExtension {
+		return nil, fmt.Errorf("no credentials provided in secret")
+	}
+
+	az := &azure.Cloud{}
+	if err := initializeCloudFromConfig(ctx, az, config, false, userAgent, enableTrafficMgr, trafficMgrPort); err != nil {
+		return nil, err
+	}
+	return az, nil
+}
+
+// initializeCloudFromConfig applies the settings of the driver to config and initializes az from it
+func initializeCloudFromConfig(ctx context.Context, az *azure.Cloud, config *azure.Config, fromSecret bool, userAgent string,
+	enableTrafficMgr bool, trafficMgrPort int64) error {
+	// Location may be either upper case with spaces (e.g. "East US") or lower case without spaces (e.g. "eastus")
+	// Kubernetes does not allow whitespaces in label values, e.g. for topology keys
+	// ensure Kubernetes compatible format for Location by enforcing lowercase-no-space format
+	config.Location = strings.ToLower(strings.ReplaceAll(config.Location, " ", ""))
+
+	// disable disk related rate limit
+	config.DiskRateLimit = &azclients.RateLimitConfig{
+		CloudProviderRateLimit: false,
+	}
+	config.SnapshotRateLimit = &azclients.RateLimitConfig{
+		CloudProviderRateLimit: false,
+	}
+	config.UserAgent = userAgent
+	if enableTrafficMgr && trafficMgrPort > 0 {
+		trafficMgrAddr := fmt.Sprintf("http://localhost:%d/", trafficMgrPort)
+		klog.V(2).Infof("set ResourceManagerEndpoint as %s", trafficMgrAddr)
+		config.ResourceManagerEndpoint = trafficMgrAddr
+	}
+	return az.InitializeCloudFromConfig(ctx, config, fromSecret, false)
+}
+
 func GetKubeClient(kubeconfig string) (clientset.Interface, error) {
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	if err != nil {
@@ -266,6 +327,16 @@ func GetKubeClient(kubeconfig string) (clientset.Interface, error) {
 	return clientset.NewForConfig(config)
 }
 
+// GetAzDiskClient returns the clientset of the custom resources used by the V2 driver
+func GetAzDiskClient(kubeconfig string) (azdiskclientset.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return azdiskclientset.NewForConfig(config)
+}
+
 // GetDiskLUN : deviceInfo could be a LUN number or a device path, e.g. /dev/disk/azure/scsi1/lun2
 func GetDiskLUN(deviceInfo string) (int32, error) {
 	var diskLUN string
@@ -347,6 +418,38 @@ func GetMaxShares(attributes map[string]string) (int, error) {
 	return 1, nil // disk is not shared
 }
 
+// GetMaxMountReplicaCount returns the number of attachment replicas to maintain for a volume.
+// If not specified, it defaults to 0 for multi-writer volumes and to maxShares - 1 otherwise.
+func GetMaxMountReplicaCount(attributes map[string]string, maxShares int, isMultiWriter bool) (int, error) {
+	for k, v := range attributes {
+		switch strings.ToLower(k) {
+		case consts.MaxMountReplicaCountField:
+			maxMountReplicaCount, err := strconv.Atoi(v)
+			if err != nil {
+				return 0, fmt.Errorf("parse %s failed with error: %v", v, err)
+			}
+			if maxMountReplicaCount < 0 || maxMountReplicaCount > maxShares-1 {
+				return 0, fmt.Errorf("%s(%d) must be in the range [0..%d] for %s(%d)", consts.MaxMountReplicaCountField, maxMountReplicaCount, maxShares-1, consts.MaxSharesField, maxShares)
+			}
+			return maxMountReplicaCount, nil
+		}
+	}
+	if isMultiWriter || maxShares < 1 {
+		return 0, nil
+	}
+	return maxShares - 1, nil
+}
+
+// IsMultiWriterVolumeCapabilities returns true if any capability allows multiple nodes to write to the volume
+func IsMultiWriterVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
+	for _, c := range volCaps {
+		if c.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER {
+			return true
+		}
+	}
+	return false
+}
+
 func GetResourceGroupFromURI(diskURI string) (string, error) {
 	fields := strings.Split(diskURI, "/")
 	if len(fields) != 9 || strings.ToLower(fields[3]) != "resourcegroups" {
@@ -629,6 +732,15 @@ func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, e
 			if diskParams.MaxShares < 1 {
 				return diskParams, fmt.Errorf("parse %s returned with invalid value: %d", v, diskParams.MaxShares)
 			}
+		case consts.MaxMountReplicaCountField:
+			maxMountReplicaCount, err := strconv.Atoi(v)
+			if err != nil {
+				return diskParams, fmt.Errorf("parse %s failed with error: %v", v, err)
+			}
+			if maxMountReplicaCount < 0 {
+				return diskParams, fmt.Errorf("parse %s returned with invalid value: %d", v, maxMountReplicaCount)
+			}
+			diskParams.MaxMountReplicaCount = &maxMountReplicaCount
 		case consts.PvcNameKey:
 			diskParams.Tags[consts.PvcNameTag] = v
 		case consts.PvcNamespaceKey:
@@ -637,6 +749,22 @@ func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, e
 			diskParams.Tags[consts.PvNameTag] = v
 		case consts.FsTypeField:
 			diskParams.FsType = strings.ToLower(v)
+		case consts.FormatOptionsField:
+			diskParams.FormatOptions = v
+		case consts.FsckPolicyField:
+			if diskParams.FsckPolicy, err = GetFsckPolicy(map[string]string{k: v}); err != nil {
+				return diskParams, err
+			}
+		case consts.TrimScheduleField:
+			if _, _, err = GetTrimSchedule(map[string]string{k: v}); err != nil {
+				return diskParams, err
+			}
+		case consts.LocalCacheField, consts.LocalCacheSizeField, consts.LocalCacheModeField:
+			// validated with all the local cache parameters below
+		case consts.StripeCountField:
+			if diskParams.StripeCount, err = GetStripeCount(map[string]string{k: v}); err != nil {
+				return diskParams, err
+			}
 		case consts.KindField:
 			// fix csi migration issue: https://github.com/kubernetes/kubernetes/issues/103433
 			diskParams.VolumeContext[consts.KindField] = string(v1.AzureManagedDisk)
@@ -691,6 +819,28 @@ func ParseDiskParameters(parameters map[string]string) (ManagedDiskParameters, e
 		diskParams.Tags[k] = v
 	}
 
+	if diskParams.MaxMountReplicaCount != nil {
+		maxShares := diskParams.MaxShares
+		if maxShares < 1 {
+			maxShares = 1
+		}
+		if *diskParams.MaxMountReplicaCount > maxShares-1 {
+			return diskParams, fmt.Errorf("%s(%d) must be in the range [0..%d] for %s(%d)", consts.MaxMountReplicaCountField, *diskParams.MaxMountReplicaCount, maxShares-1, consts.MaxSharesField, maxShares)
+		}
+	}
+
+	localCache, _, err := GetLocalCache(parameters)
+	if err != nil {
+		return diskParams, err
+	}
+	if localCache != consts.LocalCacheNone && diskParams.MaxShares > 1 {
+		return diskParams, fmt.Errorf("%s(%s) is not supported for shared disks with %s(%d)", consts.LocalCacheField, localCache, consts.MaxSharesField, diskParams.MaxShares)
+	}
+
+	if diskParams.StripeCount > 1 && diskParams.MaxShares > 1 {
+		return diskParams, fmt.Errorf("%s(%d) is not supported for shared disks with %s(%d)", consts.StripeCountField, diskParams.StripeCount, consts.MaxSharesField, diskParams.MaxShares)
+	}
+
 	if strings.EqualFold(diskParams.AccountType, string(armcompute.DiskStorageAccountTypesPremiumV2LRS)) {
 		if diskParams.CachingMode != "" && !strings.EqualFold(string(diskParams.CachingMode), string(v1.AzureDataDiskCachingNone)) {
 			return diskParams, fmt.Errorf("cachingMode %s is not supported for %s", diskParams.CachingMode, armcompute.DiskStorageAccountTypesPremiumV2LRS)
@@ -759,6 +909,9 @@ func InsertDiskProperties(disk *armcompute.Disk, publishConext map[string]string
 	prop := disk.Properties
 	if prop != nil {
 		publishConext[consts.NetworkAccessPolicyField] = string(*prop.NetworkAccessPolicy)
+		if prop.DiskSizeGB != nil {
+			publishConext[consts.DiskSizeGBField] = strconv.Itoa(int(*prop.DiskSizeGB))
+		}
 		if prop.DiskIOPSReadWrite != nil {
 			publishConext[consts.DiskIOPSReadWriteField] = strconv.Itoa(int(*prop.DiskIOPSReadWrite))
 		}
@@ -778,6 +931,15 @@ func InsertDiskProperties(disk *armcompute.Disk, publishConext map[string]string
 	}
 }
 
+// InsertDiskIdentity inserts the unique ID of disk in publishContext, the node checks it against the identifiers of
+// the device attached at the LUN of the publish context before staging it
+func InsertDiskIdentity(disk *armcompute.Disk, publishContext map[string]string) {
+	if disk == nil || disk.Properties == nil || disk.Properties.UniqueID == nil || publishContext == nil {
+		return
+	}
+	publishContext[consts.DiskUniqueIDField] = *disk.Properties.UniqueID
+}
+
 func SleepIfThrottled(err error, defaultSleepSec int) {
 	if err != nil && IsThrottlingError(err) {
 		retryAfter := getRetryAfterSeconds(err)
@@ -36,6 +36,7 @@ import (
 	"k8s.io/utils/pointer"
 	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
 	"sigs.k8s.io/azuredisk-csi-driver/test/utils/testutil"
+	azure "sigs.k8s.io/cloud-provider-azure/pkg/provider"
 )
 
 func TestCheckDiskName(t *testing.T) {
@@ -327,6 +328,97 @@ users:
 	}
 }
 
+func TestGetCloudProviderFromSecrets(t *testing.T) {
+	baseConfig := &azure.Config{
+		ResourceGroup: "rg",
+		Location:      "eastus",
+	}
+	baseConfig.SubscriptionID = "subscription"
+	baseConfig.TenantID = "tenant"
+	baseConfig.UseManagedIdentityExtension = true
+
+	tests := []struct {
+		desc                   string
+		baseConfig             *azure.Config
+		secrets                map[string]string
+		expectedErr            error
+		expectedSubscriptionID string
+		expectedResourceGroup  string
+		expectedTenantID       string
+	}{
+		{
+			desc:       "[success] identity keys override the base config",
+			baseConfig: baseConfig,
+			secrets: map[string]string{
+				consts.SecretSubscriptionIDField:  "tenant-subscription",
+				consts.SecretResourceGroupField:   "tenant-rg",
+				consts.SecretAADClientIDField:     "client",
+				consts.SecretAADClientSecretField: "secret",
+			},
+			expectedSubscriptionID: "tenant-subscription",
+			expectedResourceGroup:  "tenant-rg",
+			expectedTenantID:       "tenant",
+		},
+		{
+			desc:       "[success] base config identity is kept without credentials in the secret",
+			baseConfig: baseConfig,
+			secrets: map[string]string{
+				consts.SecretSubscriptionIDField: "tenant-subscription",
+			},
+			expectedSubscriptionID: "tenant-subscription",
+			expectedResourceGroup:  "rg",
+			expectedTenantID:       "tenant",
+		},
+		{
+			desc:       "[success] complete cloud config",
+			baseConfig: baseConfig,
+			secrets: map[string]string{
+				consts.SecretCloudConfigField: `{"tenantId": "other-tenant", "subscriptionId": "other-subscription", "resourceGroup": "other-rg", "location": "West US", "aadClientId": "client", "aadClientSecret": "secret"}`,
+			},
+			expectedSubscriptionID: "other-subscription",
+			expectedResourceGroup:  "other-rg",
+			expectedTenantID:       "other-tenant",
+		},
+		{
+			desc:        "[failure] invalid cloud config",
+			baseConfig:  baseConfig,
+			secrets:     map[string]string{consts.SecretCloudConfigField: "{"},
+			expectedErr: fmt.Errorf("failed to parse cloud-config in secret"),
+		},
+		{
+			desc:        "[failure] invalid key",
+			baseConfig:  baseConfig,
+			secrets:     map[string]string{"unknown": "value"},
+			expectedErr: fmt.Errorf("invalid key unknown in secret"),
+		},
+		{
+			desc:        "[failure] no credentials",
+			secrets:     map[string]string{consts.SecretAADClientIDField: "client"},
+			expectedErr: fmt.Errorf("no credentials provided in secret"),
+		},
+	}
+
+	for _, test := range tests {
+		cloud, err := GetCloudProviderFromSecrets(context.Background(), test.baseConfig, test.secrets, "useragent", false, -1)
+		if test.expectedErr != nil {
+			if err == nil || !strings.Contains(err.Error(), test.expectedErr.Error()) {
+				t.Errorf("desc: %s, GetCloudProviderFromSecrets err: %v, expectedErr: %v", test.desc, err, test.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("desc: %s, GetCloudProviderFromSecrets err: %v", test.desc, err)
+			continue
+		}
+		assert.Equal(t, test.expectedSubscriptionID, cloud.SubscriptionID, test.desc)
+		assert.Equal(t, test.expectedResourceGroup, cloud.ResourceGroup, test.desc)
+		assert.Equal(t, test.expectedTenantID, cloud.TenantID, test.desc)
+		assert.Equal(t, "useragent", cloud.UserAgent, test.desc)
+		assert.Regexp(t, "^[a-z0-9]+$", cloud.Location, test.desc)
+	}
+	assert.Equal(t, "subscription", baseConfig.SubscriptionID, "base config must not be modified")
+}
+
 func TestGetDiskLUN(t *testing.T) {
 	tests := []struct {
 		deviceInfo  string
@@ -523,6 +615,64 @@ func TestGetMaxShares(t *testing.T) {
 	}
 }
 
+func TestGetMaxMountReplicaCount(t *testing.T) {
+	tests := []struct {
+		options       map[string]string
+		maxShares     int
+		isMultiWriter bool
+		expectedValue int
+		expectedError error
+	}{
+		{
+			options:       nil,
+			maxShares:     3,
+			expectedValue: 2,
+		},
+		{
+			options:       map[string]string{},
+			maxShares:     3,
+			isMultiWriter: true,
+			expectedValue: 0,
+		},
+		{
+			options:       map[string]string{},
+			maxShares:     1,
+			expectedValue: 0,
+		},
+		{
+			options:       map[string]string{consts.MaxMountReplicaCountField: "1"},
+			maxShares:     3,
+			isMultiWriter: true,
+			expectedValue: 1,
+		},
+		{
+			options:       map[string]string{consts.MaxMountReplicaCountField: "NAN"},
+			maxShares:     3,
+			expectedError: fmt.Errorf("parse NAN failed with error: strconv.Atoi: parsing \"NAN\": invalid syntax"),
+		},
+		{
+			options:       map[string]string{consts.MaxMountReplicaCountField: "3"},
+			maxShares:     3,
+			expectedError: fmt.Errorf("maxmountreplicacount(3) must be in the range [0..2] for maxshares(3)"),
+		},
+		{
+			options:       map[string]string{consts.MaxMountReplicaCountField: "-1"},
+			maxShares:     3,
+			expectedError: fmt.Errorf("maxmountreplicacount(-1) must be in the range [0..2] for maxshares(3)"),
+		},
+	}
+
+	for _, test := range tests {
+		result, err := GetMaxMountReplicaCount(test.options, test.maxShares, test.isMultiWriter)
+		if result != test.expectedValue {
+			t.Errorf("input: %q, GetMaxMountReplicaCount result: %v, expected: %v", test.options, result, test.expectedValue)
+		}
+		if !reflect.DeepEqual(err, test.expectedError) {
+			t.Errorf("input: %q, GetMaxMountReplicaCount error: %v, expected: %v", test.options, err, test.expectedError)
+		}
+	}
+}
+
 func TestGetResourceGroupFromURI(t *testing.T) {
 	tests := []struct {
 		diskURL        string
@@ -1554,6 +1704,105 @@ func TestParseDiskParameters(t *testing.T) {
 			},
 			expectedError: nil,
 		},
+		{
+			name: "valid maxMountReplicaCount",
+			inputParams: map[string]string{
+				consts.MaxSharesField:            "3",
+				consts.MaxMountReplicaCountField: "2",
+			},
+			expectedOutput: ManagedDiskParameters{
+				MaxShares:            3,
+				MaxMountReplicaCount: pointer.Int(2),
+				Tags:                 make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.MaxSharesField:            "3",
+					consts.MaxMountReplicaCountField: "2",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "valid formatOptions",
+			inputParams: map[string]string{
+				consts.FormatOptionsField: "-b 4096",
+			},
+			expectedOutput: ManagedDiskParameters{
+				FormatOptions: "-b 4096",
+				Tags:          make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.FormatOptionsField: "-b 4096",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: nil,
+		},
+		{
+			name: "stripeCount with shared disk",
+			inputParams: map[string]string{
+				consts.StripeCountField: "2",
+				consts.MaxSharesField:   "2",
+			},
+			expectedOutput: ManagedDiskParameters{
+				StripeCount: 2,
+				MaxShares:   2,
+				Tags:        make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.StripeCountField: "2",
+					consts.MaxSharesField:   "2",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("stripecount(2) is not supported for shared disks with maxshares(2)"),
+		},
+		{
+			name: "localCache with shared disk",
+			inputParams: map[string]string{
+				consts.LocalCacheField:     "dm-cache",
+				consts.LocalCacheSizeField: "1Gi",
+				consts.MaxSharesField:      "2",
+			},
+			expectedOutput: ManagedDiskParameters{
+				MaxShares: 2,
+				Tags:      make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.LocalCacheField:     "dm-cache",
+					consts.LocalCacheSizeField: "1Gi",
+					consts.MaxSharesField:      "2",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("localcache(dm-cache) is not supported for shared disks with maxshares(2)"),
+		},
+		{
+			name: "localCache without localCacheSize",
+			inputParams: map[string]string{
+				consts.LocalCacheField: "dm-cache",
+			},
+			expectedOutput: ManagedDiskParameters{
+				Tags: make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.LocalCacheField: "dm-cache",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("localcachesize must be set with localcache dm-cache"),
+		},
+		{
+			name: "maxMountReplicaCount exceeds maxShares - 1",
+			inputParams: map[string]string{
+				consts.MaxMountReplicaCountField: "1",
+			},
+			expectedOutput: ManagedDiskParameters{
+				MaxMountReplicaCount: pointer.Int(1),
+				Tags:                 make(map[string]string),
+				VolumeContext: map[string]string{
+					consts.MaxMountReplicaCountField: "1",
+				},
+				DeviceSettings: make(map[string]string),
+			},
+			expectedError: fmt.Errorf("maxmountreplicacount(1) must be in the range [0..0] for maxshares(1)"),
+		},
 	}
 	for _, test := range testCases {
 		test := test
@@ -1725,6 +1974,7 @@ func TestInsertDiskProperties(t *testing.T) {
 				SKU: &armcompute.DiskSKU{Name: to.Ptr(armcompute.DiskStorageAccountTypesStandardSSDLRS)},
 				Properties: &armcompute.DiskProperties{
 					NetworkAccessPolicy: to.Ptr(armcompute.NetworkAccessPolicyAllowPrivate),
+					DiskSizeGB:          pointer.Int32(10),
 					DiskIOPSReadWrite:   pointer.Int64(6400),
 					DiskMBpsReadWrite:   pointer.Int64(100),
 					CreationData: &armcompute.CreationData{
@@ -1738,6 +1988,7 @@ func TestInsertDiskProperties(t *testing.T) {
 			expectedMap: map[string]string{
 				consts.SkuNameField:             string(armcompute.DiskStorageAccountTypesStandardSSDLRS),
 				consts.NetworkAccessPolicyField: string(armcompute.NetworkAccessPolicyAllowPrivate),
+				consts.DiskSizeGBField:          "10",
 				consts.DiskIOPSReadWriteField:   "6400",
 				consts.DiskMBPSReadWriteField:   "100",
 				consts.LogicalSectorSizeField:   "512",
@@ -1757,6 +2008,17 @@ func TestInsertDiskProperties(t *testing.T) {
 	}
 }
 
+func TestInsertDiskIdentity(t *testing.T) {
+	publishContext := map[string]string{}
+	InsertDiskIdentity(nil, publishContext)
+	InsertDiskIdentity(&armcompute.Disk{}, publishContext)
+	assert.Empty(t, publishContext)
+
+	InsertDiskIdentity(&armcompute.Disk{Properties: &armcompute.DiskProperties{UniqueID: pointer.String("3f2504e0-4f89-41d3-9a0c-0305e82c3301")}}, publishContext)
+	assert.Equal(t, map[string]string{consts.DiskUniqueIDField: "3f2504e0-4f89-41d3-9a0c-0305e82c3301"}, publishContext)
+	InsertDiskIdentity(&armcompute.Disk{Properties: &armcompute.DiskProperties{UniqueID: pointer.String("id")}}, nil)
+}
+
 func TestSleepIfThrottled(t *testing.T) {
 	const sleepDuration = 1 * time.Second
 
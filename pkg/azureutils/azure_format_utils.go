@@ -0,0 +1,182 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+var (
+	// extFormatOptions are the mkfs options allowed for ext filesystems <option, takes a value>
+	extFormatOptions = map[string]bool{
+		"-b": true, // block size
+		"-C": true, // cluster size
+		"-E": true, // extended options, e.g. lazy_itable_init=1,stride=16
+		"-G": true, // number of block groups in a flex group
+		"-g": true, // blocks per group
+		"-I": true, // inode size
+		"-i": true, // bytes per inode
+		"-J": true, // journal options
+		"-N": true, // number of inodes
+		"-O": true, // filesystem features
+		"-T": true, // usage type
+	}
+	// formatOptionsAllowlist lists the mkfs options allowed in formatOptions per fstype <fstype, <option, takes a value>>.
+	// Options that mount-utils always passes (-F, -m0 for ext, -f for xfs) or that affect the identity of the
+	// filesystem (labels, UUIDs) are not allowed.
+	formatOptionsAllowlist = map[string]map[string]bool{
+		"ext2": extFormatOptions,
+		"ext3": extFormatOptions,
+		"ext4": extFormatOptions,
+		"xfs": {
+			"-b": true,  // block size, e.g. size=4096
+			"-d": true,  // data section, e.g. su=64k,sw=4
+			"-i": true,  // inode options, e.g. size=512
+			"-K": false, // do not discard blocks
+			"-l": true,  // log section
+			"-m": true,  // metadata, e.g. reflink=1,crc=1
+			"-n": true,  // naming section
+			"-s": true,  // sector size, e.g. size=4096
+		},
+	}
+
+	formatOptionValueRE = regexp.MustCompile(`^[A-Za-z0-9_.,:=^+]+$`)
+)
+
+// GetFormatOptions returns the formatOptions set in attributes
+func GetFormatOptions(attributes map[string]string) string {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.FormatOptionsField) {
+			return v
+		}
+	}
+	return ""
+}
+
+// GetLogicalSectorSize returns the logical sector size of the disk set in attributes, 0 if it is not set
+func GetLogicalSectorSize(attributes map[string]string) (int, error) {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.LogicalSectorSizeField) {
+			size, err := strconv.Atoi(v)
+			if err != nil {
+				return 0, fmt.Errorf("parse %s:%s failed with error: %v", consts.LogicalSectorSizeField, v, err)
+			}
+			return size, nil
+		}
+	}
+	return 0, nil
+}
+
+// ParseFormatOptions validates formatOptions, mkfs options separated by spaces, against the options allowed for
+// fstype and returns the mkfs arguments. On disks with a logical sector size above 512 bytes, e.g. 4K native
+// PremiumV2 and Ultra disks, the block size must not be smaller than the sector size and the sector size is set
+// explicitly if formatOptions do not set it.
+func ParseFormatOptions(fstype, formatOptions string, logicalSectorSize int) ([]string, error) {
+	fstype = strings.ToLower(fstype)
+	fields := strings.Fields(formatOptions)
+	if len(fields) == 0 && logicalSectorSize <= 512 {
+		return nil, nil
+	}
+	allowed, ok := formatOptionsAllowlist[fstype]
+	if !ok {
+		if len(fields) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s is not supported for fstype %q", consts.FormatOptionsField, fstype)
+	}
+
+	args := make([]string, 0, len(fields)+2)
+	values := map[string]string{}
+	for i := 0; i < len(fields); i++ {
+		option := fields[i]
+		takesValue, ok := allowed[option]
+		if !ok {
+			return nil, fmt.Errorf("mkfs option %q in %s is not allowed for fstype %s", option, consts.FormatOptionsField, fstype)
+		}
+		args = append(args, option)
+		if !takesValue {
+			continue
+		}
+		if i+1 >= len(fields) {
+			return nil, fmt.Errorf("mkfs option %q in %s requires a value", option, consts.FormatOptionsField)
+		}
+		i++
+		if !formatOptionValueRE.MatchString(fields[i]) {
+			return nil, fmt.Errorf("invalid value %q of mkfs option %q in %s", fields[i], option, consts.FormatOptionsField)
+		}
+		values[option] = fields[i]
+		args = append(args, fields[i])
+	}
+
+	if logicalSectorSize > 512 {
+		blockSize, sectorOption := values["-b"], ""
+		if fstype == "xfs" {
+			blockSize, sectorOption = getFormatSubOption(values["-b"], "size"), "-s"
+		}
+		if blockSize != "" {
+			size, err := strconv.Atoi(blockSize)
+			if err != nil {
+				return nil, fmt.Errorf("invalid block size %q in %s: %v", blockSize, consts.FormatOptionsField, err)
+			}
+			if size < logicalSectorSize {
+				return nil, fmt.Errorf("block size %d in %s is smaller than the logical sector size %d of the disk", size, consts.FormatOptionsField, logicalSectorSize)
+			}
+		} else if fstype != "xfs" {
+			args = append(args, "-b", strconv.Itoa(logicalSectorSize))
+		}
+		if sectorOption != "" && values[sectorOption] == "" {
+			args = append(args, sectorOption, fmt.Sprintf("size=%d", logicalSectorSize))
+		}
+	}
+	return args, nil
+}
+
+// ValidateFormatOptions validates the formatOptions of diskParams against the fstype the volume will be formatted
+// with, which is the fsType parameter, the fsType of the mount capability or defaultFsType in that order.
+func ValidateFormatOptions(diskParams *ManagedDiskParameters, volCaps []*csi.VolumeCapability, defaultFsType string) error {
+	if diskParams.FormatOptions == "" {
+		return nil
+	}
+	fstype := diskParams.FsType
+	for _, c := range volCaps {
+		if mnt := c.GetMount(); fstype == "" && mnt != nil {
+			fstype = mnt.FsType
+		}
+	}
+	if fstype == "" {
+		fstype = defaultFsType
+	}
+	_, err := ParseFormatOptions(fstype, diskParams.FormatOptions, diskParams.LogicalSectorSize)
+	return err
+}
+
+// getFormatSubOption returns the value of key in the comma separated key=value list value
+func getFormatSubOption(value, key string) string {
+	for _, option := range strings.Split(value, ",") {
+		if k, v, ok := strings.Cut(option, "="); ok && strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormatOptions(t *testing.T) {
+	tests := []struct {
+		desc              string
+		fstype            string
+		formatOptions     string
+		logicalSectorSize int
+		expected          []string
+		expectErr         bool
+	}{
+		{
+			desc:   "no format options",
+			fstype: "ext4",
+		},
+		{
+			desc:          "ext4 options",
+			fstype:        "ext4",
+			formatOptions: "-b 4096 -i 16384  -E lazy_itable_init=1,stride=16",
+			expected:      []string{"-b", "4096", "-i", "16384", "-E", "lazy_itable_init=1,stride=16"},
+		},
+		{
+			desc:          "xfs options",
+			fstype:        "XFS",
+			formatOptions: "-K -d su=64k,sw=4 -m reflink=1",
+			expected:      []string{"-K", "-d", "su=64k,sw=4", "-m", "reflink=1"},
+		},
+		{
+			desc:          "option not in allowlist",
+			fstype:        "ext4",
+			formatOptions: "-L data",
+			expectErr:     true,
+		},
+		{
+			desc:          "xfs option on ext4",
+			fstype:        "ext4",
+			formatOptions: "-K",
+			expectErr:     true,
+		},
+		{
+			desc:          "missing value",
+			fstype:        "ext4",
+			formatOptions: "-b",
+			expectErr:     true,
+		},
+		{
+			desc:          "invalid value",
+			fstype:        "ext4",
+			formatOptions: "-E $(reboot)",
+			expectErr:     true,
+		},
+		{
+			desc:          "unsupported fstype",
+			fstype:        "ntfs",
+			formatOptions: "-b 4096",
+			expectErr:     true,
+		},
+		{
+			desc:              "unsupported fstype on 4K native disk",
+			fstype:            "ntfs",
+			logicalSectorSize: 4096,
+		},
+		{
+			desc:              "ext4 on 4K native disk",
+			fstype:            "ext4",
+			logicalSectorSize: 4096,
+			expected:          []string{"-b", "4096"},
+		},
+		{
+			desc:              "ext4 block size below sector size",
+			fstype:            "ext4",
+			formatOptions:     "-b 1024",
+			logicalSectorSize: 4096,
+			expectErr:         true,
+		},
+		{
+			desc:              "xfs on 4K native disk",
+			fstype:            "xfs",
+			formatOptions:     "-b size=4096",
+			logicalSectorSize: 4096,
+			expected:          []string{"-b", "size=4096", "-s", "size=4096"},
+		},
+		{
+			desc:              "xfs block size below sector size",
+			fstype:            "xfs",
+			formatOptions:     "-b size=2048",
+			logicalSectorSize: 4096,
+			expectErr:         true,
+		},
+		{
+			desc:              "xfs sector size set explicitly",
+			fstype:            "xfs",
+			formatOptions:     "-s size=4096",
+			logicalSectorSize: 4096,
+			expected:          []string{"-s", "size=4096"},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := ParseFormatOptions(test.fstype, test.formatOptions, test.logicalSectorSize)
+		if test.expectErr {
+			assert.Error(t, err, test.desc)
+			continue
+		}
+		assert.NoError(t, err, test.desc)
+		assert.Equal(t, test.expected, result, test.desc)
+	}
+}
+
+func TestValidateFormatOptions(t *testing.T) {
+	xfsCaps := []*csi.VolumeCapability{{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"}}}}
+
+	assert.NoError(t, ValidateFormatOptions(&ManagedDiskParameters{}, xfsCaps, "ext4"))
+	assert.NoError(t, ValidateFormatOptions(&ManagedDiskParameters{FormatOptions: "-K"}, xfsCaps, "ext4"))
+	assert.Error(t, ValidateFormatOptions(&ManagedDiskParameters{FormatOptions: "-K", FsType: "ext4"}, xfsCaps, "ext4"))
+	assert.Error(t, ValidateFormatOptions(&ManagedDiskParameters{FormatOptions: "-K"}, nil, "ext4"))
+	assert.Error(t, ValidateFormatOptions(&ManagedDiskParameters{FormatOptions: "-b 512", LogicalSectorSize: 4096}, nil, "ext4"))
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"fmt"
+	"strings"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// GetFsckPolicy returns the fsckPolicy set in attributes, none if it is not set
+func GetFsckPolicy(attributes map[string]string) (string, error) {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.FsckPolicyField) {
+			policy := strings.ToLower(v)
+			switch policy {
+			case "":
+				return consts.FsckPolicyNone, nil
+			case consts.FsckPolicyNone, consts.FsckPolicyCheck, consts.FsckPolicyRepair:
+				return policy, nil
+			}
+			return "", fmt.Errorf("%s %q is not supported, supported values are %s, %s and %s", consts.FsckPolicyField, v,
+				consts.FsckPolicyNone, consts.FsckPolicyCheck, consts.FsckPolicyRepair)
+		}
+	}
+	return consts.FsckPolicyNone, nil
+}
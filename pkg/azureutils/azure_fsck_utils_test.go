@@ -0,0 +1,42 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFsckPolicy(t *testing.T) {
+	tests := []struct {
+		attributes map[string]string
+		expected   string
+		expectErr  bool
+	}{
+		{nil, "none", false},
+		{map[string]string{"fsckPolicy": ""}, "none", false},
+		{map[string]string{"fsckPolicy": "Check"}, "check", false},
+		{map[string]string{"fsckpolicy": "repair"}, "repair", false},
+		{map[string]string{"fsckPolicy": "force"}, "", true},
+	}
+	for _, test := range tests {
+		result, err := GetFsckPolicy(test.attributes)
+		assert.Equal(t, test.expectErr, err != nil, "%v", test.attributes)
+		assert.Equal(t, test.expected, result, "%v", test.attributes)
+	}
+}
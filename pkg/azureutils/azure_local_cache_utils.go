@@ -0,0 +1,68 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// MinimumLocalCacheSize is the minimum size of the local cache of a volume
+var MinimumLocalCacheSize = resource.MustParse("64Mi")
+
+// GetLocalCache returns the type and the size in bytes of the local cache set in attributes, the type is
+// LocalCacheNone if no local cache is set. Only the writethrough mode is supported so that every write is durable on
+// the Azure disk when it completes.
+func GetLocalCache(attributes map[string]string) (string, int64, error) {
+	cacheType, size, mode := consts.LocalCacheNone, "", consts.LocalCacheModeWritethrough
+	for k, v := range attributes {
+		switch strings.ToLower(k) {
+		case consts.LocalCacheField:
+			cacheType = strings.ToLower(v)
+		case consts.LocalCacheSizeField:
+			size = v
+		case consts.LocalCacheModeField:
+			mode = strings.ToLower(v)
+		}
+	}
+
+	switch cacheType {
+	case consts.LocalCacheNone:
+		return cacheType, 0, nil
+	case consts.LocalCacheDMCache:
+	default:
+		return "", 0, fmt.Errorf("%s %s is not supported, supported values are %s and %s", consts.LocalCacheField, cacheType, consts.LocalCacheNone, consts.LocalCacheDMCache)
+	}
+	if mode != consts.LocalCacheModeWritethrough {
+		return "", 0, fmt.Errorf("%s %s is not supported, only %s is supported", consts.LocalCacheModeField, mode, consts.LocalCacheModeWritethrough)
+	}
+	if size == "" {
+		return "", 0, fmt.Errorf("%s must be set with %s %s", consts.LocalCacheSizeField, consts.LocalCacheField, cacheType)
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return "", 0, fmt.Errorf("parse %s:%s failed with error: %v", consts.LocalCacheSizeField, size, err)
+	}
+	if quantity.Cmp(MinimumLocalCacheSize) < 0 {
+		return "", 0, fmt.Errorf("%s(%s) must be at least %s", consts.LocalCacheSizeField, size, MinimumLocalCacheSize.String())
+	}
+	return cacheType, quantity.Value(), nil
+}
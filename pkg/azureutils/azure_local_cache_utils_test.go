@@ -0,0 +1,50 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+func TestGetLocalCache(t *testing.T) {
+	tests := []struct {
+		attributes    map[string]string
+		expectedType  string
+		expectedBytes int64
+		expectErr     bool
+	}{
+		{nil, consts.LocalCacheNone, 0, false},
+		{map[string]string{"localCache": "None", "localCacheSize": "1Gi"}, consts.LocalCacheNone, 0, false},
+		{map[string]string{"localCache": "dm-cache", "localCacheSize": "1Gi"}, consts.LocalCacheDMCache, 1 << 30, false},
+		{map[string]string{"localcache": "DM-Cache", "localcachesize": "64Mi", "localcachemode": "WriteThrough"}, consts.LocalCacheDMCache, 64 << 20, false},
+		{map[string]string{"localCache": "bcache", "localCacheSize": "1Gi"}, "", 0, true},
+		{map[string]string{"localCache": "dm-cache", "localCacheSize": "1Gi", "localCacheMode": "writeback"}, "", 0, true},
+		{map[string]string{"localCache": "dm-cache"}, "", 0, true},
+		{map[string]string{"localCache": "dm-cache", "localCacheSize": "1G1"}, "", 0, true},
+		{map[string]string{"localCache": "dm-cache", "localCacheSize": "32Mi"}, "", 0, true},
+	}
+	for _, test := range tests {
+		cacheType, sizeBytes, err := GetLocalCache(test.attributes)
+		assert.Equal(t, test.expectErr, err != nil, "%v", test.attributes)
+		assert.Equal(t, test.expectedType, cacheType, "%v", test.attributes)
+		assert.Equal(t, test.expectedBytes, sizeBytes, "%v", test.attributes)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// GetStripeCount returns the number of member disks of the striped volume set in attributes, 1 if it is not set
+func GetStripeCount(attributes map[string]string) (int, error) {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.StripeCountField) {
+			stripeCount, err := strconv.Atoi(v)
+			if err != nil {
+				return 0, fmt.Errorf("parse %s:%s failed with error: %v", consts.StripeCountField, v, err)
+			}
+			if stripeCount < 1 || stripeCount > consts.MaxStripeCount {
+				return 0, fmt.Errorf("%s(%d) must be in the range [1..%d]", consts.StripeCountField, stripeCount, consts.MaxStripeCount)
+			}
+			return stripeCount, nil
+		}
+	}
+	return 1, nil
+}
+
+// IsStripedVolumeID returns whether the volume or snapshot ID is the ID of a striped volume or of its snapshot
+func IsStripedVolumeID(id string) bool {
+	return strings.Contains(id, consts.StripedVolumeIDSeparator)
+}
+
+// GetStripeMembers returns the IDs of the member disks or snapshots of a striped volume or snapshot ID, or the ID
+// itself if it is not striped
+func GetStripeMembers(id string) []string {
+	return strings.Split(id, consts.StripedVolumeIDSeparator)
+}
+
+// JoinStripeMembers returns the ID of a striped volume or snapshot with the IDs of its members
+func JoinStripeMembers(members []string) string {
+	return strings.Join(members, consts.StripedVolumeIDSeparator)
+}
+
+// GetStripeMemberName returns the name of the member disk or snapshot at index of the striped volume or snapshot
+// name, name is truncated so that the member suffix is kept within the maximum disk name length
+func GetStripeMemberName(name string, index int) string {
+	suffix := fmt.Sprintf("-stripe%d", index)
+	if len(name)+len(suffix) > diskNameMaxLength {
+		name = name[:diskNameMaxLength-len(suffix)]
+	}
+	return name + suffix
+}
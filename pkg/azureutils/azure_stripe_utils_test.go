@@ -0,0 +1,63 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStripeCount(t *testing.T) {
+	tests := []struct {
+		attributes map[string]string
+		expected   int
+		expectErr  bool
+	}{
+		{nil, 1, false},
+		{map[string]string{"stripeCount": "4"}, 4, false},
+		{map[string]string{"stripecount": "1"}, 1, false},
+		{map[string]string{"stripeCount": "0"}, 0, true},
+		{map[string]string{"stripeCount": "9"}, 0, true},
+		{map[string]string{"stripeCount": "two"}, 0, true},
+	}
+	for _, test := range tests {
+		result, err := GetStripeCount(test.attributes)
+		assert.Equal(t, test.expectErr, err != nil, "%v", test.attributes)
+		assert.Equal(t, test.expected, result, "%v", test.attributes)
+	}
+}
+
+func TestStripeMembers(t *testing.T) {
+	members := []string{
+		"/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/disk-stripe0",
+		"/subscriptions/subs/resourceGroups/rg/providers/Microsoft.Compute/disks/disk-stripe1",
+	}
+	id := JoinStripeMembers(members)
+	assert.True(t, IsStripedVolumeID(id))
+	assert.Equal(t, members, GetStripeMembers(id))
+	assert.False(t, IsStripedVolumeID(members[0]))
+	assert.Equal(t, members[:1], GetStripeMembers(members[0]))
+}
+
+func TestGetStripeMemberName(t *testing.T) {
+	assert.Equal(t, "pvc-1-stripe0", GetStripeMemberName("pvc-1", 0))
+	name := GetStripeMemberName(strings.Repeat("a", 90), 7)
+	assert.Len(t, name, diskNameMaxLength)
+	assert.True(t, strings.HasSuffix(name, "-stripe7"))
+}
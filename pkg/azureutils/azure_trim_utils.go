@@ -0,0 +1,49 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+)
+
+// MinimumTrimInterval is the shortest interval at which a volume may be trimmed
+var MinimumTrimInterval = time.Hour
+
+// GetTrimSchedule returns the trim interval of the trimSchedule set in attributes and whether it is set, an interval
+// of 0 disables trim for the volume
+func GetTrimSchedule(attributes map[string]string) (time.Duration, bool, error) {
+	for k, v := range attributes {
+		if strings.EqualFold(k, consts.TrimScheduleField) {
+			if strings.EqualFold(v, consts.TrimScheduleNone) {
+				return 0, true, nil
+			}
+			interval, err := time.ParseDuration(v)
+			if err != nil {
+				return 0, false, fmt.Errorf("parse %s:%s failed with error: %v", consts.TrimScheduleField, v, err)
+			}
+			if interval < MinimumTrimInterval {
+				return 0, false, fmt.Errorf("%s(%v) must be %s or at least %v", consts.TrimScheduleField, interval, consts.TrimScheduleNone, MinimumTrimInterval)
+			}
+			return interval, true, nil
+		}
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTrimSchedule(t *testing.T) {
+	tests := []struct {
+		attributes  map[string]string
+		expected    time.Duration
+		expectedSet bool
+		expectErr   bool
+	}{
+		{nil, 0, false, false},
+		{map[string]string{"trimSchedule": "none"}, 0, true, false},
+		{map[string]string{"trimschedule": "24h"}, 24 * time.Hour, true, false},
+		{map[string]string{"trimSchedule": "30m"}, 0, false, true},
+		{map[string]string{"trimSchedule": "daily"}, 0, false, true},
+	}
+	for _, test := range tests {
+		result, set, err := GetTrimSchedule(test.attributes)
+		assert.Equal(t, test.expectErr, err != nil, "%v", test.attributes)
+		assert.Equal(t, test.expected, result, "%v", test.attributes)
+		assert.Equal(t, test.expectedSet, set, "%v", test.attributes)
+	}
+}
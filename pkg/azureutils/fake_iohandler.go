@@ -131,7 +131,16 @@ func (handler *fakeIOHandler) WriteFile(_ string, _ []byte, _ os.FileMode) error
 	return nil
 }
 
-func (handler *fakeIOHandler) Readlink(_ string) (string, error) {
+func (handler *fakeIOHandler) Readlink(name string) (string, error) {
+	switch name {
+	// the devices of the data disks are not links, their SCSI devices are
+	case "/dev/" + devName, "/dev/" + devName1:
+		return "", fmt.Errorf("readlink %s: invalid argument", name)
+	case "/sys/class/block/" + devName + "/device":
+		return "../../../" + diskPath, nil
+	case "/sys/class/block/" + devName1 + "/device":
+		return "../../../" + diskPath1, nil
+	}
 	return "/dev/azure/disk/sda", nil
 }
 
@@ -0,0 +1,110 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeSysfs is an IOHandler serving an in-memory tree of files and symlinks, e.g. a sysfs tree of the devices of a
+// node. Like in sysfs, only existing files can be written.
+type FakeSysfs struct {
+	lock sync.Mutex
+	// files are the contents of the files of the tree by path
+	files map[string]string
+	// links are the targets of the symlinks of the tree by path
+	links map[string]string
+	// writes are the files written in order
+	writes []string
+}
+
+// NewFakeSysfs returns a FakeSysfs with the files and symlinks by path
+func NewFakeSysfs(files, links map[string]string) *FakeSysfs {
+	f := &FakeSysfs{files: map[string]string{}, links: map[string]string{}}
+	for path, content := range files {
+		f.files[filepath.Clean(path)] = content
+	}
+	for path, target := range links {
+		f.links[filepath.Clean(path)] = target
+	}
+	return f
+}
+
+func (f *FakeSysfs) ReadDir(dirname string) ([]os.DirEntry, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	dirname = filepath.Clean(dirname) + "/"
+	names := map[string]bool{}
+	for _, paths := range []map[string]string{f.files, f.links} {
+		for path := range paths {
+			if name, found := strings.CutPrefix(path, dirname); found {
+				names[strings.Split(name, "/")[0]] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("open %s: %w", dirname, os.ErrNotExist)
+	}
+	entries := make([]os.DirEntry, 0, len(names))
+	for name := range names {
+		entries = append(entries, &fakeDirEntry{name: name})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (f *FakeSysfs) WriteFile(filename string, data []byte, _ os.FileMode) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	filename = filepath.Clean(filename)
+	if _, ok := f.files[filename]; !ok {
+		return fmt.Errorf("open %s: %w", filename, os.ErrNotExist)
+	}
+	f.files[filename] = string(data)
+	f.writes = append(f.writes, filename)
+	return nil
+}
+
+func (f *FakeSysfs) Readlink(name string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if target, ok := f.links[filepath.Clean(name)]; ok {
+		return target, nil
+	}
+	return "", fmt.Errorf("readlink %s: %w", name, os.ErrNotExist)
+}
+
+func (f *FakeSysfs) ReadFile(filename string) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if content, ok := f.files[filepath.Clean(filename)]; ok {
+		return []byte(content), nil
+	}
+	return nil, fmt.Errorf("open %s: %w", filename, os.ErrNotExist)
+}
+
+// Writes returns the files written in order
+func (f *FakeSysfs) Writes() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.writes...)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azureutils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeSysfs(t *testing.T) {
+	fs := NewFakeSysfs(map[string]string{
+		"/sys/class/nvme/nvme0/model":             "MSFT NVMe Accelerator v1.0\n",
+		"/sys/class/nvme/nvme0/nvme0n2/nsid":      "2\n",
+		"/sys/class/nvme/nvme0/rescan_controller": "",
+	}, map[string]string{
+		"/dev/disk/azure/data/by-lun/0": "../../../../nvme0n2",
+	})
+
+	entries, err := fs.ReadDir("/sys/class/nvme/nvme0/")
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"model", "nvme0n2", "rescan_controller"}, names)
+	_, err = fs.ReadDir("/sys/bus/scsi/devices")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	content, err := fs.ReadFile("/sys/class/nvme/nvme0/nvme0n2/nsid")
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", string(content))
+	_, err = fs.ReadFile("/sys/class/nvme/nvme0/serial")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	target, err := fs.Readlink("/dev/disk/azure/data/by-lun/0")
+	require.NoError(t, err)
+	assert.Equal(t, "../../../../nvme0n2", target)
+	_, err = fs.Readlink("/dev/disk/azure/data/by-lun/1")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, fs.WriteFile("/sys/class/nvme/nvme0/rescan_controller", []byte("1"), 0666))
+	assert.ErrorIs(t, fs.WriteFile("/sys/class/nvme/nvme0/reset_controller", []byte("1"), 0666), os.ErrNotExist)
+	assert.Equal(t, []string{"/sys/class/nvme/nvme0/rescan_controller"}, fs.Writes())
+	content, err = fs.ReadFile("/sys/class/nvme/nvme0/rescan_controller")
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(content))
+}
@@ -94,8 +94,13 @@ func getDeviceSettingsForBasicProfile(nodeInfo *NodeInfo,
 
 	deviceSettings = make(map[string]string)
 	deviceSettings[filepath.Join(deviceRoot, "queue/max_sectors_kb")] = maxSectorsKb
-	deviceSettings[filepath.Join(deviceRoot, "queue/scheduler")] = scheduler
-	deviceSettings[filepath.Join(deviceRoot, "device/queue_depth")] = queueDepth
+	if isNVMeDevice(deviceRoot) {
+		// the queues of an NVMe namespace are sized by its controller and are not scheduled by the host
+		deviceSettings[filepath.Join(deviceRoot, "queue/scheduler")] = "none"
+	} else {
+		deviceSettings[filepath.Join(deviceRoot, "queue/scheduler")] = scheduler
+		deviceSettings[filepath.Join(deviceRoot, "device/queue_depth")] = queueDepth
+	}
 	deviceSettings[filepath.Join(deviceRoot, "queue/nr_requests")] = nrRequests
 	deviceSettings[filepath.Join(deviceRoot, "queue/read_ahead_kb")] = "8"
 
@@ -132,6 +137,11 @@ func applyDeviceSettings(deviceRoot string, deviceSettings map[string]string) (e
 	return nil
 }
 
+// isNVMeDevice returns whether deviceRoot is the root of an NVMe namespace, e.g. /sys/block/nvme0n2
+func isNVMeDevice(deviceRoot string) bool {
+	return strings.HasPrefix(filepath.Base(deviceRoot), "nvme")
+}
+
 // getDeviceName gets the device name from the device lunpath
 // Device lun path is of the format /dev/disk/azure/scsi1/lun0
 func getDeviceName(lunPath string) (deviceName string, err error) {
@@ -229,6 +229,7 @@ func Test_getDeviceSettingsForBasicProfile(t *testing.T) {
 
 	tests := []struct {
 		name             string
+		deviceRoot       string
 		perfProfile      string
 		accountType      string
 		DiskSizeGibStr   string
@@ -244,6 +245,7 @@ func Test_getDeviceSettingsForBasicProfile(t *testing.T) {
 	}{
 		{
 			name:           "Should return valid disk perf settings",
+			deviceRoot:     "/sys/block/sdc",
 			perfProfile:    "basic",
 			accountType:    "Premium_LRS",
 			DiskSizeGibStr: "512",
@@ -253,10 +255,22 @@ func Test_getDeviceSettingsForBasicProfile(t *testing.T) {
 			wantErr:        false,
 			node:           nodeInfo,
 		},
+		{
+			name:           "Should return valid nvme disk perf settings",
+			deviceRoot:     "/sys/block/nvme0n2",
+			perfProfile:    "basic",
+			accountType:    "Premium_LRS",
+			DiskSizeGibStr: "512",
+			diskIopsStr:    "100",
+			diskBwMbpsStr:  "100",
+			wantScheduler:  "none",
+			wantErr:        false,
+			node:           nodeInfo,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			deviceSettings, err := getDeviceSettingsForBasicProfile(tt.node, "", tt.perfProfile, tt.accountType, tt.DiskSizeGibStr, tt.diskIopsStr, tt.diskBwMbpsStr)
+			deviceSettings, err := getDeviceSettingsForBasicProfile(tt.node, tt.deviceRoot, tt.perfProfile, tt.accountType, tt.DiskSizeGibStr, tt.diskIopsStr, tt.diskBwMbpsStr)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getDeviceSettingsForBasicProfile() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -264,6 +278,9 @@ func Test_getDeviceSettingsForBasicProfile(t *testing.T) {
 				if len(deviceSettings) == 0 {
 					t.Errorf("getDeviceSettingsForBasicProfile() failed for get deviceSettings")
 				}
+				assert.Equal(t, tt.wantScheduler, deviceSettings[path.Join(tt.deviceRoot, "queue/scheduler")])
+				_, hasQueueDepth := deviceSettings[path.Join(tt.deviceRoot, "device/queue_depth")]
+				assert.Equal(t, !isNVMeDevice(tt.deviceRoot), hasQueueDepth)
 			}
 		})
 	}
@@ -36,10 +36,16 @@ if [[ "${ARCH}" == "x86_64" || ${ARCH} == "unknown" ]]; then
   ARCH="amd64"
 fi
 
+driver_args=(--endpoint "$endpoint" --nodeid "$nodeid" -v=5 -support-zone=false -enable-disk-capacity-check=true)
+if [[ -n "${provisioner:-}" ]]; then
+  # the node has no VM in Azure to look up its data disk limit, the limit is the one of the VMs of the in-memory provisioner
+  driver_args+=(--provisioner "$provisioner" --volume-attach-limit 8)
+fi
+
 if [[ "$#" -lt 2 || "$2" != "v2" ]]; then
-  _output/${ARCH}/azurediskplugin --endpoint "$endpoint" --nodeid "$nodeid" -v=5 -support-zone=false -enable-disk-capacity-check=true &
+  _output/${ARCH}/azurediskplugin "${driver_args[@]}" &
 else
-  _output/${ARCH}/azurediskpluginv2 --endpoint "$endpoint" --nodeid "$nodeid" -v=5 -support-zone=false -enable-disk-capacity-check=true &
+  _output/${ARCH}/azurediskpluginv2 "${driver_args[@]}" &
 fi
 
 # sleep a while waiting for azurediskplugin start up
@@ -19,12 +19,15 @@ package sanity
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"sigs.k8s.io/azuredisk-csi-driver/test/utils/armemulator"
 	"sigs.k8s.io/azuredisk-csi-driver/test/utils/azure"
 	"sigs.k8s.io/azuredisk-csi-driver/test/utils/credentials"
 
@@ -34,25 +37,111 @@ import (
 const (
 	nodeid = "sanity-test-node"
 	vmType = "standard"
+
+	emulatorSubscriptionID = "00000000-0000-0000-0000-000000000000"
+	emulatorResourceGroup  = "azuredisk-csi-driver-sanity"
+	inMemoryLocation       = "eastus"
+
+	provisionerInMemory = "inmemory"
 )
 
-var useDriverV2 = flag.Bool("temp-use-driver-v2", false, "A temporary flag to enable early test and development of Azure Disk CSI Driver V2. This will be removed in the future.")
+var (
+	useDriverV2 = flag.Bool("temp-use-driver-v2", false, "A temporary flag to enable early test and development of Azure Disk CSI Driver V2. This will be removed in the future.")
+	useAzure    = flag.Bool("use-azure", false, "Run the sanity test against a resource group and VM in a real Azure subscription instead of the local ARM emulator.")
+	provisioner = flag.String("provisioner", "", "Provisioner of the driver under test, inmemory runs the driver against its in-memory model of disks and VMs instead of the local ARM emulator.")
+)
 
 func TestSanity(t *testing.T) {
+	switch {
+	case *useAzure:
+		setupAzure(t)
+	case *provisioner == provisionerInMemory:
+		setupInMemory(t)
+	default:
+		setupEmulator(t)
+	}
+	t.Setenv("nodeid", nodeid)
+
+	// Execute the script from project root
+	err := os.Chdir("../..")
+	assert.NoError(t, err)
+	// Change directory back to test/sanity
+	defer func() {
+		err := os.Chdir("test/sanity")
+		assert.NoError(t, err)
+	}()
+
+	projectRoot, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(projectRoot, "azuredisk-csi-driver"))
+
+	args := make([]string, 0)
+	if *useDriverV2 {
+		args = append(args, "v2")
+	}
+
+	cmd := exec.Command("./test/sanity/run-tests-all-clouds.sh", args...)
+	cmd.Dir = projectRoot
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Sanity test failed %v", err)
+	}
+}
+
+// setupEmulator starts the local ARM emulator with a VM for the sanity test and points the driver at it
+func setupEmulator(t *testing.T) {
+	emulator := armemulator.New(emulatorSubscriptionID, "")
+	t.Cleanup(emulator.Close)
+	emulator.AddVM(emulatorResourceGroup, nodeid, "", 0)
+
+	certFile := filepath.Join(t.TempDir(), "armemulator.pem")
+	err := emulator.WriteCACertificate(certFile)
+	assert.NoError(t, err)
+	err = emulator.WriteCloudConfig(credentials.TempAzureCredentialFilePath, emulatorResourceGroup, vmType)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		err := credentials.DeleteAzureCredentialFile()
+		assert.NoError(t, err)
+	})
+
+	log.Printf("Running sanity test against the ARM emulator at %s", emulator.URL())
+	t.Setenv("SSL_CERT_FILE", certFile)
+	t.Setenv("AZURE_CREDENTIAL_FILE", credentials.TempAzureCredentialFilePath)
+}
+
+// setupInMemory runs the driver with the in-memory provisioner, the cloud config only sets the subscription, resource
+// group and location the driver builds the IDs of disks and snapshots with, no ARM endpoint is called
+func setupInMemory(t *testing.T) {
+	config := fmt.Sprintf(`{"cloud": "%s", "subscriptionId": "%s", "resourceGroup": "%s", "location": "%s", "vmType": "%s"}`,
+		credentials.AzurePublicCloud, emulatorSubscriptionID, emulatorResourceGroup, inMemoryLocation, vmType)
+	err := os.WriteFile(credentials.TempAzureCredentialFilePath, []byte(config), 0600)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		err := credentials.DeleteAzureCredentialFile()
+		assert.NoError(t, err)
+	})
+
+	log.Printf("Running sanity test against the %s provisioner", provisionerInMemory)
+	t.Setenv("AZURE_CREDENTIAL_FILE", credentials.TempAzureCredentialFilePath)
+	t.Setenv("provisioner", provisionerInMemory)
+}
+
+// setupAzure creates a resource group with a VM for the sanity test in a real Azure subscription
+func setupAzure(t *testing.T) {
 	// Set necessary env vars for creating azure credential file
 	t.Setenv("AZURE_VM_TYPE", vmType)
 
 	creds, err := credentials.CreateAzureCredentialFile()
-	defer func() {
+	t.Cleanup(func() {
 		err := credentials.DeleteAzureCredentialFile()
 		assert.NoError(t, err)
-	}()
+	})
 	assert.NoError(t, err)
 	assert.NotNil(t, creds)
 
 	// Set necessary env vars for sanity test
 	t.Setenv("AZURE_CREDENTIAL_FILE", credentials.TempAzureCredentialFilePath)
-	t.Setenv("nodeid", nodeid)
 
 	azureClient, err := azure.GetAzureClient(creds.Cloud, creds.SubscriptionID, creds.AADClientID, creds.TenantID, creds.AADClientSecret)
 	assert.NoError(t, err)
@@ -62,42 +151,16 @@ func TestSanity(t *testing.T) {
 	log.Printf("Creating resource group %s in %s", creds.ResourceGroup, creds.Cloud)
 	_, err = azureClient.EnsureResourceGroup(ctx, creds.ResourceGroup, creds.Location, nil)
 	assert.NoError(t, err)
-	defer func() {
+	t.Cleanup(func() {
 		// Only delete resource group the test created
 		if strings.HasPrefix(creds.ResourceGroup, credentials.ResourceGroupPrefix) {
 			log.Printf("Deleting resource group %s", creds.ResourceGroup)
 			err := azureClient.DeleteResourceGroup(ctx, creds.ResourceGroup)
 			assert.NoError(t, err)
 		}
-	}()
+	})
 
 	log.Printf("Creating a VM in %s", creds.ResourceGroup)
 	_, err = azureClient.EnsureVirtualMachine(ctx, creds.ResourceGroup, creds.Location, nodeid)
 	assert.NoError(t, err)
-
-	// Execute the script from project root
-	err = os.Chdir("../..")
-	assert.NoError(t, err)
-	// Change directory back to test/sanity
-	defer func() {
-		err := os.Chdir("test/sanity")
-		assert.NoError(t, err)
-	}()
-
-	projectRoot, err := os.Getwd()
-	assert.NoError(t, err)
-	assert.True(t, strings.HasSuffix(projectRoot, "azuredisk-csi-driver"))
-
-	args := make([]string, 0)
-	if *useDriverV2 {
-		args = append(args, "v2")
-	}
-
-	cmd := exec.Command("./test/sanity/run-tests-all-clouds.sh", args...)
-	cmd.Dir = projectRoot
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		t.Fatalf("Sanity test failed %v", err)
-	}
 }
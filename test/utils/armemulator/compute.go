@@ -0,0 +1,621 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package armemulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	resourceGroupPath = "/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute"
+	gib               = 1 << 30
+)
+
+// apiError is an error response of the emulator
+type apiError struct {
+	statusCode int
+	code       string
+	message    string
+}
+
+func (e *apiError) write(w http.ResponseWriter) {
+	writeError(w, e.statusCode, e.code, e.message)
+}
+
+func notFound(resourceType, name, resourceGroup string) *apiError {
+	return &apiError{
+		statusCode: http.StatusNotFound,
+		code:       "ResourceNotFound",
+		message:    fmt.Sprintf("The Resource 'Microsoft.Compute/%s/%s' under resource group '%s' was not found.", resourceType, name, resourceGroup),
+	}
+}
+
+func badRequest(code, format string, args ...interface{}) *apiError {
+	return &apiError{statusCode: http.StatusBadRequest, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func conflict(code, format string, args ...interface{}) *apiError {
+	return &apiError{statusCode: http.StatusConflict, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func newUniqueID() string {
+	return string(uuid.NewUUID())
+}
+
+func equalFold(segment string, values ...string) bool {
+	for _, v := range values {
+		if strings.EqualFold(segment, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeBody(r *http.Request, v interface{}) *apiError {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest("InvalidRequestContent", "The request content was invalid and could not be deserialized: %v", err)
+	}
+	return nil
+}
+
+// serveCompute serves the Microsoft.Compute resources below /subscriptions
+func (e *Emulator) serveCompute(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 8 && equalFold(segments[2], "providers") && equalFold(segments[4], "locations") && equalFold(segments[6], "operations") {
+		e.serveOperation(w, segments[7])
+		return
+	}
+	if len(segments) < 7 || !equalFold(segments[2], "resourceGroups") || !equalFold(segments[4], "providers") || !equalFold(segments[5], "Microsoft.Compute") {
+		writeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s is not supported by the emulator", r.URL.Path))
+		return
+	}
+	if !strings.EqualFold(segments[1], e.subscriptionID) {
+		writeError(w, http.StatusNotFound, "SubscriptionNotFound", fmt.Sprintf("The subscription '%s' could not be found.", segments[1]))
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	resourceGroup := segments[3]
+	var apiErr *apiError
+	switch strings.ToLower(segments[6]) {
+	case "disks":
+		if len(segments) == 7 && r.Method == http.MethodGet {
+			writeList(w, e.disks, e.resourcePrefix(resourceGroup, "disks"))
+			return
+		} else if len(segments) == 8 {
+			apiErr = e.serveDisk(w, r, resourceGroup, segments[7])
+		} else {
+			apiErr = badRequest("InvalidResourceType", "The resource type could not be found")
+		}
+	case "snapshots":
+		if len(segments) == 7 && r.Method == http.MethodGet {
+			writeList(w, e.snapshots, e.resourcePrefix(resourceGroup, "snapshots"))
+			return
+		} else if len(segments) == 8 {
+			apiErr = e.serveSnapshot(w, r, resourceGroup, segments[7])
+		} else {
+			apiErr = badRequest("InvalidResourceType", "The resource type could not be found")
+		}
+	case "virtualmachines":
+		if len(segments) == 7 && r.Method == http.MethodGet {
+			writeList(w, e.vms, e.resourcePrefix(resourceGroup, "virtualMachines"))
+			return
+		} else if len(segments) == 8 {
+			apiErr = e.serveVM(w, r, resourceGroup, segments[7])
+		} else {
+			apiErr = badRequest("InvalidResourceType", "The resource type could not be found")
+		}
+	case "virtualmachinescalesets":
+		apiErr = e.serveScaleSet(w, r, resourceGroup, segments[7:])
+	default:
+		apiErr = badRequest("InvalidResourceType", "The resource type '%s' could not be found in the namespace 'Microsoft.Compute'", segments[6])
+	}
+	if apiErr != nil {
+		apiErr.write(w)
+	}
+}
+
+func (e *Emulator) resourcePrefix(resourceGroup, resourceType string) string {
+	return strings.ToLower(fmt.Sprintf(resourceGroupPath, e.subscriptionID, resourceGroup) + "/" + resourceType + "/")
+}
+
+func (e *Emulator) resourceID(resourceGroup, resourceType, name string) string {
+	return fmt.Sprintf(resourceGroupPath, e.subscriptionID, resourceGroup) + "/" + resourceType + "/" + name
+}
+
+// writeList writes the resources whose lower case ID starts with prefix and has no further segments
+func writeList[T any](w http.ResponseWriter, resources map[string]*T, prefix string) {
+	keys := []string{}
+	for key := range resources {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(strings.TrimPrefix(key, prefix), "/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	values := make([]*T, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, resources[key])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": values})
+}
+
+func (e *Emulator) serveDisk(w http.ResponseWriter, r *http.Request, resourceGroup, name string) *apiError {
+	id := e.resourceID(resourceGroup, "disks", name)
+	key := strings.ToLower(id)
+	disk, exists := e.disks[key]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			return notFound("disks", name, resourceGroup)
+		}
+		writeJSON(w, http.StatusOK, disk)
+	case http.MethodPut:
+		request := &armcompute.Disk{}
+		if apiErr := decodeBody(r, request); apiErr != nil {
+			return apiErr
+		}
+		if exists {
+			if apiErr := updateDisk(disk, request.Properties, request.SKU, request.Tags); apiErr != nil {
+				return apiErr
+			}
+			e.startOperation(w, r, http.StatusOK, disk)
+			return nil
+		}
+		disk, apiErr := e.newDisk(id, name, request)
+		if apiErr != nil {
+			return apiErr
+		}
+		e.disks[key] = disk
+		e.startOperation(w, r, http.StatusAccepted, disk)
+	case http.MethodPatch:
+		if !exists {
+			return notFound("disks", name, resourceGroup)
+		}
+		request := &armcompute.DiskUpdate{}
+		if apiErr := decodeBody(r, request); apiErr != nil {
+			return apiErr
+		}
+		var properties *armcompute.DiskProperties
+		if request.Properties != nil {
+			properties = &armcompute.DiskProperties{
+				DiskSizeGB:        request.Properties.DiskSizeGB,
+				DiskIOPSReadWrite: request.Properties.DiskIOPSReadWrite,
+				DiskMBpsReadWrite: request.Properties.DiskMBpsReadWrite,
+				BurstingEnabled:   request.Properties.BurstingEnabled,
+				MaxShares:         request.Properties.MaxShares,
+			}
+		}
+		if apiErr := updateDisk(disk, properties, request.SKU, request.Tags); apiErr != nil {
+			return apiErr
+		}
+		e.startOperation(w, r, http.StatusOK, disk)
+	case http.MethodDelete:
+		if !exists {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		if len(disk.ManagedByExtended) > 0 {
+			return conflict("OperationNotAllowed", "Disk %s is attached to VM %s.", name, *disk.ManagedByExtended[0])
+		}
+		delete(e.disks, key)
+		e.startOperation(w, r, http.StatusAccepted, nil)
+	default:
+		return &apiError{statusCode: http.StatusMethodNotAllowed, code: "MethodNotAllowed", message: r.Method + " is not supported"}
+	}
+	return nil
+}
+
+// newDisk validates the request to create a disk and returns the disk
+func (e *Emulator) newDisk(id, name string, request *armcompute.Disk) (*armcompute.Disk, *apiError) {
+	if request.Properties == nil || request.Properties.CreationData == nil || request.Properties.CreationData.CreateOption == nil {
+		return nil, badRequest("InvalidParameter", "Required parameter 'creationData.createOption' is missing (null).")
+	}
+	properties := *request.Properties
+	creationData := *properties.CreationData
+	properties.CreationData = &creationData
+
+	switch *creationData.CreateOption {
+	case armcompute.DiskCreateOptionEmpty:
+		if properties.DiskSizeGB == nil || *properties.DiskSizeGB <= 0 {
+			return nil, badRequest("InvalidParameter", "Required parameter 'diskSizeGB' is missing (null).")
+		}
+	case armcompute.DiskCreateOptionCopy, armcompute.DiskCreateOptionRestore:
+		if creationData.SourceResourceID == nil {
+			return nil, badRequest("InvalidParameter", "Required parameter 'creationData.sourceResourceId' is missing (null).")
+		}
+		sourceSizeGB, apiErr := e.sourceSizeGB(*creationData.SourceResourceID)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if properties.DiskSizeGB == nil {
+			properties.DiskSizeGB = ptr(sourceSizeGB)
+		} else if *properties.DiskSizeGB < sourceSizeGB {
+			return nil, badRequest("BadRequest", "Disk size %d GB is smaller than the size %d GB of the source %s.", *properties.DiskSizeGB, sourceSizeGB, *creationData.SourceResourceID)
+		}
+	default:
+		return nil, badRequest("InvalidParameter", "The value '%s' of parameter 'creationData.createOption' is not supported by the emulator.", *creationData.CreateOption)
+	}
+
+	sku := request.SKU
+	if sku == nil || sku.Name == nil {
+		sku = &armcompute.DiskSKU{Name: ptr(armcompute.DiskStorageAccountTypesStandardLRS)}
+	}
+	location := e.location
+	if request.Location != nil && *request.Location != "" {
+		location = *request.Location
+	}
+	properties.ProvisioningState = ptr("Succeeded")
+	properties.DiskState = ptr(armcompute.DiskStateUnattached)
+	properties.TimeCreated = ptr(time.Now().UTC())
+	properties.UniqueID = ptr(newUniqueID())
+	properties.DiskSizeBytes = ptr(int64(*properties.DiskSizeGB) * gib)
+	return &armcompute.Disk{
+		ID:               ptr(id),
+		Name:             ptr(name),
+		Type:             ptr("Microsoft.Compute/disks"),
+		Location:         ptr(location),
+		Tags:             request.Tags,
+		Zones:            request.Zones,
+		ExtendedLocation: request.ExtendedLocation,
+		SKU:              sku,
+		Properties:       &properties,
+	}, nil
+}
+
+// updateDisk applies the updatable properties of a disk
+func updateDisk(disk *armcompute.Disk, properties *armcompute.DiskProperties, sku *armcompute.DiskSKU, tags map[string]*string) *apiError {
+	if properties != nil {
+		if properties.DiskSizeGB != nil {
+			if *properties.DiskSizeGB < *disk.Properties.DiskSizeGB {
+				return badRequest("BadRequest", "Disk size reduction is not supported. Requested size: %d GB. Current size: %d GB.", *properties.DiskSizeGB, *disk.Properties.DiskSizeGB)
+			}
+			disk.Properties.DiskSizeGB = properties.DiskSizeGB
+			disk.Properties.DiskSizeBytes = ptr(int64(*properties.DiskSizeGB) * gib)
+		}
+		if properties.MaxShares != nil && *properties.MaxShares != ptrValue(disk.Properties.MaxShares) {
+			if len(disk.ManagedByExtended) > 0 {
+				return conflict("OperationNotAllowed", "Cannot change maxShares of disk %s while it is attached.", *disk.Name)
+			}
+			disk.Properties.MaxShares = properties.MaxShares
+		}
+		if properties.DiskIOPSReadWrite != nil {
+			disk.Properties.DiskIOPSReadWrite = properties.DiskIOPSReadWrite
+		}
+		if properties.DiskMBpsReadWrite != nil {
+			disk.Properties.DiskMBpsReadWrite = properties.DiskMBpsReadWrite
+		}
+		if properties.BurstingEnabled != nil {
+			disk.Properties.BurstingEnabled = properties.BurstingEnabled
+		}
+	}
+	if sku != nil && sku.Name != nil {
+		disk.SKU = sku
+	}
+	if tags != nil {
+		disk.Tags = tags
+	}
+	return nil
+}
+
+// sourceSizeGB returns the size of the disk or snapshot a resource is created from
+func (e *Emulator) sourceSizeGB(sourceResourceID string) (int32, *apiError) {
+	if source, ok := e.disks[strings.ToLower(sourceResourceID)]; ok {
+		return *source.Properties.DiskSizeGB, nil
+	}
+	if source, ok := e.snapshots[strings.ToLower(sourceResourceID)]; ok {
+		return *source.Properties.DiskSizeGB, nil
+	}
+	return 0, &apiError{
+		statusCode: http.StatusNotFound,
+		code:       "NotFound",
+		message:    fmt.Sprintf("Source resource %s was not found.", sourceResourceID),
+	}
+}
+
+func (e *Emulator) serveSnapshot(w http.ResponseWriter, r *http.Request, resourceGroup, name string) *apiError {
+	id := e.resourceID(resourceGroup, "snapshots", name)
+	key := strings.ToLower(id)
+	snapshot, exists := e.snapshots[key]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			return notFound("snapshots", name, resourceGroup)
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+	case http.MethodPut:
+		request := &armcompute.Snapshot{}
+		if apiErr := decodeBody(r, request); apiErr != nil {
+			return apiErr
+		}
+		if request.Properties == nil || request.Properties.CreationData == nil || request.Properties.CreationData.SourceResourceID == nil {
+			return badRequest("InvalidParameter", "Required parameter 'creationData.sourceResourceId' is missing (null).")
+		}
+		sourceResourceID := *request.Properties.CreationData.SourceResourceID
+		if exists {
+			if !strings.EqualFold(*snapshot.Properties.CreationData.SourceResourceID, sourceResourceID) {
+				return conflict("PropertyChangeNotAllowed", "Changing property 'sourceResourceId' is not allowed for existing disk '%s'.", name)
+			}
+			if request.Tags != nil {
+				snapshot.Tags = request.Tags
+			}
+			e.startOperation(w, r, http.StatusOK, snapshot)
+			return nil
+		}
+		sizeGB, apiErr := e.sourceSizeGB(sourceResourceID)
+		if apiErr != nil {
+			return apiErr
+		}
+		properties := *request.Properties
+		properties.DiskSizeGB = ptr(sizeGB)
+		properties.DiskSizeBytes = ptr(int64(sizeGB) * gib)
+		properties.ProvisioningState = ptr("Succeeded")
+		properties.TimeCreated = ptr(time.Now().UTC())
+		properties.UniqueID = ptr(newUniqueID())
+		location := e.location
+		if request.Location != nil && *request.Location != "" {
+			location = *request.Location
+		}
+		snapshot = &armcompute.Snapshot{
+			ID:         ptr(id),
+			Name:       ptr(name),
+			Type:       ptr("Microsoft.Compute/snapshots"),
+			Location:   ptr(location),
+			Tags:       request.Tags,
+			SKU:        request.SKU,
+			Properties: &properties,
+		}
+		e.snapshots[key] = snapshot
+		e.startOperation(w, r, http.StatusAccepted, snapshot)
+	case http.MethodDelete:
+		if !exists {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+		delete(e.snapshots, key)
+		e.startOperation(w, r, http.StatusAccepted, nil)
+	default:
+		return &apiError{statusCode: http.StatusMethodNotAllowed, code: "MethodNotAllowed", message: r.Method + " is not supported"}
+	}
+	return nil
+}
+
+// vmStatuses returns the instance view statuses of a running VM
+func vmStatuses() []*armcompute.InstanceViewStatus {
+	return []*armcompute.InstanceViewStatus{
+		{Code: ptr("ProvisioningState/succeeded"), Level: ptr(armcompute.StatusLevelTypesInfo), DisplayStatus: ptr("Provisioning succeeded")},
+		{Code: ptr("PowerState/running"), Level: ptr(armcompute.StatusLevelTypesInfo), DisplayStatus: ptr("VM running")},
+	}
+}
+
+func (e *Emulator) serveVM(w http.ResponseWriter, r *http.Request, resourceGroup, name string) *apiError {
+	id := e.resourceID(resourceGroup, "virtualMachines", name)
+	key := strings.ToLower(id)
+	vm, exists := e.vms[key]
+	if !exists {
+		return notFound("virtualMachines", name, resourceGroup)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		vm.Properties.InstanceView = &armcompute.VirtualMachineInstanceView{Statuses: vmStatuses()}
+		writeJSON(w, http.StatusOK, vm)
+	case http.MethodPut, http.MethodPatch:
+		var storageProfile *armcompute.StorageProfile
+		if r.Method == http.MethodPut {
+			request := &armcompute.VirtualMachine{}
+			if apiErr := decodeBody(r, request); apiErr != nil {
+				return apiErr
+			}
+			if request.Properties != nil {
+				storageProfile = request.Properties.StorageProfile
+			}
+		} else {
+			request := &armcompute.VirtualMachineUpdate{}
+			if apiErr := decodeBody(r, request); apiErr != nil {
+				return apiErr
+			}
+			if request.Properties != nil {
+				storageProfile = request.Properties.StorageProfile
+			}
+		}
+		if storageProfile != nil && storageProfile.DataDisks != nil {
+			dataDisks, apiErr := e.updateDataDisks(*vm.ID, vm.Zones, vm.Properties.StorageProfile.DataDisks, storageProfile.DataDisks)
+			if apiErr != nil {
+				return apiErr
+			}
+			vm.Properties.StorageProfile.DataDisks = dataDisks
+		}
+		e.startOperation(w, r, http.StatusOK, vm)
+	default:
+		return &apiError{statusCode: http.StatusMethodNotAllowed, code: "MethodNotAllowed", message: r.Method + " is not supported"}
+	}
+	return nil
+}
+
+// serveScaleSet serves scale sets and their VMs, segments are the path segments following virtualMachineScaleSets
+func (e *Emulator) serveScaleSet(w http.ResponseWriter, r *http.Request, resourceGroup string, segments []string) *apiError {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		writeList(w, e.scaleSets, e.resourcePrefix(resourceGroup, "virtualMachineScaleSets"))
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		scaleSet, ok := e.scaleSets[strings.ToLower(e.resourceID(resourceGroup, "virtualMachineScaleSets", segments[0]))]
+		if !ok {
+			return notFound("virtualMachineScaleSets", segments[0], resourceGroup)
+		}
+		writeJSON(w, http.StatusOK, scaleSet)
+	case len(segments) == 2 && equalFold(segments[1], "virtualMachines") && r.Method == http.MethodGet:
+		prefix := e.resourcePrefix(resourceGroup, "virtualMachineScaleSets") + strings.ToLower(segments[0]) + "/virtualmachines/"
+		for key, vm := range e.scaleSetVMs {
+			if strings.HasPrefix(key, prefix) {
+				vm.Properties.InstanceView = &armcompute.VirtualMachineScaleSetVMInstanceView{Statuses: vmStatuses()}
+			}
+		}
+		writeList(w, e.scaleSetVMs, prefix)
+	case len(segments) == 3 && equalFold(segments[1], "virtualMachines"):
+		id := e.resourceID(resourceGroup, "virtualMachineScaleSets", segments[0]) + "/virtualMachines/" + segments[2]
+		vm, ok := e.scaleSetVMs[strings.ToLower(id)]
+		if !ok {
+			return notFound("virtualMachineScaleSets/virtualMachines", segments[0]+"/"+segments[2], resourceGroup)
+		}
+		switch r.Method {
+		case http.MethodGet:
+			vm.Properties.InstanceView = &armcompute.VirtualMachineScaleSetVMInstanceView{Statuses: vmStatuses()}
+			writeJSON(w, http.StatusOK, vm)
+		case http.MethodPut:
+			request := &armcompute.VirtualMachineScaleSetVM{}
+			if apiErr := decodeBody(r, request); apiErr != nil {
+				return apiErr
+			}
+			if request.Properties != nil && request.Properties.StorageProfile != nil && request.Properties.StorageProfile.DataDisks != nil {
+				dataDisks, apiErr := e.updateDataDisks(*vm.ID, vm.Zones, vm.Properties.StorageProfile.DataDisks, request.Properties.StorageProfile.DataDisks)
+				if apiErr != nil {
+					return apiErr
+				}
+				vm.Properties.StorageProfile.DataDisks = dataDisks
+			}
+			e.startOperation(w, r, http.StatusOK, vm)
+		default:
+			return &apiError{statusCode: http.StatusMethodNotAllowed, code: "MethodNotAllowed", message: r.Method + " is not supported"}
+		}
+	default:
+		return badRequest("InvalidResourceType", "The resource type could not be found")
+	}
+	return nil
+}
+
+// updateDataDisks validates the requested data disks of a VM and updates the attachment state of the disks.
+// Data disks missing from the request or marked toBeDetached are detached.
+func (e *Emulator) updateDataDisks(ownerID string, ownerZones []*string, current, requested []*armcompute.DataDisk) ([]*armcompute.DataDisk, *apiError) {
+	dataDisks := []*armcompute.DataDisk{}
+	disks := []*armcompute.Disk{}
+	usedLuns := map[int32]bool{}
+	for _, dataDisk := range requested {
+		if dataDisk.ToBeDetached != nil && *dataDisk.ToBeDetached {
+			continue
+		}
+		if dataDisk.ManagedDisk == nil || dataDisk.ManagedDisk.ID == nil {
+			return nil, badRequest("InvalidParameter", "Only managed data disks are supported by the emulator.")
+		}
+		if dataDisk.Lun == nil {
+			return nil, badRequest("InvalidParameter", "Required parameter 'dataDisk.lun' is missing (null).")
+		}
+		disk, ok := e.disks[strings.ToLower(*dataDisk.ManagedDisk.ID)]
+		if !ok {
+			return nil, &apiError{
+				statusCode: http.StatusNotFound,
+				code:       "NotFound",
+				message:    fmt.Sprintf("Disk %s was not found.", *dataDisk.ManagedDisk.ID),
+			}
+		}
+		if usedLuns[*dataDisk.Lun] {
+			return nil, conflict("Conflict", "A disk at LUN %d already exists.", *dataDisk.Lun)
+		}
+		usedLuns[*dataDisk.Lun] = true
+
+		if !isManagedBy(disk, ownerID) {
+			maxShares := ptrValue(disk.Properties.MaxShares)
+			if maxShares <= 1 && len(disk.ManagedByExtended) > 0 {
+				return nil, conflict("OperationNotAllowed", "Disk %s is attached to VM %s.", *disk.ID, *disk.ManagedByExtended[0])
+			}
+			if maxShares > 1 && int32(len(disk.ManagedByExtended)) >= maxShares {
+				return nil, conflict("OperationNotAllowed", "Disk %s is already attached to its maximum number of %d VMs.", *disk.ID, maxShares)
+			}
+			if len(disk.Zones) > 0 && (len(ownerZones) == 0 || !strings.EqualFold(*disk.Zones[0], *ownerZones[0])) {
+				return nil, badRequest("BadRequest", "Disk %s in zone %s cannot be attached to VM %s in another zone.", *disk.ID, *disk.Zones[0], ownerID)
+			}
+		}
+
+		attached := *dataDisk
+		attached.Name = disk.Name
+		attached.ManagedDisk = &armcompute.ManagedDiskParameters{ID: disk.ID, StorageAccountType: (*armcompute.StorageAccountTypes)(disk.SKU.Name)}
+		attached.DiskSizeGB = disk.Properties.DiskSizeGB
+		attached.CreateOption = ptr(armcompute.DiskCreateOptionTypesAttach)
+		if attached.Caching == nil {
+			attached.Caching = ptr(armcompute.CachingTypesNone)
+		}
+		attached.ToBeDetached = nil
+		attached.DetachOption = nil
+		dataDisks = append(dataDisks, &attached)
+		disks = append(disks, disk)
+	}
+
+	maxDataDiskCount := e.maxDataDiskCounts[strings.ToLower(ownerID)]
+	if maxDataDiskCount <= 0 {
+		maxDataDiskCount = defaultMaxDataDiskCount
+	}
+	if len(dataDisks) > maxDataDiskCount {
+		return nil, conflict("OperationNotAllowed", "The maximum number of data disks allowed to be attached to a VM of this size is %d.", maxDataDiskCount)
+	}
+
+	for _, dataDisk := range current {
+		if disk, ok := e.disks[strings.ToLower(*dataDisk.ManagedDisk.ID)]; ok {
+			setManagedBy(disk, ownerID, false)
+		}
+	}
+	for _, disk := range disks {
+		setManagedBy(disk, ownerID, true)
+	}
+	return dataDisks, nil
+}
+
+func isManagedBy(disk *armcompute.Disk, ownerID string) bool {
+	for _, id := range disk.ManagedByExtended {
+		if strings.EqualFold(*id, ownerID) {
+			return true
+		}
+	}
+	return false
+}
+
+// setManagedBy adds or removes a VM from the VMs a disk is attached to
+func setManagedBy(disk *armcompute.Disk, ownerID string, attached bool) {
+	managedBy := []*string{}
+	for _, id := range disk.ManagedByExtended {
+		if !strings.EqualFold(*id, ownerID) {
+			managedBy = append(managedBy, id)
+		}
+	}
+	if attached {
+		managedBy = append(managedBy, ptr(ownerID))
+	}
+	disk.ManagedByExtended = managedBy
+	disk.ManagedBy = nil
+	disk.Properties.DiskState = ptr(armcompute.DiskStateUnattached)
+	if len(managedBy) > 0 {
+		disk.ManagedBy = managedBy[0]
+		disk.Properties.DiskState = ptr(armcompute.DiskStateAttached)
+	}
+}
+
+func ptrValue[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
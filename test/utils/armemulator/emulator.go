@@ -0,0 +1,348 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package armemulator provides an in-process emulator of the subset of Azure Resource Manager the Azure Disk
+// CSI driver uses: managed disks, snapshots, data disks of VMs and VMSS VMs, and polling of long-running
+// operations. The emulator serves the resource manager metadata and token endpoints as well, so a driver whose
+// cloud config points resourceManagerEndpoint at the emulator runs without an Azure subscription.
+package armemulator
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TenantID is the tenant of the emulator. Clients authenticate against the "adfs" tenant because the
+	// authentication libraries do not run instance discovery against login.microsoftonline.com for it.
+	TenantID = "adfs"
+
+	defaultLocation         = "eastus2"
+	defaultMaxDataDiskCount = 8
+	defaultAPIVersion       = "2023-07-01"
+	tokenAudience           = "https://management.core.windows.net/"
+
+	cloudConfigTemplate = `{
+    "cloud": "AzurePublicCloud",
+    "resourceManagerEndpoint": %q,
+    "tenantId": %q,
+    "subscriptionId": %q,
+    "aadClientId": "armemulator",
+    "aadClientSecret": "armemulator",
+    "resourceGroup": %q,
+    "location": %q,
+    "vmType": %q,
+    "useInstanceMetadata": false
+}`
+)
+
+// Emulator is an in-process emulator of Azure Resource Manager for the Microsoft.Compute resources used by the
+// driver. All resources are kept in memory and changes are applied when a request is accepted, long-running
+// operations report their progress through the Azure-AsyncOperation protocol.
+type Emulator struct {
+	// OperationPolls is the number of polls a long-running operation reports as in progress before it succeeds
+	OperationPolls int
+
+	server         *httptest.Server
+	subscriptionID string
+	location       string
+
+	mu          sync.Mutex
+	disks       map[string]*armcompute.Disk
+	snapshots   map[string]*armcompute.Snapshot
+	vms         map[string]*armcompute.VirtualMachine
+	scaleSets   map[string]*armcompute.VirtualMachineScaleSet
+	scaleSetVMs map[string]*armcompute.VirtualMachineScaleSetVM
+	// maxDataDiskCounts is the data disk limit of the VMs and VMSS VMs keyed by their lower case resource ID
+	maxDataDiskCounts map[string]int
+	operations        map[string]int
+	nextOperationID   int
+}
+
+// New starts an emulator serving the given subscription, location defaults to eastus2 when empty
+func New(subscriptionID, location string) *Emulator {
+	if location == "" {
+		location = defaultLocation
+	}
+	e := &Emulator{
+		OperationPolls:    1,
+		subscriptionID:    subscriptionID,
+		location:          location,
+		disks:             map[string]*armcompute.Disk{},
+		snapshots:         map[string]*armcompute.Snapshot{},
+		vms:               map[string]*armcompute.VirtualMachine{},
+		scaleSets:         map[string]*armcompute.VirtualMachineScaleSet{},
+		scaleSetVMs:       map[string]*armcompute.VirtualMachineScaleSetVM{},
+		maxDataDiskCounts: map[string]int{},
+		operations:        map[string]int{},
+	}
+	e.server = httptest.NewTLSServer(http.HandlerFunc(e.serveHTTP))
+	return e
+}
+
+// Close shuts down the emulator
+func (e *Emulator) Close() {
+	e.server.Close()
+}
+
+// URL returns the resource manager endpoint of the emulator
+func (e *Emulator) URL() string {
+	return e.server.URL + "/"
+}
+
+// WriteCACertificate writes the PEM encoded certificate of the emulator to path. Processes using the emulator
+// trust it by pointing the SSL_CERT_FILE environment variable at the file before their first TLS connection.
+func (e *Emulator) WriteCACertificate(path string) error {
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: e.server.Certificate().Raw})
+	return os.WriteFile(path, certificate, 0600)
+}
+
+// WriteCloudConfig writes a cloud config for the driver that points at the emulator to path
+func (e *Emulator) WriteCloudConfig(path, resourceGroup, vmType string) error {
+	config := fmt.Sprintf(cloudConfigTemplate, e.URL(), TenantID, e.subscriptionID, resourceGroup, e.location, vmType)
+	return os.WriteFile(path, []byte(config), 0600)
+}
+
+// AddVM adds a virtual machine, zone is the zone number and empty for VMs outside availability zones.
+// A maxDataDiskCount of zero or less uses a limit of 8 data disks.
+func (e *Emulator) AddVM(resourceGroup, name, zone string, maxDataDiskCount int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	id := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s", e.subscriptionID, resourceGroup, name)
+	vm := &armcompute.VirtualMachine{
+		ID:       ptr(id),
+		Name:     ptr(name),
+		Type:     ptr("Microsoft.Compute/virtualMachines"),
+		Location: ptr(e.location),
+		Properties: &armcompute.VirtualMachineProperties{
+			HardwareProfile:   &armcompute.HardwareProfile{VMSize: ptr(armcompute.VirtualMachineSizeTypesStandardD2SV3)},
+			OSProfile:         &armcompute.OSProfile{ComputerName: ptr(name)},
+			StorageProfile:    &armcompute.StorageProfile{DataDisks: []*armcompute.DataDisk{}},
+			ProvisioningState: ptr("Succeeded"),
+			VMID:              ptr(newUniqueID()),
+		},
+	}
+	if zone != "" {
+		vm.Zones = []*string{ptr(zone)}
+	}
+	e.vms[strings.ToLower(id)] = vm
+	e.maxDataDiskCounts[strings.ToLower(id)] = maxDataDiskCount
+}
+
+// AddScaleSetVM adds a VMSS VM with the given instance ID and computer name, creating its scale set if needed.
+// A maxDataDiskCount of zero or less uses a limit of 8 data disks.
+func (e *Emulator) AddScaleSetVM(resourceGroup, scaleSetName, instanceID, computerName string, maxDataDiskCount int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	scaleSetID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s", e.subscriptionID, resourceGroup, scaleSetName)
+	if _, ok := e.scaleSets[strings.ToLower(scaleSetID)]; !ok {
+		e.scaleSets[strings.ToLower(scaleSetID)] = &armcompute.VirtualMachineScaleSet{
+			ID:       ptr(scaleSetID),
+			Name:     ptr(scaleSetName),
+			Type:     ptr("Microsoft.Compute/virtualMachineScaleSets"),
+			Location: ptr(e.location),
+			SKU:      &armcompute.SKU{Name: ptr(string(armcompute.VirtualMachineSizeTypesStandardD2SV3))},
+			Properties: &armcompute.VirtualMachineScaleSetProperties{
+				OrchestrationMode: ptr(armcompute.OrchestrationModeUniform),
+				ProvisioningState: ptr("Succeeded"),
+			},
+		}
+	}
+	id := scaleSetID + "/virtualMachines/" + instanceID
+	e.scaleSetVMs[strings.ToLower(id)] = &armcompute.VirtualMachineScaleSetVM{
+		ID:         ptr(id),
+		Name:       ptr(scaleSetName + "_" + instanceID),
+		Type:       ptr("Microsoft.Compute/virtualMachineScaleSets/virtualMachines"),
+		Location:   ptr(e.location),
+		InstanceID: ptr(instanceID),
+		SKU:        &armcompute.SKU{Name: ptr(string(armcompute.VirtualMachineSizeTypesStandardD2SV3))},
+		Properties: &armcompute.VirtualMachineScaleSetVMProperties{
+			HardwareProfile: &armcompute.HardwareProfile{VMSize: ptr(armcompute.VirtualMachineSizeTypesStandardD2SV3)},
+			OSProfile:       &armcompute.OSProfile{ComputerName: ptr(computerName)},
+			StorageProfile:  &armcompute.StorageProfile{DataDisks: []*armcompute.DataDisk{}},
+			NetworkProfile: &armcompute.NetworkProfile{
+				NetworkInterfaces: []*armcompute.NetworkInterfaceReference{
+					{ID: ptr(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/networkInterfaces/%s", e.subscriptionID, resourceGroup, computerName))},
+				},
+			},
+			ProvisioningState: ptr("Succeeded"),
+			VMID:              ptr(newUniqueID()),
+		},
+	}
+	e.maxDataDiskCounts[strings.ToLower(id)] = maxDataDiskCount
+}
+
+func (e *Emulator) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	klog.V(4).Infof("armemulator: %s %s", r.Method, r.URL.String())
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	lowerPath := strings.ToLower(path)
+	switch {
+	case lowerPath == "/metadata/endpoints":
+		e.serveMetadata(w, r)
+	case strings.HasSuffix(lowerPath, "/.well-known/openid-configuration"):
+		e.serveOpenIDConfiguration(w, r)
+	case strings.HasSuffix(lowerPath, "/token") && r.Method == http.MethodPost:
+		e.serveToken(w, r)
+	case strings.HasPrefix(lowerPath, "/subscriptions/"):
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			writeError(w, http.StatusUnauthorized, "AuthenticationFailed", "Authentication failed. The 'Authorization' header is missing.")
+			return
+		}
+		e.serveCompute(w, r, strings.Split(strings.TrimPrefix(path, "/"), "/"))
+	default:
+		writeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s is not supported by the emulator", r.URL.Path))
+	}
+}
+
+// serveMetadata serves the cloud metadata in the format of the requested api-version. Version 2019-05-01 and
+// later return a list of clouds, earlier versions return a single cloud.
+func (e *Emulator) serveMetadata(w http.ResponseWriter, r *http.Request) {
+	type authentication struct {
+		LoginEndpoint string   `json:"loginEndpoint"`
+		Audiences     []string `json:"audiences"`
+	}
+	type metadata struct {
+		Name            string         `json:"name,omitempty"`
+		ResourceManager string         `json:"resourceManager,omitempty"`
+		GalleryEndpoint string         `json:"galleryEndpoint"`
+		GraphEndpoint   string         `json:"graphEndpoint"`
+		PortalEndpoint  string         `json:"portalEndpoint"`
+		Authentication  authentication `json:"authentication"`
+	}
+	m := metadata{
+		Name:            "AzureEmulator",
+		ResourceManager: e.URL(),
+		GalleryEndpoint: e.URL(),
+		GraphEndpoint:   e.URL(),
+		PortalEndpoint:  e.URL(),
+		Authentication: authentication{
+			LoginEndpoint: e.URL(),
+			Audiences:     []string{tokenAudience},
+		},
+	}
+	if r.URL.Query().Get("api-version") >= "2019-05-01" {
+		writeJSON(w, http.StatusOK, []metadata{m})
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (e *Emulator) serveOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := e.server.URL + strings.TrimSuffix(r.URL.Path, "/.well-known/openid-configuration")
+	issuer = strings.TrimSuffix(issuer, "/v2.0")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                 issuer,
+		"authorization_endpoint": issuer + "/oauth2/authorize",
+		"token_endpoint":         issuer + "/oauth2/token",
+	})
+}
+
+// serveToken issues an access token to any client
+func (e *Emulator) serveToken(w http.ResponseWriter, r *http.Request) {
+	expiresIn := time.Hour
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token_type":     "Bearer",
+		"access_token":   "armemulator-" + newUniqueID(),
+		"expires_in":     int(expiresIn.Seconds()),
+		"ext_expires_in": int(expiresIn.Seconds()),
+		"expires_on":     strconv.FormatInt(time.Now().Add(expiresIn).Unix(), 10),
+		"not_before":     strconv.FormatInt(time.Now().Unix(), 10),
+		"resource":       r.FormValue("resource"),
+	})
+}
+
+// startOperation responds to a mutating request that was accepted. The operation is reported as in progress
+// for OperationPolls polls of its Azure-AsyncOperation URL before it succeeds.
+func (e *Emulator) startOperation(w http.ResponseWriter, r *http.Request, statusCode int, body interface{}) {
+	e.nextOperationID++
+	operationID := fmt.Sprintf("%08d-0000-0000-0000-000000000000", e.nextOperationID)
+	e.operations[operationID] = e.OperationPolls
+
+	apiVersion := r.URL.Query().Get("api-version")
+	if apiVersion == "" {
+		apiVersion = defaultAPIVersion
+	}
+	operationURL := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Compute/locations/%s/operations/%s?api-version=%s",
+		e.server.URL, e.subscriptionID, e.location, operationID, apiVersion)
+	w.Header().Set("Azure-AsyncOperation", operationURL)
+	setRetryAfter(w)
+	writeJSON(w, statusCode, body)
+}
+
+func (e *Emulator) serveOperation(w http.ResponseWriter, operationID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	remainingPolls, ok := e.operations[operationID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("operation %s not found", operationID))
+		return
+	}
+	status := "Succeeded"
+	if remainingPolls > 0 {
+		e.operations[operationID] = remainingPolls - 1
+		status = "InProgress"
+	}
+	setRetryAfter(w)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":      operationID,
+		"status":    status,
+		"startTime": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// setRetryAfter asks clients to poll again right away. The track 2 SDK prefers retry-after-ms over the
+// Retry-After header the track 1 SDK understands, which only supports whole seconds.
+func setRetryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "0")
+	w.Header().Set("Retry-After-Ms", "10")
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if body == nil {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		klog.Errorf("armemulator: failed to encode response: %v", err)
+	}
+}
+
+// writeError writes an error in the format of Azure Resource Manager
+func writeError(w http.ResponseWriter, statusCode int, code, message string) {
+	w.Header().Set("x-ms-error-code", code)
+	writeJSON(w, statusCode, map[string]interface{}{
+		"error": map[string]string{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
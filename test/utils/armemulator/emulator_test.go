@@ -0,0 +1,254 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package armemulator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	consts "sigs.k8s.io/azuredisk-csi-driver/pkg/azureconstants"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/azuredisk"
+	volumehelper "sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+const (
+	testSubscriptionID = "subscription"
+	testResourceGroup  = "rg"
+	testNodeName       = "node-1"
+)
+
+var emulator *Emulator
+
+// TestMain starts the emulator and points the cloud config and the trusted certificates of the process at it
+// before any test opens a TLS connection
+func TestMain(m *testing.M) {
+	emulator = New(testSubscriptionID, "")
+	emulator.AddVM(testResourceGroup, testNodeName, "", 2)
+
+	dir, err := os.MkdirTemp("", "armemulator")
+	if err != nil {
+		log.Fatalf("failed to create temporary directory: %v", err)
+	}
+	certFile := filepath.Join(dir, "ca.pem")
+	configFile := filepath.Join(dir, "azure.json")
+	if err := emulator.WriteCACertificate(certFile); err != nil {
+		log.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := emulator.WriteCloudConfig(configFile, testResourceGroup, "standard"); err != nil {
+		log.Fatalf("failed to write cloud config: %v", err)
+	}
+	os.Setenv("SSL_CERT_FILE", certFile)
+	os.Setenv(consts.DefaultAzureCredentialFileEnv, configFile)
+
+	code := m.Run()
+	emulator.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestDriver(t *testing.T) azuredisk.CSIDriver {
+	options := azuredisk.DriverOptions{}
+	require.NoError(t, options.AddFlags().Parse([]string{"--support-zone=false", "--kubeconfig=" + filepath.Join(t.TempDir(), "kubeconfig")}))
+	return azuredisk.NewDriver(&options)
+}
+
+func checkCode(t *testing.T, expected codes.Code, err error) {
+	s, ok := status.FromError(err)
+	require.True(t, ok, "error %v is not a gRPC status", err)
+	assert.Equal(t, expected, s.Code(), "unexpected error: %v", err)
+}
+
+func TestControllerLifecycle(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDriver(t)
+
+	volumeCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	createVolume := func(name string, source *csi.VolumeContentSource) (*csi.CreateVolumeResponse, error) {
+		return d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:                name,
+			VolumeCapabilities:  []*csi.VolumeCapability{volumeCapability},
+			CapacityRange:       &csi.CapacityRange{RequiredBytes: volumehelper.GiBToBytes(10)},
+			Parameters:          map[string]string{consts.SkuNameField: "Premium_LRS"},
+			VolumeContentSource: source,
+		})
+	}
+
+	volume, err := createVolume("volume", nil)
+	require.NoError(t, err)
+	assert.Equal(t, volumehelper.GiBToBytes(10), volume.Volume.CapacityBytes)
+	volume, err = createVolume("volume", nil)
+	require.NoError(t, err, "CreateVolume must be idempotent")
+	volumeID := volume.Volume.VolumeId
+
+	publish, err := d.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volumeID,
+		NodeId:           testNodeName,
+		VolumeCapability: volumeCapability,
+		VolumeContext:    volume.Volume.VolumeContext,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0", publish.PublishContext[consts.LUN])
+
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	assert.Error(t, err, "attached disk must not be deleted")
+
+	snapshot, err := d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: volumeID, Name: "snapshot"})
+	require.NoError(t, err)
+	assert.True(t, snapshot.Snapshot.ReadyToUse)
+	assert.Equal(t, volumehelper.GiBToBytes(10), snapshot.Snapshot.SizeBytes)
+
+	_, err = d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: volumeID + "-other", Name: "snapshot"})
+	assert.Error(t, err, "snapshot must keep its source")
+
+	restored, err := createVolume("restored", &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshot.Snapshot.SnapshotId}},
+	})
+	require.NoError(t, err)
+
+	_, err = createVolume("missing-source", &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{Snapshot: &csi.VolumeContentSource_SnapshotSource{
+			SnapshotId: fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/snapshots/missing", testSubscriptionID, testResourceGroup),
+		}},
+	})
+	checkCode(t, codes.NotFound, err)
+
+	publish, err = d.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         restored.Volume.VolumeId,
+		NodeId:           testNodeName,
+		VolumeCapability: volumeCapability,
+		VolumeContext:    restored.Volume.VolumeContext,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1", publish.PublishContext[consts.LUN])
+
+	third, err := createVolume("third", nil)
+	require.NoError(t, err)
+	_, err = d.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         third.Volume.VolumeId,
+		NodeId:           testNodeName,
+		VolumeCapability: volumeCapability,
+		VolumeContext:    third.Volume.VolumeContext,
+	})
+	assert.Error(t, err, "VM must not exceed its data disk limit")
+
+	for _, id := range []string{volumeID, restored.Volume.VolumeId} {
+		_, err = d.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: id, NodeId: testNodeName})
+		require.NoError(t, err)
+	}
+
+	expand, err := d.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      volumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: volumehelper.GiBToBytes(20)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, volumehelper.GiBToBytes(20), expand.CapacityBytes)
+
+	_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snapshot.Snapshot.SnapshotId})
+	require.NoError(t, err)
+	for _, id := range []string{volumeID, restored.Volume.VolumeId, third.Volume.VolumeId, volumeID} {
+		_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: id})
+		require.NoError(t, err)
+	}
+	_, err = d.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+	assert.Error(t, err)
+}
+
+func TestControllerPublishScaleSetVM(t *testing.T) {
+	const nodeName = "vmss-node000000"
+	emulator.AddScaleSetVM(testResourceGroup, "vmss-node", "0", nodeName, 0)
+	configFile := filepath.Join(t.TempDir(), "azure.json")
+	require.NoError(t, emulator.WriteCloudConfig(configFile, testResourceGroup, "vmss"))
+	t.Setenv(consts.DefaultAzureCredentialFileEnv, configFile)
+
+	ctx := context.Background()
+	d := newTestDriver(t)
+	volumeCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	volume, err := d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "vmss-volume",
+		VolumeCapabilities: []*csi.VolumeCapability{volumeCapability},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: volumehelper.GiBToBytes(10)},
+	})
+	require.NoError(t, err)
+
+	publish, err := d.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volume.Volume.VolumeId,
+		NodeId:           nodeName,
+		VolumeCapability: volumeCapability,
+		VolumeContext:    volume.Volume.VolumeContext,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "0", publish.PublishContext[consts.LUN])
+
+	_, err = d.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volume.Volume.VolumeId, NodeId: nodeName})
+	require.NoError(t, err)
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volume.Volume.VolumeId})
+	require.NoError(t, err)
+}
+
+func TestControllerSecrets(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDriver(t)
+	volumeCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	secrets := map[string]string{
+		consts.SecretResourceGroupField:   "tenant-rg",
+		consts.SecretAADClientIDField:     "tenant-client",
+		consts.SecretAADClientSecretField: "tenant-secret",
+	}
+	createVolume := func(secrets map[string]string) (*csi.CreateVolumeResponse, error) {
+		return d.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               "tenant-volume",
+			VolumeCapabilities: []*csi.VolumeCapability{volumeCapability},
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: volumehelper.GiBToBytes(10)},
+			Secrets:            secrets,
+		})
+	}
+
+	volume, err := createVolume(secrets)
+	require.NoError(t, err)
+	assert.Contains(t, volume.Volume.VolumeId, "/resourceGroups/tenant-rg/")
+
+	_, err = createVolume(map[string]string{"unknown": "value"})
+	checkCode(t, codes.InvalidArgument, err)
+
+	snapshot, err := d.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: volume.Volume.VolumeId, Name: "tenant-snapshot", Secrets: secrets})
+	require.NoError(t, err)
+	assert.Contains(t, snapshot.Snapshot.SnapshotId, "/resourceGroups/tenant-rg/")
+
+	_, err = d.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snapshot.Snapshot.SnapshotId, Secrets: secrets})
+	require.NoError(t, err)
+	_, err = d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volume.Volume.VolumeId, Secrets: secrets})
+	require.NoError(t, err)
+}